@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("apply", "write the files of a response to disk like protoc does", runApply)
+}
+
+func runApply(args []string) error {
+	var (
+		jsonIn    = false
+		out       = "."
+		goLayout  = false
+		reqFile   = ""
+		parameter = ""
+		module    = ""
+		only      stringsFlag
+	)
+	fs := newFlagSet("apply")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.StringVar(&out, "out", out, "output directory, like the DIR in protoc --NAME_out=DIR")
+	fs.BoolVar(&goLayout, "go-module-layout", goLayout, "place generated .go files by their import path inside the Go module containing the output directory")
+	fs.StringVar(&reqFile, "request", reqFile, "captured request the response was generated for, provides parameter and go_package")
+	fs.StringVar(&parameter, "parameter", parameter, "plugin parameter, overrides the one in -request")
+	fs.StringVar(&module, "module", module, "Go module path, read from go.mod if empty")
+	fs.Var(&only, "only", "only write the files matching this glob, ** matches any directories and patterns without / match the base name, repeatable")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if err := checkGlobs(only); err != nil {
+		return fmt.Errorf("-only: %v", err)
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	msg, err := decode(bin, false, jsonIn)
+	if err != nil {
+		return err
+	}
+	resp := msg.(*pluginpb.CodeGeneratorResponse)
+	if e := resp.GetError(); e != "" {
+		return fmt.Errorf("response contains an error: %s", e)
+	}
+	if len(only) > 0 {
+		selectFiles(resp, only)
+	}
+
+	var req *pluginpb.CodeGeneratorRequest
+	if reqFile != "" {
+		req, err = readRequestFile(reqFile)
+		if err != nil {
+			return err
+		}
+		if parameter == "" {
+			parameter = req.GetParameter()
+		}
+	}
+
+	place := func(name string) (string, error) {
+		return splitPath(out, name)
+	}
+	if goLayout {
+		gl, err := newGoLayout(out, module, parameter, req)
+		if err != nil {
+			return err
+		}
+		place = gl.place
+	}
+	return applyResponse(resp, place)
+}
+
+// applyResponse writes the files of resp to the paths place maps their names to.
+// Files with an insertion point are inserted into previously written files.
+// Nothing is written if a name can not be placed.
+func applyResponse(resp *pluginpb.CodeGeneratorResponse, place func(name string) (string, error)) error {
+	dsts := make([]string, len(resp.File))
+	for i, f := range resp.File {
+		dst, err := place(f.GetName())
+		if err != nil {
+			return err
+		}
+		dsts[i] = dst
+	}
+	for i, f := range resp.File {
+		dst := dsts[i]
+		content := []byte(f.GetContent())
+		if ip := f.GetInsertionPoint(); ip != "" {
+			prev, err := os.ReadFile(dst)
+			if err != nil {
+				return fmt.Errorf("insertion point %s in %s: %v", ip, f.GetName(), err)
+			}
+			content, err = insertAt(prev, ip, content)
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.GetName(), err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertAt inserts content before the line containing the insertion point marker,
+// indented like that line.
+func insertAt(file []byte, point string, content []byte) ([]byte, error) {
+	marker := []byte("@@protoc_insertion_point(" + point + ")")
+	idx := bytes.Index(file, marker)
+	if idx < 0 {
+		return nil, fmt.Errorf("insertion point %s not found", point)
+	}
+	lineStart := bytes.LastIndexByte(file[:idx], '\n') + 1
+	indent := file[lineStart:idx]
+	indent = indent[:len(indent)-len(bytes.TrimLeft(indent, " \t"))]
+
+	var buf bytes.Buffer
+	buf.Write(file[:lineStart])
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	sc.Buffer(nil, len(content)+1)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			buf.Write(indent)
+		}
+		buf.Write(sc.Bytes())
+		buf.WriteByte('\n')
+	}
+	buf.Write(file[lineStart:])
+	return buf.Bytes(), nil
+}
+
+// goLayout maps generated Go files to the directory of their import path in a Go module.
+type goLayout struct {
+	out        string
+	modRoot    string
+	modPath    string
+	paramMod   string
+	sourceRel  bool
+	importPath map[string]string // proto file name without .proto -> go import path
+}
+
+func newGoLayout(out, modPath, parameter string, req *pluginpb.CodeGeneratorRequest) (*goLayout, error) {
+	gl := &goLayout{
+		out:        out,
+		modPath:    modPath,
+		importPath: map[string]string{},
+	}
+	for _, p := range strings.Split(parameter, ",") {
+		k, v, _ := strings.Cut(p, "=")
+		switch k {
+		case "module":
+			gl.paramMod = v
+		case "paths":
+			gl.sourceRel = v == "source_relative"
+		}
+	}
+	if req != nil {
+		for _, fd := range req.ProtoFile {
+			if gp := fd.GetOptions().GetGoPackage(); gp != "" {
+				gp, _, _ = strings.Cut(gp, ";")
+				gl.importPath[strings.TrimSuffix(fd.GetName(), ".proto")] = gp
+			}
+		}
+	}
+	if gl.sourceRel && req == nil {
+		return nil, fmt.Errorf("paths=source_relative needs -request to find go_package")
+	}
+
+	dir, err := filepath.Abs(out)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			gl.modRoot = dir
+			if gl.modPath == "" {
+				gl.modPath = modulePath(mod)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("no go.mod found in %s or its parents", out)
+		}
+		dir = parent
+	}
+	if gl.modPath == "" {
+		return nil, fmt.Errorf("no module path in %s", filepath.Join(gl.modRoot, "go.mod"))
+	}
+	return gl, nil
+}
+
+// modulePath extracts the module path from a go.mod file.
+func modulePath(mod []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(mod))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// goImportPath returns the import path of the package a generated Go file belongs to.
+func (gl *goLayout) goImportPath(name string) (string, bool) {
+	switch {
+	case gl.sourceRel:
+		// the longest stem wins, foo_bar.pb.go belongs to foo_bar.proto and not to foo.proto
+		dir, base := path.Split(name)
+		match, found := "", ""
+		for stem, ip := range gl.importPath {
+			sdir, sbase := path.Split(stem)
+			if dir == sdir && len(sbase) > len(match) && strings.HasPrefix(base, sbase) && len(base) > len(sbase) && strings.ContainsRune("._", rune(base[len(sbase)])) {
+				match, found = sbase, ip
+			}
+		}
+		return found, match != ""
+	case gl.paramMod != "":
+		return path.Join(gl.paramMod, path.Dir(name)), true
+	}
+	return path.Dir(name), true
+}
+
+func (gl *goLayout) place(name string) (string, error) {
+	dst, err := splitPath(gl.out, name)
+	if err != nil || !strings.HasSuffix(name, ".go") {
+		return dst, err
+	}
+	ip, ok := gl.goImportPath(name)
+	if !ok {
+		return "", fmt.Errorf("no go_package found for %s", name)
+	}
+	if ip != gl.modPath && !strings.HasPrefix(ip, gl.modPath+"/") {
+		return "", fmt.Errorf("%s belongs to %s outside of module %s", name, ip, gl.modPath)
+	}
+	dir := gl.modRoot
+	if rel := strings.TrimPrefix(strings.TrimPrefix(ip, gl.modPath), "/"); rel != "" {
+		if dir, err = splitPath(gl.modRoot, rel); err != nil {
+			return "", fmt.Errorf("%s: go_package %s: %v", name, ip, err)
+		}
+	}
+	return filepath.Join(dir, path.Base(name)), nil
+}
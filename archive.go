@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// archiveManifest is the name of the manifest in archives created with -archive-manifest.
+const archiveManifest = "protoc-gen-capture.manifest.json"
+
+type archiveEntry struct {
+	Name   string `json:"name"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+type archiveIndex struct {
+	Error             string         `json:"error,omitempty"`
+	SupportedFeatures uint64         `json:"supported_features"`
+	Files             []archiveEntry `json:"files"`
+}
+
+// responseFiles applies the insertion points of resp and returns the resulting files in order.
+func responseFiles(resp *pluginpb.CodeGeneratorResponse) ([]string, map[string][]byte, error) {
+	var names []string
+	files := map[string][]byte{}
+	for _, f := range resp.File {
+		if _, err := splitPath(".", f.GetName()); err != nil {
+			return nil, nil, err
+		}
+		name := path.Clean(f.GetName())
+		content := []byte(f.GetContent())
+		if ip := f.GetInsertionPoint(); ip != "" {
+			prev, ok := files[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("insertion point %s in %s: file is not generated before", ip, name)
+			}
+			var err error
+			if content, err = insertAt(prev, ip, content); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+		files[name] = content
+	}
+	return names, files, nil
+}
+
+// archiveResponse packs the generated files of resp into a tar, tgz or zip archive.
+// Timestamps are fixed so archives of the same response are identical.
+func archiveResponse(resp *pluginpb.CodeGeneratorResponse, format string, manifest bool) ([]byte, error) {
+	names, files, err := responseFiles(resp)
+	if err != nil {
+		return nil, err
+	}
+	if manifest {
+		idx := archiveIndex{Error: resp.GetError(), SupportedFeatures: resp.GetSupportedFeatures(), Files: []archiveEntry{}}
+		for _, name := range names {
+			sum := sha256.Sum256(files[name])
+			idx.Files = append(idx.Files, archiveEntry{Name: name, Bytes: len(files[name]), SHA256: hex.EncodeToString(sum[:])})
+		}
+		raw, err := json.MarshalIndent(&idx, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, archiveManifest)
+		files[archiveManifest] = append(raw, '\n')
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "tar", "tgz":
+		var gz *gzip.Writer
+		tw := tar.NewWriter(&buf)
+		if format == "tgz" {
+			gz = gzip.NewWriter(&buf)
+			tw = tar.NewWriter(gz)
+		}
+		for _, name := range names {
+			hdr := &tar.Header{
+				Name:    name,
+				Mode:    0o644,
+				Size:    int64(len(files[name])),
+				ModTime: time.Unix(0, 0).UTC(),
+				Format:  tar.FormatPAX,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, err
+			}
+			if _, err := tw.Write(files[name]); err != nil {
+				return nil, err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return nil, err
+		}
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return nil, err
+			}
+		}
+	case "zip":
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			w, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     name,
+				Method:   zip.Deflate,
+				Modified: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(files[name]); err != nil {
+				return nil, err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %q, use tar, tgz or zip", format)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func init() {
+	register("frame", "join files into a stream for -batch or extract the messages of a stream", runFrame)
+}
+
+// readFrames calls fn for each varint length prefixed frame in r,
+// the format written by writeFrame and used by java's writeDelimitedTo.
+func readFrames(r io.Reader, fn func(frame []byte) error) error {
+	br := bufio.NewReader(r)
+	for i := 0; ; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: invalid length prefix: %v", i, err)
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return fmt.Errorf("frame %d: truncated, want %d bytes: %v", i, size, err)
+		}
+		if err := fn(frame); err != nil {
+			return fmt.Errorf("frame %d: %v", i, err)
+		}
+	}
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(frame)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// runBatch processes each message in r and writes the results to w.
+// Binary messages are framed, json messages are concatenated values.
+func runBatch(r io.Reader, w io.Writer, reqIn, jsonIn, jsonOut, explain bool, process func(proto.Message, []byte) ([]byte, error)) error {
+	bw := bufio.NewWriter(w)
+	handle := func(bin []byte) error {
+		msg, err := decode(bin, reqIn, jsonIn)
+		if err != nil {
+			if explain {
+				err = fmt.Errorf("%v\n%s", err, explainDecode(bin, reqIn, jsonIn))
+			}
+			return err
+		}
+		out, err := process(msg, bin)
+		if out == nil || err != nil {
+			return err
+		}
+		if jsonOut {
+			_, err = bw.Write(append(out, '\n'))
+		} else {
+			err = writeFrame(bw, out)
+		}
+		if err != nil {
+			return err
+		}
+		// peers on pipes and sockets wait for each answer
+		return bw.Flush()
+	}
+
+	var err error
+	if jsonIn {
+		dec := json.NewDecoder(r)
+		for i := 0; err == nil; i++ {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err == io.EOF {
+				err = nil
+				break
+			}
+			if err == nil {
+				err = handle(raw)
+			}
+			if err != nil {
+				err = fmt.Errorf("message %d: %v", i, err)
+			}
+		}
+	} else {
+		err = readFrames(r, handle)
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func runFrame(args []string) error {
+	extract := ""
+	fs := newFlagSet("frame")
+	fs.StringVar(&extract, "extract", extract, "write each message of the stream on stdin to a numbered file in this directory")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture frame FILE... > stream\n")
+		fmt.Fprint(os.Stdout, "       protoc-gen-capture frame -extract DIR < stream\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	if extract != "" {
+		if err := os.MkdirAll(extract, 0o755); err != nil {
+			return err
+		}
+		n := 0
+		return readFrames(os.Stdin, func(frame []byte) error {
+			n++
+			return os.WriteFile(filepath.Join(extract, fmt.Sprintf("%06d.proto.msg", n)), frame, 0o644)
+		})
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, name := range fs.Args() {
+		frame, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := writeFrame(w, frame); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
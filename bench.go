@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// quantiles summarizes measurements of repeated plugin runs.
+type quantiles struct {
+	Min    int64 `json:"min"`
+	Median int64 `json:"median"`
+	P95    int64 `json:"p95"`
+	Max    int64 `json:"max"`
+}
+
+func newQuantiles(values []int64) quantiles {
+	if len(values) == 0 {
+		return quantiles{}
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) int64 {
+		return sorted[int(q*float64(len(sorted)-1)+0.5)]
+	}
+	return quantiles{Min: sorted[0], Median: at(0.5), P95: at(0.95), Max: sorted[len(sorted)-1]}
+}
+
+// benchResult is the outcome of replay -bench.
+type benchResult struct {
+	Plugin   string    `json:"plugin"`
+	Capture  string    `json:"capture"`
+	Runs     int       `json:"runs"`
+	Failed   int       `json:"failed"`
+	Duration quantiles `json:"duration_ns"`
+	CPU      quantiles `json:"cpu_ns"`
+	MaxRSS   quantiles `json:"max_rss_bytes"`
+	// RSSSeries is the peak resident set size of each run in order, to spot growth over time
+	RSSSeries []int64 `json:"max_rss_series"`
+}
+
+// benchReplay runs the plugin count times on req.
+func benchReplay(argv []string, req *pluginpb.CodeGeneratorRequest, want *pluginpb.CodeGeneratorResponse, capture, report string, count int, jsonOut bool) error {
+	if count < 1 {
+		return fmt.Errorf("-count must be positive")
+	}
+	results := make([]replayResult, 0, count)
+	var wall, cpu []int64
+	b := &benchResult{Capture: capture}
+	for i := 0; i < count; i++ {
+		res, _, err := replay(context.Background(), argv, req, want)
+		if err != nil {
+			return err
+		}
+		res.Capture = capture
+		results = append(results, *res)
+		b.Plugin = res.Plugin
+		b.Runs++
+		if res.Failed {
+			b.Failed++
+		}
+		wall = append(wall, int64(res.Duration))
+		cpu = append(cpu, int64(res.UserTime+res.SysTime))
+		b.RSSSeries = append(b.RSSSeries, res.MaxRSS)
+	}
+	b.Duration = newQuantiles(wall)
+	b.CPU = newQuantiles(cpu)
+	b.MaxRSS = newQuantiles(b.RSSSeries)
+
+	if report != "" {
+		if err := writeJSONFile(report, &runReport{Time: time.Now().UTC(), Results: results}); err != nil {
+			return err
+		}
+	}
+	var err error
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(b)
+	} else {
+		err = b.writeTable(os.Stdout)
+	}
+	if err == nil && b.Failed > 0 {
+		err = &exitError{code: exitPluginFailed, err: fmt.Errorf("plugin failed in %d of %d runs", b.Failed, b.Runs)}
+	}
+	return err
+}
+
+func (b *benchResult) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "plugin\t%s\n", b.Plugin)
+	fmt.Fprintf(tw, "runs\t%d\n", b.Runs)
+	fmt.Fprintf(tw, "failed\t%d\n", b.Failed)
+	fmt.Fprint(tw, "\n\tMIN\tMEDIAN\tP95\tMAX\n")
+	for _, row := range []struct {
+		name string
+		q    quantiles
+	}{{"wall", b.Duration}, {"cpu", b.CPU}} {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%v\n", row.name,
+			time.Duration(row.q.Min), time.Duration(row.q.Median), time.Duration(row.q.P95), time.Duration(row.q.Max))
+	}
+	fmt.Fprintf(tw, "max rss\t%d\t%d\t%d\t%d\n", b.MaxRSS.Min, b.MaxRSS.Median, b.MaxRSS.P95, b.MaxRSS.Max)
+	if n := len(b.RSSSeries); n > 1 {
+		fmt.Fprintf(tw, "\nmax rss first run\t%d\nmax rss last run\t%d\n", b.RSSSeries[0], b.RSSSeries[n-1])
+	}
+	return tw.Flush()
+}
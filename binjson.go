@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// binary encodings of the json mapping
+const (
+	formatCBOR    = "cbor"
+	formatMsgpack = "msgpack"
+)
+
+// jsonMember is a member of a json object, objects keep their order.
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// transcodeJSON converts json to CBOR or MessagePack.
+// Integers stay integers, other numbers become float64.
+func transcodeJSON(data []byte, format string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSONValue(dec)
+	if err != nil {
+		return nil, fmt.Errorf("%s encoding failed: %v", format, err)
+	}
+	var buf bytes.Buffer
+	switch format {
+	case formatCBOR:
+		writeCBOR(&buf, v)
+	case formatMsgpack:
+		writeMsgpack(&buf, v)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+	return buf.Bytes(), nil
+}
+
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			list := []interface{}{}
+			for dec.More() {
+				v, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			_, err = dec.Token()
+			return list, err
+		}
+		obj := []jsonMember{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{key.(string), v})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Number:
+		if !strings.ContainsAny(string(t), ".eE") {
+			if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+				return i, nil
+			}
+			if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+				return u, nil
+			}
+		}
+		return t.Float64()
+	}
+	// string, bool or nil
+	return tok, nil
+}
+
+// cborHead writes the initial byte of a data item with its argument.
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func writeCBOR(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case int64:
+		if v < 0 {
+			cborHead(buf, 1, uint64(-(v + 1)))
+		} else {
+			cborHead(buf, 0, uint64(v))
+		}
+	case uint64:
+		cborHead(buf, 0, v)
+	case float64:
+		buf.WriteByte(0xfb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		cborHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		cborHead(buf, 4, uint64(len(v)))
+		for _, e := range v {
+			writeCBOR(buf, e)
+		}
+	case []jsonMember:
+		cborHead(buf, 5, uint64(len(v)))
+		for _, m := range v {
+			writeCBOR(buf, m.key)
+			writeCBOR(buf, m.value)
+		}
+	}
+}
+
+// msgpackHead writes a type byte for n with the fixed, 8, 16 and 32 bit variants
+// of a type, a zero fixed or 8 bit variant is not available.
+func msgpackHead(buf *bytes.Buffer, n int, fixed byte, fixedMax int, b8, b16, b32 byte) {
+	switch {
+	case n <= fixedMax:
+		buf.WriteByte(fixed | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{b8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int64:
+		switch {
+		case v >= 0:
+			writeMsgpack(buf, uint64(v))
+		case v >= -32:
+			buf.WriteByte(byte(v))
+		case v >= math.MinInt8:
+			buf.Write([]byte{0xd0, byte(v)})
+		case v >= math.MinInt16:
+			buf.WriteByte(0xd1)
+			binary.Write(buf, binary.BigEndian, int16(v))
+		case v >= math.MinInt32:
+			buf.WriteByte(0xd2)
+			binary.Write(buf, binary.BigEndian, int32(v))
+		default:
+			buf.WriteByte(0xd3)
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	case uint64:
+		switch {
+		case v < 128:
+			buf.WriteByte(byte(v))
+		case v <= math.MaxUint8:
+			buf.Write([]byte{0xcc, byte(v)})
+		case v <= math.MaxUint16:
+			buf.WriteByte(0xcd)
+			binary.Write(buf, binary.BigEndian, uint16(v))
+		case v <= math.MaxUint32:
+			buf.WriteByte(0xce)
+			binary.Write(buf, binary.BigEndian, uint32(v))
+		default:
+			buf.WriteByte(0xcf)
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	case float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		msgpackHead(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		msgpackHead(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			writeMsgpack(buf, e)
+		}
+	case []jsonMember:
+		msgpackHead(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, m := range v {
+			writeMsgpack(buf, m.key)
+			writeMsgpack(buf, m.value)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("bisect", "compare the output of a known good and a candidate plugin", runBisect)
+}
+
+// fileChange is an entry of the machine readable list of changed files.
+type fileChange struct {
+	File           string `json:"file"`
+	InsertionPoint string `json:"insertion_point,omitempty"`
+	Status         string `json:"status"`
+}
+
+func runBisect(args []string) error {
+	var (
+		jsonIn    = false
+		good      = ""
+		candidate = ""
+		changes   = ""
+		lines     = 3
+		hash      = false
+	)
+	fs := newFlagSet("bisect")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.StringVar(&good, "good", good, "command of the known good plugin")
+	fs.StringVar(&candidate, "candidate", candidate, "command of the candidate plugin")
+	fs.StringVar(&changes, "changes", changes, "write the changed files as json to this file")
+	fs.IntVar(&lines, "context", lines, "number of context lines in the diff")
+	fs.BoolVar(&hash, "content-hash", hash, "diff the sha256 and length of the files instead of their content")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if good == "" || candidate == "" {
+		return fmt.Errorf("bisect needs -good and -candidate")
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	diff, changed, err := comparePlugins(strings.Fields(good), strings.Fields(candidate), req, lines, hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.WriteString(diff); err != nil {
+		return err
+	}
+	if changes != "" {
+		if err := writeJSONFile(changes, changed); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%d generated files differ", len(changed))
+	}
+	return nil
+}
+
+// comparePlugins runs both plugins on req and returns a unified diff of
+// their generated files or, with hash, of their signatures and the list of changes.
+func comparePlugins(good, candidate []string, req *pluginpb.CodeGeneratorRequest, lines int, hash bool) (string, []fileChange, error) {
+	ctx := context.Background()
+	goodRes, goodRun, err := replay(ctx, good, req, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if goodRes.Failed {
+		return "", nil, fmt.Errorf("good plugin failed: %s", goodRes.Error)
+	}
+	candRes, candRun, err := replay(ctx, candidate, req, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if candRes.Failed {
+		return "", nil, fmt.Errorf("candidate plugin failed: %s", candRes.Error)
+	}
+
+	goodResp, candResp := goodRun.resp, candRun.resp
+	if hash {
+		goodResp, candResp = signResponse(goodResp), signResponse(candResp)
+	}
+	diff, changed := diffResponses(goodResp, candResp, "good/", "candidate/", lines)
+	return diff, changed, nil
+}
+
+// diffResponses returns a unified diff of the generated files of a and b
+// with file names prefixed by prefixA and prefixB and the list of changes.
+func diffResponses(ra, rb *pluginpb.CodeGeneratorResponse, prefixA, prefixB string, lines int) (string, []fileChange) {
+	a, b := responseContents(ra), responseContents(rb)
+	var sb strings.Builder
+	changed := []fileChange{}
+	for _, k := range changedKeys(a, b) {
+		name := k.name
+		if k.insertionPoint != "" {
+			name += "@" + k.insertionPoint
+		}
+		change := fileChange{File: k.name, InsertionPoint: k.insertionPoint, Status: "modified"}
+		nameA, nameB := prefixA+name, prefixB+name
+		if _, ok := a[k]; !ok {
+			change.Status, nameA = "added", "/dev/null"
+		} else if _, ok := b[k]; !ok {
+			change.Status, nameB = "removed", "/dev/null"
+		}
+		changed = append(changed, change)
+		sb.WriteString(unifiedDiff(nameA, nameB, a[k], b[k], lines))
+	}
+	return sb.String(), changed
+}
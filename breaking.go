@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("breaking", "report wire incompatible changes between an old and a new request", runBreaking)
+}
+
+// breakingRules are the rules of breaking with their descriptions.
+var breakingRules = []sarifRule{
+	{"package-changed", "a file declares another package"},
+	{"message-removed", "a message was removed or moved to another package"},
+	{"enum-removed", "an enum was removed or moved to another package"},
+	{"field-removed", "a field was removed without reserving its number"},
+	{"field-number-reused", "a field number is used by a field with another name"},
+	{"field-type-changed", "a field type changed to an incompatible encoding or type"},
+	{"field-cardinality-changed", "a field changed between singular and repeated or required"},
+	{"enum-value-removed", "an enum value was removed without reserving its number"},
+}
+
+// breakingChange is a wire incompatible change of an element of the old request.
+type breakingChange struct {
+	File    string `json:"file"`
+	Element string `json:"element"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func runBreaking(args []string) error {
+	var (
+		jsonOut = false
+		all     = false
+		sarif   = false
+		prefix  = ""
+	)
+	fs := newFlagSet("breaking")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "check all files, not only the files to generate of the old request")
+	fs.BoolVar(&sarif, "sarif", sarif, "output as SARIF located in the sources of the new request, e.g. for code scanning")
+	fs.StringVar(&prefix, "sarif-prefix", prefix, "for -sarif: directory of the import path the proto file names are relative to")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture breaking [ARGUMENTS] OLD-REQUEST NEW-REQUEST\n\n"+
+			"Rules:\n"+rulesUsage(breakingRules, 27)+"\n"+
+			"Exits with 1 if wire incompatible changes are found.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("breaking needs an old and a new request")
+	}
+	old, err := readRequestFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cur, err := readRequestFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes := breakingChanges(old, cur, all)
+	if sarif {
+		findings := make([]sarifFinding, len(changes))
+		for i, c := range changes {
+			findings[i] = sarifFinding(c)
+		}
+		err = writeSARIF(os.Stdout, "protoc-gen-capture breaking", breakingRules, findings, cur, prefix)
+	} else if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(changes)
+	} else {
+		err = writeBreakingTable(os.Stdout, changes)
+	}
+	if err == nil && len(changes) > 0 {
+		err = fmt.Errorf("%d wire incompatible changes found", len(changes))
+	}
+	return err
+}
+
+// declarations indexes the messages and enums of a request by full name.
+type declarations struct {
+	packages map[string]string
+	messages map[string]*descriptorpb.DescriptorProto
+	enums    map[string]*descriptorpb.EnumDescriptorProto
+	// files maps full names to their file
+	files map[string]string
+	// names are the sorted full names of messages and enums
+	names []string
+}
+
+func newDeclarations(req *pluginpb.CodeGeneratorRequest) *declarations {
+	d := &declarations{
+		packages: map[string]string{},
+		messages: map[string]*descriptorpb.DescriptorProto{},
+		enums:    map[string]*descriptorpb.EnumDescriptorProto{},
+		files:    map[string]string{},
+	}
+	for _, fd := range req.ProtoFile {
+		file := fd.GetName()
+		d.packages[file] = fd.GetPackage()
+		addEnums := func(prefix string, eds []*descriptorpb.EnumDescriptorProto) {
+			for _, ed := range eds {
+				d.enums[prefix+ed.GetName()] = ed
+				d.files[prefix+ed.GetName()] = file
+			}
+		}
+		var walk func(prefix string, mds []*descriptorpb.DescriptorProto)
+		walk = func(prefix string, mds []*descriptorpb.DescriptorProto) {
+			for _, md := range mds {
+				name := prefix + md.GetName()
+				d.messages[name] = md
+				d.files[name] = file
+				walk(name+".", md.NestedType)
+				addEnums(name+".", md.EnumType)
+			}
+		}
+		walk(filePrefix(fd), fd.MessageType)
+		addEnums(filePrefix(fd), fd.EnumType)
+	}
+	for name := range d.files {
+		d.names = append(d.names, name)
+	}
+	sort.Strings(d.names)
+	return d
+}
+
+func breakingChanges(old, cur *pluginpb.CodeGeneratorRequest, all bool) []breakingChange {
+	check := map[string]bool{}
+	for _, name := range old.FileToGenerate {
+		check[name] = true
+	}
+	o, n := newDeclarations(old), newDeclarations(cur)
+	changes := []breakingChange{}
+	report := func(file, element, rule, format string, args ...interface{}) {
+		changes = append(changes, breakingChange{
+			File:    file,
+			Element: element,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, fd := range old.ProtoFile {
+		file := fd.GetName()
+		if pkg, ok := n.packages[file]; (all || check[file]) && ok && pkg != fd.GetPackage() {
+			report(file, file, "package-changed", "package %q changed to %q", fd.GetPackage(), pkg)
+		}
+	}
+	for _, name := range o.names {
+		file := o.files[name]
+		if !all && !check[file] {
+			continue
+		}
+		om, isMessage := o.messages[name]
+		if isMessage {
+			cm, ok := n.messages[name]
+			if !ok {
+				report(file, name, "message-removed", "message was removed")
+				continue
+			}
+			compareFields(name, om, cm, func(element, rule, format string, args ...interface{}) {
+				report(file, element, rule, format, args...)
+			})
+			continue
+		}
+		ce, ok := n.enums[name]
+		if !ok {
+			report(file, name, "enum-removed", "enum was removed")
+			continue
+		}
+		reserved := func(num int32) bool {
+			for _, r := range ce.ReservedRange {
+				if num >= r.GetStart() && num <= r.GetEnd() {
+					return true
+				}
+			}
+			return false
+		}
+		numbers := map[int32]bool{}
+		for _, v := range ce.Value {
+			numbers[v.GetNumber()] = true
+		}
+		for _, v := range o.enums[name].Value {
+			if !numbers[v.GetNumber()] && !reserved(v.GetNumber()) {
+				report(file, name+"."+v.GetName(), "enum-value-removed", "value %d was removed without reserving it", v.GetNumber())
+			}
+		}
+	}
+	return changes
+}
+
+func compareFields(name string, om, cm *descriptorpb.DescriptorProto, report lintReport) {
+	reserved := func(num int32) bool {
+		for _, r := range cm.ReservedRange {
+			if num >= r.GetStart() && num < r.GetEnd() {
+				return true
+			}
+		}
+		return false
+	}
+	byNumber := map[int32]*descriptorpb.FieldDescriptorProto{}
+	for _, f := range cm.Field {
+		byNumber[f.GetNumber()] = f
+	}
+	for _, of := range om.Field {
+		element := name + "." + of.GetName()
+		cf, ok := byNumber[of.GetNumber()]
+		if !ok {
+			if !reserved(of.GetNumber()) {
+				report(element, "field-removed", "field %d was removed without reserving it", of.GetNumber())
+			}
+			continue
+		}
+		if cf.GetName() != of.GetName() {
+			report(element, "field-number-reused", "number %d is now used by %s", of.GetNumber(), cf.GetName())
+		}
+		if ot, ct := fieldTypeText(of), fieldTypeText(cf); !wireCompatible(of, cf) {
+			report(element, "field-type-changed", "type %s changed to %s", ot, ct)
+		}
+		if ol, cl := of.GetLabel(), cf.GetLabel(); ol != cl {
+			report(element, "field-cardinality-changed", "%s changed to %s", labelText(ol), labelText(cl))
+		}
+	}
+}
+
+// wireEncoding groups field types sharing an encoding.
+var wireEncoding = map[descriptorpb.FieldDescriptorProto_Type]string{
+	descriptorpb.FieldDescriptorProto_TYPE_INT32:    "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_INT64:    "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_UINT32:   "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_UINT64:   "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL:     "varint",
+	descriptorpb.FieldDescriptorProto_TYPE_SINT32:   "zigzag",
+	descriptorpb.FieldDescriptorProto_TYPE_SINT64:   "zigzag",
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED32:  "fixed32",
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED32: "fixed32",
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED64:  "fixed64",
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED64: "fixed64",
+	descriptorpb.FieldDescriptorProto_TYPE_STRING:   "bytes",
+	descriptorpb.FieldDescriptorProto_TYPE_BYTES:    "bytes",
+}
+
+// wireCompatible reports whether a field of type a can be decoded as b.
+// Integer types are considered compatible even though values may be truncated.
+func wireCompatible(a, b *descriptorpb.FieldDescriptorProto) bool {
+	ta, tb := a.GetType(), b.GetType()
+	if ta != tb {
+		ea, eb := wireEncoding[ta], wireEncoding[tb]
+		return ea != "" && ea == eb
+	}
+	switch ta {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return a.GetTypeName() == b.GetTypeName()
+	}
+	return true
+}
+
+func fieldTypeText(f *descriptorpb.FieldDescriptorProto) string {
+	if f.GetTypeName() != "" {
+		return f.GetTypeName()
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+}
+
+func labelText(l descriptorpb.FieldDescriptorProto_Label) string {
+	return strings.ToLower(strings.TrimPrefix(l.String(), "LABEL_"))
+}
+
+func writeBreakingTable(w io.Writer, changes []breakingChange) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tELEMENT\tRULE\tMESSAGE\n")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.File, c.Element, c.Rule, c.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("browse", "explore the files, messages and fields of a request interactively", runBrowse)
+}
+
+const browseHelp = `Commands:
+  ls              list the elements of the current element
+  cd N|NAME|..|/  enter a listed element by number or name, go up or to the top
+  show            show the current element with its options
+  find TEXT       list all elements with TEXT in their full name
+  gen             list the files to generate and the files they depend on
+  help            show this help
+  quit            leave
+`
+
+// browseNode is an element of a request in browse.
+type browseNode struct {
+	name     string
+	kind     string
+	detail   string
+	options  proto.Message
+	parent   *browseNode
+	children []*browseNode
+}
+
+func (n *browseNode) add(c *browseNode) *browseNode {
+	c.parent = n
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *browseNode) path() string {
+	if n.parent == nil {
+		return "/"
+	}
+	if n.parent.parent == nil {
+		return "/" + n.name
+	}
+	return n.parent.path() + "/" + n.name
+}
+
+// optionsText formats non-empty options on one line, shortened to max characters.
+func optionsText(opts proto.Message, max int) string {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return ""
+	}
+	out, err := prototext.MarshalOptions{EmitUnknown: true}.Marshal(opts)
+	if err != nil || len(out) == 0 {
+		return ""
+	}
+	text := strings.Join(strings.Fields(string(out)), " ")
+	if len(text) > max {
+		text = text[:max] + "..."
+	}
+	return "[" + text + "]"
+}
+
+func newBrowseTree(req *pluginpb.CodeGeneratorRequest) *browseNode {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	root := &browseNode{kind: "request", detail: fmt.Sprintf("%d files, %d to generate", len(req.ProtoFile), len(req.FileToGenerate))}
+	for _, fd := range req.ProtoFile {
+		role := "dependency"
+		if generate[fd.GetName()] {
+			role = "generate"
+		}
+		file := root.add(&browseNode{name: fd.GetName(), kind: "file", detail: role + ", package " + fd.GetPackage(), options: fd.Options})
+		for _, ed := range fd.EnumType {
+			browseEnum(file, ed)
+		}
+		for _, md := range fd.MessageType {
+			browseMessage(file, md)
+		}
+		for _, sd := range fd.Service {
+			svc := file.add(&browseNode{name: sd.GetName(), kind: "service", options: sd.Options})
+			for _, m := range sd.Method {
+				in, out := strings.TrimPrefix(m.GetInputType(), "."), strings.TrimPrefix(m.GetOutputType(), ".")
+				if m.GetClientStreaming() {
+					in = "stream " + in
+				}
+				if m.GetServerStreaming() {
+					out = "stream " + out
+				}
+				svc.add(&browseNode{name: m.GetName(), kind: "method", detail: fmt.Sprintf("(%s) returns (%s)", in, out), options: m.Options})
+			}
+		}
+		for _, f := range fd.Extension {
+			file.add(&browseNode{name: f.GetName(), kind: "extension", detail: fmt.Sprintf("%s = %d extends %s", templateTypeName(f), f.GetNumber(), strings.TrimPrefix(f.GetExtendee(), ".")), options: f.Options})
+		}
+	}
+	return root
+}
+
+func browseEnum(parent *browseNode, ed *descriptorpb.EnumDescriptorProto) {
+	enum := parent.add(&browseNode{name: ed.GetName(), kind: "enum", options: ed.Options})
+	for _, v := range ed.Value {
+		enum.add(&browseNode{name: v.GetName(), kind: "value", detail: strconv.Itoa(int(v.GetNumber())), options: v.Options})
+	}
+}
+
+func browseMessage(parent *browseNode, md *descriptorpb.DescriptorProto) {
+	msg := parent.add(&browseNode{name: md.GetName(), kind: "message", options: md.Options})
+	for _, f := range md.Field {
+		msg.add(&browseNode{name: f.GetName(), kind: "field", detail: fmt.Sprintf("%s = %d", templateTypeName(f), f.GetNumber()), options: f.Options})
+	}
+	for _, ed := range md.EnumType {
+		browseEnum(msg, ed)
+	}
+	for _, nested := range md.NestedType {
+		browseMessage(msg, nested)
+	}
+}
+
+func runBrowse(args []string) error {
+	fs := newFlagSet("browse")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture browse REQUEST\n\n"+
+			"Reads commands line by line from stdin, so it can also be scripted.\n\n"+browseHelp)
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("browse needs exactly one request file")
+	}
+	req, err := readRequestFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return browse(os.Stdin, os.Stdout, req, isTerminal(os.Stdin))
+}
+
+func browse(r io.Reader, w io.Writer, req *pluginpb.CodeGeneratorRequest, prompt bool) error {
+	root := newBrowseTree(req)
+	cur := root
+	sc := bufio.NewScanner(r)
+	for {
+		if prompt {
+			fmt.Fprintf(w, "%s> ", cur.path())
+		}
+		if !sc.Scan() {
+			return sc.Err()
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case "":
+		case "ls":
+			for i, c := range cur.children {
+				fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%3d  %-9s %s  %s %s", i+1, c.kind, c.name, c.detail, optionsText(c.options, 60)), " "))
+			}
+		case "cd":
+			next := cur
+			switch arg {
+			case "..":
+				if cur.parent != nil {
+					next = cur.parent
+				}
+			case "/", "":
+				next = root
+			default:
+				next = nil
+				if n, err := strconv.Atoi(arg); err == nil && n > 0 && n <= len(cur.children) {
+					next = cur.children[n-1]
+				}
+				for _, c := range cur.children {
+					if next == nil && c.name == arg {
+						next = c
+					}
+				}
+			}
+			if next == nil {
+				fmt.Fprintf(w, "no element %s\n", arg)
+				continue
+			}
+			cur = next
+		case "show":
+			fmt.Fprintf(w, "%s %s %s\n", cur.kind, cur.path(), cur.detail)
+			if cur.options != nil && cur.options.ProtoReflect().IsValid() {
+				out, _ := prototext.MarshalOptions{Multiline: true, Indent: "  ", EmitUnknown: true}.Marshal(cur.options)
+				w.Write(out)
+			}
+		case "find":
+			if arg == "" {
+				fmt.Fprintln(w, "find needs a text")
+				continue
+			}
+			needle := strings.ToLower(arg)
+			var walk func(n *browseNode)
+			walk = func(n *browseNode) {
+				for _, c := range n.children {
+					if strings.Contains(strings.ToLower(c.name), needle) {
+						fmt.Fprintln(w, strings.TrimSpace(fmt.Sprintf("%-9s %s  %s", c.kind, c.path(), c.detail)))
+					}
+					walk(c)
+				}
+			}
+			walk(root)
+		case "gen":
+			deps := map[string][]string{}
+			for _, fd := range req.ProtoFile {
+				deps[fd.GetName()] = fd.Dependency
+			}
+			for _, name := range req.FileToGenerate {
+				fmt.Fprintf(w, "%s\n", name)
+				for _, dep := range deps[name] {
+					fmt.Fprintf(w, "    imports %s\n", dep)
+				}
+			}
+			fmt.Fprintf(w, "%d files to generate, %d dependencies\n", len(req.FileToGenerate), len(req.ProtoFile)-len(req.FileToGenerate))
+		case "help":
+			fmt.Fprint(w, browseHelp)
+		case "quit", "exit":
+			return nil
+		default:
+			fmt.Fprintf(w, "unknown command %s, try help\n", cmd)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// bufImageExtension is the number of the buf_extension field buf adds to
+// FileDescriptorProto in images, its module_info names the module of the file.
+const bufImageExtension = 8042
+
+// bufModule returns the buf module of the request or "" if it is unknown.
+// It is taken from a buf_module parameter set with opt in buf.gen.yaml or from the
+// image extension of the files to generate. module= is left to protoc-gen-go.
+func bufModule(req *pluginpb.CodeGeneratorRequest) string {
+	for _, p := range strings.Split(req.GetParameter(), ",") {
+		k, v, ok := strings.Cut(p, "=")
+		if ok && k == "buf_module" && v != "" {
+			return v
+		}
+	}
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	for _, fd := range req.ProtoFile {
+		if !generate[fd.GetName()] {
+			continue
+		}
+		ext := wireBytes(fd.ProtoReflect().GetUnknown(), bufImageExtension)
+		// ImageFileExtension.module_info.name
+		name := wireBytes(wireBytes(ext, 2), 1)
+		if name == nil {
+			continue
+		}
+		var parts []string
+		// remote, owner and repository
+		for _, num := range []protowire.Number{1, 2, 3} {
+			if s := wireBytes(name, num); len(s) > 0 {
+				parts = append(parts, string(s))
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, "/")
+		}
+	}
+	return ""
+}
+
+// wireBytes returns the last value of the length delimited field num in b.
+func wireBytes(b []byte, num protowire.Number) []byte {
+	var found []byte
+	for len(b) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return found
+		}
+		b = b[tagLen:]
+		if n == num && typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return found
+			}
+			found = v
+			b = b[m:]
+			continue
+		}
+		m := protowire.ConsumeFieldValue(n, typ, b)
+		if m < 0 {
+			return found
+		}
+		b = b[m:]
+	}
+	return found
+}
+
+// bufModuleName converts a module into a part of a file name.
+func bufModuleName(module string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, module)
+}
+
+// bufFileName inserts the module into a capture file name like out.proto.msg.
+func bufFileName(file, module string) string {
+	if module == "" {
+		return file
+	}
+	if i := strings.IndexByte(file, '.'); i > 0 {
+		return file[:i] + "." + bufModuleName(module) + file[i:]
+	}
+	return file + "." + bufModuleName(module)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("canonicalize", nil, func(_ *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		canonicalize(req)
+		return nil
+	})
+}
+
+// canonicalize makes requests for the same schema comparable.
+// Path separators are normalized to slashes, files_to_generate is sorted and
+// proto_file is ordered topologically by dependency, ties are broken by name.
+func canonicalize(req *pluginpb.CodeGeneratorRequest) {
+	slash := func(s string) string {
+		return strings.ReplaceAll(s, `\`, "/")
+	}
+	for i, name := range req.FileToGenerate {
+		req.FileToGenerate[i] = slash(name)
+	}
+	sort.Strings(req.FileToGenerate)
+	for _, fd := range req.ProtoFile {
+		fd.Name = proto.String(slash(fd.GetName()))
+		for i, dep := range fd.Dependency {
+			fd.Dependency[i] = slash(dep)
+		}
+	}
+	req.ProtoFile = sortFiles(req.ProtoFile)
+}
+
+// sortFiles orders files so dependencies come first, else by name.
+// Dependencies missing in files are ignored.
+func sortFiles(files []*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range files {
+		byName[fd.GetName()] = fd
+	}
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for _, fd := range files {
+		for _, dep := range fd.Dependency {
+			if _, ok := byName[dep]; ok {
+				pending[fd.GetName()]++
+				dependents[dep] = append(dependents[dep], fd.GetName())
+			}
+		}
+	}
+	var ready []string
+	for name := range byName {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	sorted := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, byName[name])
+		for _, d := range dependents[name] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(sorted) < len(byName) {
+		// import cycle or duplicate names, keep the rest in name order
+		var rest []*descriptorpb.FileDescriptorProto
+		done := map[string]bool{}
+		for _, fd := range sorted {
+			done[fd.GetName()] = true
+		}
+		for _, fd := range files {
+			if !done[fd.GetName()] {
+				rest = append(rest, fd)
+			}
+		}
+		sort.SliceStable(rest, func(i, j int) bool {
+			return rest[i].GetName() < rest[j].GetName()
+		})
+		sorted = append(sorted, rest...)
+	}
+	return sorted
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Cache keeps linked files and their types by a hash of their content and of their imports,
+// so files repeated across many requests are only built once. It is safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	maxFiles int
+	byKey    map[cacheKey]*cachedFile
+	byFile   map[protoreflect.FileDescriptor]*cachedFile
+	hits     int
+}
+
+type cacheKey [sha256.Size]byte
+
+type cachedFile struct {
+	fd    protoreflect.FileDescriptor
+	once  sync.Once
+	types *declaredTypes
+}
+
+// NewCache returns a cache dropping all entries when it holds more than maxFiles files,
+// maxFiles <= 0 does not limit it.
+func NewCache(maxFiles int) *Cache {
+	c := &Cache{maxFiles: maxFiles}
+	c.reset()
+	return c
+}
+
+func (c *Cache) reset() {
+	c.byKey = map[cacheKey]*cachedFile{}
+	c.byFile = map[protoreflect.FileDescriptor]*cachedFile{}
+}
+
+// Stats returns the number of cached files and how often a file was found in the cache.
+func (c *Cache) Stats() (files, hits int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.byKey), c.hits
+}
+
+// fileKey hashes the content of fd and the keys of its imports in keys.
+func fileKey(opts protodesc.FileOptions, fd *descriptorpb.FileDescriptorProto, keys map[string]cacheKey) (cacheKey, error) {
+	content, err := proto.MarshalOptions{Deterministic: true}.Marshal(fd)
+	if err != nil {
+		return cacheKey{}, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%t\x00", opts.AllowUnresolvable)
+	h.Write(content)
+	for _, dep := range fd.Dependency {
+		depKey, ok := keys[dep]
+		if !ok {
+			// missing imports only link with AllowUnresolvable
+			fmt.Fprintf(h, "\x00missing %s", dep)
+			continue
+		}
+		h.Write(depKey[:])
+	}
+	var key cacheKey
+	copy(key[:], h.Sum(nil))
+	return key, nil
+}
+
+// file returns the cached file for key or builds and caches it.
+func (c *Cache) file(key cacheKey, build func() (protoreflect.FileDescriptor, error)) (protoreflect.FileDescriptor, error) {
+	c.mu.Lock()
+	if cf := c.byKey[key]; cf != nil {
+		c.hits++
+		c.mu.Unlock()
+		return cf.fd, nil
+	}
+	c.mu.Unlock()
+	// concurrent misses build the file twice, the first one is kept
+	f, err := build()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cf := c.byKey[key]; cf != nil {
+		return cf.fd, nil
+	}
+	if c.maxFiles > 0 && len(c.byKey) >= c.maxFiles {
+		c.reset()
+	}
+	cf := &cachedFile{fd: f}
+	c.byKey[key] = cf
+	c.byFile[f] = cf
+	return f, nil
+}
+
+// types returns the types declared in f, built once for cached files.
+func (c *Cache) types(f protoreflect.FileDescriptor) *declaredTypes {
+	c.mu.Lock()
+	cf := c.byFile[f]
+	c.mu.Unlock()
+	if cf == nil {
+		return newDeclaredTypes(f)
+	}
+	cf.once.Do(func() {
+		cf.types = newDeclaredTypes(f)
+	})
+	return cf.types
+}
@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// A capture container is the magic, a varint length prefixed json
+// encoded metadata object and the serialized message as payload.
+// Field number 0 is invalid in proto, so the leading zero byte
+// can not be confused with a raw message.
+var containerMagic = []byte("\x00PGC")
+
+// ContainerVersion is the newest container version, it is stored in the
+// version field of the metadata. Newer containers are rejected.
+const ContainerVersion = 1
+
+// IsContainer reports whether bin is a capture container.
+func IsContainer(bin []byte) bool {
+	return bytes.HasPrefix(bin, containerMagic)
+}
+
+// WrapContainer prepends the container header with the json metadata meta to payload.
+func WrapContainer(meta, payload []byte) []byte {
+	out := make([]byte, 0, len(containerMagic)+binary.MaxVarintLen64+len(meta)+len(payload))
+	out = append(out, containerMagic...)
+	var prefix [binary.MaxVarintLen64]byte
+	out = append(out, prefix[:binary.PutUvarint(prefix[:], uint64(len(meta)))]...)
+	out = append(out, meta...)
+	return append(out, payload...)
+}
+
+// UnwrapContainer returns the json metadata and payload of a container
+// after checking its version is supported.
+// Other input is returned as payload without metadata.
+func UnwrapContainer(bin []byte) (meta, payload []byte, err error) {
+	if !IsContainer(bin) {
+		return nil, bin, nil
+	}
+	rest := bin[len(containerMagic):]
+	size, n := binary.Uvarint(rest)
+	if n <= 0 || uint64(len(rest)-n) < size {
+		return nil, nil, fmt.Errorf("capture container header is truncated")
+	}
+	meta = rest[n : n+int(size)]
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(meta, &header); err != nil {
+		return nil, nil, fmt.Errorf("capture container metadata is invalid: %v", err)
+	}
+	if header.Version > ContainerVersion {
+		return nil, nil, fmt.Errorf("capture container version %d is not supported, update protoc-gen-capture", header.Version)
+	}
+	return meta, rest[n+int(size):], nil
+}
@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// link builds files like opts.NewFiles, level by level of the import graph.
+// The files of a level only import files of lower levels and are built concurrently.
+func link(opts protodesc.FileOptions, files []*descriptorpb.FileDescriptorProto, cache *Cache) (*protoregistry.Files, error) {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range files {
+		if byName[fd.GetName()] != nil {
+			return nil, fmt.Errorf("file %q is declared more than once", fd.GetName())
+		}
+		byName[fd.GetName()] = fd
+	}
+	// the level of a file is one more than the highest level of its imports
+	level := map[string]int{}
+	visiting := map[string]bool{}
+	var levelOf func(fd *descriptorpb.FileDescriptorProto) (int, error)
+	levelOf = func(fd *descriptorpb.FileDescriptorProto) (int, error) {
+		name := fd.GetName()
+		if l, ok := level[name]; ok {
+			return l, nil
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("import cycle in file %q", name)
+		}
+		visiting[name] = true
+		l := 0
+		for _, dep := range fd.Dependency {
+			if byName[dep] == nil {
+				continue
+			}
+			dl, err := levelOf(byName[dep])
+			if err != nil {
+				return 0, err
+			}
+			if dl+1 > l {
+				l = dl + 1
+			}
+		}
+		level[name] = l
+		return l, nil
+	}
+	var levels [][]*descriptorpb.FileDescriptorProto
+	for _, fd := range files {
+		l, err := levelOf(fd)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], fd)
+	}
+
+	reg := &protoregistry.Files{}
+	keys := map[string]cacheKey{}
+	for _, batch := range levels {
+		built := make([]protoreflect.FileDescriptor, len(batch))
+		builtKeys := make([]cacheKey, len(batch))
+		errs := make([]error, len(batch))
+		// reg and keys are only read until the level is built
+		parallel(len(batch), func(i int) {
+			fd := batch[i]
+			if cache == nil {
+				built[i], errs[i] = opts.New(fd, reg)
+				return
+			}
+			if builtKeys[i], errs[i] = fileKey(opts, fd, keys); errs[i] != nil {
+				return
+			}
+			built[i], errs[i] = cache.file(builtKeys[i], func() (protoreflect.FileDescriptor, error) {
+				return opts.New(fd, reg)
+			})
+		})
+		for i, f := range built {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			if err := reg.RegisterFile(f); err != nil {
+				return nil, err
+			}
+			keys[batch[i].GetName()] = builtKeys[i]
+		}
+	}
+	return reg, nil
+}
+
+// parallel calls fn for each i in [0, n), concurrently if there are enough calls to make it worth it.
+func parallel(n int, fn func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	if n < 16 || workers < 2 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// ReadRequest reads a request captured by protoc-gen-capture from the file name.
+// The capture can be binary, json or binary in a capture container. Extensions
+// are resolved with protoregistry.GlobalTypes like protogen does.
+func ReadRequest(name string) (*pluginpb.CodeGeneratorRequest, error) {
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	switch trimmed := bytes.TrimSpace(raw); {
+	case IsContainer(raw):
+		var payload []byte
+		if _, payload, err = UnwrapContainer(raw); err == nil {
+			err = proto.Unmarshal(payload, req)
+		}
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		// extensions not linked into the program can not be used by plugins anyway
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(trimmed, req)
+	default:
+		err = proto.Unmarshal(raw, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return req, nil
+}
+
+// NewPlugin returns a protogen plugin for the request captured in the file name,
+// so generators can be tested against captures:
+//
+//	gen, err := capture.NewPlugin("testdata/out.proto.msg", protogen.Options{})
+func NewPlugin(name string, opts protogen.Options) (*protogen.Plugin, error) {
+	req, err := ReadRequest(name)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := opts.New(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return gen, nil
+}
+
+// Run runs fn on the request captured in the file name like protogen.Options.Run
+// and returns the response instead of writing it to stdout.
+// An error of fn is reported in the error of the response.
+func Run(name string, opts protogen.Options, fn func(*protogen.Plugin) error) (*pluginpb.CodeGeneratorResponse, error) {
+	gen, err := NewPlugin(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(gen); err != nil {
+		gen.Error(err)
+	}
+	return gen.Response(), nil
+}
@@ -0,0 +1,216 @@
+// Package capture builds type registries from the descriptors of code generator requests
+// and descriptor sets, so messages of the described types can be decoded with dynamicpb
+// and options and extensions resolve. It also feeds captured requests into protogen plugins.
+package capture
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	// register the well-known types in protoregistry.GlobalFiles
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/apipb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/sourcecontextpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/typepb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Options configure how registries are built.
+type Options struct {
+	// AllowUnresolvable accepts files with missing imports,
+	// references into them stay unresolved.
+	AllowUnresolvable bool
+	// WellKnownTypes adds the well-known types and descriptor.proto if they are missing.
+	WellKnownTypes bool
+	// Global adds the types of protoregistry.GlobalTypes not declared by the files.
+	Global bool
+	// Cache reuses files and types already built for identical files, if it is set.
+	Cache *Cache
+}
+
+// editionsSyntax is the syntax of files using editions.
+const editionsSyntax = "editions"
+
+// wellKnownFiles are added with Options.WellKnownTypes.
+var wellKnownFiles = []string{
+	"google/protobuf/any.proto",
+	"google/protobuf/api.proto",
+	"google/protobuf/descriptor.proto",
+	"google/protobuf/duration.proto",
+	"google/protobuf/empty.proto",
+	"google/protobuf/field_mask.proto",
+	"google/protobuf/source_context.proto",
+	"google/protobuf/struct.proto",
+	"google/protobuf/timestamp.proto",
+	"google/protobuf/type.proto",
+	"google/protobuf/wrappers.proto",
+}
+
+// NewTypesFromRequest returns a registry of the enums, messages and extensions
+// declared in the files of req.
+func NewTypesFromRequest(req *pluginpb.CodeGeneratorRequest, opts Options) (*protoregistry.Types, error) {
+	return NewTypes(req.ProtoFile, opts)
+}
+
+// NewTypesFromFDS returns a registry of the enums, messages and extensions
+// declared in the files of fds, e.g. written by protoc -o.
+func NewTypesFromFDS(fds *descriptorpb.FileDescriptorSet, opts Options) (*protoregistry.Types, error) {
+	return NewTypes(fds.File, opts)
+}
+
+// NewTypes returns a registry of the enums, messages and extensions declared in files.
+func NewTypes(files []*descriptorpb.FileDescriptorProto, opts Options) (*protoregistry.Types, error) {
+	reg, err := NewFiles(files, opts)
+	if err != nil {
+		return nil, err
+	}
+	var fds []protoreflect.FileDescriptor
+	reg.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		fds = append(fds, f)
+		return true
+	})
+	declared := make([]*declaredTypes, len(fds))
+	parallel(len(fds), func(i int) {
+		if opts.Cache != nil {
+			declared[i] = opts.Cache.types(fds[i])
+		} else {
+			declared[i] = newDeclaredTypes(fds[i])
+		}
+	})
+	types := &protoregistry.Types{}
+	for _, dt := range declared {
+		if err := dt.register(types); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Global {
+		addGlobal(types)
+	}
+	return types, nil
+}
+
+// NewFiles links files into a registry of file descriptors.
+// Editions files are declared as proto2: the protobuf module used here can not
+// build them, but resolving option extensions only needs their declarations.
+func NewFiles(files []*descriptorpb.FileDescriptorProto, opts Options) (*protoregistry.Files, error) {
+	present := map[string]bool{}
+	linked := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	for _, fd := range files {
+		present[fd.GetName()] = true
+		if fd.GetSyntax() == editionsSyntax {
+			fd = proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+			fd.Syntax = proto.String("proto2")
+		}
+		linked = append(linked, fd)
+	}
+	if opts.WellKnownTypes {
+		for _, name := range wellKnownFiles {
+			if present[name] {
+				continue
+			}
+			if f, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+				linked = append(linked, protodesc.ToFileDescriptorProto(f))
+			}
+		}
+	}
+	return link(protodesc.FileOptions{AllowUnresolvable: opts.AllowUnresolvable}, linked, opts.Cache)
+}
+
+// declaredTypes are the dynamic types declared in a file, including nested ones.
+type declaredTypes struct {
+	enums      []protoreflect.EnumType
+	messages   []protoreflect.MessageType
+	extensions []protoreflect.ExtensionType
+}
+
+func newDeclaredTypes(f protoreflect.FileDescriptor) *declaredTypes {
+	dt := &declaredTypes{}
+	dt.addEnums(f.Enums())
+	dt.addExtensions(f.Extensions())
+	dt.addMessages(f.Messages())
+	return dt
+}
+
+func (dt *declaredTypes) addEnums(d protoreflect.EnumDescriptors) {
+	for i, max := 0, d.Len(); i < max; i++ {
+		dt.enums = append(dt.enums, dynamicpb.NewEnumType(d.Get(i)))
+	}
+}
+
+func (dt *declaredTypes) addExtensions(d protoreflect.ExtensionDescriptors) {
+	for i, max := 0, d.Len(); i < max; i++ {
+		ext := d.Get(i)
+		if extTypeDesc, ok := ext.(protoreflect.ExtensionTypeDescriptor); ok {
+			dt.extensions = append(dt.extensions, extTypeDesc.Type())
+			continue
+		}
+		dt.extensions = append(dt.extensions, dynamicpb.NewExtensionType(ext))
+	}
+}
+
+func (dt *declaredTypes) addMessages(d protoreflect.MessageDescriptors) {
+	for i, max := 0, d.Len(); i < max; i++ {
+		m := d.Get(i)
+		dt.messages = append(dt.messages, dynamicpb.NewMessageType(m))
+		// add inner types
+		dt.addEnums(m.Enums())
+		dt.addExtensions(m.Extensions())
+		dt.addMessages(m.Messages())
+	}
+}
+
+func (dt *declaredTypes) register(types *protoregistry.Types) error {
+	for _, et := range dt.enums {
+		if err := types.RegisterEnum(et); err != nil {
+			return err
+		}
+	}
+	for _, mt := range dt.messages {
+		if err := types.RegisterMessage(mt); err != nil {
+			return err
+		}
+	}
+	for _, xt := range dt.extensions {
+		if err := types.RegisterExtension(xt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addGlobal adds the types of protoregistry.GlobalTypes, the types of the files take precedence.
+// Conflicting global types are skipped.
+func addGlobal(types *protoregistry.Types) {
+	protoregistry.GlobalTypes.RangeEnums(func(et protoreflect.EnumType) bool {
+		if _, err := types.FindEnumByName(et.Descriptor().FullName()); err == protoregistry.NotFound {
+			_ = types.RegisterEnum(et)
+		}
+		return true
+	})
+	protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+		if _, err := types.FindMessageByName(mt.Descriptor().FullName()); err == protoregistry.NotFound {
+			_ = types.RegisterMessage(mt)
+		}
+		return true
+	})
+	protoregistry.GlobalTypes.RangeExtensions(func(xt protoreflect.ExtensionType) bool {
+		xd := xt.TypeDescriptor()
+		if _, err := types.FindExtensionByNumber(xd.ContainingMessage().FullName(), xd.Number()); err != protoregistry.NotFound {
+			return true
+		}
+		if _, err := types.FindExtensionByName(xd.FullName()); err == protoregistry.NotFound {
+			_ = types.RegisterExtension(xt)
+		}
+		return true
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("list", "print the index of a capture directory", runList)
+}
+
+// captureIndex is the name of the index file in a capture directory.
+// It contains one json encoded captureEntry per line.
+const captureIndex = "index.jsonl"
+
+// captureEntry describes a capture stored in a capture directory.
+type captureEntry struct {
+	File            string    `json:"file"`
+	Time            time.Time `json:"time"`
+	CompilerVersion string    `json:"compiler_version,omitempty"`
+	// Module is the buf module of the request if it is known
+	Module          string   `json:"module,omitempty"`
+	Parameter       string   `json:"parameter,omitempty"`
+	Files           int      `json:"files"`
+	FilesToGenerate []string `json:"files_to_generate"`
+	// Bytes and SHA256 describe the serialized request without container
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+func compilerVersion(req *pluginpb.CodeGeneratorRequest) string {
+	v := req.GetCompilerVersion()
+	if v == nil {
+		return ""
+	}
+	s := fmt.Sprintf("%d.%d.%d", v.GetMajor(), v.GetMinor(), v.GetPatch())
+	if v.GetSuffix() != "" {
+		s += "-" + v.GetSuffix()
+	}
+	return s
+}
+
+// newCaptureEntry describes the serialized request raw captured at t, without file name.
+func newCaptureEntry(t time.Time, raw []byte, req *pluginpb.CodeGeneratorRequest, module string) *captureEntry {
+	sum := sha256.Sum256(raw)
+	return &captureEntry{
+		Time:            t,
+		CompilerVersion: compilerVersion(req),
+		Module:          module,
+		Parameter:       req.GetParameter(),
+		Files:           len(req.ProtoFile),
+		FilesToGenerate: req.FileToGenerate,
+		Bytes:           len(raw),
+		SHA256:          hex.EncodeToString(sum[:]),
+	}
+}
+
+// storeCapture writes the raw request into dir under a timestamped name
+// and appends it to the index. Unless meta is nil, it is stored in a container.
+// A non-empty buf module is added to the name.
+func storeCapture(dir string, raw []byte, req *pluginpb.CodeGeneratorRequest, meta *captureMeta, module string) (*captureEntry, error) {
+	now := time.Now().UTC()
+	e := newCaptureEntry(now, raw, req, module)
+	e.File = now.Format("20060102T150405.000000000Z") + "-" + e.SHA256[:12] + ".proto.msg"
+	if module != "" {
+		e.File = now.Format("20060102T150405.000000000Z") + "-" + bufModuleName(module) + "-" + e.SHA256[:12] + ".proto.msg"
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	data := raw
+	if meta != nil {
+		meta.Time = now
+		var err error
+		if data, err = wrapContainer(meta, raw); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, e.File), data, 0o644); err != nil {
+		return nil, fmt.Errorf("capture could not be stored: %v", err)
+	}
+	if err := appendToIndex(dir, []*captureEntry{e}); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// appendToIndex adds entries to the index of dir.
+func appendToIndex(dir string, entries []*captureEntry) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	// a single append is atomic enough for concurrent protoc runs
+	f, err := os.OpenFile(filepath.Join(dir, captureIndex), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("capture index could not be opened: %v", err)
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("capture index could not be written: %v", err)
+	}
+	return nil
+}
+
+// readIndex reads the index of a capture directory.
+func readIndex(dir string) ([]captureEntry, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, captureIndex))
+	if err != nil {
+		return nil, fmt.Errorf("capture index could not be read: %v", err)
+	}
+	var entries []captureEntry
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	sc.Buffer(nil, len(raw)+1)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e captureEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", captureIndex, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func runList(args []string) error {
+	jsonOut := false
+	fs := newFlagSet("list")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture list [ARGUMENTS] CAPTURE-DIR\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("list needs exactly one capture directory")
+	}
+	dir, err := localCaptureDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	entries, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(entries)
+	}
+	return writeIndexTable(os.Stdout, entries)
+}
+
+func writeIndexTable(w io.Writer, entries []captureEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "TIME\tFILE\tPROTOC\tFILES\tBYTES\tPARAMETER\tGENERATE\n")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			e.Time.Format(time.RFC3339), e.File, e.CompilerVersion, e.Files, e.Bytes, e.Parameter, strings.Join(e.FilesToGenerate, " "))
+	}
+	return tw.Flush()
+}
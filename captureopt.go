@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// captureOptions are the options of protoc-gen-capture itself in the parameter,
+// like --capture_opt=capture_dir=go,capture_name={{.Plugin}}-{{.Hash}}.msg.
+// They are removed from the captured parameter so the request can be replayed.
+type captureOptions struct {
+	// dir is a directory inside the output directory of protoc
+	dir string
+	// name is the template of the file name
+	name   *template.Template
+	plugin string
+}
+
+// captureName is the data of the capture_name template.
+type captureName struct {
+	// Plugin is set with capture_plugin, it defaults to capture
+	Plugin string
+	// Hash is the start of SHA256
+	Hash   string
+	SHA256 string
+	// Time is the UTC time like 20060102T150405Z
+	Time   string
+	Module string
+}
+
+// takeCaptureOptions removes the capture_ options from the parameter of req and returns them,
+// it returns nil if there are none.
+func takeCaptureOptions(req *pluginpb.CodeGeneratorRequest) (*captureOptions, error) {
+	if !strings.Contains(req.GetParameter(), "capture_") {
+		return nil, nil
+	}
+	var opts *captureOptions
+	var kept []string
+	for _, p := range strings.Split(req.GetParameter(), ",") {
+		k, v, _ := strings.Cut(p, "=")
+		if !strings.HasPrefix(k, "capture_") {
+			kept = append(kept, p)
+			continue
+		}
+		if opts == nil {
+			opts = &captureOptions{plugin: "capture"}
+		}
+		switch k {
+		case "capture_dir":
+			if v != "" && (path.IsAbs(v) || path.Clean(v) != v || v == ".." || strings.HasPrefix(v, "../")) {
+				return nil, fmt.Errorf("capture_dir %q must be a clean relative path inside the output directory", v)
+			}
+			opts.dir = v
+		case "capture_name":
+			tmpl, err := template.New("capture_name").Option("missingkey=error").Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("capture_name: %v", err)
+			}
+			opts.name = tmpl
+		case "capture_plugin":
+			opts.plugin = v
+		default:
+			return nil, fmt.Errorf("unknown option %s, want capture_dir, capture_name or capture_plugin", k)
+		}
+	}
+	if opts == nil {
+		return nil, nil
+	}
+	if len(kept) == 0 {
+		req.Parameter = nil
+	} else {
+		req.Parameter = proto.String(strings.Join(kept, ","))
+	}
+	return opts, nil
+}
+
+// fileName returns the name of the capture with content out inside the output directory,
+// file is used if there is no name template.
+func (co *captureOptions) fileName(file string, out []byte, module string) (string, error) {
+	name := file
+	if co.name != nil {
+		sum := sha256.Sum256(out)
+		data := captureName{
+			Plugin: co.plugin,
+			Hash:   hex.EncodeToString(sum[:6]),
+			SHA256: hex.EncodeToString(sum[:]),
+			Time:   time.Now().UTC().Format("20060102T150405Z"),
+			Module: bufModuleName(module),
+		}
+		var buf bytes.Buffer
+		if err := co.name.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("capture_name: %v", err)
+		}
+		name = buf.String()
+	}
+	name = path.Join(co.dir, name)
+	if name == "." || path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("capture name %q must be a clean relative path inside the output directory", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,1400 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// This is an interpreter for the subset of CEL (https://github.com/google/cel-spec)
+// used to select and rewrite descriptors: literals, lists and maps, field selection,
+// has(), indexing, the operators, the conditional, the macros all, exists, exists_one,
+// map and filter, size, matches, contains, startsWith, endsWith, conversions and the
+// lowerAscii, upperAscii, replace, split, join and trim string functions.
+// Expressions are checked dynamically when they are evaluated, enum values can be
+// named by their qualified name and message construction is not supported.
+// Values are nil, bool, int64, uint64, float64, string, []byte, []interface{},
+// map[interface{}]interface{} and protoreflect.Message, enums are int64.
+
+// celExpr is a compiled expression.
+type celExpr func(s *celScope) (interface{}, error)
+
+// celScope binds the variables of an expression.
+type celScope struct {
+	name   string
+	value  interface{}
+	parent *celScope
+}
+
+func (s *celScope) bind(name string, value interface{}) *celScope {
+	return &celScope{name, value, s}
+}
+
+func (s *celScope) lookup(name string) (interface{}, bool) {
+	for ; s != nil; s = s.parent {
+		if s.name == name {
+			return s.value, true
+		}
+	}
+	return nil, false
+}
+
+type celTokenKind int
+
+const (
+	celEOF celTokenKind = iota
+	celIdent
+	celLiteral
+	celSymbol
+)
+
+type celToken struct {
+	kind  celTokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t celToken) String() string {
+	if t.kind == celEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// celSyntaxError aborts parsing, it is recovered by compileCEL.
+type celSyntaxError struct{ err error }
+
+// celParser compiles tokens to closures, bound are the variables in scope.
+type celParser struct {
+	src   string
+	toks  []celToken
+	pos   int
+	bound map[string]int
+}
+
+// compileCEL parses src with the variables vars.
+func compileCEL(src string, vars ...string) (expr celExpr, err error) {
+	p := &celParser{src: src, bound: map[string]int{}}
+	for _, v := range vars {
+		p.bound[v]++
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(celSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			expr, err = nil, se.err
+		}
+	}()
+	p.toks = p.lex()
+	expr = p.parseExpr()
+	if t := p.peek(); t.kind != celEOF {
+		p.failf(t, "unexpected %s", t)
+	}
+	return expr, nil
+}
+
+func (p *celParser) failf(t celToken, format string, args ...interface{}) {
+	line, col := 1+strings.Count(p.src[:t.pos], "\n"), 1+t.pos-(strings.LastIndexByte(p.src[:t.pos], '\n')+1)
+	panic(celSyntaxError{fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))})
+}
+
+func (p *celParser) lex() []celToken {
+	var toks []celToken
+	src := p.src
+	for i := 0; ; {
+		for i < len(src) {
+			if c := src[i]; c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v' {
+				i++
+			} else if strings.HasPrefix(src[i:], "//") {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i == len(src) {
+			return append(toks, celToken{kind: celEOF, pos: i})
+		}
+		t := celToken{pos: i}
+		c := src[i]
+		// string and bytes literals with r and b prefixes
+		mods, quoted := "", false
+		end := i + 3
+		if end > len(src) {
+			end = len(src)
+		}
+		for _, m := range []string{"rb", "br", "r", "b", ""} {
+			if head := strings.ToLower(src[i:end]); strings.HasPrefix(head, m+`"`) || strings.HasPrefix(head, m+"'") {
+				mods, quoted = m, true
+				break
+			}
+		}
+		if quoted {
+			j := i + len(mods)
+			var s string
+			var n int
+			if strings.Contains(mods, "r") {
+				end := strings.IndexAny(src[j+1:], string(src[j])+"\n")
+				if end < 0 || src[j+1+end] == '\n' {
+					p.failf(t, "unterminated string literal")
+				}
+				s, n = src[j+1:j+1+end], end+2
+			} else {
+				var err error
+				if s, n, err = unquoteProto(src[j:]); err != nil {
+					p.failf(t, "%v", err)
+				}
+			}
+			t.kind, t.text = celLiteral, src[i:j+n]
+			if strings.Contains(mods, "b") {
+				t.value = []byte(s)
+			} else if !utf8.ValidString(s) {
+				p.failf(t, "string literal is not valid utf-8")
+			} else {
+				t.value = s
+			}
+			toks = append(toks, t)
+			i = j + n
+			continue
+		}
+		switch {
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && isIdentPart(src[j]) {
+				j++
+			}
+			t.kind, t.text = celIdent, src[i:j]
+			switch t.text {
+			case "true", "false":
+				t.kind, t.value = celLiteral, t.text == "true"
+			case "null":
+				t.kind = celLiteral
+			case "in":
+				t.kind = celSymbol
+			}
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])) || celNegativeInt(src[i:], toks):
+			// a minus is part of int literals so the smallest int64 can be written
+			sign := 0
+			if c == '-' {
+				sign = 1
+			}
+			n, float := scanNumber(src[i+sign:])
+			t.kind, t.text = celLiteral, src[i:i+sign+n]
+			var err error
+			switch {
+			case float:
+				t.value, err = strconv.ParseFloat(t.text, 64)
+			case i+n < len(src) && (src[i+n] == 'u' || src[i+n] == 'U'):
+				t.value, err = celParseUint(t.text)
+				t.text = src[i : i+n+1]
+				n++
+			default:
+				var u uint64
+				u, err = celParseUint(t.text[sign:])
+				switch {
+				case err != nil:
+				case sign == 1 && u <= 1<<63:
+					t.value = -int64(u-1) - 1
+				case u > math.MaxInt64:
+					err = fmt.Errorf("out of range")
+				default:
+					t.value = int64(u)
+				}
+			}
+			if err != nil {
+				p.failf(t, "invalid number %s", t.text)
+			}
+			i += sign + n
+		default:
+			t.kind = celSymbol
+			for _, op := range []string{"||", "&&", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]", "{", "}"} {
+				if strings.HasPrefix(src[i:], op) {
+					t.text = op
+					break
+				}
+			}
+			if t.text == "" {
+				p.failf(t, "unexpected character %q", c)
+			}
+			i += len(t.text)
+		}
+		toks = append(toks, t)
+	}
+}
+
+// celNegativeInt reports whether src starts with a negative int literal, a minus
+// directly followed by digits which is no subtraction because toks ends with an operator.
+func celNegativeInt(src string, toks []celToken) bool {
+	if len(src) < 2 || src[0] != '-' || !isDigit(src[1]) {
+		return false
+	}
+	n, float := scanNumber(src[1:])
+	if float || (1+n < len(src) && (src[1+n] == 'u' || src[1+n] == 'U')) {
+		return false
+	}
+	if len(toks) == 0 {
+		return true
+	}
+	switch last := toks[len(toks)-1]; last.kind {
+	case celIdent, celLiteral:
+		return false
+	default:
+		return last.text != ")" && last.text != "]" && last.text != "}"
+	}
+}
+
+// celParseUint parses a decimal or hexadecimal integer literal.
+func celParseUint(s string) (uint64, error) {
+	if len(s) > 2 && (s[1] == 'x' || s[1] == 'X') {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func (p *celParser) peek() celToken {
+	return p.toks[p.pos]
+}
+
+func (p *celParser) next() celToken {
+	t := p.toks[p.pos]
+	if t.kind != celEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *celParser) is(text string) bool {
+	t := p.peek()
+	return t.kind == celSymbol && t.text == text
+}
+
+func (p *celParser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *celParser) expect(text string) {
+	if !p.accept(text) {
+		p.failf(p.peek(), "expected %q, got %s", text, p.peek())
+	}
+}
+
+func (p *celParser) ident() string {
+	t := p.next()
+	if t.kind != celIdent {
+		p.failf(t, "expected identifier, got %s", t)
+	}
+	return t.text
+}
+
+func (p *celParser) parseExpr() celExpr {
+	cond := p.parseOr()
+	if !p.accept("?") {
+		return cond
+	}
+	then := p.parseOr()
+	p.expect(":")
+	els := p.parseExpr()
+	return func(s *celScope) (interface{}, error) {
+		c, err := cond(s)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := c.(bool)
+		if !ok {
+			return nil, celNoOverload("?:", c)
+		}
+		if b {
+			return then(s)
+		}
+		return els(s)
+	}
+}
+
+func (p *celParser) parseOr() celExpr {
+	expr := p.parseAnd()
+	for p.accept("||") {
+		expr = celLogical(expr, p.parseAnd(), true)
+	}
+	return expr
+}
+
+func (p *celParser) parseAnd() celExpr {
+	expr := p.parseRelation()
+	for p.accept("&&") {
+		expr = celLogical(expr, p.parseRelation(), false)
+	}
+	return expr
+}
+
+// celLogical is || for or, else &&. Like CEL, a decisive operand wins over an error in the other.
+func celLogical(left, right celExpr, or bool) celExpr {
+	op := "&&"
+	if or {
+		op = "||"
+	}
+	return func(s *celScope) (interface{}, error) {
+		l, lerr := left(s)
+		if lb, ok := l.(bool); lerr == nil && ok && lb == or {
+			return or, nil
+		} else if lerr == nil && !ok {
+			lerr = celNoOverload(op, l)
+		}
+		r, rerr := right(s)
+		if rb, ok := r.(bool); rerr == nil && ok && rb == or {
+			return or, nil
+		} else if rerr == nil && !ok {
+			rerr = celNoOverload(op, r)
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		return !or, nil
+	}
+}
+
+func (p *celParser) parseRelation() celExpr {
+	expr := p.parseAddition()
+	for {
+		t := p.peek()
+		switch {
+		case t.kind != celSymbol:
+			return expr
+		case t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">=" || t.text == "in":
+			p.next()
+			expr = celBinary(t.text, expr, p.parseAddition())
+		default:
+			return expr
+		}
+	}
+}
+
+func (p *celParser) parseAddition() celExpr {
+	expr := p.parseMultiplication()
+	for p.is("+") || p.is("-") {
+		op := p.next().text
+		expr = celBinary(op, expr, p.parseMultiplication())
+	}
+	return expr
+}
+
+func (p *celParser) parseMultiplication() celExpr {
+	expr := p.parseUnary()
+	for p.is("*") || p.is("/") || p.is("%") {
+		op := p.next().text
+		expr = celBinary(op, expr, p.parseUnary())
+	}
+	return expr
+}
+
+func (p *celParser) parseUnary() celExpr {
+	if p.is("!") || p.is("-") {
+		op := p.next().text
+		operand := p.parseUnary()
+		return func(s *celScope) (interface{}, error) {
+			v, err := operand(s)
+			if err != nil {
+				return nil, err
+			}
+			return celUnary(op, v)
+		}
+	}
+	expr, _ := p.parseMember()
+	return expr
+}
+
+// celSelection is a field selection for has().
+type celSelection struct {
+	operand celExpr
+	field   string
+}
+
+// parseMember parses a primary expression with selections, calls and indexes.
+// The last field selection is returned if nothing follows it.
+func (p *celParser) parseMember() (celExpr, *celSelection) {
+	expr := p.parsePrimary()
+	var sel *celSelection
+	for {
+		switch {
+		case p.accept("."):
+			t := p.peek()
+			name := p.ident()
+			if p.is("(") {
+				expr, sel = p.parseCall(t, expr, name), nil
+				continue
+			}
+			operand := expr
+			sel = &celSelection{operand, name}
+			expr = func(s *celScope) (interface{}, error) {
+				v, err := operand(s)
+				if err != nil {
+					return nil, err
+				}
+				return celSelect(v, name)
+			}
+		case p.accept("["):
+			operand, index := expr, p.parseExpr()
+			p.expect("]")
+			sel = nil
+			expr = func(s *celScope) (interface{}, error) {
+				v, err := operand(s)
+				if err != nil {
+					return nil, err
+				}
+				i, err := index(s)
+				if err != nil {
+					return nil, err
+				}
+				return celIndex(v, i)
+			}
+		default:
+			return expr, sel
+		}
+	}
+}
+
+func (p *celParser) parsePrimary() celExpr {
+	t := p.peek()
+	switch {
+	case t.kind == celLiteral:
+		p.next()
+		v := t.value
+		return func(*celScope) (interface{}, error) { return v, nil }
+	case p.accept("("):
+		expr := p.parseExpr()
+		p.expect(")")
+		return expr
+	case p.accept("["):
+		var elems []celExpr
+		for !p.is("]") {
+			elems = append(elems, p.parseExpr())
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect("]")
+		return func(s *celScope) (interface{}, error) {
+			list := make([]interface{}, len(elems))
+			for i, e := range elems {
+				v, err := e(s)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = v
+			}
+			return list, nil
+		}
+	case p.accept("{"):
+		var keys, values []celExpr
+		for !p.is("}") {
+			keys = append(keys, p.parseExpr())
+			p.expect(":")
+			values = append(values, p.parseExpr())
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect("}")
+		return func(s *celScope) (interface{}, error) {
+			m := map[interface{}]interface{}{}
+			for i := range keys {
+				k, err := keys[i](s)
+				if err != nil {
+					return nil, err
+				}
+				switch k.(type) {
+				case bool, int64, uint64, string:
+				default:
+					return nil, fmt.Errorf("unsupported map key type %s", celTypeName(k))
+				}
+				if _, dup := m[k]; dup {
+					return nil, fmt.Errorf("duplicate map key %v", k)
+				}
+				if m[k], err = values[i](s); err != nil {
+					return nil, err
+				}
+			}
+			return m, nil
+		}
+	}
+	dot := p.accept(".")
+	if !dot && t.kind != celIdent {
+		p.failf(t, "unexpected %s", t)
+	}
+	name := p.ident()
+	if p.is("(") && !dot {
+		return p.parseCall(t, nil, name)
+	}
+	if !dot && p.bound[name] > 0 {
+		return func(s *celScope) (interface{}, error) {
+			v, _ := s.lookup(name)
+			return v, nil
+		}
+	}
+	// qualified names can only be enum values
+	for p.is(".") && p.toks[p.pos+1].kind == celIdent && !(p.toks[p.pos+2].kind == celSymbol && p.toks[p.pos+2].text == "(") {
+		p.next()
+		name += "." + p.ident()
+	}
+	v, ok := celEnumValue(name)
+	if !ok {
+		p.failf(t, "undeclared reference to %s", name)
+	}
+	return func(*celScope) (interface{}, error) { return v, nil }
+}
+
+// celEnumValue resolves a qualified enum value like google.protobuf.FieldDescriptorProto.Type.TYPE_STRING.
+func celEnumValue(name string) (int64, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return 0, false
+	}
+	et, err := protoregistry.GlobalTypes.FindEnumByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return 0, false
+	}
+	v := et.Descriptor().Values().ByName(protoreflect.Name(name[i+1:]))
+	if v == nil {
+		return 0, false
+	}
+	return int64(v.Number()), true
+}
+
+// parseCall parses the arguments of a function, a method if target is not nil, or a macro.
+func (p *celParser) parseCall(t celToken, target celExpr, name string) celExpr {
+	p.expect("(")
+	if target == nil && name == "has" {
+		_, sel := p.parseMember()
+		if sel == nil || !p.is(")") {
+			p.failf(t, "has() needs a field selection like has(m.f)")
+		}
+		p.expect(")")
+		return func(s *celScope) (interface{}, error) {
+			v, err := sel.operand(s)
+			if err != nil {
+				return nil, err
+			}
+			return celHas(v, sel.field)
+		}
+	}
+	if target != nil && (name == "all" || name == "exists" || name == "exists_one" || name == "map" || name == "filter") {
+		return p.parseMacro(t, target, name)
+	}
+	var args []celExpr
+	for !p.is(")") {
+		args = append(args, p.parseExpr())
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(")")
+	if target != nil {
+		args = append([]celExpr{target}, args...)
+	}
+	fn, ok := celFunctions[name]
+	if !ok || (target != nil) != fn.method && !fn.both {
+		p.failf(t, "undeclared reference to function %s", name)
+	}
+	if len(args) != fn.args {
+		p.failf(t, "wrong number of arguments to %s", name)
+	}
+	var cache map[string]*regexp.Regexp
+	if name == "matches" {
+		cache = map[string]*regexp.Regexp{}
+	}
+	return func(s *celScope) (interface{}, error) {
+		vals := make([]interface{}, len(args))
+		for i, a := range args {
+			v, err := a(s)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		if cache != nil {
+			if pattern, ok := vals[1].(string); ok && cache[pattern] == nil {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("matches: %v", err)
+				}
+				cache[pattern] = re
+			}
+			if str, ok := vals[0].(string); ok {
+				if pattern, ok := vals[1].(string); ok {
+					return cache[pattern].MatchString(str), nil
+				}
+			}
+		}
+		return fn.call(name, vals)
+	}
+}
+
+// parseMacro parses the comprehensions over the elements of a list or the keys of a map.
+func (p *celParser) parseMacro(t celToken, target celExpr, name string) celExpr {
+	v := p.ident()
+	p.expect(",")
+	p.bound[v]++
+	first := p.parseExpr()
+	var second celExpr
+	if name == "map" && p.accept(",") {
+		second = p.parseExpr()
+	}
+	p.bound[v]--
+	p.expect(")")
+	pred, transform := first, celExpr(nil)
+	switch {
+	case name == "map" && second == nil:
+		pred, transform = nil, first
+	case name == "map":
+		transform = second
+	}
+	return func(s *celScope) (interface{}, error) {
+		tv, err := target(s)
+		if err != nil {
+			return nil, err
+		}
+		elems, err := celRange(tv, name)
+		if err != nil {
+			return nil, err
+		}
+		var result []interface{}
+		count := 0
+		var firstErr error
+		for _, e := range elems {
+			es := s.bind(v, e)
+			if pred != nil {
+				pv, err := pred(es)
+				b, ok := pv.(bool)
+				if err == nil && !ok {
+					err = celNoOverload(name, pv)
+				}
+				if err != nil {
+					if name == "all" || name == "exists" {
+						// a decisive element wins over errors of other elements
+						if firstErr == nil {
+							firstErr = err
+						}
+						continue
+					}
+					return nil, err
+				}
+				switch {
+				case name == "all" && !b:
+					return false, nil
+				case name == "exists" && b:
+					return true, nil
+				case b:
+					count++
+				case name == "filter" || name == "map":
+					continue
+				}
+			}
+			switch name {
+			case "filter":
+				result = append(result, e)
+			case "map":
+				mv, err := transform(es)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, mv)
+			}
+		}
+		switch name {
+		case "all", "exists":
+			if firstErr != nil {
+				return nil, firstErr
+			}
+			return name == "all", nil
+		case "exists_one":
+			return count == 1, nil
+		}
+		if result == nil {
+			result = []interface{}{}
+		}
+		return result, nil
+	}
+}
+
+// celRange returns the elements of a list or the sorted keys of a map.
+func celRange(v interface{}, macro string) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		return keys, nil
+	}
+	return nil, celNoOverload(macro, v)
+}
+
+func celTypeName(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null_type"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case uint64:
+		return "uint"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}:
+		return "map"
+	case protoreflect.Message:
+		return string(v.Descriptor().FullName())
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func celNoOverload(op string, args ...interface{}) error {
+	types := make([]string, len(args))
+	for i, a := range args {
+		types[i] = celTypeName(a)
+	}
+	return fmt.Errorf("no such overload: %s(%s)", op, strings.Join(types, ", "))
+}
+
+// celFromProto converts the value of a field to its CEL value.
+func celFromProto(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		elems := make([]interface{}, list.Len())
+		for i := range elems {
+			elems[i] = celFromScalar(fd, list.Get(i))
+		}
+		return elems
+	case fd.IsMap():
+		m := map[interface{}]interface{}{}
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			m[celFromScalar(fd.MapKey(), k.Value())] = celFromScalar(fd.MapValue(), v)
+			return true
+		})
+		return m
+	}
+	return celFromScalar(fd, v)
+}
+
+func celFromScalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return v.Bytes()
+	case protoreflect.EnumKind:
+		return int64(v.Enum())
+	}
+	return v.Message()
+}
+
+func celSelect(v interface{}, field string) (interface{}, error) {
+	switch v := v.(type) {
+	case protoreflect.Message:
+		fd := v.Descriptor().Fields().ByName(protoreflect.Name(field))
+		if fd == nil {
+			return nil, fmt.Errorf("no such field %s in %s", field, v.Descriptor().FullName())
+		}
+		return celFromProto(fd, v.Get(fd)), nil
+	case map[interface{}]interface{}:
+		if e, ok := v[field]; ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("no such key %s", field)
+	}
+	return nil, fmt.Errorf("no such field %s in %s", field, celTypeName(v))
+}
+
+func celHas(v interface{}, field string) (interface{}, error) {
+	switch v := v.(type) {
+	case protoreflect.Message:
+		fd := v.Descriptor().Fields().ByName(protoreflect.Name(field))
+		if fd == nil {
+			return nil, fmt.Errorf("no such field %s in %s", field, v.Descriptor().FullName())
+		}
+		return v.Has(fd), nil
+	case map[interface{}]interface{}:
+		_, ok := v[field]
+		return ok, nil
+	}
+	return nil, celNoOverload("has", v)
+}
+
+func celIndex(v, i interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		var n int64
+		switch i := i.(type) {
+		case int64:
+			n = i
+		case uint64:
+			if i > math.MaxInt64 {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			n = int64(i)
+		case float64:
+			if i != math.Trunc(i) {
+				return nil, celNoOverload("_[_]", v, i)
+			}
+			n = int64(i)
+		default:
+			return nil, celNoOverload("_[_]", v, i)
+		}
+		if n < 0 || n >= int64(len(v)) {
+			return nil, fmt.Errorf("index %d out of range", n)
+		}
+		return v[n], nil
+	case map[interface{}]interface{}:
+		if e, ok := celMapGet(v, i); ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("no such key %v", i)
+	}
+	return nil, celNoOverload("_[_]", v, i)
+}
+
+// celMapGet looks up a key, numeric keys match equal numbers of other types.
+func celMapGet(m map[interface{}]interface{}, k interface{}) (interface{}, bool) {
+	switch k.(type) {
+	case bool, int64, uint64, string:
+		if e, ok := m[k]; ok {
+			return e, true
+		}
+	default:
+		if _, ok := k.(float64); !ok {
+			return nil, false
+		}
+	}
+	if _, ok := celNumber(k); ok {
+		for mk, e := range m {
+			if c, ok := celCompareNumbers(mk, k); ok && c == 0 {
+				return e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func celNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// celCompareNumbers compares numbers of any type, NaN is not comparable.
+func celCompareNumbers(a, b interface{}) (int, bool) {
+	cmp := func(less, greater bool) int {
+		switch {
+		case less:
+			return -1
+		case greater:
+			return 1
+		}
+		return 0
+	}
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp(a < b, a > b), true
+		case uint64:
+			if a < 0 {
+				return -1, true
+			}
+			return cmp(uint64(a) < b, uint64(a) > b), true
+		}
+	case uint64:
+		switch b := b.(type) {
+		case uint64:
+			return cmp(a < b, a > b), true
+		case int64:
+			if b < 0 {
+				return 1, true
+			}
+			return cmp(a < uint64(b), a > uint64(b)), true
+		}
+	}
+	x, ok1 := celNumber(a)
+	y, ok2 := celNumber(b)
+	if !ok1 || !ok2 || math.IsNaN(x) || math.IsNaN(y) {
+		return 0, false
+	}
+	return cmp(x < y, x > y), true
+}
+
+func celEqual(a, b interface{}) bool {
+	if _, ok := celNumber(a); ok {
+		c, ok := celCompareNumbers(a, b)
+		return ok && c == 0
+	}
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case bool, string:
+		return a == b
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !celEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[interface{}]interface{}:
+		b, ok := b.(map[interface{}]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := celMapGet(b, k); !ok || !celEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case protoreflect.Message:
+		b, ok := b.(protoreflect.Message)
+		return ok && a.Descriptor().FullName() == b.Descriptor().FullName() && proto.Equal(a.Interface(), b.Interface())
+	}
+	return false
+}
+
+func celUnary(op string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case bool:
+		if op == "!" {
+			return !v, nil
+		}
+	case int64:
+		if op == "-" {
+			if v == math.MinInt64 {
+				return nil, fmt.Errorf("integer overflow")
+			}
+			return -v, nil
+		}
+	case float64:
+		if op == "-" {
+			return -v, nil
+		}
+	}
+	return nil, celNoOverload(op, v)
+}
+
+func celBinary(op string, left, right celExpr) celExpr {
+	return func(s *celScope) (interface{}, error) {
+		l, err := left(s)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(s)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return celEqual(l, r), nil
+		case "!=":
+			return !celEqual(l, r), nil
+		case "in":
+			switch r := r.(type) {
+			case []interface{}:
+				for _, e := range r {
+					if celEqual(l, e) {
+						return true, nil
+					}
+				}
+				return false, nil
+			case map[interface{}]interface{}:
+				_, ok := celMapGet(r, l)
+				return ok, nil
+			}
+		case "<", "<=", ">", ">=":
+			c, ok := celCompareNumbers(l, r)
+			if !ok {
+				if _, isNum := celNumber(l); isNum {
+					if _, isNum := celNumber(r); isNum {
+						// NaN compares false
+						return false, nil
+					}
+				}
+			}
+			switch l := l.(type) {
+			case string:
+				if r, isStr := r.(string); isStr {
+					c, ok = strings.Compare(l, r), true
+				}
+			case []byte:
+				if r, isBytes := r.([]byte); isBytes {
+					c, ok = bytes.Compare(l, r), true
+				}
+			case bool:
+				if r, isBool := r.(bool); isBool {
+					c, ok = 0, true
+					if l != r {
+						c = 1
+						if r {
+							c = -1
+						}
+					}
+				}
+			}
+			if ok {
+				switch op {
+				case "<":
+					return c < 0, nil
+				case "<=":
+					return c <= 0, nil
+				case ">":
+					return c > 0, nil
+				}
+				return c >= 0, nil
+			}
+		default:
+			return celArithmetic(op, l, r)
+		}
+		return nil, celNoOverload(op, l, r)
+	}
+}
+
+// celArithmetic applies + - * / % to operands of the same type, integer overflows are errors.
+func celArithmetic(op string, l, r interface{}) (interface{}, error) {
+	overflow := fmt.Errorf("integer overflow")
+	switch l := l.(type) {
+	case int64:
+		r, ok := r.(int64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			if (r > 0 && l > math.MaxInt64-r) || (r < 0 && l < math.MinInt64-r) {
+				return nil, overflow
+			}
+			return l + r, nil
+		case "-":
+			if (r < 0 && l > math.MaxInt64+r) || (r > 0 && l < math.MinInt64+r) {
+				return nil, overflow
+			}
+			return l - r, nil
+		case "*":
+			p := l * r
+			if l != 0 && (p/l != r || (l == -1 && r == math.MinInt64)) {
+				return nil, overflow
+			}
+			return p, nil
+		case "/", "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if l == math.MinInt64 && r == -1 {
+				if op == "%" {
+					return int64(0), nil
+				}
+				return nil, overflow
+			}
+			if op == "/" {
+				return l / r, nil
+			}
+			return l % r, nil
+		}
+	case uint64:
+		r, ok := r.(uint64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			if l+r < l {
+				return nil, overflow
+			}
+			return l + r, nil
+		case "-":
+			if r > l {
+				return nil, overflow
+			}
+			return l - r, nil
+		case "*":
+			if l != 0 && (l*r)/l != r {
+				return nil, overflow
+			}
+			return l * r, nil
+		case "/", "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return l / r, nil
+			}
+			return l % r, nil
+		}
+	case float64:
+		r, ok := r.(float64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			return l / r, nil
+		}
+	case string:
+		if r, ok := r.(string); ok && op == "+" {
+			return l + r, nil
+		}
+	case []byte:
+		if r, ok := r.([]byte); ok && op == "+" {
+			return append(append([]byte(nil), l...), r...), nil
+		}
+	case []interface{}:
+		if r, ok := r.([]interface{}); ok && op == "+" {
+			return append(append([]interface{}(nil), l...), r...), nil
+		}
+	}
+	return nil, celNoOverload(op, l, r)
+}
+
+// celFunction is a function or method with a fixed number of arguments, the target counts.
+type celFunction struct {
+	method, both bool
+	args         int
+	call         func(name string, args []interface{}) (interface{}, error)
+}
+
+var celFunctions = map[string]celFunction{
+	"size":       {both: true, args: 1, call: celSize},
+	"matches":    {both: true, args: 2, call: celStringFunc},
+	"contains":   {method: true, args: 2, call: celStringFunc},
+	"startsWith": {method: true, args: 2, call: celStringFunc},
+	"endsWith":   {method: true, args: 2, call: celStringFunc},
+	"lowerAscii": {method: true, args: 1, call: celStringFunc},
+	"upperAscii": {method: true, args: 1, call: celStringFunc},
+	"trim":       {method: true, args: 1, call: celStringFunc},
+	"replace":    {method: true, args: 3, call: celStringFunc},
+	"split":      {method: true, args: 2, call: celStringFunc},
+	"join":       {method: true, args: 2, call: celJoin},
+	"int":        {args: 1, call: celConvert},
+	"uint":       {args: 1, call: celConvert},
+	"double":     {args: 1, call: celConvert},
+	"string":     {args: 1, call: celConvert},
+	"bytes":      {args: 1, call: celConvert},
+	"dyn":        {args: 1, call: celConvert},
+}
+
+func celSize(name string, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case []byte:
+		return int64(len(v)), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	case map[interface{}]interface{}:
+		return int64(len(v)), nil
+	}
+	return nil, celNoOverload(name, args...)
+}
+
+func celStringFunc(name string, args []interface{}) (interface{}, error) {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return nil, celNoOverload(name, args...)
+		}
+		strs[i] = s
+	}
+	switch name {
+	case "contains":
+		return strings.Contains(strs[0], strs[1]), nil
+	case "startsWith":
+		return strings.HasPrefix(strs[0], strs[1]), nil
+	case "endsWith":
+		return strings.HasSuffix(strs[0], strs[1]), nil
+	case "lowerAscii", "upperAscii":
+		b := []byte(strs[0])
+		for i, c := range b {
+			if name == "lowerAscii" && c >= 'A' && c <= 'Z' {
+				b[i] = c + 'a' - 'A'
+			} else if name == "upperAscii" && c >= 'a' && c <= 'z' {
+				b[i] = c - 'a' + 'A'
+			}
+		}
+		return string(b), nil
+	case "trim":
+		return strings.TrimSpace(strs[0]), nil
+	case "replace":
+		return strings.ReplaceAll(strs[0], strs[1], strs[2]), nil
+	case "split":
+		parts := strings.Split(strs[0], strs[1])
+		list := make([]interface{}, len(parts))
+		for i, p := range parts {
+			list[i] = p
+		}
+		return list, nil
+	}
+	// matches with a pattern that is no string
+	return nil, celNoOverload(name, args...)
+}
+
+func celJoin(name string, args []interface{}) (interface{}, error) {
+	list, ok := args[0].([]interface{})
+	sep, ok2 := args[1].(string)
+	if !ok || !ok2 {
+		return nil, celNoOverload(name, args...)
+	}
+	strs := make([]string, len(list))
+	for i, e := range list {
+		if strs[i], ok = e.(string); !ok {
+			return nil, celNoOverload(name, args...)
+		}
+	}
+	return strings.Join(strs, sep), nil
+}
+
+func celConvert(name string, args []interface{}) (interface{}, error) {
+	rangeErr := fmt.Errorf("%s(%v) out of range", name, args[0])
+	switch v := args[0].(type) {
+	case int64:
+		switch name {
+		case "int":
+			return v, nil
+		case "uint":
+			if v < 0 {
+				return nil, rangeErr
+			}
+			return uint64(v), nil
+		case "double":
+			return float64(v), nil
+		case "string":
+			return strconv.FormatInt(v, 10), nil
+		}
+	case uint64:
+		switch name {
+		case "int":
+			if v > math.MaxInt64 {
+				return nil, rangeErr
+			}
+			return int64(v), nil
+		case "uint":
+			return v, nil
+		case "double":
+			return float64(v), nil
+		case "string":
+			return strconv.FormatUint(v, 10), nil
+		}
+	case float64:
+		switch name {
+		case "int":
+			if math.IsNaN(v) || v <= -1<<63 || v >= 1<<63 {
+				return nil, rangeErr
+			}
+			return int64(v), nil
+		case "uint":
+			if math.IsNaN(v) || v < 0 || v >= 1<<64 {
+				return nil, rangeErr
+			}
+			return uint64(v), nil
+		case "double":
+			return v, nil
+		case "string":
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		}
+	case string:
+		var err error
+		var out interface{}
+		switch name {
+		case "int":
+			out, err = strconv.ParseInt(v, 10, 64)
+		case "uint":
+			out, err = strconv.ParseUint(v, 10, 64)
+		case "double":
+			out, err = strconv.ParseFloat(v, 64)
+		case "string":
+			out = v
+		case "bytes":
+			out = []byte(v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s(%q) failed: %v", name, v, err)
+		}
+		if out != nil {
+			return out, nil
+		}
+	case []byte:
+		switch name {
+		case "string":
+			if !utf8.Valid(v) {
+				return nil, fmt.Errorf("string() of invalid utf-8")
+			}
+			return string(v), nil
+		case "bytes":
+			return v, nil
+		}
+	case bool:
+		if name == "string" {
+			return strconv.FormatBool(v), nil
+		}
+	}
+	if name == "dyn" {
+		return args[0], nil
+	}
+	return nil, celNoOverload(name, args...)
+}
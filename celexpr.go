@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	check := func(ts *transformStep) error {
+		if ts.Expr == "" {
+			return fmt.Errorf("%s needs expr", ts.Op)
+		}
+		var err error
+		ts.expr, err = compileCEL(ts.Expr, "file", "request")
+		return err
+	}
+	registerTransform("filter-expr", check, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		dropped, err := filterFilesCEL(req, ts.expr)
+		if err != nil {
+			return err
+		}
+		logEvent(logInfo, "filter-expr", "dropped", dropped, "kept", len(req.ProtoFile))
+		return nil
+	})
+	registerTransform("map-expr", check, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		return mapFilesCEL(req, ts.expr)
+	})
+}
+
+// compileFileExpr compiles a CEL expression evaluated for each file of a request
+// with the variables file and request.
+func compileFileExpr(flagName, src string) (celExpr, error) {
+	if src == "" {
+		return nil, nil
+	}
+	expr, err := compileCEL(src, "file", "request")
+	if err != nil {
+		return nil, fmt.Errorf("-%s: %v", flagName, err)
+	}
+	return expr, nil
+}
+
+func evalFileExpr(expr celExpr, req *pluginpb.CodeGeneratorRequest, fd *descriptorpb.FileDescriptorProto) (interface{}, error) {
+	s := (*celScope)(nil).bind("request", req.ProtoReflect()).bind("file", fd.ProtoReflect())
+	return expr(s)
+}
+
+// filterFilesCEL drops the files of req the expression is false for and returns their number.
+// Files imported by kept files stay in the request but are not generated.
+func filterFilesCEL(req *pluginpb.CodeGeneratorRequest, expr celExpr) (int, error) {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	keep := map[string]bool{}
+	var queue []string
+	for _, fd := range req.ProtoFile {
+		v, err := evalFileExpr(expr, req, fd)
+		if err != nil {
+			return 0, fmt.Errorf("-filter-expr: %s: %v", fd.GetName(), err)
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return 0, fmt.Errorf("-filter-expr: %s: result is %s, not bool", fd.GetName(), celTypeName(v))
+		}
+		byName[fd.GetName()] = fd
+		if b {
+			keep[fd.GetName()] = true
+			queue = append(queue, fd.GetName())
+		}
+	}
+	var generate []string
+	for _, name := range req.FileToGenerate {
+		if keep[name] {
+			generate = append(generate, name)
+		}
+	}
+	for len(queue) > 0 {
+		fd := byName[queue[0]]
+		queue = queue[1:]
+		for _, dep := range fd.GetDependency() {
+			if !keep[dep] && byName[dep] != nil {
+				logEvent(logDebug, "filter-expr", "file", dep, "kept", "imported by "+fd.GetName())
+				keep[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+	var files []*descriptorpb.FileDescriptorProto
+	for _, fd := range req.ProtoFile {
+		if keep[fd.GetName()] {
+			files = append(files, fd)
+		}
+	}
+	dropped := len(req.ProtoFile) - len(files)
+	req.ProtoFile, req.FileToGenerate = files, generate
+	return dropped, nil
+}
+
+// mapFilesCEL replaces each file of req by the result of the expression, a
+// google.protobuf.FileDescriptorProto or a map from field names to new values.
+// Files to generate and imports follow renamed files.
+func mapFilesCEL(req *pluginpb.CodeGeneratorRequest, expr celExpr) error {
+	renamed := map[string]string{}
+	for i, fd := range req.ProtoFile {
+		v, err := evalFileExpr(expr, req, fd)
+		if err != nil {
+			return fmt.Errorf("-map-expr: %s: %v", fd.GetName(), err)
+		}
+		mapped := proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+		switch v := v.(type) {
+		case protoreflect.Message:
+			if v.Descriptor() != mapped.ProtoReflect().Descriptor() {
+				return fmt.Errorf("-map-expr: %s: result is %s, not google.protobuf.FileDescriptorProto", fd.GetName(), celTypeName(v))
+			}
+			mapped = proto.Clone(v.Interface()).(*descriptorpb.FileDescriptorProto)
+		case map[interface{}]interface{}:
+			if err := celSetFields(mapped.ProtoReflect(), v); err != nil {
+				return fmt.Errorf("-map-expr: %s: %v", fd.GetName(), err)
+			}
+		default:
+			return fmt.Errorf("-map-expr: %s: result is %s, not google.protobuf.FileDescriptorProto or a map of its fields", fd.GetName(), celTypeName(v))
+		}
+		if mapped.GetName() != fd.GetName() {
+			renamed[fd.GetName()] = mapped.GetName()
+		}
+		req.ProtoFile[i] = mapped
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+	for i, name := range req.FileToGenerate {
+		if to, ok := renamed[name]; ok {
+			req.FileToGenerate[i] = to
+		}
+	}
+	for _, fd := range req.ProtoFile {
+		for i, dep := range fd.Dependency {
+			if to, ok := renamed[dep]; ok {
+				fd.Dependency[i] = to
+			}
+		}
+	}
+	return nil
+}
+
+// celSetFields sets the fields of m named by the keys of fields, null clears a field.
+func celSetFields(m protoreflect.Message, fields map[interface{}]interface{}) error {
+	// sorted for deterministic errors
+	keys, _ := celRange(fields, "")
+	for _, k := range keys {
+		name, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("field name %v is not a string", k)
+		}
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("no such field %s in %s", name, m.Descriptor().FullName())
+		}
+		if err := celSetField(m, fd, fields[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func celSetField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	if v == nil {
+		m.Clear(fd)
+		return nil
+	}
+	switch {
+	case fd.IsList():
+		elems, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %s needs a list, got %s", fd.FullName(), celTypeName(v))
+		}
+		list := m.NewField(fd).List()
+		for _, e := range elems {
+			pv, err := celToProto(fd, e, list.NewElement)
+			if err != nil {
+				return err
+			}
+			list.Append(pv)
+		}
+		m.Set(fd, protoreflect.ValueOfList(list))
+	case fd.IsMap():
+		entries, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("field %s needs a map, got %s", fd.FullName(), celTypeName(v))
+		}
+		mp := m.NewField(fd).Map()
+		for k, e := range entries {
+			kv, err := celToProto(fd.MapKey(), k, nil)
+			if err != nil {
+				return err
+			}
+			ev, err := celToProto(fd.MapValue(), e, mp.NewValue)
+			if err != nil {
+				return err
+			}
+			mp.Set(kv.MapKey(), ev)
+		}
+		m.Set(fd, protoreflect.ValueOfMap(mp))
+	default:
+		pv, err := celToProto(fd, v, func() protoreflect.Value { return m.NewField(fd) })
+		if err != nil {
+			return err
+		}
+		m.Set(fd, pv)
+	}
+	return nil
+}
+
+// celToProto converts a CEL value to a singular value of the kind of fd,
+// newMessage returns an empty message for message kinds.
+func celToProto(fd protoreflect.FieldDescriptor, v interface{}, newMessage func() protoreflect.Value) (protoreflect.Value, error) {
+	mismatch := fmt.Errorf("field %s of kind %s can not be set to %s", fd.FullName(), fd.Kind(), celTypeName(v))
+	asInt := func(lo, hi int64) (int64, bool) {
+		switch v := v.(type) {
+		case int64:
+			return v, lo <= v && v <= hi
+		case uint64:
+			return int64(v), v <= uint64(hi)
+		}
+		return 0, false
+	}
+	asUint := func(hi uint64) (uint64, bool) {
+		switch v := v.(type) {
+		case int64:
+			return uint64(v), v >= 0 && uint64(v) <= hi
+		case uint64:
+			return v, v <= hi
+		}
+		return 0, false
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if i, ok := asInt(math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, ok := asInt(math.MinInt64, math.MaxInt64); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+	case protoreflect.EnumKind:
+		if i, ok := asInt(math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if u, ok := asUint(math.MaxUint32); ok {
+			return protoreflect.ValueOfUint32(uint32(u)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, ok := asUint(math.MaxUint64); ok {
+			return protoreflect.ValueOfUint64(u), nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f, ok := celNumber(v)
+		if !ok {
+			break
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+		return protoreflect.ValueOfFloat64(f), nil
+	case protoreflect.StringKind:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		if b, ok := v.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		msg := newMessage()
+		switch v := v.(type) {
+		case protoreflect.Message:
+			if v.Descriptor().FullName() != fd.Message().FullName() {
+				break
+			}
+			proto.Merge(msg.Message().Interface(), v.Interface())
+			return msg, nil
+		case map[interface{}]interface{}:
+			if err := celSetFields(msg.Message(), v); err != nil {
+				return protoreflect.Value{}, err
+			}
+			return msg, nil
+		}
+	}
+	return protoreflect.Value{}, mismatch
+}
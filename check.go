@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// checkInput validates decoded input and prints a single OK or FAIL line with statistics.
+// decodeErr is the error of reading and decoding the input.
+func checkInput(w io.Writer, msg proto.Message, size int, start time.Time, decodeErr error) error {
+	fail := func(kind string, err error) error {
+		fmt.Fprintf(w, "FAIL %s: %v\n", kind, err)
+		return &exitError{code: exitFailure, err: fmt.Errorf("check failed")}
+	}
+	if decodeErr != nil {
+		return fail("input", decodeErr)
+	}
+	unknown := 0
+	unknownFields(msg.ProtoReflect(), "", func(string, protowire.Number) {
+		unknown++
+	})
+	took := time.Since(start).Round(time.Millisecond)
+
+	resp, ok := msg.(*pluginpb.CodeGeneratorResponse)
+	if ok {
+		if err := checkResponse(resp); err != nil {
+			return fail("response", err)
+		}
+		fmt.Fprintf(w, "OK response: %d files, %d unknown fields, %d bytes in %v\n", len(resp.File), unknown, size, took)
+		return nil
+	}
+	req := msg.(*pluginpb.CodeGeneratorRequest)
+	if missing := missingDependencies(req); len(missing) > 0 {
+		return fail("request", fmt.Errorf("missing %s", strings.Join(missing, ", ")))
+	}
+	// protoc sends files after their dependencies
+	seen := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		for _, dep := range fd.Dependency {
+			if !seen[dep] {
+				return fail("request", fmt.Errorf("%s is sent before its dependency %s", fd.GetName(), dep))
+			}
+		}
+		if seen[fd.GetName()] {
+			return fail("request", fmt.Errorf("%s is sent twice", fd.GetName()))
+		}
+		seen[fd.GetName()] = true
+	}
+	if _, err := protoTypes(req.ProtoFile); err != nil {
+		return fail("request", err)
+	}
+	kinds := map[string]int{}
+	for _, fd := range req.ProtoFile {
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			kinds[kind]++
+		})
+	}
+	fmt.Fprintf(w, "OK request: %d files, %d to generate, %d messages, %d enums, %d services, %d extensions, %d unknown fields, %d bytes in %v\n",
+		len(req.ProtoFile), len(req.FileToGenerate), kinds["message"], kinds["enum"], kinds["service"], kinds["extension"], unknown, size, took)
+	return nil
+}
+
+// checkResponse validates the files and insertion points of a response.
+func checkResponse(resp *pluginpb.CodeGeneratorResponse) error {
+	if resp.GetError() != "" {
+		return fmt.Errorf("error response: %s", resp.GetError())
+	}
+	names := map[string]bool{}
+	for i, f := range resp.File {
+		// files without name continue the previous file
+		switch {
+		case f.GetName() == "" && i == 0:
+			return fmt.Errorf("the first file has no name")
+		case f.GetName() == "" || f.GetInsertionPoint() != "":
+		case names[f.GetName()]:
+			return fmt.Errorf("%s is generated twice", f.GetName())
+		default:
+			names[f.GetName()] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// chunkManifestSuffix is appended to the wrapped file name for the manifest of its chunks.
+const chunkManifestSuffix = ".chunks.json"
+
+// wrapChunkSize splits wrapped files larger than this many bytes into chunks, 0 disables it.
+var wrapChunkSize = 0
+
+// chunkManifest lists the chunks of a wrapped file in order.
+type chunkManifest struct {
+	File   string   `json:"file"`
+	Bytes  int      `json:"bytes"`
+	SHA256 string   `json:"sha256"`
+	Chunks []string `json:"chunks"`
+}
+
+// chunkFiles splits out into files named file.000, file.001, ... and a manifest.
+// With utf8Safe, chunks do not split characters.
+func chunkFiles(file string, out []byte, size int, utf8Safe bool) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	sum := sha256.Sum256(out)
+	m := chunkManifest{File: file, Bytes: len(out), SHA256: hex.EncodeToString(sum[:])}
+	var files []*pluginpb.CodeGeneratorResponse_File
+	for rest := out; len(rest) > 0; {
+		end := size
+		if end >= len(rest) {
+			end = len(rest)
+		} else if utf8Safe {
+			for end > 1 && !utf8.RuneStart(rest[end]) {
+				end--
+			}
+		}
+		name := fmt.Sprintf("%s.%03d", file, len(files))
+		m.Chunks = append(m.Chunks, filepath.Base(name))
+		files = append(files, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(name),
+			Content: proto.String(string(rest[:end])),
+		})
+		rest = rest[end:]
+	}
+	manifest, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(files, &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(file + chunkManifestSuffix),
+		Content: proto.String(string(manifest) + "\n"),
+	}), nil
+}
+
+// isChunkManifest reports whether name is the manifest of a chunked file.
+func isChunkManifest(name string) bool {
+	return strings.HasSuffix(name, chunkManifestSuffix)
+}
+
+// isChunk reports whether name in dir is a chunk listed by a manifest next to it.
+func isChunk(dir, name string) bool {
+	ext := filepath.Ext(name)
+	if len(ext) != 4 || strings.Trim(ext[1:], "0123456789") != "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(name, ext)+chunkManifestSuffix))
+	return err == nil
+}
+
+// readChunks reassembles a chunked file from its manifest and the chunks next to it.
+func readChunks(manifest string) ([]byte, error) {
+	raw, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("chunk manifest could not be read: %v", err)
+	}
+	var m chunkManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("%s: invalid chunk manifest: %v", manifest, err)
+	}
+	var buf bytes.Buffer
+	for _, chunk := range m.Chunks {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(manifest), filepath.Base(chunk)))
+		if err != nil {
+			return nil, fmt.Errorf("chunk could not be read: %v", err)
+		}
+		buf.Write(data)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if buf.Len() != m.Bytes || hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, fmt.Errorf("%s: chunks do not match the manifest, %d of %d bytes", manifest, buf.Len(), m.Bytes)
+	}
+	return buf.Bytes(), nil
+}
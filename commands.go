@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a mode selected by the first argument, e.g. protoc-gen-capture stats.
+// Without a known command, the flags of the plugin mode are parsed.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{}
+
+func register(name, summary string, run func(args []string) error) {
+	commands[name] = command{summary: summary, run: run}
+}
+
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprint(w, "\nCommands (call as protoc-gen-capture COMMAND -help for details):\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// newFlagSet creates the flag set for a command.
+// Its usage prints the summary of the command and its arguments.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fs.SetOutput(os.Stdout)
+		fmt.Fprintf(os.Stdout, "%s: %s\n\nArguments:\n", name, commands[name].summary)
+		fs.PrintDefaults()
+	}
+	addLogFlags(fs)
+	return fs
+}
+
+// parseFlags parses args and maps -help to a clean exit.
+func parseFlags(fs *flag.FlagSet, args []string) (done bool, err error) {
+	err = fs.Parse(args)
+	if err == flag.ErrHelp {
+		return true, nil
+	}
+	setupLogging()
+	return false, err
+}
+
+// readStdin reads all of stdin.
+func readStdin() ([]byte, error) {
+	bin, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("input could not be read from stdin: %v", err)
+	}
+	logEvent(logInfo, "read", "bytes", len(bin))
+	return bin, nil
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(v string) error {
+	*sf = append(*sf, v)
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("comments", "print the comments of messages, fields, enums, services and methods", runComments)
+}
+
+// commented is a declaration with its comments from SourceCodeInfo.
+type commented struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	File     string `json:"file"`
+	Leading  string `json:"leading,omitempty"`
+	Trailing string `json:"trailing,omitempty"`
+}
+
+// documented reports whether the declaration has a comment.
+func (c *commented) documented() bool {
+	return c.Leading != "" || c.Trailing != ""
+}
+
+type commentReport struct {
+	Total        int         `json:"total"`
+	Documented   int         `json:"documented"`
+	Declarations []commented `json:"declarations"`
+}
+
+func runComments(args []string) error {
+	var (
+		jsonIn       = false
+		jsonOut      = false
+		all          = false
+		undocumented = false
+	)
+	fs := newFlagSet("comments")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	fs.BoolVar(&undocumented, "undocumented", undocumented, "only list declarations without comments")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	r := newCommentReport(req, all)
+	if undocumented {
+		decls := r.Declarations[:0]
+		for _, c := range r.Declarations {
+			if !c.documented() {
+				decls = append(decls, c)
+			}
+		}
+		r.Declarations = decls
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(r)
+	}
+	return r.writeTable(os.Stdout)
+}
+
+func newCommentReport(req *pluginpb.CodeGeneratorRequest, all bool) *commentReport {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	r := &commentReport{Declarations: []commented{}}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		type comments struct{ leading, trailing string }
+		locs := map[string]comments{}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			locs[fmt.Sprint(loc.Path)] = comments{
+				strings.TrimSpace(loc.GetLeadingComments()),
+				strings.TrimSpace(loc.GetTrailingComments()),
+			}
+		}
+		mapEntries := ""
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			if md, ok := desc.(*descriptorpb.DescriptorProto); ok && md.GetOptions().GetMapEntry() {
+				mapEntries = name + "."
+				return
+			}
+			if kind == "oneof" || kind == "extension" || (mapEntries != "" && strings.HasPrefix(name, mapEntries)) {
+				return
+			}
+			c := locs[fmt.Sprint(path)]
+			r.Declarations = append(r.Declarations, commented{
+				Name:     name,
+				Kind:     kind,
+				File:     fd.GetName(),
+				Leading:  c.leading,
+				Trailing: c.trailing,
+			})
+		})
+	}
+	r.Total = len(r.Declarations)
+	for _, c := range r.Declarations {
+		if c.documented() {
+			r.Documented++
+		}
+	}
+	return r
+}
+
+// firstLine shortens a comment for table output.
+func firstLine(s string) string {
+	if line, _, cut := strings.Cut(s, "\n"); cut {
+		return line + " ..."
+	}
+	return s
+}
+
+func (r *commentReport) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "NAME\tKIND\tLEADING\tTRAILING\n")
+	for _, c := range r.Declarations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Kind, firstLine(c.Leading), firstLine(c.Trailing))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	coverage := 100.0
+	if r.Total > 0 {
+		coverage = 100 * float64(r.Documented) / float64(r.Total)
+	}
+	_, err := fmt.Fprintf(w, "\n%d of %d declarations documented (%.0f%%)\n", r.Documented, r.Total, coverage)
+	return err
+}
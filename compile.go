@@ -0,0 +1,766 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/arnehormann/protoc-gen-capture/capture"
+)
+
+func init() {
+	register("compile", "build a request from .proto files without protoc", runCompile)
+}
+
+func runCompile(args []string) error {
+	var (
+		includes  stringsFlag
+		generate  = ""
+		parameter = ""
+		jsonOut   = false
+	)
+	fs := newFlagSet("compile")
+	fs.Var(&includes, "I", "import path to search for files and their imports, repeatable, default is the current directory")
+	fs.StringVar(&generate, "files-to-generate", generate, "comma separated files to generate, default are all files given as arguments")
+	fs.StringVar(&parameter, "parameter", parameter, "parameter of the request")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else binary proto")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture compile [ARGUMENTS] FILE.proto... > request\n\n"+
+			"The files and their imports are parsed like protoc does without running it.\n"+
+			"Files are named by their path in the first import path containing them.\n"+
+			"Imports of the well-known types and descriptor.proto not found in an import path\n"+
+			"use the descriptors built into this program. Editions are not supported and\n"+
+			"the compiler version of the request is not set.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	files := fs.Args()
+	var toGenerate []string
+	if generate != "" {
+		toGenerate = strings.Split(generate, ",")
+		if len(files) == 0 {
+			files = toGenerate
+		}
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return fmt.Errorf("compile needs .proto files")
+	}
+	if len(includes) == 0 {
+		includes = stringsFlag{"."}
+	}
+	c := &protoCompiler{includes: includes, files: map[string]*compiledFile{}}
+	req, err := c.request(files, toGenerate)
+	if err != nil {
+		return err
+	}
+	if parameter != "" {
+		req.Parameter = proto.String(parameter)
+	}
+	data, err := encode(req, jsonOut)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// protoCompiler parses .proto files and their imports into linked file descriptors.
+type protoCompiler struct {
+	includes []string
+	// sources are files by import name used before the import paths
+	sources map[string]string
+	// files are the loaded files by import name, nil while a file is loaded
+	files map[string]*compiledFile
+	// order has the imports of each file before it
+	order []*compiledFile
+}
+
+type compiledFile struct {
+	fd *descriptorpb.FileDescriptorProto
+	// parser is nil for files built into this program
+	parser  *protoParser
+	deps    []*compiledFile
+	symbols map[string]string
+}
+
+// request compiles files and returns a request for them, toGenerate defaults to all files.
+func (c *protoCompiler) request(files, toGenerate []string) (*pluginpb.CodeGeneratorRequest, error) {
+	req := &pluginpb.CodeGeneratorRequest{}
+	var names []string
+	for _, file := range files {
+		name, err := c.importName(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.load(name, nil); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if toGenerate == nil {
+		req.FileToGenerate = names
+	}
+	for _, file := range toGenerate {
+		name, err := c.importName(file)
+		if err != nil {
+			return nil, err
+		}
+		if c.files[name] == nil {
+			return nil, fmt.Errorf("file to generate %s is not compiled", name)
+		}
+		req.FileToGenerate = append(req.FileToGenerate, name)
+	}
+	for _, cf := range c.order {
+		if cf.parser != nil {
+			if err := c.link(cf); err != nil {
+				return nil, err
+			}
+		}
+		req.ProtoFile = append(req.ProtoFile, cf.fd)
+	}
+	if _, err := capture.NewFiles(withoutLegacy(req.ProtoFile), capture.Options{}); err != nil {
+		return nil, fmt.Errorf("invalid descriptors: %v", err)
+	}
+	return req, nil
+}
+
+// importName maps a file on disk to its name in the import paths like protoc,
+// other names are looked up in the import paths.
+func (c *protoCompiler) importName(file string) (string, error) {
+	if _, ok := c.sources[file]; ok {
+		return file, nil
+	}
+	if _, err := os.Stat(file); err == nil {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		for _, dir := range c.includes {
+			d, err := filepath.Abs(dir)
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(d, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return filepath.ToSlash(rel), nil
+			}
+		}
+		return "", fmt.Errorf("%s is not in an import path, add its directory with -I", file)
+	}
+	return filepath.ToSlash(filepath.Clean(file)), nil
+}
+
+// load parses the file name and its imports.
+func (c *protoCompiler) load(name string, importedBy *compiledFile) (*compiledFile, error) {
+	if cf, ok := c.files[name]; ok {
+		if cf == nil {
+			return nil, fmt.Errorf("%s: import cycle with %s", importedBy.fd.GetName(), name)
+		}
+		return cf, nil
+	}
+	c.files[name] = nil
+	cf := &compiledFile{}
+	src, err := c.read(name)
+	switch {
+	case err == nil:
+		if cf.parser, err = parseProto(name, string(src)); err != nil {
+			return nil, err
+		}
+		cf.fd = cf.parser.fd
+	case os.IsNotExist(err):
+		f, ferr := protoregistry.GlobalFiles.FindFileByPath(name)
+		if ferr != nil {
+			if importedBy != nil {
+				return nil, fmt.Errorf("%s: import %s not found in import paths", importedBy.fd.GetName(), name)
+			}
+			return nil, fmt.Errorf("%s not found in import paths", name)
+		}
+		cf.fd = protodesc.ToFileDescriptorProto(f)
+	default:
+		return nil, err
+	}
+	for _, dep := range cf.fd.Dependency {
+		d, err := c.load(dep, cf)
+		if err != nil {
+			return nil, err
+		}
+		cf.deps = append(cf.deps, d)
+	}
+	cf.symbols = protoSymbols(cf.fd)
+	c.files[name] = cf
+	c.order = append(c.order, cf)
+	return cf, nil
+}
+
+// read returns the source of name or its content in the first import path containing it.
+func (c *protoCompiler) read(name string) ([]byte, error) {
+	if src, ok := c.sources[name]; ok {
+		return []byte(src), nil
+	}
+	for _, dir := range c.includes {
+		src, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if !os.IsNotExist(err) {
+			return src, err
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// protoSymbols maps the full names of the packages, messages, enums, extensions and services of fd to their kind.
+func protoSymbols(fd *descriptorpb.FileDescriptorProto) map[string]string {
+	syms := map[string]string{}
+	pkg := fd.GetPackage()
+	for p := pkg; p != ""; p = protoParent(p) {
+		syms[p] = "package"
+	}
+	var addMessages func(prefix string, msgs []*descriptorpb.DescriptorProto)
+	addExtensions := func(prefix string, exts []*descriptorpb.FieldDescriptorProto) {
+		for _, ext := range exts {
+			syms[joinName(prefix, ext.GetName())] = "extension"
+		}
+	}
+	addEnums := func(prefix string, enums []*descriptorpb.EnumDescriptorProto) {
+		for _, e := range enums {
+			syms[joinName(prefix, e.GetName())] = "enum"
+		}
+	}
+	addMessages = func(prefix string, msgs []*descriptorpb.DescriptorProto) {
+		for _, m := range msgs {
+			name := joinName(prefix, m.GetName())
+			syms[name] = "message"
+			addEnums(name, m.EnumType)
+			addExtensions(name, m.Extension)
+			addMessages(name, m.NestedType)
+		}
+	}
+	addMessages(pkg, fd.MessageType)
+	addEnums(pkg, fd.EnumType)
+	addExtensions(pkg, fd.Extension)
+	for _, s := range fd.Service {
+		syms[joinName(pkg, s.GetName())] = "service"
+	}
+	return syms
+}
+
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func protoParent(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// visibleSymbols are the symbols of cf, its imports and their public imports.
+func (cf *compiledFile) visibleSymbols() map[string]string {
+	syms := map[string]string{}
+	var addPublic func(f *compiledFile)
+	addPublic = func(f *compiledFile) {
+		for k, v := range f.symbols {
+			syms[k] = v
+		}
+		for _, i := range f.fd.PublicDependency {
+			addPublic(f.deps[i])
+		}
+	}
+	for _, dep := range cf.deps {
+		addPublic(dep)
+	}
+	for k, v := range cf.symbols {
+		syms[k] = v
+	}
+	return syms
+}
+
+// resolveProtoName resolves a relative name in scope like protoc, searching from the innermost scope outwards.
+// The first part of the name is resolved first, the rest must be declared in what it resolves to.
+func resolveProtoName(syms map[string]string, scope, name string) (string, string, bool) {
+	if strings.HasPrefix(name, ".") {
+		kind, ok := syms[name[1:]]
+		return name[1:], kind, ok
+	}
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+	for s := scope; ; s = protoParent(s) {
+		if kind, ok := syms[joinName(s, first)]; ok {
+			full := joinName(s, name)
+			if first == name {
+				return full, kind, true
+			}
+			if kind, ok := syms[full]; ok {
+				return full, kind, true
+			}
+		}
+		if s == "" {
+			return "", "", false
+		}
+	}
+}
+
+// link resolves the type names of cf, sets json names and defaults and interprets its options.
+func (c *protoCompiler) link(cf *compiledFile) error {
+	syms := cf.visibleSymbols()
+	pkg := cf.fd.GetPackage()
+	var errs []string
+	resolveType := func(scope, element, name string, messageOnly bool) (string, string) {
+		full, kind, ok := resolveProtoName(syms, scope, name)
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("%s: %s: unknown type %s", cf.fd.GetName(), element, name))
+		case kind == "message", kind == "enum" && !messageOnly:
+			return "." + full, kind
+		default:
+			errs = append(errs, fmt.Sprintf("%s: %s: %s is not a message", cf.fd.GetName(), element, name))
+		}
+		return name, ""
+	}
+	resolveField := func(scope string, f *descriptorpb.FieldDescriptorProto) {
+		element := joinName(scope, f.GetName())
+		if f.JsonName == nil {
+			f.JsonName = proto.String(jsonName(f.GetName()))
+		}
+		if f.Extendee != nil {
+			extendee, _ := resolveType(scope, element, f.GetExtendee(), true)
+			f.Extendee = proto.String(extendee)
+		}
+		if f.TypeName == nil {
+			return
+		}
+		name, kind := resolveType(scope, element, f.GetTypeName(), f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP)
+		f.TypeName = proto.String(name)
+		switch {
+		case f.Type != nil:
+		case kind == "message":
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		case kind == "enum":
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		}
+	}
+	var resolveMessages func(scope string, msgs []*descriptorpb.DescriptorProto)
+	resolveMessages = func(scope string, msgs []*descriptorpb.DescriptorProto) {
+		for _, m := range msgs {
+			name := joinName(scope, m.GetName())
+			for _, f := range m.Field {
+				resolveField(name, f)
+			}
+			for _, f := range m.Extension {
+				resolveField(name, f)
+			}
+			resolveMessages(name, m.NestedType)
+		}
+	}
+	resolveMessages(pkg, cf.fd.MessageType)
+	for _, f := range cf.fd.Extension {
+		resolveField(pkg, f)
+	}
+	for _, s := range cf.fd.Service {
+		scope := joinName(pkg, s.GetName())
+		for _, m := range s.Method {
+			element := joinName(scope, m.GetName())
+			input, _ := resolveType(scope, element, m.GetInputType(), true)
+			output, _ := resolveType(scope, element, m.GetOutputType(), true)
+			m.InputType, m.OutputType = proto.String(input), proto.String(output)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	for _, d := range cf.parser.defaults {
+		value, err := protoDefaultValue(d.field, d.value)
+		if err != nil {
+			t := d.value.tok
+			return fmt.Errorf("%s:%d:%d: default of %s: %v", cf.fd.GetName(), t.line+1, t.col+1, d.field.GetName(), err)
+		}
+		d.field.DefaultValue = proto.String(value)
+	}
+	if err := c.interpretOptions(cf, syms); err != nil {
+		return err
+	}
+	for msg, ranges := range cf.parser.maxRanges {
+		if msg.GetOptions().GetMessageSetWireFormat() {
+			for _, r := range ranges {
+				r.End = proto.Int32(math.MaxInt32)
+			}
+		}
+	}
+	return nil
+}
+
+// protoDefaultValue formats a default like protoc does in descriptors.
+func protoDefaultValue(f *descriptorpb.FieldDescriptorProto, c protoConstant) (string, error) {
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "", fmt.Errorf("repeated fields can not have defaults")
+	}
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return "", fmt.Errorf("messages can not have defaults")
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		if c.tok.kind != tokString {
+			return "", fmt.Errorf("expected string")
+		}
+		return c.tok.text, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		if c.tok.kind != tokString {
+			return "", fmt.Errorf("expected string")
+		}
+		return cEscape(c.tok.text), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		if c.tok.kind != tokIdent || c.negative {
+			return "", fmt.Errorf("expected enum value")
+		}
+		return c.tok.text, nil
+	}
+	if t := f.GetType(); t == descriptorpb.FieldDescriptorProto_TYPE_FLOAT || t == descriptorpb.FieldDescriptorProto_TYPE_DOUBLE {
+		v, err := protoFloat(c)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		case math.IsNaN(v):
+			return "nan", nil
+		case t == descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+			return strconv.FormatFloat(v, 'g', -1, 32), nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	// the scalar field types have the numbers of their kinds
+	v, err := protoScalar(protoreflect.Kind(f.GetType()), c)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(v.Interface()), nil
+}
+
+// cEscape escapes bytes like protoc does for defaults of bytes fields.
+func cEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&sb, "\\%03o", c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func protoFloat(c protoConstant) (float64, error) {
+	var v float64
+	switch {
+	case c.tok.kind == tokInt:
+		u, err := parseProtoUint(c.tok.text)
+		if err != nil {
+			return 0, err
+		}
+		v = float64(u)
+	case c.tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(c.tok.text, 64)
+		if err != nil {
+			return 0, err
+		}
+		v = f
+	case c.tok.kind == tokIdent && (c.tok.text == "inf" || c.tok.text == "infinity"):
+		v = math.Inf(1)
+	case c.tok.kind == tokIdent && c.tok.text == "nan":
+		v = math.NaN()
+	default:
+		return 0, fmt.Errorf("expected number, found %s", c.tok)
+	}
+	if c.negative {
+		v = -v
+	}
+	return v, nil
+}
+
+// protoScalar converts a constant to a value of a scalar kind other than enums.
+func protoScalar(kind protoreflect.Kind, c protoConstant) (protoreflect.Value, error) {
+	switch kind {
+	case protoreflect.BoolKind:
+		if c.tok.kind == tokIdent && !c.negative && (c.tok.text == "true" || c.tok.text == "false") {
+			return protoreflect.ValueOfBool(c.tok.text == "true"), nil
+		}
+		return protoreflect.Value{}, fmt.Errorf("expected true or false, found %s", c.tok)
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if c.tok.kind != tokString {
+			return protoreflect.Value{}, fmt.Errorf("expected string, found %s", c.tok)
+		}
+		if kind == protoreflect.BytesKind {
+			return protoreflect.ValueOfBytes([]byte(c.tok.text)), nil
+		}
+		return protoreflect.ValueOfString(c.tok.text), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		v, err := protoFloat(c)
+		if kind == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(v)), err
+		}
+		return protoreflect.ValueOfFloat64(v), err
+	}
+	if c.tok.kind != tokInt {
+		return protoreflect.Value{}, fmt.Errorf("expected integer, found %s", c.tok)
+	}
+	u, err := parseProtoUint(c.tok.text)
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("invalid integer %s", c.tok.text)
+	}
+	outOfRange := fmt.Errorf("integer %s out of range", c.tok.text)
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if (c.negative && u > 1<<31) || (!c.negative && u >= 1<<31) {
+			return protoreflect.Value{}, outOfRange
+		}
+		if c.negative {
+			return protoreflect.ValueOfInt32(int32(-int64(u))), nil
+		}
+		return protoreflect.ValueOfInt32(int32(u)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if (c.negative && u > 1<<63) || (!c.negative && u >= 1<<63) {
+			return protoreflect.Value{}, outOfRange
+		}
+		if c.negative {
+			return protoreflect.ValueOfInt64(int64(-u)), nil
+		}
+		return protoreflect.ValueOfInt64(int64(u)), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if c.negative || u >= 1<<32 {
+			return protoreflect.Value{}, outOfRange
+		}
+		return protoreflect.ValueOfUint32(uint32(u)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if c.negative {
+			return protoreflect.Value{}, outOfRange
+		}
+		return protoreflect.ValueOfUint64(u), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %v", kind)
+}
+
+// interpretOptions sets the options of cf, custom ones are resolved in the types of cf and its imports.
+func (c *protoCompiler) interpretOptions(cf *compiledFile, syms map[string]string) error {
+	var types *protoregistry.Types
+	// builtin options go first, linking the file for custom ones can depend on them like on allow_alias
+	for _, custom := range []bool{false, true} {
+		for _, o := range cf.parser.options {
+			if o.needsTypes() != custom {
+				continue
+			}
+			if custom && types == nil {
+				var err error
+				if types, err = capture.NewTypes(withoutLegacy(cf.closure()), capture.Options{Cache: typeCache}); err != nil {
+					return fmt.Errorf("%s: %v", cf.fd.GetName(), err)
+				}
+			}
+			if err := setProtoOption(o, joinName(cf.fd.GetPackage(), o.scope), syms, types); err != nil {
+				return fmt.Errorf("%s:%d:%d: option %s: %v", cf.fd.GetName(), o.pos.line+1, o.pos.col+1, o, err)
+			}
+		}
+	}
+	return nil
+}
+
+// closure returns cf and its transitive imports, imports first.
+func (cf *compiledFile) closure() []*descriptorpb.FileDescriptorProto {
+	var files []*descriptorpb.FileDescriptorProto
+	seen := map[*compiledFile]bool{}
+	var add func(f *compiledFile)
+	add = func(f *compiledFile) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		for _, d := range f.deps {
+			add(d)
+		}
+		files = append(files, f.fd)
+	}
+	add(cf)
+	return files
+}
+
+// withoutLegacy returns files with weak fields and message sets declared as regular ones,
+// protobuf-go does not build them but protoc accepts them.
+// Extensions of message sets with numbers beyond regular fields are dropped.
+func withoutLegacy(files []*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	clean := make([]*descriptorpb.FileDescriptorProto, len(files))
+	for i, fd := range files {
+		clean[i] = fd
+		if fd.WeakDependency == nil && !hasLegacy(fd.Extension, fd.MessageType, false) {
+			continue
+		}
+		clean[i] = proto.Clone(fd).(*descriptorpb.FileDescriptorProto)
+		clean[i].WeakDependency = nil
+		clean[i].Extension = regularExtensions(clean[i].Extension)
+		hasLegacy(clean[i].Extension, clean[i].MessageType, true)
+	}
+	return clean
+}
+
+// hasLegacy reports weak fields, message sets and their extensions in msgs and exts, clear removes them.
+func hasLegacy(exts []*descriptorpb.FieldDescriptorProto, msgs []*descriptorpb.DescriptorProto, clear bool) bool {
+	legacy := len(regularExtensions(exts)) != len(exts)
+	for _, m := range msgs {
+		if m.GetOptions().GetMessageSetWireFormat() {
+			legacy = true
+			if clear {
+				m.Options.MessageSetWireFormat = nil
+				for _, r := range m.ExtensionRange {
+					if r.GetEnd() > maxFieldNumber+1 {
+						r.End = proto.Int32(maxFieldNumber + 1)
+					}
+				}
+			}
+		}
+		for _, f := range m.Field {
+			if f.GetOptions().GetWeak() {
+				legacy = true
+				if clear {
+					f.Options.Weak = nil
+				}
+			}
+		}
+		if clear {
+			m.Extension = regularExtensions(m.Extension)
+		}
+		if hasLegacy(m.Extension, m.NestedType, clear) {
+			legacy = true
+		}
+	}
+	return legacy
+}
+
+func regularExtensions(exts []*descriptorpb.FieldDescriptorProto) []*descriptorpb.FieldDescriptorProto {
+	regular := exts[:0:0]
+	for _, ext := range exts {
+		if ext.GetNumber() <= maxFieldNumber {
+			regular = append(regular, ext)
+		}
+	}
+	return regular
+}
+
+func (o *protoOption) needsTypes() bool {
+	for _, p := range o.name {
+		if p.extension {
+			return true
+		}
+	}
+	return o.value.aggregate != ""
+}
+
+// setProtoOption sets the field named by o in its options message.
+func setProtoOption(o *protoOption, scope string, syms map[string]string, types *protoregistry.Types) error {
+	m := o.options.ProtoReflect()
+	for i, part := range o.name {
+		var fd protoreflect.FieldDescriptor
+		if part.extension {
+			full, kind, ok := resolveProtoName(syms, scope, part.name)
+			if !ok || kind != "extension" {
+				return fmt.Errorf("unknown extension %s", part.name)
+			}
+			xt, err := types.FindExtensionByName(protoreflect.FullName(full))
+			if err != nil {
+				return fmt.Errorf("extension %s: %v", full, err)
+			}
+			fd = xt.TypeDescriptor()
+			if fd.ContainingMessage().FullName() != m.Descriptor().FullName() {
+				return fmt.Errorf("%s extends %s, not %s", full, fd.ContainingMessage().FullName(), m.Descriptor().FullName())
+			}
+		} else if fd = m.Descriptor().Fields().ByName(protoreflect.Name(part.name)); fd == nil || part.name == "uninterpreted_option" {
+			return fmt.Errorf("%s has no field %s", m.Descriptor().FullName(), part.name)
+		}
+		if i < len(o.name)-1 {
+			if fd.Message() == nil || fd.IsList() {
+				return fmt.Errorf("%s is not a message", fd.FullName())
+			}
+			m = m.Mutable(fd).Message()
+			continue
+		}
+		var v protoreflect.Value
+		if fd.IsList() {
+			v = m.NewField(fd).List().NewElement()
+		} else {
+			v = m.NewField(fd)
+		}
+		v, err := protoOptionValue(fd, v, o.value, types)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fd.IsList():
+			m.Mutable(fd).List().Append(v)
+		case m.Has(fd):
+			return fmt.Errorf("%s is already set", fd.FullName())
+		default:
+			m.Set(fd, v)
+		}
+	}
+	return nil
+}
+
+// protoOptionValue converts c to a value of fd, empty is a new value for messages.
+func protoOptionValue(fd protoreflect.FieldDescriptor, empty protoreflect.Value, c protoConstant, types *protoregistry.Types) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if c.aggregate == "" {
+			return protoreflect.Value{}, fmt.Errorf("expected message value in braces")
+		}
+		opts := prototext.UnmarshalOptions{}
+		if types != nil {
+			opts.Resolver = types
+		}
+		if err := opts.Unmarshal([]byte(c.aggregate), empty.Message().Interface()); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return empty, nil
+	case protoreflect.EnumKind:
+		if c.tok.kind == tokIdent && !c.negative {
+			if v := fd.Enum().Values().ByName(protoreflect.Name(c.tok.text)); v != nil {
+				return protoreflect.ValueOfEnum(v.Number()), nil
+			}
+		}
+		return protoreflect.Value{}, fmt.Errorf("%s is no value of %s", c.tok, fd.Enum().FullName())
+	}
+	if c.aggregate != "" {
+		return protoreflect.Value{}, fmt.Errorf("%s is not a message", fd.FullName())
+	}
+	return protoScalar(fd.Kind(), c)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("compiler-version", func(ts *transformStep) error {
+		if ts.Value == nil {
+			return nil
+		}
+		var err error
+		ts.version, err = parseCompilerVersion(*ts.Value)
+		return err
+	}, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		req.CompilerVersion = nil
+		if ts.version != nil {
+			req.CompilerVersion = proto.Clone(ts.version).(*pluginpb.Version)
+		}
+		return nil
+	})
+}
+
+// parseCompilerVersion parses MAJOR.MINOR.PATCH[-SUFFIX] like compilerVersion prints it.
+func parseCompilerVersion(s string) (*pluginpb.Version, error) {
+	version, suffix, _ := strings.Cut(s, "-")
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("compiler version %q is not MAJOR.MINOR.PATCH[-SUFFIX]", s)
+	}
+	var nums [3]int32
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("compiler version %q is not MAJOR.MINOR.PATCH[-SUFFIX]", s)
+		}
+		nums[i] = int32(n)
+	}
+	return &pluginpb.Version{
+		Major:  proto.Int32(nums[0]),
+		Minor:  proto.Int32(nums[1]),
+		Patch:  proto.Int32(nums[2]),
+		Suffix: proto.String(suffix),
+	}, nil
+}
+
+// compilerVersionFlags handles -set-compiler-version and -clear-compiler-version.
+type compilerVersionFlags struct {
+	set   string
+	clear bool
+}
+
+// apply overrides the compiler version of req if requested.
+func (cv *compilerVersionFlags) apply(req *pluginpb.CodeGeneratorRequest) error {
+	switch {
+	case cv.set != "" && cv.clear:
+		return fmt.Errorf("-set-compiler-version and -clear-compiler-version can not be combined")
+	case cv.clear:
+		req.CompilerVersion = nil
+	case cv.set != "":
+		v, err := parseCompilerVersion(cv.set)
+		if err != nil {
+			return err
+		}
+		req.CompilerVersion = v
+	}
+	return nil
+}
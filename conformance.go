@@ -0,0 +1,572 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("conformance", "run a plugin on a built-in suite of tricky requests and check its responses", runConformance)
+}
+
+// conformanceCase is a request of the suite, built from the sources of its files.
+type conformanceCase struct {
+	name, description string
+	sources           map[string]string
+	// generate are the files to generate, the others are only imported
+	generate []string
+	// editions marks the first file to generate as edition 2023
+	editions bool
+}
+
+// conformanceResult is the outcome of a case.
+type conformanceResult struct {
+	Case    string   `json:"case"`
+	Status  string   `json:"status"`
+	Files   int      `json:"files"`
+	Details []string `json:"details,omitempty"`
+}
+
+// conformanceVersion is the compiler version of the suite requests, the first protoc with editions.
+const conformanceVersion = "5.27.0"
+
+var conformanceCases = []conformanceCase{
+	{
+		name:        "proto3-optional",
+		description: "proto3 optional fields with synthetic oneofs, one renamed to avoid a collision",
+		sources: map[string]string{"conformance/optional.proto": `syntax = "proto3";
+package conformance.optional;
+option go_package = "example.com/conformance/optional";
+
+message Presence {
+  optional int32 count = 1;
+  optional string name = 2;
+  optional Presence next = 3;
+  optional Kind kind = 4;
+  int32 plain = 5;
+  oneof choice {
+    string a = 6;
+    int64 b = 7;
+  }
+  oneof _size {
+    uint32 size_in_bytes = 8;
+  }
+  optional uint64 size = 9;
+  repeated Kind kinds = 10;
+
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+    KIND_A = 1;
+  }
+}
+`},
+		generate: []string{"conformance/optional.proto"},
+	},
+	{
+		name:        "editions",
+		description: "a file of edition 2023, plugins without editions support must not declare it",
+		sources: map[string]string{"conformance/editions.proto": `package conformance.editions;
+option go_package = "example.com/conformance/editions";
+
+message Edition {
+  optional int32 id = 1;
+  repeated int32 values = 2;
+  optional Status status = 3;
+  map<string, int32> counts = 4;
+  optional Edition parent = 5;
+
+  enum Status {
+    STATUS_UNKNOWN = 0;
+    STATUS_OK = 1;
+  }
+}
+`},
+		generate: []string{"conformance/editions.proto"},
+		editions: true,
+	},
+	{
+		name:        "deep-nesting",
+		description: "messages nested 32 levels deep referencing each other",
+		sources:     map[string]string{"conformance/nesting.proto": deepNestingSource(32)},
+		generate:    []string{"conformance/nesting.proto"},
+	},
+	{
+		name:        "large-oneof",
+		description: "a oneof with 300 fields of all types",
+		sources:     map[string]string{"conformance/oneof.proto": largeOneofSource(300)},
+		generate:    []string{"conformance/oneof.proto"},
+	},
+	{
+		name:        "maps",
+		description: "maps with all key types and scalar, enum and message values",
+		sources:     map[string]string{"conformance/maps.proto": mapsSource()},
+		generate:    []string{"conformance/maps.proto"},
+	},
+	{
+		name:        "custom-options",
+		description: "custom options of all descriptor kinds with scalar, enum, repeated and message values",
+		sources: map[string]string{
+			"conformance/options/options.proto": `syntax = "proto2";
+package conformance.options;
+option go_package = "example.com/conformance/options";
+
+import "google/protobuf/descriptor.proto";
+
+message Rule {
+  optional string name = 1;
+  repeated int32 limits = 2;
+  optional Level level = 3;
+}
+
+enum Level {
+  LEVEL_LOW = 0;
+  LEVEL_HIGH = 1;
+}
+
+extend google.protobuf.FileOptions {
+  optional string owner = 51000;
+}
+extend google.protobuf.MessageOptions {
+  optional Rule rule = 51000;
+  optional bool internal = 51001;
+}
+extend google.protobuf.FieldOptions {
+  repeated string tags = 51000;
+  optional Level level = 51001;
+  optional double weight = 51002;
+}
+extend google.protobuf.OneofOptions {
+  optional bool exclusive = 51000;
+}
+extend google.protobuf.EnumOptions {
+  optional string prefix = 51000;
+}
+extend google.protobuf.EnumValueOptions {
+  optional string label = 51000;
+}
+extend google.protobuf.ServiceOptions {
+  optional string host = 51000;
+}
+extend google.protobuf.MethodOptions {
+  optional Rule method_rule = 51000;
+}
+`,
+			"conformance/options/use.proto": `syntax = "proto3";
+package conformance.options.use;
+option go_package = "example.com/conformance/options/use";
+
+import "conformance/options/options.proto";
+
+option (conformance.options.owner) = "conformance";
+
+message Annotated {
+  option (conformance.options.rule) = { name: "annotated" limits: [1, 2, 3] level: LEVEL_HIGH };
+  option (conformance.options.internal) = true;
+
+  string id = 1 [(conformance.options.tags) = "key", (conformance.options.tags) = "id", (conformance.options.level) = LEVEL_HIGH];
+  double score = 2 [(conformance.options.weight) = -0.5, deprecated = true];
+  oneof value {
+    option (conformance.options.exclusive) = true;
+    string text = 3;
+    int64 number = 4;
+  }
+}
+
+enum Color {
+  option (conformance.options.prefix) = "COLOR_";
+  COLOR_UNSPECIFIED = 0 [(conformance.options.label) = "none"];
+  COLOR_RED = 1 [(conformance.options.label) = "red"];
+}
+
+service Annotations {
+  option (conformance.options.host) = "localhost";
+  rpc Get(Annotated) returns (Annotated) {
+    option (conformance.options.method_rule) = { name: "get" level: LEVEL_LOW };
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+}
+`,
+		},
+		generate: []string{"conformance/options/use.proto"},
+	},
+	{
+		name:        "empty-files",
+		description: "files without declarations, one of them without a package",
+		sources: map[string]string{
+			"conformance/empty.proto":   "syntax = \"proto3\";\noption go_package = \"example.com/conformance/empty\";\n",
+			"conformance/package.proto": "package conformance.empty;\noption go_package = \"example.com/conformance/empty\";\n",
+		},
+		generate: []string{"conformance/empty.proto", "conformance/package.proto"},
+	},
+	{
+		name:        "proto2",
+		description: "required fields, defaults of all types, groups, extension ranges and extensions",
+		sources: map[string]string{"conformance/proto2.proto": `syntax = "proto2";
+package conformance.proto2;
+option go_package = "example.com/conformance/proto2";
+
+message Legacy {
+  required int32 id = 1;
+  optional string name = 2 [default = "unnamed \"quoted\""];
+  optional bytes data = 3 [default = "\000\001\377"];
+  optional double ratio = 4 [default = -inf];
+  optional float scale = 5 [default = 1.5];
+  optional bool enabled = 6 [default = true];
+  optional uint64 big = 7 [default = 18446744073709551615];
+  optional sint32 delta = 8 [default = -2147483648];
+  optional Mode mode = 9 [default = MODE_B];
+  optional group Result = 10 {
+    optional string url = 11;
+    repeated string snippets = 12;
+  }
+  repeated int32 packed = 13 [packed = true];
+  reserved 20 to 29;
+  reserved "removed";
+  extensions 100 to 199, 1000 to max;
+
+  enum Mode {
+    MODE_A = 1;
+    MODE_B = 2;
+  }
+
+  extend Legacy {
+    optional Legacy nested_extension = 100;
+  }
+}
+
+extend Legacy {
+  optional string note = 101;
+  repeated int32 numbers = 102;
+}
+`},
+		generate: []string{"conformance/proto2.proto"},
+	},
+	{
+		name:        "services",
+		description: "services with unary and streaming methods and well-known types",
+		sources: map[string]string{"conformance/services.proto": `syntax = "proto3";
+package conformance.services;
+option go_package = "example.com/conformance/services";
+
+import "google/protobuf/empty.proto";
+import "google/protobuf/timestamp.proto";
+
+message Event {
+  string id = 1;
+  google.protobuf.Timestamp at = 2;
+}
+
+service Events {
+  rpc Get(Event) returns (Event);
+  rpc List(google.protobuf.Empty) returns (stream Event);
+  rpc Record(stream Event) returns (google.protobuf.Empty);
+  rpc Sync(stream Event) returns (stream Event) {
+    option deprecated = true;
+  }
+}
+
+service Empty {}
+`},
+		generate: []string{"conformance/services.proto"},
+	},
+	{
+		name:        "unusual-names",
+		description: "names likely to collide with generated code",
+		sources:     map[string]string{"conformance/names.proto": unusualNamesSource()},
+		generate:    []string{"conformance/names.proto"},
+	},
+}
+
+func deepNestingSource(depth int) string {
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto3\";\npackage conformance.nesting;\noption go_package = \"example.com/conformance/nesting\";\n\n")
+	for i := 0; i < depth; i++ {
+		indent := strings.Repeat("  ", i)
+		fmt.Fprintf(&sb, "%smessage Level%d {\n", indent, i)
+		fmt.Fprintf(&sb, "%s  Level0 root = 1;\n", indent)
+		fmt.Fprintf(&sb, "%s  repeated Level%d siblings = 2;\n", indent, i)
+	}
+	// the innermost message references its parent, the parents reference their child
+	fmt.Fprintf(&sb, "%s  Level%d parent = 3;\n", strings.Repeat("  ", depth-1), depth-2)
+	for i := depth - 1; i >= 0; i-- {
+		indent := strings.Repeat("  ", i)
+		if i < depth-1 {
+			fmt.Fprintf(&sb, "%s  Level%d child = 4;\n", indent, i+1)
+		}
+		fmt.Fprintf(&sb, "%s}\n", indent)
+	}
+	return sb.String()
+}
+
+func largeOneofSource(fields int) string {
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto3\";\npackage conformance.oneof;\noption go_package = \"example.com/conformance/oneof\";\n\nmessage Big {\n  string before = 1;\n  oneof choice {\n")
+	types := []string{
+		"double", "float", "int64", "uint64", "int32", "fixed64", "fixed32", "bool", "string", "bytes",
+		"uint32", "sfixed32", "sfixed64", "sint32", "sint64", "Big", "Kind",
+	}
+	for i := 0; i < fields; i++ {
+		fmt.Fprintf(&sb, "    %s choice_%d = %d;\n", types[i%len(types)], i, i+2)
+	}
+	fmt.Fprintf(&sb, "  }\n  string after = %d;\n\n  enum Kind {\n    KIND_UNSPECIFIED = 0;\n  }\n}\n", fields+2)
+	return sb.String()
+}
+
+func mapsSource() string {
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto3\";\npackage conformance.maps;\noption go_package = \"example.com/conformance/maps\";\n\nmessage Maps {\n")
+	keys := []string{"int32", "int64", "uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64", "bool", "string"}
+	n := 1
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "  map<%s, string> %s_to_string = %d;\n", k, k, n)
+		n++
+	}
+	for _, v := range []string{"bytes", "double", "Value", "Kind", "Maps"} {
+		fmt.Fprintf(&sb, "  map<string, %s> string_to_%s = %d;\n", v, strings.ToLower(v), n)
+		n++
+	}
+	sb.WriteString("\n  message Value {\n    map<int32, Value> children = 1;\n  }\n\n  enum Kind {\n    KIND_UNSPECIFIED = 0;\n  }\n}\n")
+	return sb.String()
+}
+
+func unusualNamesSource() string {
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto2\";\npackage conformance.names;\noption go_package = \"example.com/conformance/names\";\n\n")
+	for _, msg := range unusualMessageNames {
+		fmt.Fprintf(&sb, "message %s {\n", msg)
+		for i, field := range unusualNames {
+			fmt.Fprintf(&sb, "  optional string %s = %d;\n", field, i+1)
+		}
+		sb.WriteString("}\n\n")
+	}
+	sb.WriteString("enum Keywords {\n  class = 0;\n  func = 1;\n  self = 2;\n  nil = 3;\n}\n")
+	return sb.String()
+}
+
+// request builds the request of cc.
+func (cc *conformanceCase) request(parameter string) (*pluginpb.CodeGeneratorRequest, error) {
+	c := &protoCompiler{sources: cc.sources, files: map[string]*compiledFile{}}
+	req, err := c.request(cc.generate, nil)
+	if err != nil {
+		return nil, fmt.Errorf("case %s: %v", cc.name, err)
+	}
+	if cc.editions {
+		for _, fd := range req.ProtoFile {
+			if fd.GetName() == cc.generate[0] {
+				// the edition field is unknown to the protobuf module version used here
+				fd.Syntax = proto.String(editionsSyntax)
+				fd.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(fd.ProtoReflect().GetUnknown(), fileEditionField, protowire.VarintType), edition2023))
+			}
+		}
+	}
+	if req.CompilerVersion, err = parseCompilerVersion(conformanceVersion); err != nil {
+		return nil, err
+	}
+	if parameter != "" {
+		req.Parameter = proto.String(parameter)
+	}
+	return req, nil
+}
+
+func runConformance(args []string) error {
+	var (
+		jsonOut   = false
+		parameter = ""
+		only      = ""
+		write     = ""
+	)
+	fs := newFlagSet("conformance")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.StringVar(&parameter, "parameter", parameter, "parameter of the requests")
+	fs.StringVar(&only, "cases", only, "comma separated cases to run, default are all")
+	fs.StringVar(&write, "write", write, "write the requests of the cases into this directory instead of running a plugin")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture conformance [ARGUMENTS] PLUGIN [PLUGIN-ARGS...]\n"+
+			"   or: protoc-gen-capture conformance -write DIR\n\n"+
+			"Each case is a request the plugin must answer with a valid response without error.\n"+
+			"File names of the response must be relative without .. and written once,\n"+
+			"the response must declare proto3_optional for proto3 optional fields.\n"+
+			"A plugin not declaring supports_editions is reported unsupported for editions,\n"+
+			"if it declares it the minimum and maximum edition must include 2023.\n"+
+			"Exits with 3 if a case failed.\n\nCases:\n")
+		for _, cc := range conformanceCases {
+			fmt.Fprintf(os.Stdout, "  %-17s%s\n", cc.name, cc.description)
+		}
+		fmt.Fprint(os.Stdout, "\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	cases := conformanceCases
+	if only != "" {
+		cases = nil
+		for _, name := range strings.Split(only, ",") {
+			found := false
+			for _, cc := range conformanceCases {
+				if cc.name == name {
+					cases = append(cases, cc)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown case %q", name)
+			}
+		}
+	}
+	if write != "" {
+		if err := os.MkdirAll(write, 0o755); err != nil {
+			return err
+		}
+		for _, cc := range cases {
+			req, err := cc.request(parameter)
+			if err != nil {
+				return err
+			}
+			bin, err := encode(req, false)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(write, cc.name+".proto.msg"), bin, 0o644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("conformance needs the plugin command")
+	}
+
+	results := []conformanceResult{}
+	failed := 0
+	for _, cc := range cases {
+		req, err := cc.request(parameter)
+		if err != nil {
+			return err
+		}
+		in, err := encode(req, false)
+		if err != nil {
+			return err
+		}
+		pr, err := runPlugin(context.Background(), fs.Args(), in)
+		if err != nil {
+			return err
+		}
+		res := checkConformance(&cc, req, pr)
+		if res.Status == "fail" {
+			failed++
+		}
+		results = append(results, res)
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else if err := writeConformanceTable(os.Stdout, results); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &exitError{code: exitPluginFailed, err: fmt.Errorf("plugin failed %d of %d cases", failed, len(results))}
+	}
+	return nil
+}
+
+// checkConformance checks the result of the plugin for a case, the status is ok, fail or unsupported.
+func checkConformance(cc *conformanceCase, req *pluginpb.CodeGeneratorRequest, pr *pluginResult) conformanceResult {
+	res := conformanceResult{Case: cc.name, Status: "ok"}
+	fail := func(format string, args ...interface{}) {
+		res.Status = "fail"
+		res.Details = append(res.Details, fmt.Sprintf(format, args...))
+	}
+	resp := pr.resp
+	features := resp.GetSupportedFeatures()
+	editions := features&featureSupportsEditions != 0
+	if cc.editions && !editions {
+		// protoc rejects editions files for plugins without support before running them
+		res.Status = "unsupported"
+		res.Details = append(res.Details, "does not declare supports_editions")
+		return res
+	}
+	if pr.failed() {
+		fail("%s", strings.TrimSpace(pr.failure()))
+		return res
+	}
+	res.Files = len(resp.File)
+	if usesProto3Optional(req) && features&uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) == 0 {
+		fail("uses proto3 optional fields but does not declare proto3_optional, protoc rejects the response")
+	}
+	if editions {
+		unknown := resp.ProtoReflect().GetUnknown()
+		min, hasMin := wireVarint(unknown, responseMinimumEdition)
+		max, hasMax := wireVarint(unknown, responseMaximumEdition)
+		switch {
+		case !hasMin || !hasMax:
+			fail("declares supports_editions without minimum and maximum edition")
+		case cc.editions && (min > edition2023 || max < edition2023):
+			fail("editions %d to %d do not include 2023", min, max)
+		}
+	}
+	written := map[string]bool{}
+	for i, f := range resp.File {
+		name := f.GetName()
+		switch {
+		case name == "" && i == 0:
+			fail("first file has no name")
+		case name == "":
+			// continues the previous file
+		case strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../"):
+			fail("file name %q is not a clean relative path", name)
+		case f.InsertionPoint != nil:
+		case written[name]:
+			fail("file %s is written twice", name)
+		default:
+			written[name] = true
+		}
+	}
+	return res
+}
+
+func usesProto3Optional(req *pluginpb.CodeGeneratorRequest) bool {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	found := false
+	for _, fd := range req.ProtoFile {
+		if !generate[fd.GetName()] {
+			continue
+		}
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			if f, ok := desc.(*descriptorpb.FieldDescriptorProto); ok && f.GetProto3Optional() {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+func writeConformanceTable(w io.Writer, results []conformanceResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CASE\tSTATUS\tFILES\tDETAILS")
+	for _, r := range results {
+		details := strings.Join(strings.Fields(strings.Join(r.Details, "; ")), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", r.Case, r.Status, r.Files, details)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/arnehormann/protoc-gen-capture/capture"
+)
+
+func init() {
+	register("meta", "print the metadata of a capture container", runMeta)
+}
+
+// captureMeta describes the context of a capture, it is the metadata of capture containers.
+type captureMeta struct {
+	Version int               `json:"version"`
+	Time    time.Time         `json:"time"`
+	Tool    string            `json:"tool"`
+	Kind    string            `json:"kind"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Env     *captureEnv       `json:"environment,omitempty"`
+	Build   *buildInfo        `json:"build,omitempty"`
+	// Process is the result of the protoc run the capture was made in, set by run
+	Process *processResult `json:"process,omitempty"`
+}
+
+// toolVersion is the module version of this program.
+func toolVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return bi.Main.Path + "@" + bi.Main.Version
+}
+
+func newCaptureMeta(kind string, labels []string) (*captureMeta, error) {
+	meta := &captureMeta{
+		Version: capture.ContainerVersion,
+		Time:    time.Now().UTC(),
+		Tool:    toolVersion(),
+		Kind:    kind,
+		Build:   newBuildInfo(),
+	}
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, want KEY=VALUE", l)
+		}
+		if meta.Labels == nil {
+			meta.Labels = map[string]string{}
+		}
+		meta.Labels[k] = v
+	}
+	return meta, nil
+}
+
+// wrapContainer prepends the container header with meta to payload.
+func wrapContainer(meta *captureMeta, payload []byte) ([]byte, error) {
+	js, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	return capture.WrapContainer(js, payload), nil
+}
+
+// unwrapContainer returns the metadata and payload of a container.
+// Other input is returned as payload without metadata.
+func unwrapContainer(bin []byte) (*captureMeta, []byte, error) {
+	js, payload, err := capture.UnwrapContainer(bin)
+	if err != nil || js == nil {
+		return nil, payload, err
+	}
+	meta := &captureMeta{}
+	if err := json.Unmarshal(js, meta); err != nil {
+		return nil, nil, fmt.Errorf("capture container metadata is invalid: %v", err)
+	}
+	return meta, payload, nil
+}
+
+func runMeta(args []string) error {
+	fs := newFlagSet("meta")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	meta, _, err := unwrapContainer(bin)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("input is no capture container")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(meta)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// languages maps file extensions of generated files to languages.
+var languages = map[string]string{
+	".go":    "go",
+	".java":  "java",
+	".kt":    "kotlin",
+	".ts":    "typescript",
+	".js":    "javascript",
+	".py":    "python",
+	".pyi":   "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".cs":    "csharp",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".swift": "swift",
+	".dart":  "dart",
+	".php":   "php",
+	".md":    "markdown",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".proto": "proto",
+}
+
+// encodeSplitContent encodes resp as json with the content of each file
+// as array of lines or, if dir is set, as reference to a file written into dir.
+// With hash, the sha256 and length of the content are added and the content is
+// only kept if lines or dir is set.
+func encodeSplitContent(resp *pluginpb.CodeGeneratorResponse, dir string, lines, hash bool) ([]byte, error) {
+	head := proto.Clone(resp).(*pluginpb.CodeGeneratorResponse)
+	head.File = nil
+	raw, err := encode(head, true)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	files := make([]map[string]interface{}, 0, len(resp.File))
+	for i, f := range resp.File {
+		meta := proto.Clone(f).(*pluginpb.CodeGeneratorResponse_File)
+		meta.Content = nil
+		raw, err := encode(meta, true)
+		if err != nil {
+			return nil, err
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, err
+		}
+		if lang := languages[strings.ToLower(path.Ext(f.GetName()))]; lang != "" {
+			entry["content_language"] = lang
+		}
+		if hash && f.Content != nil {
+			sig := signContent(f.GetContent())
+			entry["content_sha256"] = sig.sha256
+			entry["content_length"] = sig.length
+		}
+		switch {
+		case f.Content == nil:
+		case dir == "" && !lines:
+		case dir == "":
+			entry["content_lines"] = strings.Split(f.GetContent(), "\n")
+		default:
+			name := f.GetName()
+			if f.GetInsertionPoint() != "" || used[name] {
+				name = fmt.Sprintf("%s.%d", name, i)
+			}
+			used[name] = true
+			p, err := splitPath(dir, name)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(p, []byte(f.GetContent()), 0o644); err != nil {
+				return nil, err
+			}
+			entry["content_file"] = filepath.ToSlash(p)
+		}
+		files = append(files, entry)
+	}
+	if len(files) > 0 {
+		doc["file"] = files
+	}
+	if !jsonOptions.Multiline {
+		return json.Marshal(doc)
+	}
+	out, err := json.MarshalIndent(doc, "", jsonOptions.Indent)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// joinContent reverts encodeSplitContent so the json can be decoded as response.
+// Files with only a hash get their signature line as content.
+// Json without split content is returned unchanged.
+func joinContent(raw []byte) ([]byte, error) {
+	if !strings.Contains(string(raw), `"content_lines"`) && !strings.Contains(string(raw), `"content_file"`) &&
+		!strings.Contains(string(raw), `"content_sha256"`) {
+		return raw, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	var files []map[string]json.RawMessage
+	if err := json.Unmarshal(doc["file"], &files); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		delete(f, "content_language")
+		sum, length := f["content_sha256"], f["content_length"]
+		delete(f, "content_sha256")
+		delete(f, "content_length")
+		var content string
+		if lines, ok := f["content_lines"]; ok {
+			var ls []string
+			if err := json.Unmarshal(lines, &ls); err != nil {
+				return nil, fmt.Errorf("content_lines: %v", err)
+			}
+			content = strings.Join(ls, "\n")
+		} else if file, ok := f["content_file"]; ok {
+			var name string
+			if err := json.Unmarshal(file, &name); err != nil {
+				return nil, fmt.Errorf("content_file: %v", err)
+			}
+			b, err := os.ReadFile(filepath.FromSlash(name))
+			if err != nil {
+				return nil, fmt.Errorf("content_file: %v", err)
+			}
+			content = string(b)
+		} else if sum != nil {
+			var sig contentSignature
+			if err := json.Unmarshal(sum, &sig.sha256); err != nil {
+				return nil, fmt.Errorf("content_sha256: %v", err)
+			}
+			if err := json.Unmarshal(length, &sig.length); err != nil {
+				return nil, fmt.Errorf("content_length: %v", err)
+			}
+			content = sig.String()
+		} else {
+			continue
+		}
+		delete(f, "content_lines")
+		delete(f, "content_file")
+		c, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		f["content"] = c
+	}
+	var err error
+	if doc["file"], err = json.Marshal(files); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// contentSignature identifies generated content by its sha256 and length.
+type contentSignature struct {
+	sha256 string
+	length int
+}
+
+// signatureLine matches the content of files of signed responses.
+var signatureLine = regexp.MustCompile(`^sha256:[0-9a-f]{64} length:[0-9]+\n$`)
+
+func signContent(content string) contentSignature {
+	sum := sha256.Sum256([]byte(content))
+	return contentSignature{sha256: hex.EncodeToString(sum[:]), length: len(content)}
+}
+
+func (cs contentSignature) String() string {
+	return fmt.Sprintf("sha256:%s length:%d\n", cs.sha256, cs.length)
+}
+
+// signResponse returns a copy of resp with the content of each file replaced by its signature line,
+// so it can be compared with a response read from json written with -content-hash.
+func signResponse(resp *pluginpb.CodeGeneratorResponse) *pluginpb.CodeGeneratorResponse {
+	signed := proto.Clone(resp).(*pluginpb.CodeGeneratorResponse)
+	for _, f := range signed.File {
+		if f.Content != nil {
+			f.Content = proto.String(signContent(f.GetContent()).String())
+		}
+	}
+	return signed
+}
+
+// isSigned reports whether the content of all files of resp are signature lines.
+func isSigned(resp *pluginpb.CodeGeneratorResponse) bool {
+	signed := false
+	for _, f := range resp.File {
+		if f.Content == nil {
+			continue
+		}
+		if !signatureLine.MatchString(f.GetContent()) {
+			return false
+		}
+		signed = true
+	}
+	return signed
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("corpus", "compare two capture corpora, e.g. of builds from different branches", runCorpus)
+}
+
+// corpusCapture is the newest capture of a plugin, parameter and files to generate in a corpus.
+type corpusCapture struct {
+	file   string
+	plugin string
+	time   time.Time
+	req    *pluginpb.CodeGeneratorRequest
+}
+
+// corpusChange is a request that appeared, disappeared or changed between two corpora.
+type corpusChange struct {
+	Status          string   `json:"status"`
+	Plugin          string   `json:"plugin,omitempty"`
+	Parameter       string   `json:"parameter,omitempty"`
+	FilesToGenerate []string `json:"files_to_generate"`
+	Old             string   `json:"old,omitempty"`
+	New             string   `json:"new,omitempty"`
+	Details         []string `json:"details,omitempty"`
+}
+
+func runCorpus(args []string) error {
+	var (
+		jsonOut = false
+		all     = false
+	)
+	fs := newFlagSet("corpus")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "also report unchanged requests")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture corpus diff [ARGUMENTS] OLD-DIR NEW-DIR\n\n")
+		fmt.Fprint(os.Stdout, "Captures of both directories and their subdirectories are matched by plugin, parameter\n")
+		fmt.Fprint(os.Stdout, "and files to generate. The plugin is the label plugin, else the subdirectory like run\n")
+		fmt.Fprint(os.Stdout, "stores it. Of several matching captures in a corpus the newest one is compared.\n")
+		fmt.Fprint(os.Stdout, "Requests are reported as appeared, disappeared or changed.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || (args[0] != "diff" && !strings.HasPrefix(args[0], "-")) {
+		fs.Usage()
+		return fmt.Errorf("want subcommand diff")
+	}
+	if args[0] == "diff" {
+		args = args[1:]
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("want OLD-DIR and NEW-DIR")
+	}
+	oldDir, err := localCaptureDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newDir, err := localCaptureDir(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	before, err := loadCorpus(oldDir)
+	if err != nil {
+		return err
+	}
+	after, err := loadCorpus(newDir)
+	if err != nil {
+		return err
+	}
+	changes := diffCorpora(before, after, all)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(changes)
+	}
+	return writeCorpusTable(os.Stdout, changes)
+}
+
+// loadCorpus reads the captures in dir and its subdirectories by their match key.
+// Files that are no requests, like responses next to them, are skipped.
+func loadCorpus(dir string) (map[string]*corpusCapture, error) {
+	captures := map[string]*corpusCapture{}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || d.Name() == captureIndex || isChunk(filepath.Dir(name), d.Name()) {
+			return nil
+		}
+		var raw []byte
+		if isChunkManifest(name) {
+			raw, err = readChunks(name)
+		} else {
+			raw, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		meta, raw, err := unwrapContainer(raw)
+		if err != nil {
+			log.Printf("warning: %s skipped: %v\n", name, err)
+			return nil
+		}
+		req, err := decodeRequest(raw, looksLikeJSON(raw))
+		if err != nil || len(req.FileToGenerate) == 0 || len(req.ProtoFile) == 0 {
+			logEvent(logInfo, "corpus_skip", "file", name, "error", "not a request")
+			return nil
+		}
+		c := &corpusCapture{req: req}
+		c.file, _ = filepath.Rel(dir, name)
+		if meta != nil {
+			c.plugin = meta.Labels["plugin"]
+			c.time = meta.Time
+		}
+		if c.plugin == "" {
+			if sub := filepath.Dir(c.file); sub != "." {
+				c.plugin = filepath.ToSlash(sub)
+			}
+		}
+		if c.time.IsZero() {
+			if info, err := d.Info(); err == nil {
+				c.time = info.ModTime()
+			}
+		}
+		key := corpusKey(c.plugin, req)
+		if prev := captures[key]; prev == nil || prev.time.Before(c.time) {
+			captures[key] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("corpus could not be read: %v", err)
+	}
+	return captures, nil
+}
+
+func corpusKey(plugin string, req *pluginpb.CodeGeneratorRequest) string {
+	files := append([]string(nil), req.FileToGenerate...)
+	sort.Strings(files)
+	return plugin + "\x00" + req.GetParameter() + "\x00" + strings.Join(files, "\x00")
+}
+
+// diffCorpora matches the captures of two corpora, unchanged ones are only reported with all.
+func diffCorpora(before, after map[string]*corpusCapture, all bool) []corpusChange {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	changes := []corpusChange{}
+	for _, k := range sorted {
+		a, b := before[k], after[k]
+		c := corpusChange{}
+		switch {
+		case a == nil:
+			c.Status, c.New = "appeared", b.file
+		case b == nil:
+			c.Status, c.Old = "disappeared", a.file
+		default:
+			c.Old, c.New = a.file, b.file
+			if c.Details = requestChanges(a.req, b.req); len(c.Details) == 0 {
+				if !all {
+					continue
+				}
+				c.Status = "unchanged"
+			} else {
+				c.Status = "changed"
+			}
+		}
+		some := a
+		if some == nil {
+			some = b
+		}
+		c.Plugin = some.plugin
+		c.Parameter = some.req.GetParameter()
+		c.FilesToGenerate = some.req.FileToGenerate
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// requestChanges describes how two requests for the same files differ.
+func requestChanges(a, b *pluginpb.CodeGeneratorRequest) []string {
+	if proto.Equal(a, b) {
+		return nil
+	}
+	var details []string
+	if va, vb := compilerVersion(a), compilerVersion(b); va != vb {
+		if va == "" {
+			va = "unknown"
+		}
+		if vb == "" {
+			vb = "unknown"
+		}
+		details = append(details, fmt.Sprintf("compiler %s -> %s", va, vb))
+	}
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range a.ProtoFile {
+		files[fd.GetName()] = fd
+	}
+	seen := map[string]bool{}
+	for _, fd := range b.ProtoFile {
+		name := fd.GetName()
+		seen[name] = true
+		switch old := files[name]; {
+		case old == nil:
+			details = append(details, "added "+name)
+		case !proto.Equal(old, fd):
+			details = append(details, "changed "+name)
+		}
+	}
+	for _, fd := range a.ProtoFile {
+		if !seen[fd.GetName()] {
+			details = append(details, "removed "+fd.GetName())
+		}
+	}
+	if len(details) == 0 {
+		details = append(details, "file order or other fields")
+	}
+	return details
+}
+
+func writeCorpusTable(w io.Writer, changes []corpusChange) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tPLUGIN\tPARAMETER\tFILES TO GENERATE\tDETAILS")
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Status, c.Plugin, c.Parameter, strings.Join(c.FilesToGenerate, " "), strings.Join(c.Details, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	register("dedupe", "remove duplicate requests of a capture directory and record which capture they duplicate", runDedupe)
+}
+
+// dedupeMapping is the default name of the file mapping removed duplicates to the kept capture.
+const dedupeMapping = "duplicates.json"
+
+// dedupeGroup is a kept capture and the identical captures removed in its favor.
+type dedupeGroup struct {
+	Kept       string   `json:"kept"`
+	Duplicates []string `json:"duplicates"`
+	// Bytes is the size of the removed files
+	Bytes int64 `json:"bytes"`
+}
+
+type dedupeCapture struct {
+	file string
+	time time.Time
+	size int64
+}
+
+func runDedupe(args []string) error {
+	var (
+		jsonOut = false
+		canon   = false
+		dryRun  = false
+		mapping = ""
+	)
+	fs := newFlagSet("dedupe")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output the groups of duplicates as json, else as table")
+	fs.BoolVar(&canon, "canonical", canon, "also treat requests as identical if they only differ in file order and path separators, like -canonical")
+	fs.BoolVar(&dryRun, "dry-run", dryRun, "only report duplicates, do not remove them")
+	fs.StringVar(&mapping, "mapping", mapping, "json file mapping removed duplicates to the kept capture, merged with its previous content, default "+dedupeMapping+" in the capture directory")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture dedupe [ARGUMENTS] CAPTURE-DIR\n\n"+
+			"Requests in the directory and its subdirectories are compared without their container\n"+
+			"metadata. Of identical requests the oldest capture is kept, the others are removed\n"+
+			"from disk and the index and recorded in the mapping.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("dedupe needs exactly one capture directory")
+	}
+	dir := fs.Arg(0)
+	if isRemote(dir) {
+		return fmt.Errorf("dedupe needs a local directory, not %s", dir)
+	}
+	if mapping == "" {
+		mapping = filepath.Join(dir, dedupeMapping)
+	}
+	groups, err := findDuplicates(dir, canon)
+	if err != nil {
+		return err
+	}
+	removed, saved := 0, int64(0)
+	for _, g := range groups {
+		removed += len(g.Duplicates)
+		saved += g.Bytes
+	}
+	if !dryRun && removed > 0 {
+		if err := removeDuplicates(dir, groups, mapping); err != nil {
+			return err
+		}
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "found"
+	}
+	log.Printf("%s %d duplicates of %d captures, %d bytes\n", verb, removed, len(groups), saved)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(groups)
+	}
+	return writeDedupeTable(os.Stdout, groups)
+}
+
+// findDuplicates groups the identical requests in dir and its subdirectories,
+// groups without duplicates are omitted. Names are relative to dir.
+func findDuplicates(dir string, canon bool) ([]dedupeGroup, error) {
+	byKey := map[[sha256.Size]byte][]dedupeCapture{}
+	var keys [][sha256.Size]byte
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || d.Name() == captureIndex || d.Name() == dedupeMapping {
+			return nil
+		}
+		if isChunk(filepath.Dir(name), d.Name()) || isChunkManifest(name) {
+			logEvent(logInfo, "dedupe_skip", "file", name, "error", "chunked capture")
+			return nil
+		}
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		meta, payload, err := unwrapContainer(raw)
+		if err != nil {
+			log.Printf("warning: %s skipped: %v\n", name, err)
+			return nil
+		}
+		req, err := decodeRequest(payload, looksLikeJSON(payload))
+		if err != nil || len(req.FileToGenerate) == 0 || len(req.ProtoFile) == 0 {
+			logEvent(logInfo, "dedupe_skip", "file", name, "error", "not a request")
+			return nil
+		}
+		if canon {
+			canonicalize(req)
+		}
+		if canon || looksLikeJSON(payload) {
+			if payload, err = encode(req, false); err != nil {
+				return err
+			}
+		}
+		c := dedupeCapture{size: int64(len(raw))}
+		c.file, _ = filepath.Rel(dir, name)
+		if meta != nil {
+			c.time = meta.Time
+		} else if info, err := d.Info(); err == nil {
+			c.time = info.ModTime()
+		}
+		key := sha256.Sum256(payload)
+		if byKey[key] == nil {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], c)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("capture directory could not be read: %v", err)
+	}
+	var groups []dedupeGroup
+	for _, key := range keys {
+		cs := byKey[key]
+		if len(cs) < 2 {
+			continue
+		}
+		sort.Slice(cs, func(i, j int) bool {
+			if !cs[i].time.Equal(cs[j].time) {
+				return cs[i].time.Before(cs[j].time)
+			}
+			return cs[i].file < cs[j].file
+		})
+		g := dedupeGroup{Kept: filepath.ToSlash(cs[0].file)}
+		for _, c := range cs[1:] {
+			g.Duplicates = append(g.Duplicates, filepath.ToSlash(c.file))
+			g.Bytes += c.size
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Kept < groups[j].Kept
+	})
+	return groups, nil
+}
+
+// removeDuplicates records the duplicates of groups in the mapping file,
+// removes them and drops them from the indexes of their directories.
+func removeDuplicates(dir string, groups []dedupeGroup, mapping string) error {
+	keptBy := map[string]string{}
+	if raw, err := os.ReadFile(mapping); err == nil {
+		if err := json.Unmarshal(raw, &keptBy); err != nil {
+			return fmt.Errorf("%s: %v", mapping, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	removed := map[string]bool{}
+	for _, g := range groups {
+		for _, dup := range g.Duplicates {
+			keptBy[dup] = g.Kept
+			removed[dup] = true
+		}
+	}
+	// earlier duplicates of a now removed capture point to the capture kept for it
+	for dup, kept := range keptBy {
+		if removed[kept] {
+			keptBy[dup] = keptBy[kept]
+		}
+	}
+	out, err := json.MarshalIndent(keptBy, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(mapping, append(out, '\n'), 0o644); err != nil {
+		return fmt.Errorf("mapping could not be written: %v", err)
+	}
+
+	indexDirs := map[string]bool{}
+	for name := range removed {
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		indexDirs[filepath.Dir(filepath.Join(dir, filepath.FromSlash(name)))] = true
+	}
+	for d := range indexDirs {
+		if err := dropFromIndex(d, func(file string) bool {
+			rel, _ := filepath.Rel(dir, filepath.Join(d, file))
+			return removed[filepath.ToSlash(rel)]
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropFromIndex rewrites the index of dir without the entries drop reports,
+// directories without index are left alone.
+func dropFromIndex(dir string, drop func(file string) bool) error {
+	if _, err := os.Stat(filepath.Join(dir, captureIndex)); os.IsNotExist(err) {
+		return nil
+	}
+	entries, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		if drop(e.File) {
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	// replace the index at once so concurrent readers never see a partial file
+	tmp := filepath.Join(dir, captureIndex+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("capture index could not be written: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, captureIndex)); err != nil {
+		return fmt.Errorf("capture index could not be written: %v", err)
+	}
+	return nil
+}
+
+func writeDedupeTable(w io.Writer, groups []dedupeGroup) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "KEPT\tDUPLICATES\tBYTES\n")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", g.Kept, len(g.Duplicates), g.Bytes)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("fix-deps", nil, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		return fixDependencies(req, ts.Paths)
+	})
+}
+
+// checkDependencies writes the dependencies missing in req to w
+// and returns an error if there are any.
+func checkDependencies(w io.Writer, req *pluginpb.CodeGeneratorRequest) error {
+	missing := missingDependencies(req)
+	for _, m := range missing {
+		fmt.Fprintln(w, m)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d dependencies are missing", len(missing))
+	}
+	fmt.Fprintln(w, "all dependencies are present")
+	return nil
+}
+
+// findDependency looks up a file in the extra descriptor sets
+// and in dirs for files written by -split.
+func findDependency(name string, dirs []string) (*descriptorpb.FileDescriptorProto, error) {
+	for _, fd := range extraFiles {
+		if fd.GetName() == name {
+			return fd, nil
+		}
+	}
+	for _, dir := range dirs {
+		src, err := splitPath(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range []string{".json", ".txtpb"} {
+			raw, err := os.ReadFile(src + ext)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			fd := &descriptorpb.FileDescriptorProto{}
+			// options of unknown extensions can not be kept in text formats
+			if ext == ".json" {
+				err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(raw, fd)
+			} else {
+				err = prototext.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(raw, fd)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", src+ext, err)
+			}
+			if fd.GetName() != name {
+				return nil, fmt.Errorf("%s contains file %q", src+ext, fd.GetName())
+			}
+			return fd, nil
+		}
+	}
+	return nil, nil
+}
+
+// fixDependencies adds the missing dependencies of req and their dependencies
+// from the extra descriptor sets and dirs. Files are kept in dependency order.
+func fixDependencies(req *pluginpb.CodeGeneratorRequest, dirs []string) error {
+	present := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		present[fd.GetName()] = true
+	}
+	var notFound []string
+	queue := append([]*descriptorpb.FileDescriptorProto{}, req.ProtoFile...)
+	added := 0
+	for len(queue) > 0 {
+		fd := queue[0]
+		queue = queue[1:]
+		for _, dep := range fd.Dependency {
+			if present[dep] {
+				continue
+			}
+			present[dep] = true
+			found, err := findDependency(dep, dirs)
+			if err != nil {
+				return err
+			}
+			if found == nil {
+				notFound = append(notFound, fd.GetName()+" imports "+dep)
+				continue
+			}
+			req.ProtoFile = append(req.ProtoFile, found)
+			queue = append(queue, found)
+			added++
+		}
+	}
+	if added > 0 {
+		req.ProtoFile = sortFiles(req.ProtoFile)
+		log.Printf("added %d missing dependencies\n", added)
+	}
+	if len(notFound) > 0 {
+		return fmt.Errorf("dependencies not found: %s", strings.Join(notFound, ", "))
+	}
+	return nil
+}
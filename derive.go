@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("derive", "derive the request of a second stage plugin from a request and the response of the base generator", runDerive)
+}
+
+func runDerive(args []string) error {
+	jsonOut := false
+	fs := newFlagSet("derive")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture derive [ARGUMENTS] REQUEST RESPONSE > derived.proto.msg\n\n"+
+			"The files to generate of the request are restricted to the ones the base generator\n"+
+			"emitted files for, so insertion point plugins running after it can be replayed alone\n"+
+			"on realistic input. Generated files are traced to proto files by the source files\n"+
+			"of their generated code info, else by their names like foo/bar.pb.go, bar_pb2.py or\n"+
+			"BarOuterClass.java for foo/bar.proto, preferring matching directories.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("derive needs a request and a response")
+	}
+	req, err := readRequestFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	resp, err := readResponseFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if resp.GetError() != "" {
+		return fmt.Errorf("%s is an error response: %s", fs.Arg(1), resp.GetError())
+	}
+	derived, unmatched := deriveRequest(req, resp)
+	for _, name := range unmatched {
+		log.Printf("warning: generated file %s matches no file to generate\n", name)
+	}
+	log.Printf("%d of %d files to generate have generated files\n", len(derived.FileToGenerate), len(req.FileToGenerate))
+	if len(derived.FileToGenerate) == 0 {
+		return fmt.Errorf("the response has no files of the files to generate")
+	}
+	if !jsonOut && isTerminal(os.Stdout) {
+		return fmt.Errorf("refusing to write binary proto to a terminal, redirect stdout or use -json-out")
+	}
+	out, err := encode(derived, jsonOut)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// deriveRequest returns a copy of req generating only the files resp has generated
+// files for, in their order in req, and the generated files not traced to one.
+// Insertions into files of earlier generators are ignored.
+func deriveRequest(req *pluginpb.CodeGeneratorRequest, resp *pluginpb.CodeGeneratorResponse) (*pluginpb.CodeGeneratorRequest, []string) {
+	emitted := map[string]bool{}
+	var unmatched []string
+	for _, f := range resp.File {
+		if f.GetInsertionPoint() != "" || f.GetName() == "" {
+			continue
+		}
+		sources := annotatedSources(f, req.FileToGenerate)
+		if len(sources) == 0 {
+			sources = sourcesByName(f.GetName(), req.FileToGenerate)
+		}
+		if len(sources) == 0 {
+			unmatched = append(unmatched, f.GetName())
+		}
+		for _, s := range sources {
+			if !emitted[s] {
+				logEvent(logInfo, "derive", "file", s, "generated", f.GetName())
+			}
+			emitted[s] = true
+		}
+	}
+	derived := proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+	derived.FileToGenerate = nil
+	for _, name := range req.FileToGenerate {
+		if emitted[name] {
+			derived.FileToGenerate = append(derived.FileToGenerate, name)
+		}
+	}
+	return derived, unmatched
+}
+
+// annotatedSources returns the files to generate named in the generated code info of f.
+func annotatedSources(f *pluginpb.CodeGeneratorResponse_File, generate []string) []string {
+	isGenerated := map[string]bool{}
+	for _, name := range generate {
+		isGenerated[name] = true
+	}
+	seen := map[string]bool{}
+	var sources []string
+	for _, a := range f.GetGeneratedCodeInfo().GetAnnotation() {
+		if s := a.GetSourceFile(); isGenerated[s] && !seen[s] {
+			seen[s] = true
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// sourcesByName returns the files to generate whose base name starts the base name
+// of the generated file, ignoring case, underscores and dashes. Of these the longest
+// names win, ties are broken by the longest directory suffix the generated file has.
+func sourcesByName(generated string, generate []string) []string {
+	norm := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(s))
+	}
+	gdir, gbase := path.Split(generated)
+	gstem := norm(gbase)
+	type candidate struct {
+		name        string
+		stem, depth int
+	}
+	var cands []candidate
+	for _, name := range generate {
+		dir, base := path.Split(name)
+		stem := norm(strings.TrimSuffix(base, ".proto"))
+		if stem == "" || !strings.HasPrefix(gstem, stem) {
+			continue
+		}
+		depth := 0
+		for d := strings.Split(strings.Trim(dir, "/"), "/"); len(d) > 0 && d[0] != ""; d = d[1:] {
+			if strings.HasSuffix("/"+strings.Trim(gdir, "/"), "/"+strings.Join(d, "/")) {
+				depth = len(d)
+				break
+			}
+		}
+		cands = append(cands, candidate{name, len(stem), depth})
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].stem != cands[j].stem {
+			return cands[i].stem > cands[j].stem
+		}
+		return cands[i].depth > cands[j].depth
+	})
+	var sources []string
+	for _, c := range cands {
+		if c.stem != cands[0].stem || c.depth != cands[0].depth {
+			break
+		}
+		sources = append(sources, c.name)
+	}
+	return sources
+}
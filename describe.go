@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("describe", "print the descriptor, file and comments of a message, enum, service, field or method by full name", runDescribe)
+}
+
+// symbol is a declaration found by its full name.
+type symbol struct {
+	Name     string          `json:"name"`
+	Kind     string          `json:"kind"`
+	File     string          `json:"file"`
+	Leading  string          `json:"leading,omitempty"`
+	Trailing string          `json:"trailing,omitempty"`
+	Detached []string        `json:"detached,omitempty"`
+	Desc     json.RawMessage `json:"descriptor"`
+
+	desc proto.Message
+}
+
+func runDescribe(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+	)
+	fs := newFlagSet("describe")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as text")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture describe [ARGUMENTS] FULL.NAME... < request\n\n"+
+			"Prints the descriptors with resolved options, the declaring file and the comments.\n"+
+			"Names of nested declarations are separated by dots, enum values are scoped like their enum.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("describe needs a full name")
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	var found []*symbol
+	for _, name := range fs.Args() {
+		s := findSymbol(req, strings.TrimPrefix(name, "."))
+		if s == nil {
+			return fmt.Errorf("%s is not declared in the request", name)
+		}
+		found = append(found, s)
+	}
+	if jsonOut {
+		for _, s := range found {
+			if s.Desc, err = encode(s.desc, true); err != nil {
+				return err
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if len(found) == 1 {
+			return enc.Encode(found[0])
+		}
+		return enc.Encode(found)
+	}
+	for i, s := range found {
+		if i > 0 {
+			fmt.Fprintln(os.Stdout)
+		}
+		if err := s.writeText(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSymbol returns the declaration with the full name or nil.
+func findSymbol(req *pluginpb.CodeGeneratorRequest, name string) *symbol {
+	for _, fd := range req.ProtoFile {
+		var found *symbol
+		walkDeclarations(fd, func(kind, full string, path []int32, desc proto.Message) {
+			if found == nil && full == name {
+				found = &symbol{Name: full, Kind: kind, File: fd.GetName(), desc: desc}
+				found.comments(fd, path)
+			}
+		})
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// comments adds the comments at path in the SourceCodeInfo of fd.
+func (s *symbol) comments(fd *descriptorpb.FileDescriptorProto, path []int32) {
+	want := fmt.Sprint(path)
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		if fmt.Sprint(loc.Path) != want {
+			continue
+		}
+		s.Leading = strings.TrimSpace(loc.GetLeadingComments())
+		s.Trailing = strings.TrimSpace(loc.GetTrailingComments())
+		for _, c := range loc.LeadingDetachedComments {
+			s.Detached = append(s.Detached, strings.TrimSpace(c))
+		}
+		return
+	}
+}
+
+func (s *symbol) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "%s %s\nfile: %s\n", s.Kind, s.Name, s.File)
+	for _, c := range s.Detached {
+		fmt.Fprintf(w, "detached comment:\n%s\n", indentLines(c, "  "))
+	}
+	if s.Leading != "" {
+		fmt.Fprintf(w, "leading comment:\n%s\n", indentLines(s.Leading, "  "))
+	}
+	if s.Trailing != "" {
+		fmt.Fprintf(w, "trailing comment:\n%s\n", indentLines(s.Trailing, "  "))
+	}
+	out, err := prototext.MarshalOptions{Multiline: true, Indent: "  ", EmitUnknown: true}.Marshal(s.desc)
+	if err != nil {
+		return fmt.Errorf("text marshal error: %v", err)
+	}
+	fmt.Fprintf(w, "descriptor:\n%s", indentLines(string(out), "  "))
+	return nil
+}
+
+// indentLines prefixes each non-empty line of s.
+func indentLines(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "")
+}
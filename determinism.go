@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// checkDeterminism runs the plugin runs times on req and writes a diff
+// of each generated file differing from the first run to w.
+func checkDeterminism(w io.Writer, argv []string, req *pluginpb.CodeGeneratorRequest, runs int) error {
+	in, err := encode(req, false)
+	if err != nil {
+		return err
+	}
+	var first *pluginResult
+	var firstContents map[fileKey]string
+	differing := map[fileKey]int{}
+	var order []fileKey
+	identical := 1
+	for i := 0; i < runs; i++ {
+		pr, err := runPlugin(context.Background(), argv, in)
+		if err != nil {
+			return err
+		}
+		if pr.failed() {
+			return pr.exitError()
+		}
+		if first == nil {
+			first, firstContents = pr, responseContents(pr.resp)
+			continue
+		}
+		if bytes.Equal(first.stdout, pr.stdout) {
+			identical++
+			continue
+		}
+		contents := responseContents(pr.resp)
+		for _, k := range changedKeys(firstContents, contents) {
+			if differing[k] == 0 {
+				order = append(order, k)
+				fmt.Fprint(w, unifiedDiff(
+					fmt.Sprintf("%s (run 1)", describeKey(k)),
+					fmt.Sprintf("%s (run %d)", describeKey(k), i+1),
+					firstContents[k], contents[k], 3))
+			}
+			differing[k]++
+		}
+	}
+	if identical == runs {
+		fmt.Fprintf(w, "all %d responses are identical\n", runs)
+		return nil
+	}
+	if len(order) == 0 {
+		// same content, but e.g. in a different order
+		return fmt.Errorf("%d of %d responses differ from the first run in their encoding, not in generated content", runs-identical, runs)
+	}
+	for _, k := range order {
+		fmt.Fprintf(w, "%s differs in %d of %d runs\n", describeKey(k), differing[k], runs-1)
+	}
+	return fmt.Errorf("%d of %d responses differ from the first run", runs-identical, runs)
+}
+
+func describeKey(k fileKey) string {
+	if k.insertionPoint == "" {
+		return k.name
+	}
+	return k.name + "@" + k.insertionPoint
+}
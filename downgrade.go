@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("downgrade-editions", nil, func(_ *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		for _, w := range downgradeEditions(req) {
+			log.Printf("warning: %s\n", w)
+		}
+		return nil
+	})
+}
+
+// fileEditionField is FileDescriptorProto.edition.
+const fileEditionField = 14
+
+// numbers of the features field in the options messages
+const (
+	fileFeatures      = 50
+	messageFeatures   = 12
+	fieldFeatures     = 21
+	oneofFeatures     = 1
+	enumFeatures      = 7
+	enumValueFeatures = 2
+	serviceFeatures   = 34
+	methodFeatures    = 35
+	rangeFeatures     = 50
+)
+
+// values of the FeatureSet fields used here
+const (
+	presenceExplicit       = 1
+	presenceImplicit       = 2
+	presenceLegacyRequired = 3
+	enumOpen               = 1
+	enumClosed             = 2
+	repeatedPacked         = 1
+	repeatedExpanded       = 2
+	utf8None               = 3
+	encodingDelimited      = 2
+)
+
+// featureSet holds the resolved FeatureSet values relevant for syntax.
+type featureSet struct {
+	presence, enumType, repeated, utf8, encoding uint64
+}
+
+// edition2023Features are the defaults of editions 2023 and 2024.
+var edition2023Features = featureSet{
+	presence: presenceExplicit,
+	enumType: enumOpen,
+	repeated: repeatedPacked,
+	utf8:     2,
+	encoding: 1,
+}
+
+// with returns fs overridden by the features set in opts.
+func (fs featureSet) with(opts proto.Message, num protowire.Number) featureSet {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return fs
+	}
+	set := wireBytes(opts.ProtoReflect().GetUnknown(), num)
+	for _, f := range []struct {
+		num protowire.Number
+		v   *uint64
+	}{{1, &fs.presence}, {2, &fs.enumType}, {3, &fs.repeated}, {4, &fs.utf8}, {5, &fs.encoding}} {
+		if v, ok := wireVarint(set, f.num); ok {
+			*f.v = v
+		}
+	}
+	return fs
+}
+
+// wireVarint returns the last value of the varint field num in b.
+func wireVarint(b []byte, num protowire.Number) (uint64, bool) {
+	var found uint64
+	ok := false
+	for len(b) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			break
+		}
+		b = b[tagLen:]
+		m := protowire.ConsumeFieldValue(n, typ, b)
+		if m < 0 {
+			break
+		}
+		if n == num && typ == protowire.VarintType {
+			found, _ = protowire.ConsumeVarint(b)
+			ok = true
+		}
+		b = b[m:]
+	}
+	return found, ok
+}
+
+// dropUnknown removes field num from the unknown fields of m.
+func dropUnknown(m proto.Message, num protowire.Number) {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return
+	}
+	b := m.ProtoReflect().GetUnknown()
+	var kept []byte
+	for len(b) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return
+		}
+		m := protowire.ConsumeFieldValue(n, typ, b[tagLen:])
+		if m < 0 {
+			return
+		}
+		if n != num {
+			kept = append(kept, b[:tagLen+m]...)
+		}
+		b = b[tagLen+m:]
+	}
+	m.ProtoReflect().SetUnknown(kept)
+}
+
+// editionField is a field or extension with its resolved features.
+type editionField struct {
+	fd       *descriptorpb.FieldDescriptorProto
+	md       *descriptorpb.DescriptorProto
+	features featureSet
+}
+
+// downgradeEditions converts files using editions into proto2 or proto3 files
+// with equivalent labels, presence and packing. It returns warnings about
+// features without equivalent.
+func downgradeEditions(req *pluginpb.CodeGeneratorRequest) []string {
+	var warnings []string
+	for _, fd := range req.ProtoFile {
+		if fd.GetSyntax() == editionsSyntax {
+			for _, w := range downgradeFile(fd) {
+				warnings = append(warnings, fd.GetName()+": "+w)
+			}
+		}
+	}
+	return warnings
+}
+
+func downgradeFile(fd *descriptorpb.FileDescriptorProto) []string {
+	var warnings []string
+	file := edition2023Features.with(fd.Options, fileFeatures)
+	dropUnknown(fd, fileEditionField)
+	dropUnknown(fd.Options, fileFeatures)
+
+	var fields []editionField
+	var enums []*descriptorpb.EnumDescriptorProto
+	closedEnums := map[*descriptorpb.EnumDescriptorProto]bool{}
+	proto2Only := false
+	collectEnums := func(parent featureSet, eds []*descriptorpb.EnumDescriptorProto) {
+		for _, ed := range eds {
+			if parent.with(ed.Options, enumFeatures).enumType == enumClosed {
+				closedEnums[ed] = true
+				proto2Only = true
+			}
+			enums = append(enums, ed)
+		}
+	}
+	collectFields := func(parent featureSet, md *descriptorpb.DescriptorProto, fds []*descriptorpb.FieldDescriptorProto) {
+		for _, f := range fds {
+			fs := parent.with(f.Options, fieldFeatures)
+			fields = append(fields, editionField{f, md, fs})
+			if fs.presence == presenceLegacyRequired || f.GetDefaultValue() != "" ||
+				(fs.encoding == encodingDelimited && f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE) {
+				proto2Only = true
+			}
+		}
+	}
+	var walk func(parent featureSet, mds []*descriptorpb.DescriptorProto)
+	walk = func(parent featureSet, mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			ms := parent.with(md.Options, messageFeatures)
+			dropUnknown(md.Options, messageFeatures)
+			if len(md.ExtensionRange) > 0 || len(md.Extension) > 0 {
+				proto2Only = true
+			}
+			for _, r := range md.ExtensionRange {
+				dropUnknown(r.Options, rangeFeatures)
+			}
+			for _, o := range md.OneofDecl {
+				dropUnknown(o.Options, oneofFeatures)
+			}
+			collectFields(ms, md, md.Field)
+			collectFields(ms, nil, md.Extension)
+			collectEnums(ms, md.EnumType)
+			walk(ms, md.NestedType)
+		}
+	}
+	walk(file, fd.MessageType)
+	collectFields(file, nil, fd.Extension)
+	collectEnums(file, fd.EnumType)
+	for _, sd := range fd.Service {
+		dropUnknown(sd.Options, serviceFeatures)
+		for _, m := range sd.Method {
+			dropUnknown(m.Options, methodFeatures)
+		}
+	}
+	for _, f := range fd.Extension {
+		// extensions of options are allowed in proto3
+		if !strings.HasPrefix(f.GetExtendee(), ".google.protobuf.") {
+			proto2Only = true
+		}
+	}
+	for _, ed := range enums {
+		dropUnknown(ed.Options, enumFeatures)
+		for _, v := range ed.Value {
+			dropUnknown(v.Options, enumValueFeatures)
+		}
+	}
+
+	syntax := "proto3"
+	if proto2Only {
+		syntax = "proto2"
+	}
+	fd.Syntax = proto.String(syntax)
+	for _, f := range fields {
+		warnings = append(warnings, downgradeField(f, syntax)...)
+		dropUnknown(f.fd.Options, fieldFeatures)
+	}
+	if syntax == "proto2" {
+		for _, ed := range enums {
+			if !closedEnums[ed] {
+				warnings = append(warnings, fmt.Sprintf("enum %s is open, but proto2 enums are closed", ed.GetName()))
+			}
+		}
+	}
+	clearEmptyOptions(fd)
+	return warnings
+}
+
+// packable reports whether repeated fields of type t can be packed.
+func packable(t descriptorpb.FieldDescriptorProto_Type) bool {
+	switch t {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+func downgradeField(f editionField, syntax string) []string {
+	var warnings []string
+	fd, fs := f.fd, f.features
+	repeated := fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	message := fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	if repeated && packable(fd.GetType()) {
+		// packed is the default in proto3, expanded in proto2
+		switch {
+		case syntax == "proto2" && fs.repeated == repeatedPacked:
+			setOptions(fd).Packed = proto.Bool(true)
+		case syntax == "proto3" && fs.repeated == repeatedExpanded:
+			setOptions(fd).Packed = proto.Bool(false)
+		}
+	}
+	if syntax == "proto2" {
+		switch {
+		case fs.presence == presenceLegacyRequired:
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		case fs.presence == presenceImplicit && !repeated && !message:
+			warnings = append(warnings, fmt.Sprintf("field %s has implicit presence, proto2 fields have explicit presence", fd.GetName()))
+		}
+		if message && fs.encoding == encodingDelimited {
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum()
+		}
+		return warnings
+	}
+	if fs.utf8 == utf8None && fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING {
+		warnings = append(warnings, fmt.Sprintf("field %s does not validate UTF-8, proto3 strings do", fd.GetName()))
+	}
+	// explicit presence of singular scalars needs proto3 optional with a synthetic oneof
+	if f.md != nil && fs.presence == presenceExplicit && !repeated && !message && fd.OneofIndex == nil {
+		name := "_" + fd.GetName()
+		for taken := true; taken; {
+			taken = false
+			for _, o := range f.md.OneofDecl {
+				if o.GetName() == name {
+					name = "X" + name
+					taken = true
+				}
+			}
+		}
+		fd.Proto3Optional = proto.Bool(true)
+		fd.OneofIndex = proto.Int32(int32(len(f.md.OneofDecl)))
+		f.md.OneofDecl = append(f.md.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(name)})
+	}
+	return warnings
+}
+
+func setOptions(fd *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldOptions {
+	if fd.Options == nil {
+		fd.Options = &descriptorpb.FieldOptions{}
+	}
+	return fd.Options
+}
+
+// clearEmptyOptions removes options which only contained features.
+func clearEmptyOptions(fd *descriptorpb.FileDescriptorProto) {
+	if fd.Options != nil && proto.Size(fd.Options) == 0 {
+		fd.Options = nil
+	}
+	clearFields := func(fds []*descriptorpb.FieldDescriptorProto) {
+		for _, f := range fds {
+			if f.Options != nil && proto.Size(f.Options) == 0 {
+				f.Options = nil
+			}
+		}
+	}
+	clearEnums := func(eds []*descriptorpb.EnumDescriptorProto) {
+		for _, ed := range eds {
+			if ed.Options != nil && proto.Size(ed.Options) == 0 {
+				ed.Options = nil
+			}
+			for _, v := range ed.Value {
+				if v.Options != nil && proto.Size(v.Options) == 0 {
+					v.Options = nil
+				}
+			}
+		}
+	}
+	var walk func(mds []*descriptorpb.DescriptorProto)
+	walk = func(mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			if md.Options != nil && proto.Size(md.Options) == 0 {
+				md.Options = nil
+			}
+			for _, o := range md.OneofDecl {
+				if o.Options != nil && proto.Size(o.Options) == 0 {
+					o.Options = nil
+				}
+			}
+			clearFields(md.Field)
+			clearFields(md.Extension)
+			clearEnums(md.EnumType)
+			walk(md.NestedType)
+		}
+	}
+	walk(fd.MessageType)
+	clearFields(fd.Extension)
+	clearEnums(fd.EnumType)
+	for _, sd := range fd.Service {
+		if sd.Options != nil && proto.Size(sd.Options) == 0 {
+			sd.Options = nil
+		}
+		for _, m := range sd.Method {
+			if m.Options != nil && proto.Size(m.Options) == 0 {
+				m.Options = nil
+			}
+		}
+	}
+}
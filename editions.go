@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// editionsSyntax is the syntax of files using editions.
+const editionsSyntax = "editions"
+
+// editions related values missing in the protobuf module version used here.
+const (
+	// featureSupportsEditions is CodeGeneratorResponse.FEATURE_SUPPORTS_EDITIONS
+	featureSupportsEditions = 2
+	// CodeGeneratorResponse.minimum_edition and maximum_edition
+	responseMinimumEdition = 3
+	responseMaximumEdition = 4
+
+	editionProto2 = 998
+	editionProto3 = 999
+	edition2023   = 1000
+	edition2024   = 1001
+)
+
+// responseFeatureNames are the names of the supported features of a CodeGeneratorResponse.
+var responseFeatureNames = map[string]uint64{
+	"proto3_optional":   uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL),
+	"supports_editions": featureSupportsEditions,
+}
+
+// parseFeatures parses comma separated feature names or numbers, all or none.
+func parseFeatures(s string) (uint64, error) {
+	var features uint64
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "feature_")
+		if f, ok := responseFeatureNames[name]; ok {
+			features |= f
+			continue
+		}
+		switch name {
+		case "all":
+			for _, f := range responseFeatureNames {
+				features |= f
+			}
+		case "none", "":
+		default:
+			f, err := strconv.ParseUint(name, 0, 64)
+			if err != nil {
+				return 0, fmt.Errorf("unknown feature %q, want proto3_optional, supports_editions, a number, all or none", name)
+			}
+			features |= f
+		}
+	}
+	return features, nil
+}
+
+// parseEdition parses an edition like 2023, EDITION_2023, proto3 or a number of the Edition enum.
+func parseEdition(s string) (int32, error) {
+	name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "edition_")
+	switch name {
+	case "proto2":
+		return editionProto2, nil
+	case "proto3":
+		return editionProto3, nil
+	case "2023":
+		return edition2023, nil
+	case "2024":
+		return edition2024, nil
+	}
+	n, err := strconv.ParseInt(name, 10, 32)
+	if err != nil || n < editionProto2 {
+		return 0, fmt.Errorf("unknown edition %q, want proto2, proto3, 2023, 2024 or a number of the Edition enum", s)
+	}
+	return int32(n), nil
+}
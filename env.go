@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+)
+
+// envVars are the environment variables recorded in captures by default.
+// The list is short on purpose and has no prefixes, the environment may contain
+// secrets like BUF_TOKEN.
+var envVars = []string{
+	"PATH", "GOPATH", "GOBIN", "GOFLAGS", "GO111MODULE", "LANG", "LC_ALL", "TZ",
+	"BUF_CACHE_DIR", "BUF_CONFIG_DIR",
+}
+
+// captureEnv is the environment a capture was made in.
+type captureEnv struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+	Dir  string `json:"dir,omitempty"`
+	// Protoc is the protoc found in PATH
+	Protoc string `json:"protoc,omitempty"`
+	// Parent is the executable of the calling process if it is known, usually protoc or buf
+	Parent    string            `json:"parent,omitempty"`
+	Args      []string          `json:"args"`
+	Parameter string            `json:"parameter,omitempty"`
+	Vars      map[string]string `json:"vars,omitempty"`
+}
+
+// newCaptureEnv records the environment with the default variables and extra.
+func newCaptureEnv(extra []string) *captureEnv {
+	env := &captureEnv{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		Args: os.Args,
+		Vars: map[string]string{},
+	}
+	env.Dir, _ = os.Getwd()
+	env.Protoc, _ = exec.LookPath("protoc")
+	// only available on linux
+	env.Parent, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", os.Getppid()))
+
+	names := append(append([]string{}, envVars...), extra...)
+	sort.Strings(names)
+	for _, k := range names {
+		if v, ok := os.LookupEnv(k); ok {
+			env.Vars[k] = v
+		}
+	}
+	return env
+}
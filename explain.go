@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("explain", "decode input and explain why it fails to decode", runExplain)
+}
+
+func runExplain(args []string) error {
+	var (
+		jsonIn = false
+		reqIn  = true
+	)
+	fs := newFlagSet("explain")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&reqIn, "req-in", reqIn, "input is request, not response")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	_, err = decode(bin, reqIn, jsonIn)
+	if err == nil {
+		fmt.Fprintf(os.Stdout, "OK: %d bytes decode without errors\n", len(bin))
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "%v\n%s", err, explainDecode(bin, reqIn, jsonIn))
+	return fmt.Errorf("input could not be decoded")
+}
+
+// wireIssue is a problem found while walking the wire format.
+type wireIssue struct {
+	offset int
+	path   string
+	err    error
+}
+
+func (wi wireIssue) String() string {
+	path := wi.path
+	if path == "" {
+		path = "top level"
+	}
+	return fmt.Sprintf("byte offset %d in %s: %v", wi.offset, path, wi.err)
+}
+
+// wireWalker walks binary proto guided by a message descriptor.
+// Contrary to proto.Unmarshal, it does not stop at the first problem
+// but skips broken submessages and continues with their parent.
+type wireWalker struct {
+	issues  []wireIssue
+	unknown []wireIssue
+}
+
+func (ww *wireWalker) walk(b []byte, md protoreflect.MessageDescriptor, base int, path string) {
+	counts := map[protowire.Number]int{}
+	for pos := 0; pos < len(b); {
+		num, typ, n := protowire.ConsumeTag(b[pos:])
+		if n < 0 {
+			ww.issues = append(ww.issues, wireIssue{base + pos, path, fmt.Errorf("invalid tag: %v", protowire.ParseError(n))})
+			return
+		}
+		var fd protoreflect.FieldDescriptor
+		if md != nil {
+			fd = md.Fields().ByNumber(num)
+		}
+		fieldPath := fmt.Sprintf("field %d", num)
+		if fd != nil {
+			fieldPath = string(fd.Name())
+			if fd.Cardinality() == protoreflect.Repeated {
+				fieldPath = fmt.Sprintf("%s[%d]", fd.Name(), counts[num])
+			}
+			counts[num]++
+		}
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		start := pos + n
+		m := protowire.ConsumeFieldValue(num, typ, b[start:])
+		if m < 0 {
+			ww.issues = append(ww.issues, wireIssue{base + start, fieldPath, fmt.Errorf("%s value: %v", wireTypeName(typ), protowire.ParseError(m))})
+			return
+		}
+		switch {
+		case fd == nil:
+			ww.unknown = append(ww.unknown, wireIssue{base + pos, fieldPath, fmt.Errorf("unknown field with %s value", wireTypeName(typ))})
+		case !wireTypeMatches(fd, typ):
+			ww.issues = append(ww.issues, wireIssue{base + pos, fieldPath, fmt.Errorf("wire type %s does not match %s field", wireTypeName(typ), fd.Kind())})
+		case fd.Kind() == protoreflect.MessageKind && typ == protowire.BytesType:
+			v, k := protowire.ConsumeBytes(b[start:])
+			ww.walk(v, fd.Message(), base+start+k, fieldPath)
+		}
+		pos = start + m
+	}
+}
+
+func wireTypeMatches(fd protoreflect.FieldDescriptor, typ protowire.Type) bool {
+	if fd.IsList() && typ == protowire.BytesType {
+		// packed
+		return true
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Uint32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Uint64Kind:
+		return typ == protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return typ == protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return typ == protowire.Fixed64Type
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind:
+		return typ == protowire.BytesType
+	case protoreflect.GroupKind:
+		return typ == protowire.StartGroupType
+	}
+	return false
+}
+
+func wireTypeName(typ protowire.Type) string {
+	switch typ {
+	case protowire.VarintType:
+		return "varint"
+	case protowire.Fixed32Type:
+		return "fixed32"
+	case protowire.Fixed64Type:
+		return "fixed64"
+	case protowire.BytesType:
+		return "length-delimited"
+	case protowire.StartGroupType:
+		return "start group"
+	case protowire.EndGroupType:
+		return "end group"
+	}
+	return fmt.Sprintf("invalid wire type %d", typ)
+}
+
+func looksLikeJSON(bin []byte) bool {
+	trimmed := bytes.TrimSpace(bin)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && utf8.Valid(trimmed)
+}
+
+func messageName(reqIn bool) string {
+	if reqIn {
+		return "CodeGeneratorRequest"
+	}
+	return "CodeGeneratorResponse"
+}
+
+func walkMessage(bin []byte, reqIn bool) *wireWalker {
+	var md protoreflect.MessageDescriptor
+	if reqIn {
+		md = (&pluginpb.CodeGeneratorRequest{}).ProtoReflect().Descriptor()
+	} else {
+		md = (&pluginpb.CodeGeneratorResponse{}).ProtoReflect().Descriptor()
+	}
+	ww := &wireWalker{}
+	ww.walk(bin, md, 0, "")
+	return ww
+}
+
+// explainDecode describes where and why bin could not be decoded.
+func explainDecode(bin []byte, reqIn, jsonIn bool) string {
+	var sb strings.Builder
+	var causes []string
+	switch {
+	case len(bytes.TrimSpace(bin)) == 0:
+		causes = append(causes, "input is empty; did the previous command in the pipeline fail?")
+	case jsonIn && !looksLikeJSON(bin):
+		causes = append(causes, "input is not json but -json-in is set; it might be binary proto")
+	case !jsonIn && looksLikeJSON(bin):
+		causes = append(causes, "input looks like json; use -json-in")
+	}
+	if jsonIn {
+		// protojson errors already contain the position
+		writeCauses(&sb, causes)
+		return sb.String()
+	}
+
+	ww := walkMessage(bin, reqIn)
+	if len(ww.issues) == 0 {
+		fmt.Fprintf(&sb, "the wire format is valid for %s\n", messageName(reqIn))
+		if reqIn {
+			causes = append(causes, "the descriptors in proto_file are inconsistent, so extensions can not be resolved")
+		}
+	}
+	for _, wi := range ww.issues {
+		fmt.Fprintf(&sb, "problem at %v\n", wi)
+		if wi.err != nil && strings.Contains(wi.err.Error(), io.ErrUnexpectedEOF.Error()) {
+			causes = append(causes, fmt.Sprintf("input is truncated; %d bytes were read", len(bin)))
+		}
+	}
+	for _, wi := range ww.unknown {
+		fmt.Fprintf(&sb, "unknown field at %v\n", wi)
+	}
+
+	// check whether the input matches the other message type better
+	other := walkMessage(bin, !reqIn)
+	if len(ww.issues)+len(ww.unknown) > 0 && len(other.issues)+len(other.unknown) == 0 {
+		causes = append(causes, fmt.Sprintf("input is a valid %s; toggle -req-in", messageName(!reqIn)))
+	}
+	if reqIn && len(other.issues) == 0 {
+		resp := &pluginpb.CodeGeneratorResponse{}
+		if proto.Unmarshal(bin, resp) == nil && len(resp.File) == 1 && len(walkMessage([]byte(resp.File[0].GetContent()), true).issues) == 0 {
+			causes = append(causes, "input is a capture still wrapped in a response; unwrap it with -req-in=false or use the contained file")
+		}
+	}
+	writeCauses(&sb, causes)
+	return sb.String()
+}
+
+func writeCauses(sb *strings.Builder, causes []string) {
+	if len(causes) == 0 {
+		return
+	}
+	sb.WriteString("likely causes:\n")
+	for _, c := range causes {
+		fmt.Fprintf(sb, "- %s\n", c)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// fieldTree is a parsed set of field paths like a FieldMask.
+// A node without children selects the whole field.
+type fieldTree map[protoreflect.Name]fieldTree
+
+// parseFieldPaths parses comma separated paths of proto or json field names for md.
+// Unlike FieldMask, paths may continue into the elements of repeated message fields.
+func parseFieldPaths(md protoreflect.MessageDescriptor, paths string) (fieldTree, error) {
+	tree := fieldTree{}
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node, cur := tree, md
+		segments := strings.Split(path, ".")
+		for i, seg := range segments {
+			if cur == nil {
+				return nil, fmt.Errorf("field path %q: %s is no message", path, strings.Join(segments[:i], "."))
+			}
+			fd := cur.Fields().ByName(protoreflect.Name(seg))
+			if fd == nil {
+				fd = cur.Fields().ByJSONName(seg)
+			}
+			if fd == nil {
+				return nil, fmt.Errorf("field path %q: %s has no field %s", path, cur.FullName(), seg)
+			}
+			child, ok := node[fd.Name()]
+			if ok && child == nil {
+				// an enclosing field is selected completely
+				break
+			}
+			if i == len(segments)-1 {
+				node[fd.Name()] = nil
+				break
+			}
+			if child == nil {
+				child = fieldTree{}
+				node[fd.Name()] = child
+			}
+			node, cur = child, nil
+			if !fd.IsMap() {
+				cur = fd.Message()
+			}
+		}
+	}
+	return tree, nil
+}
+
+// projectFields returns a copy of msg with only the selected fields.
+func projectFields(msg proto.Message, tree fieldTree) proto.Message {
+	dst := msg.ProtoReflect().New()
+	projectMessage(msg.ProtoReflect(), dst, tree)
+	return dst.Interface()
+}
+
+func projectMessage(src, dst protoreflect.Message, tree fieldTree) {
+	fields := src.Descriptor().Fields()
+	for name, sub := range tree {
+		fd := fields.ByName(name)
+		if !src.Has(fd) {
+			continue
+		}
+		v := src.Get(fd)
+		switch {
+		case sub == nil:
+			dst.Set(fd, v)
+		case fd.IsList():
+			from, to := v.List(), dst.Mutable(fd).List()
+			for i := 0; i < from.Len(); i++ {
+				elem := to.NewElement()
+				projectMessage(from.Get(i).Message(), elem.Message(), sub)
+				to.Append(elem)
+			}
+		default:
+			projectMessage(v.Message(), dst.Mutable(fd).Message(), sub)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("generate", "generate random valid requests or mutate a capture to fuzz plugins", runGenerate)
+}
+
+// unusualNames are valid proto identifiers likely to collide with generated code.
+var unusualNames = []string{
+	"type", "func", "string", "class", "package", "import", "default", "range", "self", "this",
+	"descriptor", "reset", "XXX_unrecognized", "_leading", "trailing_", "ALLCAPS", "x",
+	"get_value", "set_value", "has_value", "clear_value", "size", "hash", "equals", "proto",
+	"very_long_field_name_that_keeps_going_and_going_to_test_line_wrapping_in_generated_code",
+}
+
+// unusualMessageNames are valid message names likely to collide with generated code.
+var unusualMessageNames = []string{"Message", "Descriptor", "Any", "String_", "Builder", "Object", "Reset", "Enum", "Error", "Type"}
+
+var scalarTypes = []descriptorpb.FieldDescriptorProto_Type{
+	descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+}
+
+// generated option extensions, declared in gen/options.proto
+const (
+	optionsFile      = "gen/options.proto"
+	fieldNoteOption  = 50001
+	messageIDOption  = 50002
+	fileOwnerOption  = 50003
+	optionsExtendee  = ".google.protobuf."
+	descriptorFile   = "google/protobuf/descriptor.proto"
+	generatedPackage = "gen"
+)
+
+func runGenerate(args []string) error {
+	var (
+		seed    = time.Now().UnixNano()
+		g       = generator{files: 3, messages: 4, fields: 8, enums: 2, services: 1, options: true}
+		count   = 1
+		out     = ""
+		jsonOut = false
+		mutate  = 0
+		jsonIn  = false
+	)
+	fs := newFlagSet("generate")
+	fs.Int64Var(&seed, "seed", seed, "seed of the random generator, printed to stderr to reproduce a run")
+	fs.IntVar(&g.files, "files", g.files, "number of generated files, they import earlier ones")
+	fs.IntVar(&g.messages, "messages", g.messages, "number of messages per file")
+	fs.IntVar(&g.fields, "fields", g.fields, "maximum number of fields per message")
+	fs.IntVar(&g.enums, "enums", g.enums, "number of enums per file")
+	fs.IntVar(&g.services, "services", g.services, "number of services per file")
+	fs.BoolVar(&g.options, "options", g.options, "declare custom options in "+optionsFile+" and set them")
+	fs.BoolVar(&g.unusual, "unusual-names", g.unusual, "also use names likely to collide with generated code")
+	fs.IntVar(&count, "count", count, "number of requests, more than one needs -out")
+	fs.StringVar(&out, "out", out, "write the requests into this directory instead of stdout")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.IntVar(&mutate, "mutate", mutate, "apply this many random mutations to the request on stdin instead of generating one")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "for -mutate: input is json, else binary proto")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture generate [ARGUMENTS] > request\n"+
+			"   or: protoc-gen-capture generate -mutate N [ARGUMENTS] < request > mutated-request\n\n"+
+			"Generated requests are valid, mutations flip labels, shuffle fields\n"+
+			"and rename fields, they may produce invalid requests.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if count > 1 && out == "" {
+		return fmt.Errorf("-count needs -out")
+	}
+	log.Printf("seed %d\n", seed)
+	g.rnd = rand.New(rand.NewSource(seed))
+
+	if mutate > 0 {
+		bin, err := readStdin()
+		if err != nil {
+			return err
+		}
+		req, err := decodeRequest(bin, jsonIn)
+		if err != nil {
+			return err
+		}
+		for _, m := range g.mutate(req, mutate) {
+			log.Printf("mutation: %s\n", m)
+		}
+		return writeGenerated(req, out, fmt.Sprintf("mutated-%d.proto.msg", seed), jsonOut)
+	}
+	for i := 0; i < count; i++ {
+		req, err := g.request()
+		if err != nil {
+			return fmt.Errorf("seed %d: %v", seed, err)
+		}
+		if err := writeGenerated(req, out, fmt.Sprintf("generated-%d-%03d.proto.msg", seed, i), jsonOut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGenerated(req *pluginpb.CodeGeneratorRequest, dir, name string, jsonOut bool) error {
+	data, err := encode(req, jsonOut)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if jsonOut {
+		name = strings.TrimSuffix(name, ".msg") + ".json"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0o644)
+}
+
+type generator struct {
+	rnd                                      *rand.Rand
+	files, messages, fields, enums, services int
+	options, unusual                         bool
+}
+
+// genType is a message or enum available for references.
+type genType struct {
+	file, name string
+	proto3     bool
+}
+
+// jsonName is the json name protoc derives from a field name.
+func jsonName(name string) string {
+	var sb strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			sb.WriteRune(c - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(c)
+			upper = false
+		}
+	}
+	return sb.String()
+}
+
+func (g *generator) chance(percent int) bool {
+	return g.rnd.Intn(100) < percent
+}
+
+// optionValue encodes an extension as unknown field.
+func optionValue(opts proto.Message, num protowire.Number, value interface{}) {
+	r := opts.ProtoReflect()
+	b := r.GetUnknown()
+	switch v := value.(type) {
+	case string:
+		b = protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), v)
+	case int64:
+		b = protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), uint64(v))
+	}
+	r.SetUnknown(b)
+}
+
+func generatedOptions() *descriptorpb.FileDescriptorProto {
+	ext := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, extendee string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(jsonName(name)),
+			Number:   proto.Int32(num),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			Extendee: proto.String(optionsExtendee + extendee),
+		}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(optionsFile),
+		Package:    proto.String(generatedPackage + ".opt"),
+		Dependency: []string{descriptorFile},
+		Syntax:     proto.String("proto3"),
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			ext("field_note", fieldNoteOption, descriptorpb.FieldDescriptorProto_TYPE_STRING, "FieldOptions"),
+			ext("message_id", messageIDOption, descriptorpb.FieldDescriptorProto_TYPE_INT64, "MessageOptions"),
+			ext("file_owner", fileOwnerOption, descriptorpb.FieldDescriptorProto_TYPE_STRING, "FileOptions"),
+		},
+	}
+}
+
+// request generates a valid request.
+func (g *generator) request() (*pluginpb.CodeGeneratorRequest, error) {
+	req := &pluginpb.CodeGeneratorRequest{}
+	if g.options {
+		req.ProtoFile = append(req.ProtoFile,
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			generatedOptions())
+	}
+	var messages, enums []genType
+	var generated []string
+	for i := 0; i < g.files; i++ {
+		fd := g.file(i, &messages, &enums)
+		req.ProtoFile = append(req.ProtoFile, fd)
+		if i == g.files-1 || g.chance(50) {
+			generated = append(generated, fd.GetName())
+		}
+	}
+	req.FileToGenerate = generated
+	if _, err := protoTypes(req.ProtoFile); err != nil {
+		return nil, fmt.Errorf("generated request is invalid: %v", err)
+	}
+	return req, nil
+}
+
+// file generates file i, messages and enums contain the declarations of earlier files
+// and get the ones of this file added.
+func (g *generator) file(i int, messages, enums *[]genType) *descriptorpb.FileDescriptorProto {
+	name := fmt.Sprintf("%s/f%d.proto", generatedPackage, i)
+	pkg := fmt.Sprintf("%s.f%d", generatedPackage, i)
+	proto3 := g.chance(50)
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(name),
+		Package: proto.String(pkg),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/gen/f" + fmt.Sprint(i))},
+	}
+	if proto3 {
+		fd.Syntax = proto.String("proto3")
+	}
+	deps := map[string]bool{}
+	if g.options {
+		fd.Dependency = append(fd.Dependency, optionsFile)
+		deps[optionsFile] = true
+		optionValue(fd.Options, fileOwnerOption, fmt.Sprintf("team-%d", g.rnd.Intn(10)))
+	}
+
+	for j := 0; j < g.enums; j++ {
+		ename := fmt.Sprintf("E%d", j)
+		prefix := strings.ToUpper(ename) + "_"
+		ed := &descriptorpb.EnumDescriptorProto{Name: proto.String(ename)}
+		first := int32(0)
+		if !proto3 && g.chance(50) {
+			first = 1
+		}
+		for k := 0; k < 1+g.rnd.Intn(4); k++ {
+			ed.Value = append(ed.Value, &descriptorpb.EnumValueDescriptorProto{
+				Name:   proto.String(fmt.Sprintf("%sV%d", prefix, k)),
+				Number: proto.Int32(first + int32(k)),
+			})
+		}
+		fd.EnumType = append(fd.EnumType, ed)
+		*enums = append(*enums, genType{name, "." + pkg + "." + ename, proto3})
+	}
+
+	// declare all messages first so fields can reference any of them
+	local := make([]genType, g.messages)
+	usedNames := map[string]bool{}
+	for j := range local {
+		mname := fmt.Sprintf("M%d", j)
+		if g.unusual && g.chance(30) {
+			if n := unusualMessageNames[g.rnd.Intn(len(unusualMessageNames))]; !usedNames[n] {
+				mname = n
+			}
+		}
+		usedNames[mname] = true
+		local[j] = genType{name, "." + pkg + "." + mname, proto3}
+		fd.MessageType = append(fd.MessageType, &descriptorpb.DescriptorProto{Name: proto.String(mname)})
+	}
+	candidates := append(append([]genType{}, *messages...), local...)
+	var enumCandidates []genType
+	for _, e := range *enums {
+		// proto3 messages can not use closed proto2 enums
+		if !proto3 || e.proto3 {
+			enumCandidates = append(enumCandidates, e)
+		}
+	}
+	ref := func(t genType) string {
+		if t.file != name && !deps[t.file] {
+			deps[t.file] = true
+			fd.Dependency = append(fd.Dependency, t.file)
+		}
+		return t.name
+	}
+	for j, md := range fd.MessageType {
+		g.message(md, local[j].name, proto3, candidates, enumCandidates, ref)
+		if g.options && g.chance(30) {
+			md.Options = &descriptorpb.MessageOptions{}
+			optionValue(md.Options, messageIDOption, int64(j+1))
+		}
+	}
+	*messages = append(*messages, local...)
+
+	for j := 0; j < g.services && len(candidates) > 0; j++ {
+		sd := &descriptorpb.ServiceDescriptorProto{Name: proto.String(fmt.Sprintf("S%d", j))}
+		for k := 0; k < 1+g.rnd.Intn(3); k++ {
+			m := &descriptorpb.MethodDescriptorProto{
+				Name:       proto.String(fmt.Sprintf("Call%d", k)),
+				InputType:  proto.String(ref(candidates[g.rnd.Intn(len(candidates))])),
+				OutputType: proto.String(ref(candidates[g.rnd.Intn(len(candidates))])),
+			}
+			if g.chance(20) {
+				m.ClientStreaming = proto.Bool(true)
+			}
+			if g.chance(20) {
+				m.ServerStreaming = proto.Bool(true)
+			}
+			sd.Method = append(sd.Method, m)
+		}
+		fd.Service = append(fd.Service, sd)
+	}
+	return fd
+}
+
+// message adds fields, oneofs and map entries to md.
+func (g *generator) message(md *descriptorpb.DescriptorProto, fullName string, proto3 bool, messages, enums []genType, ref func(genType) string) {
+	used, usedJSON := map[string]bool{}, map[string]bool{}
+	number := int32(0)
+	var realOneofs []*descriptorpb.OneofDescriptorProto
+	var synthetic []*descriptorpb.FieldDescriptorProto
+	oneof := -1
+	for k := 0; k < 1+g.rnd.Intn(g.fields); k++ {
+		fname := fmt.Sprintf("f_%d", k)
+		if g.unusual && g.chance(30) {
+			if n := unusualNames[g.rnd.Intn(len(unusualNames))]; !used[n] && !usedJSON[jsonName(n)] {
+				fname = n
+			}
+		}
+		used[fname], usedJSON[jsonName(fname)] = true, true
+		number += 1 + int32(g.rnd.Intn(3))
+		if g.chance(5) {
+			number += 1000
+		}
+		if number >= firstReservedNumber && number <= lastReservedNumber {
+			number = lastReservedNumber + 1
+		}
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(fname),
+			JsonName: proto.String(jsonName(fname)),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		switch r := g.rnd.Intn(10); {
+		case r < 2 && len(messages) > 0:
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String(ref(messages[g.rnd.Intn(len(messages))]))
+		case r < 3 && len(enums) > 0:
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+			f.TypeName = proto.String(ref(enums[g.rnd.Intn(len(enums))]))
+		case r < 4 && !strings.HasPrefix(fname, "_"):
+			g.mapField(md, fullName, f)
+		default:
+			f.Type = scalarTypes[g.rnd.Intn(len(scalarTypes))].Enum()
+		}
+		md.Field = append(md.Field, f)
+		if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			oneof = -1
+			continue
+		}
+
+		// consecutive fields may share a oneof
+		switch {
+		case oneof >= 0 && g.chance(50):
+			f.OneofIndex = proto.Int32(int32(oneof))
+			continue
+		case g.chance(15):
+			oneof = len(realOneofs)
+			realOneofs = append(realOneofs, &descriptorpb.OneofDescriptorProto{Name: proto.String(fmt.Sprintf("choice_%d", oneof))})
+			f.OneofIndex = proto.Int32(int32(oneof))
+			continue
+		}
+		oneof = -1
+		switch {
+		case g.chance(20):
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		case proto3 && f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && g.chance(25):
+			f.Proto3Optional = proto.Bool(true)
+			synthetic = append(synthetic, f)
+		case !proto3 && g.chance(10):
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		case !proto3 && g.chance(15):
+			switch f.GetType() {
+			case descriptorpb.FieldDescriptorProto_TYPE_INT32:
+				f.DefaultValue = proto.String("42")
+			case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+				f.DefaultValue = proto.String("default")
+			case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+				f.DefaultValue = proto.String("true")
+			}
+		}
+		if g.options && g.chance(20) {
+			f.Options = &descriptorpb.FieldOptions{}
+			optionValue(f.Options, fieldNoteOption, "note for "+fname)
+		}
+		if g.chance(5) {
+			if f.Options == nil {
+				f.Options = &descriptorpb.FieldOptions{}
+			}
+			f.Options.Deprecated = proto.Bool(true)
+		}
+	}
+	// synthetic oneofs follow the real ones
+	md.OneofDecl = realOneofs
+	for _, f := range synthetic {
+		name := "_" + f.GetName()
+		for used[name] {
+			name = "X" + name
+		}
+		used[name] = true
+		f.OneofIndex = proto.Int32(int32(len(md.OneofDecl)))
+		md.OneofDecl = append(md.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(name)})
+	}
+}
+
+// mapField turns f into a map with a nested entry message in md.
+func (g *generator) mapField(md *descriptorpb.DescriptorProto, fullName string, f *descriptorpb.FieldDescriptorProto) {
+	entry := strings.ToUpper(jsonName(f.GetName())[:1]) + jsonName(f.GetName())[1:] + "Entry"
+	key := []descriptorpb.FieldDescriptorProto_Type{
+		descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	}[g.rnd.Intn(4)]
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(num),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+	}
+	md.NestedType = append(md.NestedType, &descriptorpb.DescriptorProto{
+		Name: proto.String(entry),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			field("key", 1, key),
+			field("value", 2, scalarTypes[g.rnd.Intn(len(scalarTypes))]),
+		},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	})
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	f.TypeName = proto.String(fullName + "." + entry)
+}
+
+// mutate applies n random mutations to the messages of the files to generate
+// and describes them.
+func (g *generator) mutate(req *pluginpb.CodeGeneratorRequest, n int) []string {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	type target struct {
+		name string
+		md   *descriptorpb.DescriptorProto
+	}
+	var targets []target
+	for _, fd := range req.ProtoFile {
+		if !generate[fd.GetName()] {
+			continue
+		}
+		var walk func(prefix string, mds []*descriptorpb.DescriptorProto)
+		walk = func(prefix string, mds []*descriptorpb.DescriptorProto) {
+			for _, md := range mds {
+				name := prefix + md.GetName()
+				if !md.GetOptions().GetMapEntry() && len(md.Field) > 0 {
+					targets = append(targets, target{name, md})
+				}
+				walk(name+".", md.NestedType)
+			}
+		}
+		walk(filePrefix(fd), fd.MessageType)
+	}
+	var done []string
+	for i := 0; i < n && len(targets) > 0; i++ {
+		t := targets[g.rnd.Intn(len(targets))]
+		f := t.md.Field[g.rnd.Intn(len(t.md.Field))]
+		switch g.rnd.Intn(3) {
+		case 0:
+			g.rnd.Shuffle(len(t.md.Field), func(i, j int) {
+				t.md.Field[i], t.md.Field[j] = t.md.Field[j], t.md.Field[i]
+			})
+			done = append(done, "shuffled fields of "+t.name)
+		case 1:
+			if f.OneofIndex != nil || f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED || f.DefaultValue != nil || isMapField(t.md, f) {
+				i--
+				continue
+			}
+			if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				f.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+				if f.Options != nil {
+					f.Options.Packed = nil
+				}
+			} else {
+				f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			done = append(done, fmt.Sprintf("flipped label of %s.%s to %s", t.name, f.GetName(), labelText(f.GetLabel())))
+		default:
+			name := unusualNames[g.rnd.Intn(len(unusualNames))]
+			taken := false
+			for _, other := range t.md.Field {
+				taken = taken || other.GetName() == name || other.GetJsonName() == jsonName(name)
+			}
+			if taken {
+				i--
+				continue
+			}
+			done = append(done, fmt.Sprintf("renamed %s.%s to %s", t.name, f.GetName(), name))
+			f.Name = proto.String(name)
+			f.JsonName = proto.String(jsonName(name))
+		}
+	}
+	return done
+}
+
+// isMapField reports whether f is a map field of md.
+func isMapField(md *descriptorpb.DescriptorProto, f *descriptorpb.FieldDescriptorProto) bool {
+	if f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return false
+	}
+	for _, nested := range md.NestedType {
+		if nested.GetOptions().GetMapEntry() && strings.HasSuffix(f.GetTypeName(), "."+md.GetName()+"."+nested.GetName()) {
+			return true
+		}
+	}
+	return false
+}
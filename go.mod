@@ -3,3 +3,8 @@ module github.com/arnehormann/protoc-gen-capture
 go 1.18
 
 require google.golang.org/protobuf v1.28.0
+
+require (
+	golang.org/x/net v0.11.0
+	golang.org/x/text v0.10.0 // indirect
+)
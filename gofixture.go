@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"text/template"
+)
+
+// goFixture is the data of goFixtureTemplate.
+type goFixture struct {
+	Package string
+	Name    string
+	// Embed is the file name for go:embed, else Data is embedded as literal
+	Embed string
+	Data  []byte
+}
+
+var goFixtureTemplate = template.Must(template.New("fixture").Funcs(template.FuncMap{
+	"mod": func(a, b int) int { return a % b },
+}).Parse(`// Code generated by protoc-gen-capture -gofixture. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Embed}}
+	_ "embed"
+{{- end}}
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+{{if .Embed}}
+//go:embed {{printf "%q" .Embed}}
+var {{.Name}}Bytes []byte
+{{else}}
+// {{.Name}}Bytes is the captured binary CodeGeneratorRequest.
+var {{.Name}}Bytes = []byte{
+{{- range $i, $b := .Data}}{{if eq 0 (mod $i 16)}}
+	{{else}} {{end}}{{printf "0x%02x," $b}}{{end}}
+}
+{{end}}
+// {{.Name}} decodes {{.Name}}Bytes, resolving extensions declared in the request.
+func {{.Name}}() (*pluginpb.CodeGeneratorRequest, error) {
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal({{.Name}}Bytes, req); err != nil {
+		return nil, fmt.Errorf("request could not be decoded: %v", err)
+	}
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: req.ProtoFile})
+	if err != nil {
+		return nil, fmt.Errorf("request files could not be loaded: %v", err)
+	}
+	types := &protoregistry.Types{}
+	var register func(exts protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors)
+	register = func(exts protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			if err == nil {
+				err = types.RegisterExtension(dynamicpb.NewExtensionType(exts.Get(i)))
+			}
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			register(msgs.Get(i).Extensions(), msgs.Get(i).Messages())
+		}
+	}
+	files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		register(f.Extensions(), f.Messages())
+		return err == nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("request extensions could not be registered: %v", err)
+	}
+	req = &pluginpb.CodeGeneratorRequest{}
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal({{.Name}}Bytes, req); err != nil {
+		return nil, fmt.Errorf("request could not be decoded: %v", err)
+	}
+	return req, nil
+}
+`))
+
+// writeGoFixture creates a go file embedding the binary request bin
+// or an accessor for the file f.Embed.
+func writeGoFixture(f goFixture, bin []byte) ([]byte, error) {
+	if !token.IsIdentifier(f.Package) {
+		return nil, fmt.Errorf("invalid go package name %q", f.Package)
+	}
+	if !token.IsIdentifier(f.Name) {
+		return nil, fmt.Errorf("invalid go name %q", f.Name)
+	}
+	if f.Embed == "" {
+		f.Data = bin
+	}
+	var buf bytes.Buffer
+	if err := goFixtureTemplate.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
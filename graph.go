@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("graph", "print the import graph of a request as dot or mermaid", runGraph)
+}
+
+func runGraph(args []string) error {
+	var (
+		jsonIn = false
+		format = "dot"
+		from   stringsFlag
+	)
+	fs := newFlagSet("graph")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.StringVar(&format, "format", format, "output format, dot or mermaid")
+	fs.Var(&from, "from", "only show the transitive imports of this file, repeatable")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if format != "dot" && format != "mermaid" {
+		return fmt.Errorf("unknown format %q, use dot or mermaid", format)
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	g := newImportGraph(req)
+	if len(from) > 0 {
+		g = g.reachable(from)
+	}
+	if format == "mermaid" {
+		return g.writeMermaid(os.Stdout)
+	}
+	return g.writeDot(os.Stdout)
+}
+
+type importEdge struct {
+	from, to string
+	public   bool
+	weak     bool
+}
+
+type importGraph struct {
+	files    []string
+	generate map[string]bool
+	edges    []importEdge
+}
+
+func newImportGraph(req *pluginpb.CodeGeneratorRequest) *importGraph {
+	g := &importGraph{generate: map[string]bool{}}
+	for _, name := range req.FileToGenerate {
+		g.generate[name] = true
+	}
+	for _, fd := range req.ProtoFile {
+		g.files = append(g.files, fd.GetName())
+		public, weak := map[int32]bool{}, map[int32]bool{}
+		for _, i := range fd.PublicDependency {
+			public[i] = true
+		}
+		for _, i := range fd.WeakDependency {
+			weak[i] = true
+		}
+		for i, dep := range fd.Dependency {
+			g.edges = append(g.edges, importEdge{
+				from:   fd.GetName(),
+				to:     dep,
+				public: public[int32(i)],
+				weak:   weak[int32(i)],
+			})
+		}
+	}
+	sort.Strings(g.files)
+	return g
+}
+
+// reachable returns the subgraph of files imported by roots, directly or transitively.
+func (g *importGraph) reachable(roots []string) *importGraph {
+	out := map[string][]importEdge{}
+	for _, e := range g.edges {
+		out[e.from] = append(out[e.from], e)
+	}
+	seen := map[string]bool{}
+	sub := &importGraph{generate: g.generate}
+	var visit func(string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		sub.files = append(sub.files, name)
+		for _, e := range out[name] {
+			sub.edges = append(sub.edges, e)
+			visit(e.to)
+		}
+	}
+	for _, r := range roots {
+		visit(r)
+	}
+	sort.Strings(sub.files)
+	return sub
+}
+
+func (g *importGraph) writeDot(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph imports {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, f := range g.files {
+		attrs := ""
+		if g.generate[f] {
+			attrs = " [style=filled, fillcolor=lightblue]"
+		}
+		fmt.Fprintf(&sb, "\t%q%s;\n", f, attrs)
+	}
+	for _, e := range g.edges {
+		attrs := ""
+		switch {
+		case e.public:
+			attrs = " [style=bold, label=public]"
+		case e.weak:
+			attrs = " [style=dashed, label=weak]"
+		}
+		fmt.Fprintf(&sb, "\t%q -> %q%s;\n", e.from, e.to, attrs)
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (g *importGraph) writeMermaid(w io.Writer) error {
+	ids := map[string]string{}
+	id := func(name string) string {
+		if s, ok := ids[name]; ok {
+			return s
+		}
+		s := fmt.Sprintf("f%d", len(ids))
+		ids[name] = s
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteString("graph LR\n")
+	for _, f := range g.files {
+		fmt.Fprintf(&sb, "\t%s[%q]\n", id(f), f)
+	}
+	for _, e := range g.edges {
+		arrow := "-->"
+		switch {
+		case e.public:
+			arrow = "==>|public|"
+		case e.weak:
+			arrow = "-.->|weak|"
+		}
+		fmt.Fprintf(&sb, "\t%s %s %s\n", id(e.from), arrow, id(e.to))
+	}
+	sb.WriteString("\tclassDef generate fill:#add8e6\n")
+	for _, f := range g.files {
+		if g.generate[f] {
+			fmt.Fprintf(&sb, "\tclass %s generate\n", id(f))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
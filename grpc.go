@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("remote", "run a request on a remote generator served by serve over gRPC", runRemote)
+}
+
+// grpcGenerate is the path of the gRPC method
+// protoc_gen_capture.CapturePlugin.Generate(CodeGeneratorRequest) returns (CodeGeneratorResponse).
+const grpcGenerate = "/protoc_gen_capture.CapturePlugin/Generate"
+
+// grpcPluginHeader is the metadata key naming the plugin registered with serve -plugin.
+// Without it, the request is captured and returned wrapped like in plugin mode.
+const grpcPluginHeader = "capture-plugin"
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+// grpcFrame prefixes msg with the uncompressed flag and its length.
+func grpcFrame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+// grpcUnframe returns the message of a body containing exactly one uncompressed frame.
+func grpcUnframe(body []byte) ([]byte, error) {
+	if len(body) < 5 {
+		return nil, fmt.Errorf("gRPC message truncated, got %d bytes", len(body))
+	}
+	if body[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint64(len(body)-5) != uint64(n) {
+		return nil, fmt.Errorf("gRPC message has %d bytes, want one message of %d bytes", len(body)-5, n)
+	}
+	return body[5:], nil
+}
+
+// grpcError is a failed gRPC call.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.msg)
+}
+
+// generate serves CapturePlugin.Generate.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	mt := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || (mt != "application/grpc" && !strings.HasPrefix(mt, "application/grpc+")) {
+		http.Error(w, "gRPC endpoint, POST with Content-Type application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	out, err := s.generateResponse(r.Context(), w, r)
+	if err == nil {
+		_, err = w.Write(grpcFrame(out))
+	}
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		if e, ok := err.(*grpcError); ok {
+			code, msg = e.code, e.msg
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func (s *server) generateResponse(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody+5))
+	if err != nil {
+		return nil, &grpcError{grpcResourceExhausted, err.Error()}
+	}
+	bin, err := grpcUnframe(body)
+	if err != nil {
+		if len(body) > 0 && body[0] != 0 {
+			return nil, &grpcError{grpcUnimplemented, err.Error()}
+		}
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	req, err := decodeRequest(bin, false)
+	if err != nil {
+		s.metrics.decodeFailed(grpcGenerate)
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if s.captures != "" {
+		meta, err := newCaptureMeta("request", []string{"source=grpc"})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := storeCapture(s.captures, bin, req, meta, ""); err != nil {
+			return nil, err
+		}
+		s.metrics.captured(len(bin))
+	}
+
+	plugin := r.Header.Get(grpcPluginHeader)
+	if plugin == "" {
+		return wrapResponse("out.proto.msg", bin, false)
+	}
+	argv, ok := s.plugins[plugin]
+	if !ok {
+		return nil, &grpcError{grpcNotFound, fmt.Sprintf("unknown plugin %q, register it with -plugin", plugin)}
+	}
+	res, pr, err := replay(ctx, argv, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.replayed(plugin, res.Duration, res.Failed || pr.resp == nil)
+	if pr.resp == nil {
+		return nil, &grpcError{grpcUnknown, res.Error}
+	}
+	// an error response is a regular result of the plugin protocol
+	return encode(pr.resp, false)
+}
+
+func runRemote(args []string) error {
+	var (
+		jsonIn   = false
+		jsonOut  = false
+		plugin   = ""
+		caFile   = ""
+		insecure = false
+		timeout  = time.Minute
+		passErr  = false
+	)
+	fs := newFlagSet("remote")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.StringVar(&plugin, "plugin", plugin, "run this plugin registered with serve -plugin, else the request is only captured")
+	fs.StringVar(&caFile, "ca", caFile, "PEM file with certificates to verify the server with instead of the system pool")
+	fs.BoolVar(&insecure, "insecure", insecure, "do not verify the server certificate")
+	fs.DurationVar(&timeout, "timeout", timeout, "timeout of the call")
+	fs.BoolVar(&passErr, "pass-error", passErr, "write an error response and exit successfully, like protoc expects it from a plugin")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture remote [ARGUMENTS] HOST:PORT < request > response\n\n"+
+			"Calls "+grpcGenerate+" of a server started with\n"+
+			"serve -tls-cert -tls-key. gRPC needs HTTP/2, which the go standard library\n"+
+			"only speaks over TLS.\n"+
+			"Exits with 2 if the plugin returned an error response and 3 if the call failed.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("remote needs the address of the server")
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	if bin, err = encode(req, false); err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates found", caFile)
+		}
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := grpcCall(ctx, client, fs.Arg(0), plugin, bin)
+	if err != nil {
+		return &exitError{code: exitPluginFailed, err: err}
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(out, resp); err != nil {
+		return &exitError{code: exitPluginFailed, err: fmt.Errorf("CodeGeneratorResponse could not be decoded: %v", err)}
+	}
+	if resp.Error != nil && !passErr {
+		return &exitError{code: exitPluginError, err: fmt.Errorf("plugin error: %s", resp.GetError())}
+	}
+	if out, err = encode(resp, jsonOut); err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// grpcCall calls CapturePlugin.Generate on addr and returns the serialized response.
+func grpcCall(ctx context.Context, client *http.Client, addr, plugin string, req []byte) ([]byte, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(addr, "/")+grpcGenerate, bytes.NewReader(grpcFrame(req)))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	if plugin != "" {
+		r.Header.Set(grpcPluginHeader, plugin)
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor != 2 {
+		return nil, fmt.Errorf("server answered with %s, gRPC needs HTTP/2", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server answered with %s", resp.Status)
+	}
+	// errors without a message may be sent in the headers only
+	status := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or missing grpc-status %q", status)
+	}
+	if code != grpcOK {
+		if m, err := url.PathUnescape(msg); err == nil {
+			msg = m
+		}
+		return nil, &grpcError{code, msg}
+	}
+	return grpcUnframe(body)
+}
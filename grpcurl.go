@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/arnehormann/protoc-gen-capture/capture"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// serviceProtoset returns a FileDescriptorSet with the files to generate declaring
+// services and all files they import, like grpcurl -protoset and Evans expect it.
+// The set is verified to link.
+func serviceProtoset(req *pluginpb.CodeGeneratorRequest) ([]byte, error) {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range req.ProtoFile {
+		byName[fd.GetName()] = fd
+	}
+	keep := map[string]bool{}
+	var add func(name string) error
+	add = func(name string) error {
+		if keep[name] {
+			return nil
+		}
+		fd := byName[name]
+		if fd == nil {
+			return fmt.Errorf("%s is missing in the request", name)
+		}
+		keep[name] = true
+		for _, dep := range fd.Dependency {
+			if err := add(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	services := 0
+	for _, name := range req.FileToGenerate {
+		if fd := byName[name]; fd != nil && len(fd.Service) > 0 {
+			services += len(fd.Service)
+			if err := add(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if services == 0 {
+		return nil, fmt.Errorf("the files to generate declare no services")
+	}
+	// protoc sends files after their imports, grpcurl needs the same order
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range req.ProtoFile {
+		if keep[fd.GetName()] {
+			set.File = append(set.File, fd)
+		}
+	}
+	if _, err := capture.NewFiles(set.File, capture.Options{}); err != nil {
+		return nil, fmt.Errorf("descriptor set does not link: %v", err)
+	}
+	logEvent(logInfo, "protoset", "files", len(set.File), "services", services)
+	return proto.MarshalOptions{Deterministic: true}.Marshal(set)
+}
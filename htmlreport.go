@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+)
+
+const htmlReportStyle = `body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+.summary td, .summary th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.diff { width: 100%; font-family: monospace; font-size: 0.9em; table-layout: fixed; }
+.diff td { vertical-align: top; white-space: pre-wrap; word-break: break-all; padding: 0 0.4em; }
+.diff td.num { width: 3.5em; color: #888; text-align: right; user-select: none; }
+.del { background: #fdd; }
+.add { background: #dfd; }
+.skip td { background: #eef; color: #666; text-align: center; }
+.failed { color: #b00; }
+`
+
+// htmlFile is a changed file of a replay in the html report.
+type htmlFile struct {
+	capture, status string
+	key             fileKey
+	ops             []diffOp
+	added, removed  int
+}
+
+// writeHTMLReport writes a standalone page with a summary and side-by-side diffs
+// of the files changed from the golden responses in results.
+func writeHTMLReport(name string, results []replayResult, context int) error {
+	var files []htmlFile
+	for _, res := range results {
+		if res.want == nil || res.got == nil {
+			continue
+		}
+		want, got := responseContents(res.want), responseContents(res.got)
+		for _, k := range changedKeys(want, got) {
+			a, inA := want[k]
+			b, inB := got[k]
+			f := htmlFile{capture: res.Capture, key: k, status: "changed", ops: diffLines(splitLines(a), splitLines(b))}
+			switch {
+			case !inA:
+				f.status = "added"
+			case !inB:
+				f.status = "removed"
+			}
+			for _, op := range f.ops {
+				switch op.kind {
+				case '+':
+					f.added++
+				case '-':
+					f.removed++
+				}
+			}
+			files = append(files, f)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>protoc-gen-capture replay report</title>\n<style>\n")
+	sb.WriteString(htmlReportStyle)
+	sb.WriteString("</style>\n</head>\n<body>\n<h1>Replay report</h1>\n")
+	fmt.Fprintf(&sb, "<p>%s, %d captures, %d changed files</p>\n", time.Now().UTC().Format(time.RFC3339), len(results), len(files))
+	sb.WriteString("<table class=\"summary\">\n<tr><th>Capture</th><th>File</th><th>Status</th><th>+</th><th>-</th></tr>\n")
+	for _, res := range results {
+		if res.Failed {
+			fmt.Fprintf(&sb, "<tr class=\"failed\"><td>%s</td><td colspan=\"4\">%s failed: %s</td></tr>\n",
+				html.EscapeString(res.Capture), html.EscapeString(res.Plugin), html.EscapeString(res.Error))
+		}
+	}
+	for i, f := range files {
+		fmt.Fprintf(&sb, "<tr><td>%s</td><td><a href=\"#file-%d\">%s</a></td><td>%s</td><td>%d</td><td>%d</td></tr>\n",
+			html.EscapeString(f.capture), i+1, html.EscapeString(htmlFileName(f.key)), f.status, f.added, f.removed)
+	}
+	sb.WriteString("</table>\n")
+	for i, f := range files {
+		fmt.Fprintf(&sb, "<h2 id=\"file-%d\">%s: %s</h2>\n", i+1, html.EscapeString(f.capture), html.EscapeString(htmlFileName(f.key)))
+		writeSideBySide(&sb, f.ops, context)
+	}
+	sb.WriteString("</body>\n</html>\n")
+	if err := os.WriteFile(name, []byte(sb.String()), 0o644); err != nil {
+		return fmt.Errorf("html report could not be written: %v", err)
+	}
+	return nil
+}
+
+func htmlFileName(k fileKey) string {
+	if k.insertionPoint == "" {
+		return k.name
+	}
+	return k.name + " @" + k.insertionPoint
+}
+
+// writeSideBySide writes ops as table with the old lines on the left and the new ones on the right.
+// Unchanged lines more than context lines away from a change are collapsed.
+func writeSideBySide(sb *strings.Builder, ops []diffOp, context int) {
+	sb.WriteString("<table class=\"diff\">\n")
+	cell := func(class string, num int, line string) {
+		if num == 0 {
+			sb.WriteString("<td class=\"num\"></td><td></td>")
+			return
+		}
+		if class != "" {
+			class = " class=\"" + class + "\""
+		}
+		fmt.Fprintf(sb, "<td class=\"num\">%d</td><td%s>%s</td>", num, class, html.EscapeString(strings.TrimSuffix(line, "\n")))
+	}
+	// next[i] is 1 + the index of the first change at or after op i, 0 if there is none
+	next := make([]int, len(ops)+1)
+	for i := len(ops) - 1; i >= 0; i-- {
+		next[i] = next[i+1]
+		if ops[i].kind != ' ' {
+			next[i] = i + 1
+		}
+	}
+	// near reports whether op i is at most context lines away from a change
+	last := -1
+	near := func(i int) bool {
+		if ops[i].kind != ' ' {
+			last = i
+			return true
+		}
+		return (last >= 0 && i-last <= context) || (next[i] > 0 && next[i]-1-i <= context)
+	}
+	lineA, lineB, skipped := 0, 0, 0
+	for i := 0; i < len(ops); {
+		if !near(i) {
+			skipped++
+			lineA++
+			lineB++
+			i++
+			continue
+		}
+		if skipped > 0 {
+			fmt.Fprintf(sb, "<tr class=\"skip\"><td colspan=\"4\">%d unchanged lines</td></tr>\n", skipped)
+			skipped = 0
+		}
+		if ops[i].kind == ' ' {
+			lineA++
+			lineB++
+			sb.WriteString("<tr>")
+			cell("", lineA, ops[i].line)
+			cell("", lineB, ops[i].line)
+			sb.WriteString("</tr>\n")
+			i++
+			continue
+		}
+		// pair a run of deletions with the following insertions
+		var del, ins []string
+		for ; i < len(ops) && ops[i].kind == '-'; i++ {
+			del = append(del, ops[i].line)
+		}
+		for ; i < len(ops) && ops[i].kind == '+'; i++ {
+			ins = append(ins, ops[i].line)
+		}
+		last = i - 1
+		for j := 0; j < len(del) || j < len(ins); j++ {
+			sb.WriteString("<tr>")
+			if j < len(del) {
+				lineA++
+				cell("del", lineA, del[j])
+			} else {
+				cell("", 0, "")
+			}
+			if j < len(ins) {
+				lineB++
+				cell("add", lineB, ins[j])
+			} else {
+				cell("", 0, "")
+			}
+			sb.WriteString("</tr>\n")
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(sb, "<tr class=\"skip\"><td colspan=\"4\">%d unchanged lines</td></tr>\n", skipped)
+	}
+	sb.WriteString("</table>\n")
+}
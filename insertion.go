@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("insertion-points", "list insertion point markers of a response and check the targets of another response", runInsertionPoints)
+}
+
+var insertionMarker = regexp.MustCompile(`@@protoc_insertion_point\(([^)]*)\)`)
+
+// insertionPoint is a marker in generated content.
+type insertionPoint struct {
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	Point string `json:"point"`
+}
+
+// missingTarget is an insertion of another response without matching marker.
+type missingTarget struct {
+	File  string `json:"file"`
+	Point string `json:"point"`
+}
+
+// insertionPoints lists the markers in the generated content of resp in order.
+func insertionPoints(resp *pluginpb.CodeGeneratorResponse) []insertionPoint {
+	var points []insertionPoint
+	for _, f := range resp.File {
+		for i, line := range strings.Split(f.GetContent(), "\n") {
+			for _, m := range insertionMarker.FindAllStringSubmatch(line, -1) {
+				points = append(points, insertionPoint{File: f.GetName(), Line: i + 1, Point: m[1]})
+			}
+		}
+	}
+	return points
+}
+
+// missingTargets lists the insertions of other without a marker in points.
+func missingTargets(points []insertionPoint, other *pluginpb.CodeGeneratorResponse) []missingTarget {
+	have := map[missingTarget]bool{}
+	for _, p := range points {
+		have[missingTarget{p.File, p.Point}] = true
+	}
+	var missing []missingTarget
+	for _, f := range other.File {
+		t := missingTarget{f.GetName(), f.GetInsertionPoint()}
+		if t.Point != "" && !have[t] {
+			have[t] = true
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+func runInsertionPoints(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		check   = ""
+	)
+	fs := newFlagSet("insertion-points")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.StringVar(&check, "check", check, "response file whose insertion points must all exist in the input")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture insertion-points [ARGUMENTS] < response\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	msg, err := decode(bin, false, jsonIn)
+	if err != nil {
+		return err
+	}
+	points := insertionPoints(msg.(*pluginpb.CodeGeneratorResponse))
+	var missing []missingTarget
+	if check != "" {
+		other, err := readResponseFile(check)
+		if err != nil {
+			return err
+		}
+		missing = missingTargets(points, other)
+	}
+
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		v := interface{}(points)
+		if check != "" {
+			v = map[string]interface{}{"points": points, "missing": missing}
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	} else if err := writeInsertionTable(os.Stdout, points, missing); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d insertion points of %s do not exist", len(missing), check)
+	}
+	return nil
+}
+
+func writeInsertionTable(w io.Writer, points []insertionPoint, missing []missingTarget) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tLINE\tPOINT\n")
+	for _, p := range points {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", p.File, p.Line, p.Point)
+	}
+	if len(missing) > 0 {
+		fmt.Fprint(tw, "\nMISSING IN FILE\tPOINT\n")
+		for _, m := range missing {
+			fmt.Fprintf(tw, "%s\t%s\n", m.File, m.Point)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("lint", "check field numbers, reserved names and numbers and enum aliases of a request", runLint)
+}
+
+// field numbers reserved for the protobuf implementation and the largest field number.
+const (
+	firstReservedNumber = 19000
+	lastReservedNumber  = 19999
+	maxFieldNumber      = 1<<29 - 1
+)
+
+// lintRules are the rules of lint with their descriptions.
+var lintRules = []sarifRule{
+	{"duplicate-number", "fields or enum values share a number, enums need allow_alias"},
+	{"reserved-number", "a field or enum value uses a reserved number"},
+	{"reserved-name", "a field or enum value uses a reserved name"},
+	{"implementation-number", "a field number is in 19000-19999, reserved for protobuf"},
+	{"invalid-number", "a field number is not in 1-536870911"},
+	{"unnecessary-alias", "allow_alias is set, but no enum values share a number"},
+}
+
+type lintIssue struct {
+	File    string `json:"file"`
+	Element string `json:"element"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func runLint(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		all     = false
+		sarif   = false
+		prefix  = ""
+	)
+	fs := newFlagSet("lint")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "check all files, not only the files to generate")
+	fs.BoolVar(&sarif, "sarif", sarif, "output as SARIF located in the proto sources, e.g. for code scanning")
+	fs.StringVar(&prefix, "sarif-prefix", prefix, "for -sarif: directory of the import path the proto file names are relative to")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture lint [ARGUMENTS] < request\n\n"+
+			"Rules:\n"+rulesUsage(lintRules, 23)+"\n"+
+			"Exits with 1 if issues are found.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil && !jsonIn {
+		// invalid descriptors can not be built to resolve options, but can be checked
+		log.Printf("warning: %v, options are not resolved\n", err)
+		req, err = decodeUncheckedRequest(bin)
+	}
+	if err != nil {
+		return err
+	}
+	issues := lintRequest(req, all)
+	if sarif {
+		findings := make([]sarifFinding, len(issues))
+		for i, issue := range issues {
+			findings[i] = sarifFinding(issue)
+		}
+		err = writeSARIF(os.Stdout, "protoc-gen-capture lint", lintRules, findings, req, prefix)
+	} else if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(issues)
+	} else {
+		err = writeLintTable(os.Stdout, issues)
+	}
+	if err == nil && len(issues) > 0 {
+		err = fmt.Errorf("%d issues found", len(issues))
+	}
+	return err
+}
+
+// decodeUncheckedRequest decodes a binary request without building its descriptors.
+func decodeUncheckedRequest(bin []byte) (*pluginpb.CodeGeneratorRequest, error) {
+	_, bin, err := unwrapContainer(bin)
+	if err != nil {
+		return nil, err
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(bin, req); err != nil {
+		return nil, fmt.Errorf("CodeGenerationRequest unmarshal failed: %v", err)
+	}
+	return req, nil
+}
+
+func lintRequest(req *pluginpb.CodeGeneratorRequest, all bool) []lintIssue {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	issues := []lintIssue{}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		report := func(element, rule, format string, args ...interface{}) {
+			issues = append(issues, lintIssue{
+				File:    fd.GetName(),
+				Element: element,
+				Rule:    rule,
+				Message: fmt.Sprintf(format, args...),
+			})
+		}
+		lintEnums := func(prefix string, eds []*descriptorpb.EnumDescriptorProto) {
+			for _, ed := range eds {
+				lintEnum(prefix+ed.GetName(), ed, report)
+			}
+		}
+		var walk func(prefix string, mds []*descriptorpb.DescriptorProto)
+		walk = func(prefix string, mds []*descriptorpb.DescriptorProto) {
+			for _, md := range mds {
+				name := prefix + md.GetName()
+				lintMessage(name, md, report)
+				walk(name+".", md.NestedType)
+				lintEnums(name+".", md.EnumType)
+			}
+		}
+		walk(filePrefix(fd), fd.MessageType)
+		lintEnums(filePrefix(fd), fd.EnumType)
+	}
+	return issues
+}
+
+type lintReport func(element, rule, format string, args ...interface{})
+
+func lintMessage(name string, md *descriptorpb.DescriptorProto, report lintReport) {
+	reservedNames := map[string]bool{}
+	for _, n := range md.ReservedName {
+		reservedNames[n] = true
+	}
+	byNumber := map[int32]string{}
+	for _, f := range md.Field {
+		element := name + "." + f.GetName()
+		n := f.GetNumber()
+		if other, ok := byNumber[n]; ok {
+			report(element, "duplicate-number", "number %d is also used by %s", n, other)
+		} else {
+			byNumber[n] = f.GetName()
+		}
+		if reservedNames[f.GetName()] {
+			report(element, "reserved-name", "name %s is reserved", f.GetName())
+		}
+		for _, r := range md.ReservedRange {
+			// the end of message ranges is exclusive
+			if n >= r.GetStart() && n < r.GetEnd() {
+				report(element, "reserved-number", "number %d is reserved by %s", n, rangeText(r.GetStart(), r.GetEnd()-1))
+			}
+		}
+		switch {
+		case n < 1 || n > maxFieldNumber:
+			report(element, "invalid-number", "number %d is not in 1-%d", n, maxFieldNumber)
+		case n >= firstReservedNumber && n <= lastReservedNumber:
+			report(element, "implementation-number", "number %d is in %d-%d, reserved for protobuf", n, firstReservedNumber, lastReservedNumber)
+		}
+	}
+}
+
+func lintEnum(name string, ed *descriptorpb.EnumDescriptorProto, report lintReport) {
+	reservedNames := map[string]bool{}
+	for _, n := range ed.ReservedName {
+		reservedNames[n] = true
+	}
+	aliases := false
+	byNumber := map[int32]string{}
+	for _, v := range ed.Value {
+		element := name + "." + v.GetName()
+		n := v.GetNumber()
+		if other, ok := byNumber[n]; ok {
+			aliases = true
+			if !ed.GetOptions().GetAllowAlias() {
+				report(element, "duplicate-number", "number %d is also used by %s without allow_alias", n, other)
+			}
+		} else {
+			byNumber[n] = v.GetName()
+		}
+		if reservedNames[v.GetName()] {
+			report(element, "reserved-name", "name %s is reserved", v.GetName())
+		}
+		for _, r := range ed.ReservedRange {
+			// the end of enum ranges is inclusive
+			if n >= r.GetStart() && n <= r.GetEnd() {
+				report(element, "reserved-number", "number %d is reserved by %s", n, rangeText(r.GetStart(), r.GetEnd()))
+			}
+		}
+	}
+	if ed.GetOptions().GetAllowAlias() && !aliases {
+		report(name, "unnecessary-alias", "allow_alias is set, but no values share a number")
+	}
+}
+
+func rangeText(first, last int32) string {
+	if first == last {
+		return fmt.Sprint(first)
+	}
+	return fmt.Sprintf("%d-%d", first, last)
+}
+
+func writeLintTable(w io.Writer, issues []lintIssue) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tELEMENT\tRULE\tMESSAGE\n")
+	for _, i := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", i.File, i.Element, i.Rule, i.Message)
+	}
+	return tw.Flush()
+}
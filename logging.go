@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// log levels of logEvent, warnings and errors are always logged
+const (
+	logInfo  = 1
+	logDebug = 2
+)
+
+// logLevel selects the events logged to stderr, set with -v.
+var logLevel = 0
+
+// logJSON writes all diagnostics as json lines, set with -log-json.
+var logJSON = false
+
+// addLogFlags adds -v and -log-json to fs.
+func addLogFlags(fs *flag.FlagSet) {
+	fs.IntVar(&logLevel, "v", logLevel, "log more to stderr, 1 for bytes, durations and files, 2 also for the type registry")
+	fs.BoolVar(&logJSON, "log-json", logJSON, "log to stderr as json lines with level, event and fields for machines")
+}
+
+// setupLogging routes the standard logger through the json writer for -log-json.
+func setupLogging() {
+	if logJSON {
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{})
+	}
+}
+
+// logEvent logs event with key value pairs in fields if level is enabled.
+// Durations are logged in nanoseconds in json, keys of durations end in _ns.
+func logEvent(level int, event string, fields ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	name := "info"
+	if level >= logDebug {
+		name = "debug"
+	}
+	if logJSON {
+		writeJSONLog(name, event, fields...)
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", name, event)
+	for i := 0; i+1 < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if d, ok := fields[i+1].(time.Duration); ok {
+			fmt.Fprintf(&sb, " %s=%v", strings.TrimSuffix(key, "_ns"), d.Round(time.Microsecond))
+			continue
+		}
+		fmt.Fprintf(&sb, " %s=%v", key, fields[i+1])
+	}
+	log.Println(sb.String())
+}
+
+func writeJSONLog(level, event string, fields ...interface{}) {
+	entry := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"event": event,
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		entry[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{"level": "error", "event": "log", "error": err.Error()})
+	}
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// jsonLogWriter converts lines of the standard logger into json lines.
+type jsonLogWriter struct{}
+
+func (jsonLogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	level := "info"
+	switch {
+	case strings.HasPrefix(msg, "warning: "):
+		level, msg = "warning", strings.TrimPrefix(msg, "warning: ")
+	case strings.HasPrefix(msg, "error: "):
+		level, msg = "error", strings.TrimPrefix(msg, "error: ")
+	}
+	writeJSONLog(level, "message", "message", msg)
+	return len(p), nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// wireLeaves lists the scalar values in b as "path = wire value", sorted.
+// Submessages known to md are descended into, so sorting the leaves
+// normalizes the field order.
+func wireLeaves(b []byte, md protoreflect.MessageDescriptor, path string, leaves []string) []string {
+	counts := map[protowire.Number]int{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return append(leaves, fmt.Sprintf("%s = invalid tag %x", path, b))
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return append(leaves, fmt.Sprintf("%s = invalid value %x", path, b))
+		}
+		var fd protoreflect.FieldDescriptor
+		if md != nil {
+			fd = md.Fields().ByNumber(num)
+		}
+		fieldPath := fmt.Sprintf("%s.field %d", path, num)
+		if fd != nil {
+			fieldPath = path + "." + string(fd.Name())
+			if fd.Cardinality() == protoreflect.Repeated {
+				fieldPath = fmt.Sprintf("%s[%d]", fieldPath, counts[num])
+			}
+			counts[num]++
+		}
+		if fd != nil && fd.Kind() == protoreflect.MessageKind && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(b[n:])
+			leaves = wireLeaves(v, fd.Message(), fieldPath, leaves)
+		} else {
+			leaves = append(leaves, fmt.Sprintf("%s = %s %x", fieldPath, wireTypeName(typ), b[n:n+m]))
+		}
+		b = b[n+m:]
+	}
+	sort.Strings(leaves)
+	return leaves
+}
+
+// unknownFields calls fn for each unknown field in m and its submessages.
+func unknownFields(m protoreflect.Message, path string, fn func(path string, num protowire.Number)) {
+	for b := m.GetUnknown(); len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		fn(path, num)
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return
+		}
+		b = b[n+m:]
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = "[" + string(fd.FullName()) + "]"
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				unknownFields(l.Get(i).Message(), fmt.Sprintf("%s[%d]", name, i), fn)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				unknownFields(v.Message(), fmt.Sprintf("%s[%v]", name, k.Interface()), fn)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			unknownFields(v.Message(), name, fn)
+		}
+		return true
+	})
+}
+
+// checkLossless reports to w whether msg decoded from bin encodes to the same data.
+// It returns false if data was lost or changed.
+func checkLossless(w io.Writer, bin []byte, msg proto.Message) (bool, error) {
+	out, err := encode(msg, false)
+	if err != nil {
+		return false, err
+	}
+	md := msg.ProtoReflect().Descriptor()
+	before := wireLeaves(bin, md, string(md.Name()), nil)
+	after := wireLeaves(out, md, string(md.Name()), nil)
+
+	lost, added := diffSorted(before, after)
+	for _, l := range lost {
+		fmt.Fprintf(w, "lost:  %s\n", l)
+	}
+	for _, a := range added {
+		fmt.Fprintf(w, "added: %s\n", a)
+	}
+	unknown := 0
+	unknownFields(msg.ProtoReflect(), string(md.Name()), func(path string, num protowire.Number) {
+		unknown++
+		fmt.Fprintf(w, "unknown field %d in %s: kept in binary proto, dropped in json\n", num, path)
+	})
+	ok := len(lost) == 0 && len(added) == 0
+	if ok {
+		fmt.Fprintf(w, "binary round trip is lossless after normalizing field order, %d unknown fields\n", unknown)
+	} else {
+		fmt.Fprintf(w, "binary round trip lost %d and added %d values\n", len(lost), len(added))
+	}
+	return ok, nil
+}
+
+// diffSorted returns the elements only in a and only in b.
+func diffSorted(a, b []string) (onlyA, onlyB []string) {
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] == b[0]:
+			a, b = a[1:], b[1:]
+		case a[0] < b[0]:
+			onlyA, a = append(onlyA, a[0]), a[1:]
+		default:
+			onlyB, b = append(onlyB, b[0]), b[1:]
+		}
+	}
+	return append(onlyA, a...), append(onlyB, b...)
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"text/template"
+	"time"
 
+	"github.com/arnehormann/protoc-gen-capture/capture"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
-	"google.golang.org/protobuf/types/dynamicpb"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
@@ -24,13 +30,18 @@ Call it as a protoc-plugin to capture code generation requests:
   will create a file out.proto.msg in the current directory.
   For sensible values of ..., that is.
 
+Captures are stored in a container with metadata like the capture time.
+It is unwrapped transparently on input. Use -raw to store the plain
+request, which can be piped into a plugin directly.
+
 To support usage as a plugin, --wrap is true by default.
 Unset it if you do not want to convert input requests to responses.
 Like when you intend to pipe it to test your plugin:
 
 Use it to test a plugin independent of protoc (result as json):
   < cgreq.proto.msg \
-  PLUGIN \
+  protoc_gen_capture -wrap=false \
+  | PLUGIN \
   | protoc_gen_capture -wrap=false -json-out \
   > generation-response.json
 
@@ -39,25 +50,82 @@ You can also convert the code generation request to json:
   protoc_gen_capture -req-in=false -wrap=false -json-out \
   > generation-request.json
 
+Input is read from stdin or from the file given with -in or as the only
+argument, - is stdin:
+  protoc_gen_capture -wrap=false -json-out cgreq.proto.msg
+
 This enables you to diff results of various program versions.
 
 NOTE:
 This program might not be lossless.
 It will always decode and reencode.
-Unknown message parts will not be visible and might get dropped.
+Unknown message parts are kept in binary proto output in deterministic
+order, but they are not visible and get dropped in json output.
+Use -check-lossless to verify a capture and -strict to fail on them.
 
 Decoding for responses is shallow. Included files - if proto -
 will not be decoded.
 `
 
+// exit codes, see exitError
+const (
+	exitFailure      = 1
+	exitPluginError  = 2
+	exitPluginFailed = 3
+)
+
+// exitError sets the exit code for err.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
 func main() {
 	err := run()
 	if err != nil {
-		log.Printf("%v\n", err)
+		code := exitFailure
+		if e, ok := err.(*exitError); ok {
+			code = e.code
+		}
+		if logJSON {
+			writeJSONLog("error", "exit", "error", err.Error(), "code", code)
+		} else {
+			log.Printf("%v\n", err)
+		}
+		if pluginErrors.out != nil && writeErrorResponse(pluginErrors.out, err, pluginErrors.json) == nil {
+			code = 0
+		}
+		os.Exit(code)
+	}
+}
+
+// pluginErrors reports errors of the plugin mode in a response written to out, see -error-response.
+var pluginErrors struct {
+	out  io.Writer
+	json bool
+}
+
+// writeErrorResponse writes a CodeGeneratorResponse with err to w.
+func writeErrorResponse(w io.Writer, err error, asJSON bool) error {
+	out, err := encode(&pluginpb.CodeGeneratorResponse{Error: proto.String(err.Error())}, asJSON)
+	if err != nil {
+		return err
 	}
+	_, err = w.Write(out)
+	return err
 }
 
 func run() error {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			return cmd.run(os.Args[2:])
+		}
+	}
+
 	var (
 		help    = false
 		file    = "out.proto.msg"
@@ -65,6 +133,69 @@ func run() error {
 		jsonOut = false
 		reqIn   = true
 		wrap    = true
+		explain = false
+		canon   = false
+		split   = ""
+		splitAs = "json"
+		join    = ""
+		checkLL = false
+		remap   stringsFlag
+		strip   stringsFlag
+		capDir  = ""
+		batch   = false
+		raw     = false
+		labels  stringsFlag
+		extra   stringsFlag
+		tmplArg = ""
+		openAPI = false
+		jschema = false
+		grpcurl = false
+		fields  = ""
+		force   = false
+		hexOut  = false
+		fixture = false
+		fixPkg  = "fixtures"
+		fixName = "Request"
+		fixFile = ""
+		cLines  = false
+		cDir    = ""
+		cHash   = false
+		only    stringsFlag
+		archive = ""
+		archMan = false
+		roots   = false
+		rootImp = false
+		chkDeps = false
+		fixDeps = false
+		repair  = false
+		depPath stringsFlag
+		cv      compilerVersionFlags
+		bufMode = false
+		jIndent = jsonOptions.Indent
+		jCamel  = false
+		jEmit   = false
+		jSingle = false
+		jCanon  = false
+		digest  = false
+		downEd  = false
+		envSnap = true
+		envVar  stringsFlag
+		transf  = ""
+		filtExp = ""
+		mapExpr = ""
+		errResp = true
+		tee     stringsFlag
+		cborOut = false
+		mpOut   = false
+		feats   = "proto3_optional"
+		chunks  = ""
+		check   = false
+		minEd   = ""
+		maxEd   = ""
+		in      = ""
+		outArg  = ""
+		patchF  = ""
+		embRaw  = false
 	)
 
 	flag.CommandLine.Init(flag.CommandLine.Name(), flag.ContinueOnError)
@@ -75,24 +206,589 @@ func run() error {
 	flag.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
 	flag.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
 
+	flag.BoolVar(&embRaw, "embed-raw", embRaw, "only for binary input and json, CBOR or MessagePack output: add the original input as "+rawField+" field to fall back to if decoding is lossy")
+	flag.BoolVar(&cborOut, "cbor-out", cborOut, "output the json mapping encoded as CBOR")
+	flag.BoolVar(&mpOut, "msgpack-out", mpOut, "output the json mapping encoded as MessagePack")
+	flag.StringVar(&jIndent, "json-indent", jIndent, "indentation of json output, empty for single line output")
+	flag.BoolVar(&jCamel, "json-camel", jCamel, "use lowerCamelCase json names in json output instead of proto field names")
+	flag.BoolVar(&jEmit, "json-emit-unpopulated", jEmit, "include fields with default values in json output")
+	flag.BoolVar(&jSingle, "json-compact", jSingle, "write json output on a single line without spaces")
+	flag.BoolVar(&jCanon, "canonical-json", jCanon, "output canonical json to hash and compare across machines and protobuf versions: sorted keys, fixed number formatting and no whitespace, implies -json-out")
+
 	flag.BoolVar(&reqIn, "req-in", reqIn, "input is request, not response")
 	flag.BoolVar(&wrap, "wrap", wrap, "wrap input in response with filename "+file)
+	flag.BoolVar(&bufMode, "buf", bufMode, "support buf: advertise editions, add the buf module to the wrapped file name and captures, see the README")
+	flag.BoolVar(&errResp, "error-response", errResp, "only with -wrap when run as plugin with piped stdin and without -in and -out: report errors in the error of a CodeGeneratorResponse and exit successfully, so protoc shows them")
+	flag.StringVar(&feats, "supported-features", feats, "only with -wrap: comma separated features of the response, proto3_optional, supports_editions, numbers, all or none")
+	flag.StringVar(&minEd, "minimum-edition", minEd, "only with -wrap: minimum edition of the response like 2023, defaults to 2023 for supports_editions")
+	flag.StringVar(&maxEd, "maximum-edition", maxEd, "only with -wrap: maximum edition of the response like 2024, defaults to 2024 for supports_editions")
+	flag.IntVar(&wrapChunkSize, "chunk-size", wrapChunkSize, "only with -wrap: split larger wrapped files into chunks of this many bytes named FILE.000, FILE.001, ... and a manifest FILE"+chunkManifestSuffix)
+	flag.StringVar(&in, "in", in, "read the input from this file or named pipe instead of stdin, unix://PATH connects to a unix domain socket, - is stdin, can also be the only argument")
+	flag.StringVar(&outArg, "out", outArg, "write the output to this file or named pipe instead of stdout, unix://PATH connects to a unix domain socket, the same socket as -in is one connection")
+	flag.StringVar(&chunks, "chunks", chunks, "read the input from the chunks listed in this manifest written by -chunk-size instead of stdin")
+	flag.BoolVar(&raw, "raw", raw, "store captures as plain binary proto instead of a container with metadata")
+	flag.Var(&labels, "label", "add label KEY=VALUE to the metadata of captures, repeatable")
+	flag.BoolVar(&envSnap, "env", envSnap, "record protoc, working directory, arguments, parameter and some environment variables in the metadata of captures")
+	flag.Var(&envVar, "env-var", "also record this environment variable with -env, repeatable")
+	flag.StringVar(&tmplArg, "template", tmplArg, "render the decoded input with this go text/template file instead of encoding it, see the README for helpers")
+	flag.BoolVar(&openAPI, "openapi-out", openAPI, "only for requests: output the messages of the files to generate as OpenAPI components")
+	flag.BoolVar(&jschema, "jsonschema-out", jschema, "only for requests: output the messages of the files to generate as JSON Schema definitions")
+	flag.BoolVar(&grpcurl, "grpcurl-out", grpcurl, "only for requests: output a FileDescriptorSet of the files to generate with services and their imports for grpcurl -protoset and Evans")
+	flag.StringVar(&fields, "fields", fields, "only output these comma separated field paths like files_to_generate,proto_file.name, repeated fields apply to each element")
+	flag.BoolVar(&fixture, "gofixture", fixture, "only for requests: output a go file embedding the request with an accessor resolving extensions")
+	flag.StringVar(&fixPkg, "gofixture-package", fixPkg, "package name for -gofixture")
+	flag.StringVar(&fixName, "gofixture-name", fixName, "name of the accessor for -gofixture, the data is NAMEBytes")
+	flag.StringVar(&fixFile, "gofixture-embed", fixFile, "for -gofixture: use go:embed for this file instead of a literal, store it with -wrap=false")
+	flag.BoolVar(&cLines, "content-lines", cLines, "only for json output of responses: write file content as array of lines")
+	flag.StringVar(&cDir, "content-dir", cDir, "only for json output of responses: write file content into this directory and reference it")
+	flag.BoolVar(&cHash, "content-hash", cHash, "only for json output of responses: write the sha256 and length of each file instead of its content, a compact signature to store and compare, content is kept with -content-lines or -content-dir")
+	flag.Var(&only, "only", "only for responses: keep the files matching this glob, ** matches any directories and patterns without / match the base name, repeatable")
+	flag.StringVar(&archive, "archive", archive, "only for responses: output the generated files with applied insertion points as tar, tgz or zip archive")
+	flag.BoolVar(&archMan, "archive-manifest", archMan, "add "+archiveManifest+" with sizes and checksums to -archive")
+	flag.BoolVar(&digest, "digest", digest, "output the sha256 of the deterministic binary encoding as hex instead of the encoded input, a stable golden for CI")
+	flag.BoolVar(&hexOut, "hex-out", hexOut, "output a hex dump of the binary output for debugging")
+	flag.BoolVar(&force, "force", force, "write binary output even if stdout is a terminal")
+	flag.BoolVar(&explain, "explain", explain, "explain where and why input could not be decoded")
+	flag.BoolVar(&batch, "batch", batch, "input and output are streams of varint length prefixed messages or concatenated json, see the frame command")
+	flag.Var(&tee, "tee", "also write the input as received to this file or directory, ending in / or existing, failures are only logged, repeatable")
+	flag.StringVar(&capDir, "capture-dir", capDir, "only for requests: also store the raw input under a timestamped name in this directory and add it to its index, s3:// and gs:// urls are uploaded with the aws and gcloud tools")
 
+	flag.BoolVar(&canon, "canonical", canon, "only for requests: sort files by dependency and name and normalize paths for stable diffs")
+	flag.BoolVar(&chkDeps, "check-deps", chkDeps, "only for requests: report dependencies missing in the request instead of writing output")
+	flag.BoolVar(&repair, "repair", repair, "only for requests: fix hand edited requests, point imports to renamed files, restore missing packages, qualify type names and recompute imports from the used types and options, changes are logged")
+	flag.BoolVar(&fixDeps, "fix-deps", fixDeps, "only for requests: add missing dependencies from -extra-descriptors and -deps-path")
+	flag.Var(&depPath, "deps-path", "for -fix-deps: directory with files written by -split, repeatable")
+	flag.BoolVar(&roots, "roots-only", roots, "only for requests: drop all files but the files to generate, the request is marked as incomplete in the capture container")
+	flag.BoolVar(&rootImp, "roots-imports", rootImp, "for -roots-only: also keep the files imported by the files to generate")
+	flag.StringVar(&split, "split", split, "only for requests: write each proto file and a manifest into this directory instead of stdout")
+	flag.StringVar(&splitAs, "split-format", splitAs, "file format for -split, json or txtpb")
+	flag.StringVar(&join, "join", join, "read the request from a directory written by -split instead of stdin")
+	flag.BoolVar(&check, "check", check, "only decode, resolve and validate the input and print a line with OK or FAIL and statistics instead of writing output")
+	flag.BoolVar(&checkLL, "check-lossless", checkLL, "only for binary input: report data changed or lost by decoding and reencoding instead of writing output")
+	flag.BoolVar(&downEd, "downgrade-editions", downEd, "only for requests: convert files using editions to proto2 or proto3 for plugins without editions support, best effort")
+	flag.StringVar(&transf, "transform", transf, "only for requests: apply the operations of this yaml or json pipeline file before the other transformations, see the README")
+	flag.StringVar(&filtExp, "filter-expr", filtExp, "only for requests: drop the files this CEL expression over file and request is false for, like !file.package.startsWith('internal.'), see the README")
+	flag.StringVar(&mapExpr, "map-expr", mapExpr, "only for requests: replace each file by the result of this CEL expression, the file or a map of the fields to set like {'options': null}, see the README")
+	flag.StringVar(&patchF, "patch", patchF, "apply the add, replace, remove and test edits of this json file addressed by field path to the decoded input, see the README")
+	flag.Var(&remap, "remap", "only for requests: rename proto package old.pkg=new.pkg with subpackages, type references and go_package, repeatable")
+	flag.Var(&strip, "strip-option", "only for requests: remove option extensions matching this full name or declaring file pattern or field number, repeatable")
+	flag.StringVar(&cv.set, "set-compiler-version", cv.set, "only for requests: replace the compiler version with MAJOR.MINOR.PATCH[-SUFFIX]")
+	flag.BoolVar(&cv.clear, "clear-compiler-version", cv.clear, "only for requests: remove the compiler version")
+	flag.BoolVar(&noResolve, "no-resolve", noResolve, "only for requests: skip building the type registry for speed, option extensions stay unknown fields and are dropped in json")
+	flag.BoolVar(&strictDecoding, "strict", strictDecoding, "fail if the binary input has unknown fields after resolving extensions, listing their numbers and byte offsets")
+	flag.Var(&extra, "extra-descriptors", "only for requests: resolve extensions with this binary FileDescriptorSet like protoc -o --include_imports writes it, repeatable")
+
+	addLogFlags(flag.CommandLine)
 	flag.Parse()
+	setupLogging()
 
 	if help {
 		flag.CommandLine.SetOutput(os.Stdout)
 		fmt.Fprint(os.Stdout, usage)
+		printCommands(os.Stdout)
 		fmt.Fprint(os.Stdout, "\nArguments:\n")
 		flag.PrintDefaults()
 		return nil
 	}
 
-	bin, err := io.ReadAll(os.Stdin)
+	if err := loadExtraDescriptors(extra); err != nil {
+		return err
+	}
+	if err := checkGlobs(only); err != nil {
+		return fmt.Errorf("-only: %v", err)
+	}
+	jsonOptions.Indent = jIndent
+	jsonOptions.Multiline = jIndent != "" && !jSingle
+	if !jsonOptions.Multiline {
+		jsonOptions.Indent = ""
+	}
+	jsonOptions.UseProtoNames = !jCamel
+	jsonOptions.EmitUnpopulated = jEmit
+	var err error
+	fileSet, featsSet := false, false
+	flag.Visit(func(f *flag.Flag) {
+		fileSet = fileSet || f.Name == "file"
+		featsSet = featsSet || f.Name == "supported-features"
+	})
+	if featsSet {
+		if responseFeatures.features, err = parseFeatures(feats); err != nil {
+			return err
+		}
+	}
+	if bufMode && !featsSet {
+		// buf refuses to send editions files to plugins not supporting them
+		responseFeatures.features |= featureSupportsEditions
+	}
+	if responseFeatures.features&featureSupportsEditions != 0 {
+		responseFeatures.minEdition = edition2023
+		responseFeatures.maxEdition = edition2024
+	}
+	if minEd != "" {
+		if responseFeatures.minEdition, err = parseEdition(minEd); err != nil {
+			return err
+		}
+	}
+	if maxEd != "" {
+		if responseFeatures.maxEdition, err = parseEdition(maxEd); err != nil {
+			return err
+		}
+	}
+	if lo, hi := responseFeatures.minEdition, responseFeatures.maxEdition; lo != 0 && hi != 0 && lo > hi {
+		return fmt.Errorf("-minimum-edition is after -maximum-edition")
+	}
+	// the transformation flags add steps of the registered operations, -repair and
+	// -fix-deps run before -check-deps, the others after the steps of -transform
+	var fixes, pipeline transformPipeline
+	if repair {
+		fixes = append(fixes, transformStep{Op: "repair", origin: "-repair"})
+	}
+	if fixDeps {
+		fixes = append(fixes, transformStep{Op: "fix-deps", Paths: depPath, origin: "-fix-deps"})
+	}
+	if transf != "" {
+		if pipeline, err = loadTransform(transf); err != nil {
+			return err
+		}
+	}
+	if filtExp != "" {
+		step := transformStep{Op: "filter-expr", origin: "-filter-expr"}
+		if step.expr, err = compileFileExpr("filter-expr", filtExp); err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	if mapExpr != "" {
+		step := transformStep{Op: "map-expr", origin: "-map-expr"}
+		if step.expr, err = compileFileExpr("map-expr", mapExpr); err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	switch {
+	case cv.set != "" && cv.clear:
+		return fmt.Errorf("-set-compiler-version and -clear-compiler-version can not be combined")
+	case cv.clear:
+		pipeline = append(pipeline, transformStep{Op: "compiler-version", origin: "-clear-compiler-version"})
+	case cv.set != "":
+		step := transformStep{Op: "compiler-version", origin: "-set-compiler-version"}
+		if step.version, err = parseCompilerVersion(cv.set); err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	if downEd {
+		pipeline = append(pipeline, transformStep{Op: "downgrade-editions", origin: "-downgrade-editions"})
+	}
+	if len(remap) > 0 {
+		step := transformStep{Op: "remap", origin: "-remap"}
+		if step.remaps, err = parseRemaps(remap); err != nil {
+			return err
+		}
+		pipeline = append(pipeline, step)
+	}
+	if len(strip) > 0 {
+		pipeline = append(pipeline, transformStep{Op: "strip-option", Options: strip, origin: "-strip-option"})
+	}
+	if canon {
+		pipeline = append(pipeline, transformStep{Op: "canonicalize", origin: "-canonical"})
+	}
+	var patches patchEdits
+	if patchF != "" {
+		if patches, err = loadPatch(patchF); err != nil {
+			return err
+		}
+	}
+	if batch && (join != "" || split != "") {
+		return fmt.Errorf("-batch can not be combined with -join or -split")
+	}
+	if jCanon {
+		if cborOut || mpOut {
+			return fmt.Errorf("-canonical-json can not be combined with -cbor-out or -msgpack-out")
+		}
+		jsonOut = true
+	}
+	binJSON := ""
+	switch {
+	case cborOut && (mpOut || jsonOut), mpOut && jsonOut:
+		return fmt.Errorf("only one of -json-out, -cbor-out and -msgpack-out can be used")
+	case cborOut:
+		binJSON = formatCBOR
+	case mpOut:
+		binJSON = formatMsgpack
+	}
+	if check && batch {
+		return fmt.Errorf("-check can not be combined with -batch")
+	}
+	if embRaw && (jsonIn || (!jsonOut && binJSON == "")) {
+		return fmt.Errorf("-embed-raw needs binary input and json, CBOR or MessagePack output")
+	}
+	if noResolve && (check || digest || len(extra) > 0) {
+		// unknown fields are encoded after resolved extensions, digests would change
+		return fmt.Errorf("-no-resolve can not be combined with -check, -digest or -extra-descriptors")
+	}
+	if noResolve && strictDecoding && reqIn {
+		return fmt.Errorf("-strict can not be combined with -no-resolve, options would stay unknown")
+	}
+	if chunks != "" && (batch || join != "") {
+		return fmt.Errorf("-chunks can not be combined with -batch or -join")
+	}
+	if len(tee) > 0 && (batch || join != "") {
+		return fmt.Errorf("-tee can not be combined with -batch or -join")
+	}
+	if roots && (!wrap || raw || jsonOut || binJSON != "" || split != "") {
+		// without the container, the request looks complete and fails later on missing imports
+		return fmt.Errorf("-roots-only needs the capture container marking the request as incomplete, it can not be combined with -wrap=false, -raw, -json-out, -cbor-out, -msgpack-out or -split")
+	}
+	switch {
+	case flag.NArg() > 1:
+		return fmt.Errorf("only one input file can be given, got %d arguments", flag.NArg())
+	case flag.NArg() == 1 && in != "":
+		return fmt.Errorf("the input file can not be given with -in and as argument")
+	case flag.NArg() == 1:
+		in = flag.Arg(0)
+	}
+	if in == "-" {
+		in = ""
+	}
+	if in != "" && (join != "" || chunks != "") {
+		return fmt.Errorf("an input file can not be combined with -join or -chunks")
+	}
+
+	modes := 0
+	for _, set := range []bool{tmplArg != "", openAPI, jschema, grpcurl, fixture, archive != "", digest} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("only one of -template, -openapi-out, -jsonschema-out, -grpcurl-out, -gofixture, -archive and -digest can be used")
+	}
+	binaryOut := !check && !jsonOut && !hexOut && tmplArg == "" && !openAPI && !jschema && !fixture && !digest && split == "" && !checkLL && !chkDeps
+	if binaryOut && !force && outArg == "" && isTerminal(os.Stdout) {
+		return fmt.Errorf("refusing to write binary proto to a terminal, redirect stdout or use -json-out, -hex-out or -force")
+	}
+	input, output, err := openStreams(in, outArg)
 	if err != nil {
-		return fmt.Errorf("CodeGenerationRequest could not be read from stdin: %v", err)
+		return err
+	}
+	if input != nil {
+		defer input.Close()
+	}
+	var stdout io.Writer = os.Stdout
+	if output != nil {
+		defer output.Close()
+		stdout = output
+	}
+	// protoc only shows errors of plugins exiting successfully, it pipes stdin and stdout
+	pluginMode := in == "" && outArg == "" && !isTerminal(os.Stdin)
+	if errResp && pluginMode && wrap && !check && !batch && !hexOut && split == "" && !checkLL && !chkDeps {
+		pluginErrors.out, pluginErrors.json = stdout, jsonOut
+	}
+	if hexOut {
+		dumper := hex.Dumper(stdout)
+		defer dumper.Close()
+		stdout = dumper
+	}
+
+	var projection fieldTree
+	if fields != "" {
+		var md protoreflect.MessageDescriptor = (&pluginpb.CodeGeneratorResponse{}).ProtoReflect().Descriptor()
+		if reqIn {
+			md = (&pluginpb.CodeGeneratorRequest{}).ProtoReflect().Descriptor()
+		}
+		if projection, err = parseFieldPaths(md, fields); err != nil {
+			return err
+		}
+	}
+	var tmpl *template.Template
+	if tmplArg != "" {
+		if tmpl, err = loadTemplate(tmplArg); err != nil {
+			return err
+		}
+	}
+
+	kind := "response"
+	if reqIn {
+		kind = "request"
+	}
+	meta, err := newCaptureMeta(kind, labels)
+	if err != nil {
+		return err
+	}
+	if raw {
+		meta = nil
+	} else if envSnap && reqIn {
+		meta.Env = newCaptureEnv(envVar)
+	}
+
+	// process handles one input, bin is the raw input or nil for -join.
+	// It returns nil if it did not produce output for stdout.
+	process := func(msg proto.Message, bin []byte) ([]byte, error) {
+		var err error
+		file := file
+		module := ""
+		var capOpts *captureOptions
+		if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok && wrap {
+			if capOpts, err = takeCaptureOptions(req); err != nil {
+				return nil, err
+			}
+			if capOpts != nil {
+				// the raw input still holds the capture options
+				bin = nil
+			}
+		}
+		if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok && meta != nil && meta.Env != nil {
+			meta.Env.Parameter = req.GetParameter()
+		}
+		if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok && bufMode {
+			module = bufModule(req)
+			if meta != nil {
+				delete(meta.Labels, "buf_module")
+				if module != "" {
+					if meta.Labels == nil {
+						meta.Labels = map[string]string{}
+					}
+					meta.Labels["buf_module"] = module
+				}
+			}
+			if !fileSet && (capOpts == nil || capOpts.name == nil) {
+				file = bufFileName(file, module)
+			}
+		}
+		if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok && capDir != "" {
+			in := bin
+			if in == nil || jsonIn {
+				if in, err = encode(req, false); err != nil {
+					return nil, err
+				}
+			}
+			store := storeCapture
+			if isRemote(capDir) {
+				store = storeRemoteCapture
+			}
+			entry, err := store(capDir, in, req, meta, module)
+			if err != nil {
+				return nil, err
+			}
+			logEvent(logInfo, "capture", "file", entry.File)
+		}
+		if checkLL {
+			if bin == nil || jsonIn {
+				return nil, fmt.Errorf("-check-lossless needs binary input")
+			}
+			ok, err := checkLossless(stdout, bin, msg)
+			if err == nil && !ok {
+				err = fmt.Errorf("input can not be reencoded without loss")
+			}
+			return nil, err
+		}
+		if err := patches.apply(msg); err != nil {
+			return nil, err
+		}
+		if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok {
+			if err := fixes.apply(req); err != nil {
+				return nil, err
+			}
+			if chkDeps {
+				return nil, checkDependencies(stdout, req)
+			}
+			if err := pipeline.apply(req); err != nil {
+				return nil, err
+			}
+			if roots {
+				dropped := rootsOnly(req, rootImp)
+				log.Printf("warning: request is incomplete, %d files were dropped by -roots-only\n", dropped)
+				if meta != nil {
+					if meta.Labels == nil {
+						meta.Labels = map[string]string{}
+					}
+					meta.Labels["incomplete"] = "roots-only"
+				}
+			}
+			if split != "" {
+				return nil, splitRequest(req, split, splitAs)
+			}
+		}
+
+		if resp, ok := msg.(*pluginpb.CodeGeneratorResponse); ok && len(only) > 0 {
+			logEvent(logInfo, "only", "dropped", selectFiles(resp, only), "kept", len(resp.File))
+		}
+		if projection != nil {
+			msg = projectFields(msg, projection)
+		}
+		if tmpl != nil || openAPI || jschema || grpcurl || fixture || archive != "" || digest {
+			var out []byte
+			req, isReq := msg.(*pluginpb.CodeGeneratorRequest)
+			switch {
+			case digest:
+				if out, err = encode(msg, false); err == nil {
+					sum := sha256.Sum256(out)
+					out = []byte(hex.EncodeToString(sum[:]) + "\n")
+				}
+			case tmpl != nil:
+				out, err = renderTemplate(tmpl, msg)
+			case archive != "":
+				if isReq {
+					return nil, fmt.Errorf("archives can only be created for responses")
+				}
+				out, err = archiveResponse(msg.(*pluginpb.CodeGeneratorResponse), archive, archMan)
+			case !isReq:
+				err = fmt.Errorf("schemas and fixtures can only be created for requests")
+			case fixture:
+				if out, err = encode(req, false); err == nil {
+					out, err = writeGoFixture(goFixture{Package: fixPkg, Name: fixName, Embed: fixFile}, out)
+				}
+			case openAPI:
+				out, err = openAPIDocument(req)
+			case grpcurl:
+				out, err = serviceProtoset(req)
+			default:
+				out, err = jsonSchemaDocument(req)
+			}
+			if err != nil || !wrap {
+				return out, err
+			}
+			if capOpts != nil {
+				if file, err = capOpts.fileName(file, out, module); err != nil {
+					return nil, err
+				}
+			}
+			return wrapResponse(file, out, jsonOut)
+		}
+
+		var out []byte
+		if resp, ok := msg.(*pluginpb.CodeGeneratorResponse); ok && jsonOut && (cLines || cDir != "" || cHash) {
+			out, err = encodeSplitContent(resp, cDir, cLines, cHash)
+		} else {
+			out, err = encode(msg, jsonOut || binJSON != "")
+		}
+		if err == nil && embRaw && bin != nil {
+			var raw []byte
+			if _, raw, err = unwrapContainer(bin); err == nil {
+				out, err = embedRaw(out, raw)
+			}
+		}
+		if err == nil && jCanon {
+			out, err = canonicalJSON(out)
+		}
+		if err == nil && binJSON != "" {
+			out, err = transcodeJSON(out, binJSON)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if jsonOut || binJSON != "" {
+			warnUnknown(msg)
+		}
+		if wrap {
+			if capOpts != nil {
+				if file, err = capOpts.fileName(file, out, module); err != nil {
+					return nil, err
+				}
+			}
+			if meta != nil && !jsonOut && binJSON == "" {
+				// the wrapped file is the capture
+				meta.Time = time.Now().UTC()
+				if out, err = wrapContainer(meta, out); err != nil {
+					return nil, err
+				}
+			}
+			if capOpts != nil {
+				if err := storeRunCapture(capOpts.plugin, file, out); err != nil {
+					return nil, err
+				}
+			}
+			out, err = wrapResponse(file, out, jsonOut)
+			if err == nil && jCanon {
+				out, err = canonicalJSON(out)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	if batch {
+		var r io.Reader = os.Stdin
+		if input != nil {
+			r = input
+		}
+		return runBatch(r, stdout, reqIn, jsonIn, jsonOut, explain, process)
 	}
 
+	var msg proto.Message
+	var bin []byte
+	start := time.Now()
+	if join != "" {
+		msg, err = joinRequest(join)
+	} else if chunks != "" {
+		if bin, err = readChunks(chunks); err == nil {
+			msg, bin, err = decodeInput(bin, reqIn, jsonIn, explain)
+		}
+	} else {
+		msg, bin, err = readInput(input, reqIn, jsonIn, explain)
+		if bin != nil {
+			// also tee input which can not be decoded
+			teeInput(tee, bin)
+		}
+	}
+	if check {
+		return checkInput(stdout, msg, len(bin), start, err)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := process(msg, bin)
+	if out == nil || err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(out)
+	if err != nil {
+		// this is probably nonsensical :-)
+		return fmt.Errorf("output error: %v", err)
+	}
+	return nil
+}
+
+// readInput reads and decodes a request or response from in or from stdin if in is nil.
+func readInput(in io.Reader, reqIn, jsonIn, explain bool) (proto.Message, []byte, error) {
+	var bin []byte
+	var err error
+	if in != nil {
+		if bin, err = io.ReadAll(in); err != nil {
+			return nil, nil, fmt.Errorf("input could not be read: %v", err)
+		}
+	} else {
+		if !jsonIn && isTerminal(os.Stdin) {
+			return nil, nil, fmt.Errorf("stdin is a terminal, pipe a binary capture into it, name a file or use -json-in")
+		}
+		if bin, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, nil, fmt.Errorf("CodeGenerationRequest could not be read from stdin: %v", err)
+		}
+	}
+	logEvent(logInfo, "read", "bytes", len(bin))
+	return decodeInput(bin, reqIn, jsonIn, explain)
+}
+
+// decodeInput unwraps and decodes input read by readInput.
+func decodeInput(bin []byte, reqIn, jsonIn, explain bool) (proto.Message, []byte, error) {
+	var err error
+	if !jsonIn {
+		if _, bin, err = unwrapContainer(bin); err != nil {
+			return nil, nil, err
+		}
+	}
+	msg, err := decode(bin, reqIn, jsonIn)
+	if err != nil && explain {
+		err = fmt.Errorf("%v\n%s", err, explainDecode(bin, reqIn, jsonIn))
+	}
+	return msg, bin, err
+}
+
+// decode unmarshals a request or response from json or binary proto.
+func decode(bin []byte, reqIn, jsonIn bool) (proto.Message, error) {
 	var msg proto.Message
 	if reqIn {
 		msg = &pluginpb.CodeGeneratorRequest{}
@@ -100,10 +796,23 @@ func run() error {
 		msg = &pluginpb.CodeGeneratorResponse{}
 	}
 
+	var err error
+	if !jsonIn {
+		// captures might be stored in a container
+		if _, bin, err = unwrapContainer(bin); err != nil {
+			return nil, err
+		}
+	}
 	var format string
+	start := time.Now()
 	if jsonIn {
 		format = "json"
-		err = protojson.Unmarshal(bin, msg)
+		bin = stripRaw(bin)
+		if reqIn {
+			msg, err = unmarshalRequestJSON(bin)
+		} else if bin, err = joinContent(bin); err == nil {
+			err = protojson.Unmarshal(bin, msg)
+		}
 	} else {
 		format = "proto"
 		if reqIn {
@@ -114,152 +823,368 @@ func run() error {
 		}
 	}
 	if err != nil {
-		return fmt.Errorf("%s unmarshal error: %v", format, err)
+		return nil, fmt.Errorf("%s unmarshal error: %v", format, err)
 	}
-
-	encode := func(msg proto.Message, asJSON bool) ([]byte, error) {
-		var format string
-		var out []byte
-		if asJSON {
-			format = "json"
-			out, err = protojson.MarshalOptions{
-				Multiline:     true,
-				Indent:        "\t",
-				UseProtoNames: true,
-			}.Marshal(msg)
-		} else {
-			format = "proto"
-			out, err = proto.MarshalOptions{
-				Deterministic: true,
-			}.Marshal(msg)
+	if strictDecoding && !jsonIn {
+		if err := checkUnknownFields(msg, bin); err != nil {
+			return nil, err
 		}
-		if err != nil {
-			err = fmt.Errorf("%s marshal error: %v", format, err)
+	}
+	fields := []interface{}{"format", format, "bytes", len(bin), "duration_ns", time.Since(start)}
+	if req, ok := msg.(*pluginpb.CodeGeneratorRequest); ok {
+		fields = append(fields, "files", len(req.ProtoFile), "files_to_generate", len(req.FileToGenerate))
+	} else {
+		fields = append(fields, "files", len(msg.(*pluginpb.CodeGeneratorResponse).File))
+	}
+	logEvent(logInfo, "decode", fields...)
+	return msg, nil
+}
+
+// decodeRequest is decode for callers that only handle requests.
+func decodeRequest(bin []byte, jsonIn bool) (*pluginpb.CodeGeneratorRequest, error) {
+	msg, err := decode(bin, true, jsonIn)
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*pluginpb.CodeGeneratorRequest), nil
+}
+
+// jsonOptions are used by encode for json output.
+var jsonOptions = protojson.MarshalOptions{
+	Multiline:     true,
+	Indent:        "\t",
+	UseProtoNames: true,
+}
+
+func encode(msg proto.Message, asJSON bool) ([]byte, error) {
+	var format string
+	var out []byte
+	var err error
+	start := time.Now()
+	if asJSON {
+		format = "json"
+		out, err = jsonOptions.Marshal(msg)
+		if err == nil && !jsonOptions.Multiline {
+			// protojson randomly adds spaces to single line output
+			var buf bytes.Buffer
+			if err = json.Compact(&buf, out); err == nil {
+				out = buf.Bytes()
+			}
 		}
-		return out, err
+	} else {
+		format = "proto"
+		out, err = proto.MarshalOptions{
+			Deterministic: true,
+		}.Marshal(msg)
 	}
-	out, err := encode(msg, jsonOut)
 	if err != nil {
-		return err
+		err = fmt.Errorf("%s marshal error: %v", format, err)
+	} else {
+		logEvent(logInfo, "encode", "format", format, "bytes", len(out), "duration_ns", time.Since(start))
 	}
-	if wrap {
-		feat := uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
-		resp := &pluginpb.CodeGeneratorResponse{
-			File: []*pluginpb.CodeGeneratorResponse_File{
-				{
-					Name:    proto.String(file),
-					Content: proto.String(string(out)),
-				},
+	return out, err
+}
+
+// warnUnknown logs if msg contains unknown fields json can not represent.
+func warnUnknown(msg proto.Message) {
+	unknown := 0
+	unknownFields(msg.ProtoReflect(), "", func(string, protowire.Number) {
+		unknown++
+	})
+	if unknown > 0 {
+		log.Printf("warning: %d unknown fields are dropped in json output, use -check-lossless for details\n", unknown)
+	}
+}
+
+// supportedFeatures are advertised by a code generator response.
+type supportedFeatures struct {
+	features uint64
+	// minEdition and maxEdition are only set if they are not 0
+	minEdition, maxEdition int32
+}
+
+// responseFeatures are advertised by wrapped responses.
+var responseFeatures = supportedFeatures{
+	features: uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL),
+}
+
+// wrapResponse stores out as file in a code generator response.
+func wrapResponse(file string, out []byte, asJSON bool) ([]byte, error) {
+	feat := responseFeatures.features
+	resp := &pluginpb.CodeGeneratorResponse{
+		File: []*pluginpb.CodeGeneratorResponse_File{
+			{
+				Name:    proto.String(file),
+				Content: proto.String(string(out)),
 			},
-			SupportedFeatures: &feat,
-		}
-		out, err = encode(resp, jsonOut)
+		},
+		SupportedFeatures: &feat,
+	}
+	if wrapChunkSize > 0 && len(out) > wrapChunkSize {
+		files, err := chunkFiles(file, out, wrapChunkSize, asJSON)
 		if err != nil {
-			return fmt.Errorf("code generation response error: %v", err)
+			return nil, err
 		}
+		resp.File = files
 	}
-
-	_, err = os.Stdout.Write(out)
+	// the edition fields are unknown to the protobuf module version used here
+	var b []byte
+	if responseFeatures.minEdition != 0 {
+		b = protowire.AppendTag(b, responseMinimumEdition, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(responseFeatures.minEdition))
+	}
+	if responseFeatures.maxEdition != 0 {
+		b = protowire.AppendTag(b, responseMaximumEdition, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(responseFeatures.maxEdition))
+	}
+	if b != nil {
+		resp.ProtoReflect().SetUnknown(b)
+	}
+	out, err := encode(resp, asJSON)
 	if err != nil {
-		// this is probably nonsensical :-)
-		return fmt.Errorf("output error: %v", err)
+		return nil, fmt.Errorf("code generation response error: %v", err)
 	}
-	return nil
+	return out, nil
 }
 
 // the following code supports proto unmarshaling with extensions
 
-type typeRegistry struct {
-	*protoregistry.Types
-}
+// extraFiles are added to the files of a request to resolve extensions, see -extra-descriptors.
+var extraFiles []*descriptorpb.FileDescriptorProto
 
-func (tr *typeRegistry) addEnums(d protoreflect.EnumDescriptors) error {
-	for i, max := 0, d.Len(); i < max; i++ {
-		err := tr.RegisterEnum(dynamicpb.NewEnumType(d.Get(i)))
+// loadExtraDescriptors reads descriptor sets into extraFiles.
+// Files appearing in several sets are only added once.
+func loadExtraDescriptors(names []string) error {
+	seen := map[string]bool{}
+	for _, name := range names {
+		bin, err := os.ReadFile(name)
 		if err != nil {
-			return err
+			return fmt.Errorf("descriptor set could not be read: %v", err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(bin, set); err != nil {
+			return fmt.Errorf("descriptor set %s could not be decoded: %v", name, err)
+		}
+		for _, fd := range set.File {
+			if !seen[fd.GetName()] {
+				seen[fd.GetName()] = true
+				extraFiles = append(extraFiles, fd)
+			}
 		}
 	}
 	return nil
 }
 
-func (tr *typeRegistry) addExtensions(d protoreflect.ExtensionDescriptors) error {
-	for i, max := 0, d.Len(); i < max; i++ {
-		ext := d.Get(i)
-		extTypeDesc, ok := ext.(protoreflect.ExtensionTypeDescriptor)
-		if ok {
-			err := tr.RegisterExtension(extTypeDesc.Type())
-			if err != nil {
-				return err
-			}
-			continue
+// withExtraFiles adds the extra files missing from fileDescs.
+func withExtraFiles(fileDescs []*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	if len(extraFiles) == 0 {
+		return fileDescs
+	}
+	present := map[string]bool{}
+	for _, fd := range fileDescs {
+		present[fd.GetName()] = true
+	}
+	files := append([]*descriptorpb.FileDescriptorProto{}, fileDescs...)
+	for _, fd := range extraFiles {
+		if !present[fd.GetName()] {
+			files = append(files, fd)
 		}
-		err := tr.RegisterExtension(dynamicpb.NewExtensionType(d.Get(i)))
-		if err != nil {
-			return err
+	}
+	return files
+}
+
+// typeCache keeps the files built for the type registries of all requests,
+// batches and corpora mostly repeat the same files.
+var typeCache = capture.NewCache(50000)
+
+func protoTypes(fileDescs []*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error) {
+	return fileTypes(capture.Options{Cache: typeCache}, withExtraFiles(fileDescs))
+}
+
+// partialProtoTypes is protoTypes for requests with missing imports.
+func partialProtoTypes(fileDescs []*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error) {
+	return fileTypes(capture.Options{AllowUnresolvable: true, Cache: typeCache}, withExtraFiles(fileDescs))
+}
+
+func fileTypes(opts capture.Options, fileDescs []*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error) {
+	start := time.Now()
+	types, err := capture.NewTypes(fileDescs, opts)
+	if err != nil {
+		return nil, err
+	}
+	cached, hits := typeCache.Stats()
+	logEvent(logDebug, "registry", "files", len(fileDescs), "messages", types.NumMessages(), "enums", types.NumEnums(),
+		"extensions", types.NumExtensions(), "cached_files", cached, "cache_hits", hits, "duration_ns", time.Since(start))
+	return types, nil
+}
+
+// noResolve skips resolving extensions when decoding requests, set with -no-resolve.
+var noResolve = false
+
+func unmarshalRequest(raw []byte) (*pluginpb.CodeGeneratorRequest, error) {
+	if noResolve {
+		req := &pluginpb.CodeGeneratorRequest{}
+		if err := (proto.UnmarshalOptions{Resolver: &protoregistry.Types{}}).Unmarshal(raw, req); err != nil {
+			return nil, fmt.Errorf("CodeGenerationRequest unmarshal failed: %v", err)
 		}
+		return req, nil
+	}
+	return unmarshalRequestTypes(raw, requestTypes)
+}
+
+// requestTypes is protoTypes, but also accepts requests with missing imports
+// like -roots-only writes them.
+func requestTypes(fileDescs []*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error) {
+	types, err := protoTypes(fileDescs)
+	if err == nil || len(missingDependencies(&pluginpb.CodeGeneratorRequest{ProtoFile: fileDescs})) == 0 {
+		return types, err
+	}
+	log.Printf("warning: request is incomplete, options declared in missing imports stay unknown\n")
+	return partialProtoTypes(fileDescs)
+}
+
+func unmarshalRequestTypes(raw []byte, loadTypes func([]*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error)) (*pluginpb.CodeGeneratorRequest, error) {
+	req := &pluginpb.CodeGeneratorRequest{}
+	// extensions are only resolved with the types of the request, not with the ones linked in
+	err := proto.UnmarshalOptions{Resolver: &protoregistry.Types{}}.Unmarshal(raw, req)
+	if err != nil {
+		return nil, fmt.Errorf("CodeGenerationRequest unmarshal failed: %v", err)
+	}
+	types, err := loadTypes(req.ProtoFile)
+	if err != nil {
+		return nil, fmt.Errorf("CodeGenerationRequest types could not be loaded: %v", err)
+	}
+	if err := resolveUnknown(req.ProtoReflect(), types); err != nil {
+		return nil, fmt.Errorf("CodeGenerationRequest types could not be resolved: %v", err)
+	}
+	return req, nil
+}
+
+// resolveAgain encodes req and decodes it with its own types again. Extensions set in options
+// keep the names of their old descriptors when declarations are renamed.
+func resolveAgain(req *pluginpb.CodeGeneratorRequest) error {
+	raw, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	again, err := unmarshalRequest(raw)
+	if err != nil {
+		return err
 	}
+	proto.Reset(req)
+	proto.Merge(req, again)
 	return nil
 }
 
-func (tr *typeRegistry) addMessages(d protoreflect.MessageDescriptors) error {
-	for i, max := 0, d.Len(); i < max; i++ {
-		m := d.Get(i)
-		err := tr.RegisterMessage(dynamicpb.NewMessageType(m))
-		if err != nil {
-			return err
-		}
-		// add inner types
-		if err := tr.addEnums(m.Enums()); err != nil {
-			return err
+// resolveUnknown parses the unknown fields of m and its nested messages again with resolver,
+// so the extensions it knows are set and unresolvable fields are kept as unknown fields.
+// Only options have extensions, this is a lot cheaper than unmarshaling the whole request again.
+func resolveUnknown(m protoreflect.Message, resolver *protoregistry.Types) error {
+	for _, fd := range extendableFields[m.Descriptor().FullName()] {
+		if !m.Has(fd) {
+			continue
 		}
-		if err := tr.addExtensions(m.Extensions()); err != nil {
-			return err
+		var err error
+		switch v := m.Get(fd); {
+		case fd.IsMap():
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				err = resolveUnknown(mv.Message(), resolver)
+				return err == nil
+			})
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len() && err == nil; i++ {
+				err = resolveUnknown(list.Get(i).Message(), resolver)
+			}
+		default:
+			err = resolveUnknown(v.Message(), resolver)
 		}
-		if err := tr.addMessages(m.Messages()); err != nil {
+		if err != nil {
 			return err
 		}
 	}
-	return nil
+	unknown := m.GetUnknown()
+	if len(unknown) == 0 || m.Descriptor().ExtensionRanges().Len() == 0 {
+		return nil
+	}
+	m.SetUnknown(nil)
+	return proto.UnmarshalOptions{Merge: true, Resolver: resolver}.Unmarshal(unknown, m.Interface())
 }
 
-func protoTypes(fileDescs []*descriptorpb.FileDescriptorProto) (*protoregistry.Types, error) {
-	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: fileDescs})
-	if err != nil {
-		return nil, err
+// extendableFields lists the fields of the messages in requests leading to messages with extension ranges.
+var extendableFields = newExtendableFields((&pluginpb.CodeGeneratorRequest{}).ProtoReflect().Descriptor())
+
+func newExtendableFields(root protoreflect.MessageDescriptor) map[protoreflect.FullName][]protoreflect.FieldDescriptor {
+	fieldMessage := func(fd protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+		if fd.IsMap() {
+			return fd.MapValue().Message()
+		}
+		return fd.Message()
 	}
-	tr := typeRegistry{Types: &protoregistry.Types{}}
-	files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
-		if err = tr.addEnums(f.Enums()); err != nil {
-			return false
+	var all []protoreflect.MessageDescriptor
+	seen := map[protoreflect.FullName]bool{}
+	var collect func(md protoreflect.MessageDescriptor)
+	collect = func(md protoreflect.MessageDescriptor) {
+		if seen[md.FullName()] {
+			return
 		}
-		if err = tr.addExtensions(f.Extensions()); err != nil {
-			return false
+		seen[md.FullName()] = true
+		all = append(all, md)
+		for i := 0; i < md.Fields().Len(); i++ {
+			if sub := fieldMessage(md.Fields().Get(i)); sub != nil {
+				collect(sub)
+			}
 		}
-		if err = tr.addMessages(f.Messages()); err != nil {
-			return false
+	}
+	collect(root)
+	// messages are extendable if they declare extension ranges or contain extendable messages
+	extendable := map[protoreflect.FullName]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, md := range all {
+			if extendable[md.FullName()] {
+				continue
+			}
+			found := md.ExtensionRanges().Len() > 0
+			for i := 0; i < md.Fields().Len() && !found; i++ {
+				sub := fieldMessage(md.Fields().Get(i))
+				found = sub != nil && extendable[sub.FullName()]
+			}
+			if found {
+				extendable[md.FullName()] = true
+				changed = true
+			}
 		}
-		return true
-	})
-	if err != nil {
-		return nil, err
 	}
-	return tr.Types, nil
+	fields := map[protoreflect.FullName][]protoreflect.FieldDescriptor{}
+	for _, md := range all {
+		for i := 0; i < md.Fields().Len(); i++ {
+			fd := md.Fields().Get(i)
+			if sub := fieldMessage(fd); sub != nil && extendable[sub.FullName()] {
+				fields[md.FullName()] = append(fields[md.FullName()], fd)
+			}
+		}
+	}
+	return fields
 }
 
-func unmarshalRequest(raw []byte) (*pluginpb.CodeGeneratorRequest, error) {
+// unmarshalRequestJSON is unmarshalRequest for json.
+func unmarshalRequestJSON(raw []byte) (*pluginpb.CodeGeneratorRequest, error) {
+	// extension names can only be resolved when the descriptors are known
 	req := &pluginpb.CodeGeneratorRequest{}
-	err := proto.Unmarshal(raw, req)
+	err := protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(raw, req)
 	if err != nil {
 		return nil, fmt.Errorf("CodeGenerationRequest unmarshal failed: %v", err)
 	}
-	types, err := protoTypes(req.ProtoFile)
+	if noResolve {
+		return req, nil
+	}
+	types, err := requestTypes(req.ProtoFile)
 	if err != nil {
 		return nil, fmt.Errorf("CodeGenerationRequest types could not be loaded: %v", err)
 	}
-	// unmarshal a second time to also resolve extensions
 	req = &pluginpb.CodeGeneratorRequest{}
-	err = proto.UnmarshalOptions{
+	err = protojson.UnmarshalOptions{
 		Resolver: types,
 	}.Unmarshal(raw, req)
 	if err != nil {
@@ -0,0 +1,899 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arnehormann/protoc-gen-capture/capture"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// testRequest builds a request with a custom option resolvable from the request itself.
+func testRequest() *pluginpb.CodeGeneratorRequest {
+	opts := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("opts.proto"),
+		Package:    proto.String("opts"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("secret"),
+			Number:   proto.Int32(50000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+			Extendee: proto.String(".google.protobuf.FieldOptions"),
+			JsonName: proto.String("secret"),
+		}},
+	}
+	fieldOpts := &descriptorpb.FieldOptions{}
+	// [opts.secret] = true
+	fieldOpts.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 50000, protowire.VarintType), 1))
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Package:    proto.String("test"),
+		Dependency: []string{"opts.proto"},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Msg"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("id"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				JsonName: proto.String("id"),
+				Options:  fieldOpts,
+			}},
+		}},
+	}
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"test.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			opts,
+			file,
+		},
+	}
+}
+
+// appendUnknown adds field 9999 with a varint to the unknown fields of m.
+func appendUnknown(m proto.Message) {
+	r := m.ProtoReflect()
+	r.SetUnknown(protowire.AppendVarint(protowire.AppendTag(r.GetUnknown(), 9999, protowire.VarintType), 42))
+}
+
+func TestBinaryRoundTripKeepsUnknownFields(t *testing.T) {
+	req := testRequest()
+	appendUnknown(req)
+	appendUnknown(req.ProtoFile[2])
+	appendUnknown(req.ProtoFile[2].MessageType[0].Field[0].Options)
+	in, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := decode(in, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("reencoded request differs from input:\n got %x\nwant %x", out, in)
+	}
+
+	unknown := 0
+	unknownFields(msg.ProtoReflect(), "", func(string, protowire.Number) {
+		unknown++
+	})
+	if unknown != 3 {
+		t.Errorf("got %d unknown fields, want 3", unknown)
+	}
+}
+
+func TestBinaryRoundTripKeepsUnresolvableExtensions(t *testing.T) {
+	req := testRequest()
+	// without opts.proto, the option can not be resolved
+	req.ProtoFile = []*descriptorpb.FileDescriptorProto{req.ProtoFile[0], req.ProtoFile[2]}
+	req.ProtoFile[1].Dependency = nil
+	in, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := decode(in, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("reencoded request differs from input:\n got %x\nwant %x", out, in)
+	}
+}
+
+func TestBinaryRoundTripNormalizesFieldOrder(t *testing.T) {
+	req := testRequest()
+	in, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// move files_to_generate (field 1) behind everything else
+	tag := protowire.AppendTag(nil, 1, protowire.BytesType)
+	first := protowire.AppendBytes(tag, []byte("test.proto"))
+	if !bytes.HasPrefix(in, first) {
+		t.Fatalf("unexpected encoding %x", in)
+	}
+	shuffled := append(append([]byte{}, in[len(first):]...), first...)
+
+	msg, err := decode(shuffled, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("reencoded request is not in deterministic order:\n got %x\nwant %x", out, in)
+	}
+	ok, err := checkLossless(&bytes.Buffer{}, shuffled, msg)
+	if err != nil || !ok {
+		t.Errorf("checkLossless = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func TestJSONRoundTripResolvesExtensions(t *testing.T) {
+	in, err := proto.MarshalOptions{Deterministic: true}.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := decode(in, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := encode(msg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(js, []byte("[opts.secret]")) {
+		t.Fatalf("json does not contain resolved extension:\n%s", js)
+	}
+	msg, err = decode(js, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("json round trip differs from input:\n got %x\nwant %x", out, in)
+	}
+}
+
+// bufRequest turns testRequest into the shape buf sends for an editions file of a module.
+func bufRequest() *pluginpb.CodeGeneratorRequest {
+	req := testRequest()
+	file := req.ProtoFile[2]
+	file.Syntax = proto.String(editionsSyntax)
+	// edition = EDITION_2023
+	u := protowire.AppendVarint(protowire.AppendTag(nil, 14, protowire.VarintType), edition2023)
+	var name []byte
+	for i, s := range []string{"buf.build", "acme", "petapis"} {
+		name = protowire.AppendString(protowire.AppendTag(name, protowire.Number(i+1), protowire.BytesType), s)
+	}
+	moduleInfo := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), name)
+	ext := protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), moduleInfo)
+	u = protowire.AppendBytes(protowire.AppendTag(u, bufImageExtension, protowire.BytesType), ext)
+	file.ProtoReflect().SetUnknown(u)
+	return req
+}
+
+func TestBufRequestShape(t *testing.T) {
+	req := bufRequest()
+	in, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := decode(in, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Errorf("reencoded request differs from input:\n got %x\nwant %x", out, in)
+	}
+	// the option of the editions file is resolved
+	opts := msg.(*pluginpb.CodeGeneratorRequest).ProtoFile[2].MessageType[0].Field[0].Options
+	if len(opts.ProtoReflect().GetUnknown()) != 0 {
+		t.Errorf("option of editions file was not resolved")
+	}
+
+	if got, want := bufModule(req), "buf.build/acme/petapis"; got != want {
+		t.Errorf("got module %q from image extension, want %q", got, want)
+	}
+	// module= is the option of protoc-gen-go
+	req.Parameter = proto.String("paths=source_relative,module=example.com/petapis")
+	if got, want := bufModule(req), "buf.build/acme/petapis"; got != want {
+		t.Errorf("got module %q with module= parameter, want %q", got, want)
+	}
+	req.Parameter = proto.String("paths=source_relative,buf_module=buf.build/acme/other")
+	if got, want := bufModule(req), "buf.build/acme/other"; got != want {
+		t.Errorf("got module %q from parameter, want %q", got, want)
+	}
+	if got, want := bufFileName("out.proto.msg", "buf.build/acme/other"), "out.buf.build_acme_other.proto.msg"; got != want {
+		t.Errorf("got file name %q, want %q", got, want)
+	}
+}
+
+// evalCEL compiles and evaluates src without variables.
+func evalCEL(src string) (interface{}, error) {
+	expr, err := compileCEL(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr(nil)
+}
+
+func TestCELValues(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want interface{}
+	}{
+		// literals
+		{`42`, int64(42)},
+		{`0x2A`, int64(42)},
+		{`42u`, uint64(42)},
+		{`-9223372036854775808`, int64(math.MinInt64)},
+		{`9223372036854775807`, int64(math.MaxInt64)},
+		{`18446744073709551615u`, uint64(math.MaxUint64)},
+		{`1.5e3`, 1500.0},
+		{`.5`, 0.5},
+		{`"a\tb"`, "a\tb"},
+		{`r'a\tb'`, `a\tb`},
+		{`b"ab"`, []byte("ab")},
+		{`true`, true},
+		{`null`, nil},
+		{`[1, "a", [true]]`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{`{"a": 1, 2: "b"}`, map[interface{}]interface{}{"a": int64(1), int64(2): "b"}},
+		// operators and precedence
+		{`1 + 2 * 3`, int64(7)},
+		{`(1 + 2) * 3`, int64(9)},
+		{`2-1`, int64(1)},
+		{`2 - -1`, int64(3)},
+		{`-(1 + 2)`, int64(-3)},
+		{`7 / 2`, int64(3)},
+		{`-7 % 3`, int64(-1)},
+		{`7u / 2u`, uint64(3)},
+		{`7.0 / 2.0`, 3.5},
+		{`"ab" + "cd"`, "abcd"},
+		{`[1] + [2]`, []interface{}{int64(1), int64(2)}},
+		{`1 < 2 && "a" < "b"`, true},
+		{`1 == 1.0 && 1u == 1`, true},
+		{`!true || false`, false},
+		{`true ? 1 : 2`, int64(1)},
+		{`2 in [1, 2]`, true},
+		{`"a" in {"a": 1}`, true},
+		{`[1, 2][1]`, int64(2)},
+		{`{"a": {"b": 3}}.a.b`, int64(3)},
+		{`has({"a": 1}.a)`, true},
+		{`false && 1 / 0 == 0`, false},
+		// functions
+		{`size("äb")`, int64(2)},
+		{`"abc".startsWith("ab") && "abc".endsWith("bc") && "abc".contains("b")`, true},
+		{`"a1".matches("^[a-z][0-9]$")`, true},
+		{`"A,b".lowerAscii().split(",")`, []interface{}{"a", "b"}},
+		{`["a", "b"].join("-")`, "a-b"},
+		{`"aXa".replace("a", "b")`, "bXb"},
+		{`int("-3") + int(2u)`, int64(-1)},
+		{`uint(3) + uint("4")`, uint64(7)},
+		{`string(1.5)`, "1.5"},
+		{`google.protobuf.FieldDescriptorProto.Type.TYPE_STRING`, int64(9)},
+		// macros
+		{`[1, 2, 3].all(x, x > 0)`, true},
+		{`[1, 2, 3].exists(x, x > 2)`, true},
+		{`[1, 2, 3].exists_one(x, x > 1)`, false},
+		{`[1, 2, 3].map(x, x * 2)`, []interface{}{int64(2), int64(4), int64(6)}},
+		{`[1, 2, 3].map(x, x > 1, x * 2)`, []interface{}{int64(4), int64(6)}},
+		{`[1, 2, 3].filter(x, x % 2 == 1)`, []interface{}{int64(1), int64(3)}},
+		{`{"a": 1, "b": 2}.filter(k, k != "a")`, []interface{}{"b"}},
+		{`[1, 0].exists(x, 1 / x == 1)`, true},
+		{`[[1], [2, 3]].map(x, x.map(x, x + 1))`, []interface{}{[]interface{}{int64(2)}, []interface{}{int64(3), int64(4)}}},
+	} {
+		got, err := evalCEL(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestCELErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		// parse errors
+		{`1 +`, "1:4: unexpected end of expression"},
+		{"1 +\n  )", "2:3: unexpected \")\""},
+		{`"abc`, "1:1:"},
+		{`r'a`, "1:1: unterminated string literal"},
+		{`9223372036854775808`, "invalid number 9223372036854775808"},
+		{`-9223372036854775809`, "invalid number -9223372036854775809"},
+		{`18446744073709551616u`, "invalid number"},
+		{`1 # 2`, `unexpected character '#'`},
+		{`unknown`, "undeclared reference to unknown"},
+		{`nope(1)`, "undeclared reference to function nope"},
+		{`size(1, 2)`, "wrong number of arguments to size"},
+		{`has(1)`, "has() needs a field selection"},
+		{`[1].all(1, true)`, "1:9:"},
+		// evaluation errors
+		{`9223372036854775807 + 1`, "integer overflow"},
+		{`-9223372036854775808 - 1`, "integer overflow"},
+		{`-(-9223372036854775808)`, "integer overflow"},
+		{`-9223372036854775808 / -1`, "integer overflow"},
+		{`0u - 1u`, "integer overflow"},
+		{`1 / 0`, "division by zero"},
+		{`1 % 0`, "division by zero"},
+		{`1 + 1u`, "no such overload"},
+		{`1 + 1.0`, "no such overload"},
+		{`[1][1]`, "index 1 out of range"},
+		{`{"a": 1}.b`, "no such key b"},
+		{`{1: 1, 1: 2}`, "duplicate map key 1"},
+		{`int(9223372036854775808.0)`, "out of range"},
+		{`int("x")`, `int("x") failed`},
+		{`"a".matches("(")`, "matches:"},
+		{`[1, 0].all(x, 1 / x == 1)`, "division by zero"},
+	} {
+		_, err := evalCEL(tc.src)
+		if err == nil {
+			t.Errorf("%s: got no error, want %q", tc.src, tc.want)
+		} else if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %q, want %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestFilterFilesCEL(t *testing.T) {
+	req := testRequest()
+	expr, err := compileFileExpr("filter-expr", `file.package == "test" && request.file_to_generate.exists(f, f == file.name)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := filterFilesCEL(req, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fd := range req.ProtoFile {
+		names = append(names, fd.GetName())
+	}
+	// imports of kept files stay
+	want := []string{"google/protobuf/descriptor.proto", "opts.proto", "test.proto"}
+	if dropped != 0 || !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %v with %d dropped, want %v", names, dropped, want)
+	}
+
+	req = testRequest()
+	if expr, err = compileFileExpr("filter-expr", `file.name != "test.proto"`); err != nil {
+		t.Fatal(err)
+	}
+	if dropped, err = filterFilesCEL(req, expr); err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 1 || len(req.ProtoFile) != 2 || len(req.FileToGenerate) != 0 {
+		t.Errorf("got %d files, %d to generate with %d dropped, want 2, 0 and 1", len(req.ProtoFile), len(req.FileToGenerate), dropped)
+	}
+
+	if expr, err = compileFileExpr("filter-expr", `file.name`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = filterFilesCEL(testRequest(), expr); err == nil || !strings.Contains(err.Error(), "not bool") {
+		t.Errorf("got error %v for a string result, want not bool", err)
+	}
+	if _, err = compileFileExpr("filter-expr", `file.`); err == nil || !strings.HasPrefix(err.Error(), "-filter-expr: 1:6:") {
+		t.Errorf("got error %v, want -filter-expr: 1:6:", err)
+	}
+}
+
+func TestMapFilesCEL(t *testing.T) {
+	req := testRequest()
+	expr, err := compileFileExpr("map-expr", `file.name == "opts.proto" ? {"name": "renamed.proto", "options": {"go_package": "example.com/opts"}} : file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mapFilesCEL(req, expr); err != nil {
+		t.Fatal(err)
+	}
+	opts := req.ProtoFile[1]
+	if opts.GetName() != "renamed.proto" || opts.GetOptions().GetGoPackage() != "example.com/opts" || opts.GetPackage() != "opts" {
+		t.Errorf("got %v, want renamed.proto with go_package and the original package", opts)
+	}
+	if deps := req.ProtoFile[2].GetDependency(); !reflect.DeepEqual(deps, []string{"renamed.proto"}) {
+		t.Errorf("got dependencies %v of test.proto, want the renamed file", deps)
+	}
+	// options of fields survive the copy
+	if len(req.ProtoFile[2].GetMessageType()[0].GetField()[0].GetOptions().ProtoReflect().GetUnknown()) == 0 {
+		t.Errorf("field options of test.proto were lost")
+	}
+
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{`1`, "not google.protobuf.FileDescriptorProto or a map of its fields"},
+		{`{"nope": 1}`, "no such field nope"},
+		{`{"name": 1}`, "can not be set to int"},
+		{`{"dependency": "a"}`, "needs a list"},
+		{`request`, "not google.protobuf.FileDescriptorProto"},
+	} {
+		expr, err := compileFileExpr("map-expr", tc.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mapFilesCEL(testRequest(), expr); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vectors of RFC 7914 section 11
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		if got := hex.EncodeToString(pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iterations, 64)); got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tc.password, tc.salt, tc.iterations, got, tc.want)
+		}
+	}
+}
+
+func TestApplyResponseRejectsEscapingNames(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	gl := &goLayout{out: out, modRoot: out, modPath: "example.com/m"}
+	places := map[string]func(string) (string, error){
+		"out":       func(name string) (string, error) { return splitPath(out, name) },
+		"go-layout": gl.place,
+	}
+	for layout, place := range places {
+		for _, name := range []string{"../escaped.txt", "../escaped.go", "/tmp/abs.txt", "a/../../escaped.txt", `a\b.txt`, ""} {
+			resp := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+				{Name: proto.String("ok.txt"), Content: proto.String("ok")},
+				{Name: proto.String(name), Content: proto.String("escaped")},
+			}}
+			err := applyResponse(resp, place)
+			if err == nil || !strings.Contains(err.Error(), "can not be used as path") {
+				t.Errorf("%s: %q: got error %v, want can not be used as path", layout, name, err)
+			}
+		}
+	}
+	// nothing is written when a name is rejected
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %d entries in %s (%v), want none", len(entries), dir, err)
+	}
+
+	gl.modPath = "example.com"
+	gl.paramMod = "example.com/../.."
+	resp := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+		{Name: proto.String("x.pb.go"), Content: proto.String("package x")},
+	}}
+	if err := applyResponse(resp, gl.place); err == nil {
+		t.Errorf("go_package outside of the module root was accepted")
+	}
+
+	resp.File[0].Name = proto.String("a/b.txt")
+	if err := applyResponse(resp, places["out"]); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "a", "b.txt")); err != nil || string(b) != "package x" {
+		t.Errorf("got %q (%v) in a/b.txt, want the content", b, err)
+	}
+}
+
+func TestObfuscateRenamesResolvedExtensions(t *testing.T) {
+	in, err := proto.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := decodeRequest(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obfuscate(req, func(fd *descriptorpb.FileDescriptorProto) bool {
+		return !strings.HasPrefix(fd.GetName(), "google/protobuf/")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, original := range []string{`"opts.proto"`, `"test.proto"`, `"opts"`, `"test"`, `"Msg"`, `"id"`, `"secret"`, `opts.secret`, `.test.`} {
+		if bytes.Contains(out, []byte(original)) {
+			t.Errorf("json output contains %s", original)
+		}
+	}
+	again, err := decodeRequest(out, true)
+	if err != nil {
+		t.Fatalf("obfuscated json output can not be read: %v", err)
+	}
+	opts := again.ProtoFile[2].MessageType[0].Field[0].Options
+	if len(opts.ProtoReflect().GetUnknown()) != 0 {
+		t.Errorf("option of obfuscated field was not resolved")
+	}
+}
+
+func TestRemapGoPackage(t *testing.T) {
+	pr := packageRemap{from: "foo.v1", to: "bar.v2"}
+	for _, tc := range []struct {
+		gp, pkg, want string
+	}{
+		{"example.com/foo/v1", "foo.v1", "example.com/bar/v2"},
+		{"example.com/foo/v1;foov1", "foo.v1", "example.com/bar/v2;barv2"},
+		{"example.com/foo/v1;foo_v1", "foo.v1", "example.com/bar/v2;bar_v2"},
+		{"example.com/foo/v1;v1", "foo.v1", "example.com/bar/v2;v2"},
+		{"example.com/foo/v1;custom", "foo.v1", "example.com/bar/v2;custom"},
+		{"example.com/foo/v1/sub;subpb", "foo.v1.sub", "example.com/bar/v2/sub;subpb"},
+		{"example.com/foo/v1/sub;v1sub", "foo.v1.sub", "example.com/bar/v2/sub;v2sub"},
+		{"foo/v1;foov1", "foo.v1", "bar/v2;barv2"},
+	} {
+		if got := pr.goPackage(tc.gp, tc.pkg); got != tc.want {
+			t.Errorf("goPackage(%q, %q) = %q, want %q", tc.gp, tc.pkg, got, tc.want)
+		}
+	}
+}
+
+func TestRemapPackagesRewritesReferences(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("foo.proto"),
+		Package: proto.String("foo.v1"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/foo/v1;foov1")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Msg"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("other"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".foo.v1.Msg"),
+			}, {
+				Name:     proto.String("kept"),
+				Number:   proto.Int32(2),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".foo.v10.Msg"),
+			}},
+			ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{{Start: proto.Int32(100), End: proto.Int32(200)}},
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("ext"),
+			Number:   proto.Int32(100),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(".foo.v1.sub.Sub"),
+			Extendee: proto.String(".foo.v1.Msg"),
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Svc"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Call"),
+				InputType:  proto.String(".foo.v1.Msg"),
+				OutputType: proto.String("foo.v1.sub.Sub"),
+			}},
+		}},
+	}
+	req := &pluginpb.CodeGeneratorRequest{ProtoFile: []*descriptorpb.FileDescriptorProto{file}}
+	noResolve = true
+	defer func() { noResolve = false }()
+	remaps, err := parseRemaps([]string{".foo.v1.=bar.v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remapPackages(req, remaps); err != nil {
+		t.Fatal(err)
+	}
+	file = req.ProtoFile[0]
+	m, ext, meth := file.MessageType[0], file.Extension[0], file.Service[0].Method[0]
+	for _, tc := range []struct{ field, got, want string }{
+		{"package", file.GetPackage(), "bar.v2"},
+		{"go_package", file.GetOptions().GetGoPackage(), "example.com/bar/v2;barv2"},
+		{"type_name", m.Field[0].GetTypeName(), ".bar.v2.Msg"},
+		{"type_name of another package", m.Field[1].GetTypeName(), ".foo.v10.Msg"},
+		{"extension type_name", ext.GetTypeName(), ".bar.v2.sub.Sub"},
+		{"extendee", ext.GetExtendee(), ".bar.v2.Msg"},
+		{"input_type", meth.GetInputType(), ".bar.v2.Msg"},
+		{"output_type", meth.GetOutputType(), "bar.v2.sub.Sub"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.field, tc.got, tc.want)
+		}
+	}
+}
+
+func TestRemapResolvesExtensionsAgain(t *testing.T) {
+	in, err := proto.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := decodeRequest(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remapPackages(req, []packageRemap{{from: "opts", to: "renamed.opts"}}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := encode(req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`"[renamed.opts.secret]"`)) || bytes.Contains(out, []byte(`"[opts.secret]"`)) {
+		t.Errorf("json output does not name the extension after the remapped package")
+	}
+	if _, err := decodeRequest(out, true); err != nil {
+		t.Errorf("remapped json output can not be read: %v", err)
+	}
+}
+
+func TestContainerVersionIsChecked(t *testing.T) {
+	payload, err := proto.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, version := range []int{capture.ContainerVersion, capture.ContainerVersion + 1} {
+		bin, err := wrapContainer(&captureMeta{Version: version, Kind: "request"}, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, "out.proto.msg")
+		if err := os.WriteFile(name, bin, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, mainErr := decode(bin, true, false)
+		_, libErr := capture.ReadRequest(name)
+		if supported := version <= capture.ContainerVersion; supported != (mainErr == nil) || supported != (libErr == nil) {
+			t.Errorf("version %d: got errors %v and %v from decode and capture.ReadRequest", version, mainErr, libErr)
+		}
+	}
+}
+
+// The golden descriptors in testdata/protoc were produced by protoc, they are the raw
+// descriptors embedded in the generated code of google.golang.org/protobuf v1.28.0.
+func TestCompileMatchesProtoc(t *testing.T) {
+	root := filepath.Join("testdata", "protoc")
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(path, ".proto") {
+			name, err := filepath.Rel(root, path)
+			names = append(names, filepath.ToSlash(name))
+			return err
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		c := &protoCompiler{includes: []string{root}, files: map[string]*compiledFile{}}
+		req, err := c.request([]string{name}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		// custom options are unknown fields in the golden descriptors,
+		// a round trip turns them into unknown fields in the compiled one too
+		fd := req.ProtoFile[len(req.ProtoFile)-1]
+		fd.SourceCodeInfo = nil
+		bin, err := proto.Marshal(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, want := &descriptorpb.FileDescriptorProto{}, &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(bin, got); err != nil {
+			t.Fatal(err)
+		}
+		golden, err := os.ReadFile(filepath.Join(root, strings.TrimSuffix(name, ".proto")+".pb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proto.Unmarshal(golden, want); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("%s: compiled descriptor differs from protoc\ngot:\n%v\nwant:\n%v", name, prototext.Format(got), prototext.Format(want))
+		}
+	}
+}
+
+func TestCompileRejectsEditions(t *testing.T) {
+	c := &protoCompiler{
+		sources: map[string]string{"a.proto": "edition = \"2023\";\npackage a;\n"},
+		files:   map[string]*compiledFile{},
+	}
+	_, err := c.request([]string{"a.proto"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "editions are not supported") {
+		t.Errorf("got error %v for a file using editions", err)
+	}
+}
+
+// h2cServer serves the handlers of s over HTTP/2 without TLS, gRPC needs HTTP/2.
+func h2cServer(t *testing.T, s *server) (*httptest.Server, *http.Client) {
+	ts := httptest.NewServer(h2c.NewHandler(s.handler(), &http2.Server{}))
+	t.Cleanup(ts.Close)
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	return ts, client
+}
+
+func TestGRPCGenerate(t *testing.T) {
+	s := &server{plugins: map[string][]string{}, maxBody: 1 << 20, metrics: newServerMetrics()}
+	ts, client := h2cServer(t, s)
+	bin, err := proto.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, ts.URL+grpcGenerate, bytes.NewReader(grpcFrame(bin)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("got %s %s with Content-Type %q", resp.Proto, resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("got grpc-status %q in the trailers, want 0", status)
+	}
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		t.Fatalf("response is not a single uncompressed frame: % x", body)
+	}
+	out := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(body[5:], out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.File) != 1 || out.File[0].GetName() != "out.proto.msg" || out.File[0].GetContent() != string(bin) {
+		t.Errorf("response does not return the request in out.proto.msg: %v", out)
+	}
+
+	// the client used by remote reads the same response
+	msg, err := grpcCall(context.Background(), client, ts.URL, "", bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, body[5:]) {
+		t.Errorf("grpcCall returned a different response")
+	}
+}
+
+func TestGRPCGenerateErrors(t *testing.T) {
+	s := &server{plugins: map[string][]string{}, maxBody: 1 << 20, metrics: newServerMetrics()}
+	ts, client := h2cServer(t, s)
+	bin, err := proto.Marshal(testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = grpcCall(context.Background(), client, ts.URL, "missing", bin)
+	if e, ok := err.(*grpcError); !ok || e.code != grpcNotFound || !strings.Contains(e.msg, `unknown plugin "missing"`) {
+		t.Errorf("got %v for an unknown plugin, want status %d", err, grpcNotFound)
+	}
+
+	for _, tc := range []struct {
+		body []byte
+		code string
+	}{
+		{grpcFrame(bin)[:10], "3"},
+		{append([]byte{1}, grpcFrame(bin)[1:]...), "12"},
+		{grpcFrame([]byte{0xff}), "3"},
+	} {
+		r, err := http.NewRequest(http.MethodPost, ts.URL+grpcGenerate, bytes.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/grpc")
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// errors are sent in the trailers of a successful HTTP response without a message
+		if resp.StatusCode != http.StatusOK || len(body) != 0 {
+			t.Errorf("got %s with %d bytes for a failed call", resp.Status, len(body))
+		}
+		if status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"); status != tc.code || msg == "" {
+			t.Errorf("got grpc-status %q and grpc-message %q, want status %s with a message", status, msg, tc.code)
+		}
+	}
+}
+
+func TestTransformUsesRegisteredOps(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pipeline.yaml")
+	pipelineYAML := `- op: compiler-version
+  value: 3.21.12-rc1
+- op: map-expr
+  expr: 'file.name == "test.proto" ? {"package": "renamed"} : file'
+- op: remap
+  from: renamed
+  to: example.renamed
+- op: set-parameter
+  append: plugins=grpc
+`
+	if err := os.WriteFile(name, []byte(pipelineYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := loadTransform(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := testRequest()
+	if err := pipeline.apply(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := compilerVersion(req); got != "3.21.12-rc1" {
+		t.Errorf("got compiler version %q", got)
+	}
+	if got := req.ProtoFile[2].GetPackage(); got != "example.renamed" {
+		t.Errorf("got package %q, want example.renamed", got)
+	}
+	if got := req.GetParameter(); got != "paths=source_relative,plugins=grpc" {
+		t.Errorf("got parameter %q", got)
+	}
+
+	if err := os.WriteFile(name, []byte("- op: bogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTransform(name); err == nil || !strings.Contains(err.Error(), "want one of canonicalize, compiler-version,") {
+		t.Errorf("got error %v for an unknown op", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("merge", "merge captured requests of several protoc runs into one request", runMerge)
+}
+
+func runMerge(args []string) error {
+	jsonOut := false
+	fs := newFlagSet("merge")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture merge [ARGUMENTS] REQUEST... > merged.proto.msg\n\n")
+		fmt.Fprint(os.Stdout, "Files of the same name must be identical and parameters must match.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no requests given")
+	}
+
+	var reqs []*pluginpb.CodeGeneratorRequest
+	for _, name := range fs.Args() {
+		req, err := readRequestFile(name)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+	merged, err := mergeRequests(fs.Args(), reqs)
+	if err != nil {
+		return err
+	}
+	if !jsonOut && isTerminal(os.Stdout) {
+		return fmt.Errorf("refusing to write binary proto to a terminal, redirect stdout or use -json-out")
+	}
+	out, err := encode(merged, jsonOut)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// mergeRequests unions the files of reqs, names are used in errors.
+// Every request lists its files after their dependencies, so appending keeps that order.
+func mergeRequests(names []string, reqs []*pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorRequest, error) {
+	merged := &pluginpb.CodeGeneratorRequest{}
+	// seen maps file names to the file and the index of the request it is taken from
+	type source struct {
+		fd  *descriptorpb.FileDescriptorProto
+		req int
+	}
+	seen := map[string]source{}
+	generate := map[string]bool{}
+	for i, req := range reqs {
+		if i == 0 {
+			merged.Parameter = req.Parameter
+			merged.CompilerVersion = req.CompilerVersion
+		} else {
+			if req.GetParameter() != merged.GetParameter() {
+				return nil, fmt.Errorf("%s: parameter %q differs from %q in %s", names[i], req.GetParameter(), merged.GetParameter(), names[0])
+			}
+			if v, w := compilerVersion(req), compilerVersion(merged); v != w {
+				return nil, fmt.Errorf("%s: compiler version %q differs from %q in %s", names[i], v, w, names[0])
+			}
+		}
+		for _, fd := range req.ProtoFile {
+			prev, ok := seen[fd.GetName()]
+			if !ok {
+				seen[fd.GetName()] = source{fd, i}
+				merged.ProtoFile = append(merged.ProtoFile, fd)
+			} else if !proto.Equal(prev.fd, fd) {
+				return nil, fmt.Errorf("%s: file %s differs from the one in %s", names[i], fd.GetName(), names[prev.req])
+			}
+		}
+		for _, name := range req.FileToGenerate {
+			if !generate[name] {
+				generate[name] = true
+				merged.FileToGenerate = append(merged.FileToGenerate, name)
+			}
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayBuckets are the upper bounds in seconds of the replay latency histogram.
+var replayBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// serverMetrics counts what serve handles, exported on /metrics in the
+// Prometheus text format.
+type serverMetrics struct {
+	mu             sync.Mutex
+	requests       map[[2]string]int64 // by endpoint and status code
+	requestBytes   map[string]int64
+	decodeFailures map[string]int64
+	captures       int64
+	captureBytes   int64
+	replays        map[string]*latencyHistogram // by plugin
+	replayFailures map[string]int64
+}
+
+type latencyHistogram struct {
+	counts []int64 // per bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:       map[[2]string]int64{},
+		requestBytes:   map[string]int64{},
+		decodeFailures: map[string]int64{},
+		replays:        map[string]*latencyHistogram{},
+		replayFailures: map[string]int64{},
+	}
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.n += int64(n)
+	return n, err
+}
+
+// instrument counts the requests to endpoint with their status and body size.
+func (sm *serverMetrics) instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		h(sr, r)
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+		sm.requests[[2]string{endpoint, fmt.Sprint(sr.status)}]++
+		sm.requestBytes[endpoint] += body.n
+	}
+}
+
+func (sm *serverMetrics) decodeFailed(endpoint string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.decodeFailures[endpoint]++
+}
+
+func (sm *serverMetrics) captured(bytes int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.captures++
+	sm.captureBytes += int64(bytes)
+}
+
+func (sm *serverMetrics) replayed(plugin string, d time.Duration, failed bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	h := sm.replays[plugin]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]int64, len(replayBuckets))}
+		sm.replays[plugin] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range replayBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if failed {
+		sm.replayFailures[plugin]++
+	}
+}
+
+func (sm *serverMetrics) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	sm.write(w)
+}
+
+// write writes the metrics in the Prometheus text format, series sorted by labels.
+func (sm *serverMetrics) write(w io.Writer) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	header := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("protoc_gen_capture_http_requests_total", "counter", "HTTP requests by endpoint and status code.")
+	var keys [][2]string
+	for k := range sm.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "protoc_gen_capture_http_requests_total{endpoint=%s,code=%s} %d\n", labelValue(k[0]), labelValue(k[1]), sm.requests[k])
+	}
+	writeLabeled(w, "protoc_gen_capture_request_bytes_total", "endpoint", "Bytes of request bodies by endpoint.", sm.requestBytes, header)
+	writeLabeled(w, "protoc_gen_capture_decode_failures_total", "endpoint", "Bodies that could not be decoded by endpoint.", sm.decodeFailures, header)
+
+	header("protoc_gen_capture_captures_total", "counter", "Requests stored in the capture directory.")
+	fmt.Fprintf(w, "protoc_gen_capture_captures_total %d\n", sm.captures)
+	header("protoc_gen_capture_captured_bytes_total", "counter", "Bytes of requests stored in the capture directory.")
+	fmt.Fprintf(w, "protoc_gen_capture_captured_bytes_total %d\n", sm.captureBytes)
+
+	header("protoc_gen_capture_replay_duration_seconds", "histogram", "Duration of plugin runs by plugin.")
+	var plugins []string
+	for plugin := range sm.replays {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+	for _, plugin := range plugins {
+		h := sm.replays[plugin]
+		var cumulative int64
+		for i, le := range replayBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "protoc_gen_capture_replay_duration_seconds_bucket{plugin=%s,le=\"%g\"} %d\n", labelValue(plugin), le, cumulative)
+		}
+		fmt.Fprintf(w, "protoc_gen_capture_replay_duration_seconds_bucket{plugin=%s,le=\"+Inf\"} %d\n", labelValue(plugin), h.count)
+		fmt.Fprintf(w, "protoc_gen_capture_replay_duration_seconds_sum{plugin=%s} %g\n", labelValue(plugin), h.sum)
+		fmt.Fprintf(w, "protoc_gen_capture_replay_duration_seconds_count{plugin=%s} %d\n", labelValue(plugin), h.count)
+	}
+	writeLabeled(w, "protoc_gen_capture_replay_failures_total", "plugin", "Failed plugin runs by plugin.", sm.replayFailures, header)
+}
+
+func writeLabeled(w io.Writer, name, label, help string, values map[string]int64, header func(name, typ, help string)) {
+	header(name, "counter", help)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, labelValue(k), values[k])
+	}
+}
+
+// labelValue quotes a label value of the text format.
+func labelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
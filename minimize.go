@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("minimize", "shrink a request to the smallest one still failing a plugin", runMinimize)
+}
+
+func runMinimize(args []string) error {
+	var (
+		jsonIn      = false
+		jsonOut     = false
+		match       = ""
+		timeout     = time.Minute
+		keepSources = false
+		valid       = true
+	)
+	fs := newFlagSet("minimize")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.StringVar(&match, "match", match, "regular expression the plugin error or stderr must match to count as reproduced")
+	fs.DurationVar(&timeout, "timeout", timeout, "maximum duration of a single plugin run")
+	fs.BoolVar(&keepSources, "keep-source-info", keepSources, "keep source code info with comments, else it is stripped to anonymize the result")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture minimize [ARGUMENTS] PLUGIN [PLUGIN-ARGS...] < request\n\n")
+		fmt.Fprint(os.Stdout, "Removes files, services, methods, messages, enums and fields as long as PLUGIN still fails.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&valid, "valid", valid, "only keep reductions whose descriptors still link, so the plugin fails for the same reason")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	var re *regexp.Regexp
+	if match != "" {
+		var err error
+		re, err = regexp.Compile(match)
+		if err != nil {
+			return fmt.Errorf("invalid -match: %v", err)
+		}
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	if !keepSources {
+		for _, fd := range req.ProtoFile {
+			fd.SourceCodeInfo = nil
+		}
+	}
+
+	runs := 0
+	fails := func(req *pluginpb.CodeGeneratorRequest) (bool, error) {
+		if valid {
+			if _, err := protoTypes(req.ProtoFile); err != nil {
+				return false, nil
+			}
+		}
+		in, err := encode(req, false)
+		if err != nil {
+			return false, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		runs++
+		pr, err := runPlugin(ctx, fs.Args(), in)
+		if err != nil {
+			return false, err
+		}
+		if !pr.failed() {
+			return false, nil
+		}
+		return re == nil || re.MatchString(pr.failure()), nil
+	}
+	ok, err := fails(req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("plugin does not fail on the input request, nothing to minimize")
+	}
+
+	before := requestSize(req)
+	req, err = minimizeRequest(req, fails)
+	if err != nil {
+		return err
+	}
+	log.Printf("minimized after %d plugin runs from %s to %s\n", runs, before, requestSize(req))
+
+	out, err := encode(req, jsonOut)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func requestSize(req *pluginpb.CodeGeneratorRequest) string {
+	st := newRequestStats(req, 0)
+	return fmt.Sprintf("%d files, %d messages, %d fields, %d bytes",
+		st.Files, st.Total.Messages, st.Total.Fields, st.Bytes)
+}
+
+// part kinds removed by minimizeRequest, in order of removal
+var partKinds = []string{"file", "service", "method", "message", "enum", "field", "value"}
+
+// minimizeRequest removes parts of req as long as fails reports true.
+// Parts are removed in chunks of decreasing size like delta debugging does.
+func minimizeRequest(req *pluginpb.CodeGeneratorRequest, fails func(*pluginpb.CodeGeneratorRequest) (bool, error)) (*pluginpb.CodeGeneratorRequest, error) {
+	for progress := true; progress; {
+		progress = false
+		for _, kind := range partKinds {
+			ids := listParts(req, kind)
+			n := 2
+			for len(ids) > 0 {
+				chunk := (len(ids) + n - 1) / n
+				removed := false
+				for start := 0; start < len(ids); start += chunk {
+					end := start + chunk
+					if end > len(ids) {
+						end = len(ids)
+					}
+					drop := map[string]bool{}
+					for _, id := range ids[start:end] {
+						drop[id] = true
+					}
+					cand := removeParts(req, kind, drop)
+					if proto.Equal(cand, req) {
+						// nothing could be removed
+						continue
+					}
+					ok, err := fails(cand)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						req = cand
+						ids = append(ids[:start:start], ids[end:]...)
+						removed, progress = true, true
+						if n > 2 {
+							n--
+						}
+						break
+					}
+				}
+				if !removed {
+					if chunk == 1 {
+						break
+					}
+					n *= 2
+					if n > len(ids) {
+						n = len(ids)
+					}
+				}
+			}
+		}
+	}
+	return req, nil
+}
+
+// walkParts calls fn with the identifier of each part of kind in req.
+// If fn returns true, the part is removed.
+func walkParts(req *pluginpb.CodeGeneratorRequest, kind string, fn func(id string) bool) {
+	if kind == "file" {
+		// files to generate are never removed completely
+		var files []*descriptorpb.FileDescriptorProto
+		gone := map[string]bool{}
+		kept := 0
+		for _, fd := range req.ProtoFile {
+			if fn(fd.GetName()) {
+				gone[fd.GetName()] = true
+				continue
+			}
+			files = append(files, fd)
+		}
+		var gen []string
+		for _, name := range req.FileToGenerate {
+			if !gone[name] {
+				gen = append(gen, name)
+				kept++
+			}
+		}
+		if kept == 0 && len(req.FileToGenerate) > 0 {
+			return
+		}
+		req.ProtoFile = files
+		req.FileToGenerate = gen
+		for _, fd := range files {
+			fd.Dependency = filterStrings(fd.Dependency, gone)
+			// indices are no longer valid after removing dependencies
+			fd.PublicDependency = nil
+			fd.WeakDependency = nil
+		}
+		return
+	}
+	for _, fd := range req.ProtoFile {
+		prefix := fd.GetPackage()
+		if prefix != "" {
+			prefix += "."
+		}
+		switch kind {
+		case "service":
+			fd.Service = filterServices(fd.Service, prefix, fn)
+		case "method":
+			for _, sd := range fd.Service {
+				name := prefix + sd.GetName() + "."
+				var methods []*descriptorpb.MethodDescriptorProto
+				for _, m := range sd.Method {
+					if !fn(name + m.GetName()) {
+						methods = append(methods, m)
+					}
+				}
+				sd.Method = methods
+			}
+		case "enum", "value":
+			fd.EnumType = walkEnums(fd.EnumType, prefix, kind, fn)
+		}
+		fd.MessageType = walkMessages(fd.MessageType, prefix, kind, fn)
+	}
+}
+
+func filterStrings(ss []string, drop map[string]bool) []string {
+	var out []string
+	for _, s := range ss {
+		if !drop[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func filterServices(sds []*descriptorpb.ServiceDescriptorProto, prefix string, fn func(string) bool) []*descriptorpb.ServiceDescriptorProto {
+	var out []*descriptorpb.ServiceDescriptorProto
+	for _, sd := range sds {
+		if !fn(prefix + sd.GetName()) {
+			out = append(out, sd)
+		}
+	}
+	return out
+}
+
+func walkEnums(eds []*descriptorpb.EnumDescriptorProto, prefix, kind string, fn func(string) bool) []*descriptorpb.EnumDescriptorProto {
+	var out []*descriptorpb.EnumDescriptorProto
+	for _, ed := range eds {
+		name := prefix + ed.GetName()
+		if kind == "enum" && fn(name) {
+			continue
+		}
+		if kind == "value" {
+			var values []*descriptorpb.EnumValueDescriptorProto
+			for i, v := range ed.Value {
+				// the first value is the default and must stay
+				if i == 0 || !fn(name+"."+v.GetName()) {
+					values = append(values, v)
+				}
+			}
+			ed.Value = values
+		}
+		out = append(out, ed)
+	}
+	return out
+}
+
+func walkMessages(mds []*descriptorpb.DescriptorProto, prefix, kind string, fn func(string) bool) []*descriptorpb.DescriptorProto {
+	var out []*descriptorpb.DescriptorProto
+	for _, md := range mds {
+		name := prefix + md.GetName()
+		if kind == "message" && !md.GetOptions().GetMapEntry() && fn(name) {
+			continue
+		}
+		switch kind {
+		case "field":
+			var fields []*descriptorpb.FieldDescriptorProto
+			for _, f := range md.Field {
+				if md.GetOptions().GetMapEntry() || !fn(name+"."+f.GetName()) {
+					fields = append(fields, f)
+				}
+			}
+			md.Field = fields
+		case "enum", "value":
+			md.EnumType = walkEnums(md.EnumType, name+".", kind, fn)
+		}
+		md.NestedType = walkMessages(md.NestedType, name+".", kind, fn)
+		out = append(out, md)
+	}
+	return out
+}
+
+func listParts(req *pluginpb.CodeGeneratorRequest, kind string) []string {
+	var ids []string
+	walkParts(proto.Clone(req).(*pluginpb.CodeGeneratorRequest), kind, func(id string) bool {
+		ids = append(ids, id)
+		return false
+	})
+	return ids
+}
+
+func removeParts(req *pluginpb.CodeGeneratorRequest, kind string, drop map[string]bool) *pluginpb.CodeGeneratorRequest {
+	req = proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+	walkParts(req, kind, func(id string) bool {
+		return drop[id]
+	})
+	return req
+}
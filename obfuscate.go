@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("deobfuscate", "translate pseudonyms of an obfuscated capture in text like plugin errors back to the original names", runDeobfuscate)
+	registerTransform("obfuscate", func(ts *transformStep) error {
+		if ts.KeyEnv != "" && ts.Mapping == "" {
+			return fmt.Errorf("obfuscate needs a mapping to encrypt with key_env")
+		}
+		return nil
+	}, applyObfuscate)
+}
+
+// applyObfuscate runs an obfuscate step, without files it skips google/protobuf.
+func applyObfuscate(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+	passphrase := ""
+	if ts.KeyEnv != "" {
+		if passphrase = os.Getenv(ts.KeyEnv); passphrase == "" {
+			return fmt.Errorf("environment variable %s is not set", ts.KeyEnv)
+		}
+	}
+	m, err := obfuscate(req, func(fd *descriptorpb.FileDescriptorProto) bool {
+		if len(ts.Files) == 0 {
+			return !strings.HasPrefix(fd.GetName(), "google/protobuf/")
+		}
+		return ts.inScope(fd)
+	})
+	if err != nil || ts.Mapping == "" {
+		return err
+	}
+	return writeMapping(ts.Mapping, m, passphrase)
+}
+
+// obfuscationMagic starts encrypted mapping files, the rest of the first line names
+// the cipher, key derivation and its iterations. A random salt follows the line.
+const obfuscationMagic = "protoc-gen-capture mapping "
+
+const (
+	// mappingCipher is the only supported cipher and key derivation
+	mappingCipher = "aes-256-gcm pbkdf2-sha256"
+	// mappingIterations is the default work factor of the key derivation
+	mappingIterations = 600000
+	// maxMappingIterations bounds the work factor read from mapping files
+	maxMappingIterations = 1 << 24
+	mappingSaltSize      = 16
+)
+
+// obfuscationMapping maps the pseudonyms of an obfuscated request to the original names.
+type obfuscationMapping struct {
+	Names map[string]string `json:"names"`
+}
+
+// obfuscator assigns pseudonyms per kind, the same name of a kind always gets the same pseudonym.
+// Package and directory segments share a kind, so packages and paths keep their structure.
+type obfuscator struct {
+	mapping   obfuscationMapping
+	kinds     map[string]map[string]string
+	fullNames map[string]string
+	files     map[string]string
+}
+
+// prefixes of the pseudonyms
+const (
+	pseudoNamespace = "n"
+	pseudoFile      = "p"
+	pseudoMessage   = "M"
+	pseudoField     = "f"
+	pseudoOneof     = "o"
+	pseudoEnum      = "E"
+	pseudoValue     = "V"
+	pseudoService   = "S"
+	pseudoMethod    = "R"
+)
+
+func newObfuscator() *obfuscator {
+	return &obfuscator{
+		mapping:   obfuscationMapping{Names: map[string]string{}},
+		kinds:     map[string]map[string]string{},
+		fullNames: map[string]string{},
+		files:     map[string]string{},
+	}
+}
+
+// name returns the pseudonym for a name of the kind.
+func (o *obfuscator) name(kind, name string) string {
+	names := o.kinds[kind]
+	if names == nil {
+		names = map[string]string{}
+		o.kinds[kind] = names
+	}
+	if p, ok := names[name]; ok {
+		return p
+	}
+	p := fmt.Sprintf("%s%d", kind, len(names)+1)
+	names[name] = p
+	o.mapping.Names[p] = name
+	return p
+}
+
+// derived records a pseudonym derived from another one, like map entry names.
+func (o *obfuscator) derived(pseudonym, name string) string {
+	o.mapping.Names[pseudonym] = name
+	return pseudonym
+}
+
+// path obfuscates the segments of a path or package separated by sep.
+func (o *obfuscator) path(p, sep string) string {
+	if p == "" {
+		return ""
+	}
+	segments := strings.Split(p, sep)
+	for i, s := range segments {
+		segments[i] = o.name(pseudoNamespace, s)
+	}
+	return strings.Join(segments, sep)
+}
+
+// goPackage obfuscates an import path with an optional package name.
+func (o *obfuscator) goPackage(gp string) string {
+	importPath, name, named := strings.Cut(gp, ";")
+	gp = o.path(importPath, "/")
+	if named {
+		gp += ";" + o.name(pseudoNamespace, name)
+	}
+	return gp
+}
+
+// languageOptions are cleared by obfuscation, they name packages and classes.
+func clearLanguageOptions(opts *descriptorpb.FileOptions) {
+	opts.JavaPackage, opts.JavaOuterClassname = nil, nil
+	opts.CsharpNamespace, opts.ObjcClassPrefix, opts.SwiftPrefix = nil, nil, nil
+	opts.PhpClassPrefix, opts.PhpNamespace, opts.PhpMetadataNamespace = nil, nil, nil
+	opts.RubyPackage = nil
+}
+
+// obfuscate replaces the names of declarations, packages and paths of the files in scope
+// with pseudonyms and removes their comments. References in all files are updated
+// and options are resolved again so their extensions are named by pseudonyms, too.
+func obfuscate(req *pluginpb.CodeGeneratorRequest, inScope func(*descriptorpb.FileDescriptorProto) bool) (*obfuscationMapping, error) {
+	o := newObfuscator()
+	for _, fd := range req.ProtoFile {
+		if !inScope(fd) {
+			continue
+		}
+		dir, base := path.Split(fd.GetName())
+		name := o.path(strings.TrimSuffix(dir, "/"), "/")
+		if name != "" {
+			name += "/"
+		}
+		name += o.name(pseudoFile, strings.TrimSuffix(base, ".proto")) + ".proto"
+		o.files[fd.GetName()] = name
+		fd.Name = &name
+
+		oldPrefix := filePrefix(fd)
+		if fd.Package != nil {
+			pkg := o.path(fd.GetPackage(), ".")
+			fd.Package = &pkg
+		}
+		newPrefix := filePrefix(fd)
+		if opts := fd.Options; opts != nil {
+			if opts.GoPackage != nil {
+				gp := o.goPackage(opts.GetGoPackage())
+				opts.GoPackage = &gp
+			}
+			clearLanguageOptions(opts)
+		}
+		o.messages(oldPrefix, newPrefix, fd.MessageType, nil)
+		o.enums(oldPrefix, newPrefix, fd.EnumType)
+		o.fields(fd.Extension)
+		for _, sd := range fd.Service {
+			sd.Name = strPtr(o.name(pseudoService, sd.GetName()))
+			for _, m := range sd.Method {
+				m.Name = strPtr(o.name(pseudoMethod, m.GetName()))
+			}
+		}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			loc.LeadingComments, loc.TrailingComments, loc.LeadingDetachedComments = nil, nil, nil
+		}
+	}
+
+	ref := func(s *string) {
+		if s == nil {
+			return
+		}
+		if name, ok := o.fullNames[*s]; ok {
+			*s = name
+		}
+	}
+	for i, name := range req.FileToGenerate {
+		if renamed, ok := o.files[name]; ok {
+			req.FileToGenerate[i] = renamed
+		}
+	}
+	for _, fd := range req.ProtoFile {
+		for i, dep := range fd.Dependency {
+			if renamed, ok := o.files[dep]; ok {
+				fd.Dependency[i] = renamed
+			}
+		}
+		for _, f := range fd.Extension {
+			ref(f.TypeName)
+			ref(f.Extendee)
+		}
+		for _, sd := range fd.Service {
+			for _, m := range sd.Method {
+				ref(m.InputType)
+				ref(m.OutputType)
+			}
+		}
+		remapMessages(fd.MessageType, ref)
+	}
+	// group fields are named like their message in lower case
+	var groups func(mds []*descriptorpb.DescriptorProto)
+	groupFields := func(fds []*descriptorpb.FieldDescriptorProto) {
+		for _, f := range fds {
+			if f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_GROUP {
+				continue
+			}
+			if original, ok := o.mapping.Names[f.GetName()]; ok {
+				msg := f.GetTypeName()[strings.LastIndex(f.GetTypeName(), ".")+1:]
+				f.Name = strPtr(o.derived(strings.ToLower(msg), original))
+				if f.JsonName != nil {
+					f.JsonName = strPtr(jsonName(f.GetName()))
+				}
+			}
+		}
+	}
+	groups = func(mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			groupFields(md.Field)
+			groupFields(md.Extension)
+			groups(md.NestedType)
+		}
+	}
+	for _, fd := range req.ProtoFile {
+		groupFields(fd.Extension)
+		groups(fd.MessageType)
+	}
+	if err := resolveAgain(req); err != nil {
+		return nil, err
+	}
+	return &o.mapping, nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// messages renames mds, entries maps the type names of the fields of the parent to the original field names.
+func (o *obfuscator) messages(oldPrefix, newPrefix string, mds []*descriptorpb.DescriptorProto, entries map[string]string) {
+	for _, md := range mds {
+		oldName := oldPrefix + md.GetName()
+		if md.GetOptions().GetMapEntry() {
+			// map entries are named after their field
+			if field, ok := entries["."+oldName]; ok {
+				p := o.name(pseudoField, field)
+				md.Name = strPtr(o.derived(strings.ToUpper(p[:1])+p[1:]+"Entry", md.GetName()))
+			}
+		} else {
+			md.Name = strPtr(o.name(pseudoMessage, md.GetName()))
+		}
+		newName := newPrefix + md.GetName()
+		o.fullNames["."+oldName] = "." + newName
+		fields := map[string]string{}
+		for _, f := range md.Field {
+			fields[f.GetTypeName()] = f.GetName()
+		}
+		if !md.GetOptions().GetMapEntry() {
+			o.fields(md.Field)
+			for i, name := range md.ReservedName {
+				md.ReservedName[i] = o.name(pseudoField, name)
+			}
+		}
+		o.fields(md.Extension)
+		// synthetic oneofs of proto3 optional fields are named after their field
+		synthetic := map[int32]string{}
+		for _, f := range md.Field {
+			if f.GetProto3Optional() && f.OneofIndex != nil {
+				synthetic[f.GetOneofIndex()] = "_" + f.GetName()
+			}
+		}
+		for i, od := range md.OneofDecl {
+			if name, ok := synthetic[int32(i)]; ok {
+				od.Name = strPtr(o.derived(name, od.GetName()))
+			} else {
+				od.Name = strPtr(o.name(pseudoOneof, od.GetName()))
+			}
+		}
+		o.messages(oldName+".", newName+".", md.NestedType, fields)
+		o.enums(oldName+".", newName+".", md.EnumType)
+	}
+}
+
+// fields renames fields and the enum values of their defaults.
+func (o *obfuscator) fields(fds []*descriptorpb.FieldDescriptorProto) {
+	for _, f := range fds {
+		f.Name = strPtr(o.name(pseudoField, f.GetName()))
+		if f.JsonName != nil {
+			f.JsonName = strPtr(jsonName(f.GetName()))
+		}
+		if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_ENUM && f.DefaultValue != nil {
+			f.DefaultValue = strPtr(o.name(pseudoValue, f.GetDefaultValue()))
+		}
+	}
+}
+
+func (o *obfuscator) enums(oldPrefix, newPrefix string, eds []*descriptorpb.EnumDescriptorProto) {
+	for _, ed := range eds {
+		oldName := oldPrefix + ed.GetName()
+		ed.Name = strPtr(o.name(pseudoEnum, ed.GetName()))
+		o.fullNames["."+oldName] = "." + newPrefix + ed.GetName()
+		for _, v := range ed.Value {
+			v.Name = strPtr(o.name(pseudoValue, v.GetName()))
+		}
+		for i, name := range ed.ReservedName {
+			ed.ReservedName[i] = o.name(pseudoValue, name)
+		}
+	}
+}
+
+// mappingAEAD returns AES-GCM with the key derived from passphrase and salt.
+func mappingAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes with PBKDF2 and HMAC-SHA256 as in RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// writeMapping writes the mapping as json, encrypted with AES-GCM if passphrase is set.
+func writeMapping(name string, m *obfuscationMapping, passphrase string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if passphrase != "" {
+		// the header is authenticated with the data
+		header := []byte(fmt.Sprintf("%s%s %d\n", obfuscationMagic, mappingCipher, mappingIterations))
+		salt := make([]byte, mappingSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		header = append(header, salt...)
+		gcm, err := mappingAEAD(passphrase, salt, mappingIterations)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		data = gcm.Seal(append(append([]byte{}, header...), nonce...), nonce, data, header)
+	}
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		return fmt.Errorf("mapping could not be written: %v", err)
+	}
+	return nil
+}
+
+// readMapping reads a plain or encrypted mapping.
+func readMapping(name string, passphrase string) (*obfuscationMapping, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("mapping could not be read: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte(obfuscationMagic)) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is encrypted, a key is needed", name)
+		}
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 || len(data) < eol+1+mappingSaltSize {
+			return nil, fmt.Errorf("%s is truncated", name)
+		}
+		params := string(data[len(obfuscationMagic):eol])
+		i := strings.LastIndexByte(params, ' ')
+		iterations, err := strconv.Atoi(params[i+1:])
+		if i < 0 || params[:i] != mappingCipher || err != nil || iterations < 1 || iterations > maxMappingIterations {
+			return nil, fmt.Errorf("%s uses unsupported encryption %q", name, params)
+		}
+		header := data[:eol+1+mappingSaltSize]
+		data = data[len(header):]
+		gcm, err := mappingAEAD(passphrase, header[eol+1:], iterations)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < gcm.NonceSize() {
+			return nil, fmt.Errorf("%s is truncated", name)
+		}
+		if data, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], header); err != nil {
+			return nil, fmt.Errorf("%s could not be decrypted, wrong key?", name)
+		}
+	}
+	m := &obfuscationMapping{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: invalid mapping: %v", name, err)
+	}
+	return m, nil
+}
+
+var identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// deobfuscate replaces pseudonyms in text, also as parts of identifiers joined by _.
+func (m *obfuscationMapping) deobfuscate(text string) string {
+	return identifierPattern.ReplaceAllStringFunc(text, func(id string) string {
+		if name, ok := m.Names[id]; ok {
+			return name
+		}
+		parts := strings.Split(id, "_")
+		for i, p := range parts {
+			if name, ok := m.Names[p]; ok {
+				parts[i] = name
+			}
+		}
+		return strings.Join(parts, "_")
+	})
+}
+
+func runDeobfuscate(args []string) error {
+	var (
+		mapping = ""
+		keyEnv  = ""
+	)
+	fs := newFlagSet("deobfuscate")
+	fs.StringVar(&mapping, "mapping", mapping, "mapping file written by the obfuscate transformation")
+	fs.StringVar(&keyEnv, "key-env", keyEnv, "environment variable holding the key of an encrypted mapping")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture deobfuscate -mapping FILE [ARGUMENTS] < text\n\n"+
+			"Pseudonyms in text like plugin errors are replaced by the original names.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if mapping == "" {
+		fs.Usage()
+		return fmt.Errorf("deobfuscate needs -mapping")
+	}
+	passphrase := ""
+	if keyEnv != "" {
+		if passphrase = os.Getenv(keyEnv); passphrase == "" {
+			return fmt.Errorf("environment variable %s is not set", keyEnv)
+		}
+	}
+	m, err := readMapping(mapping, passphrase)
+	if err != nil {
+		return err
+	}
+	text, err := readStdin()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(os.Stdout, m.deobfuscate(string(text)))
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// checkGlobs validates patterns for matchGlob.
+func checkGlobs(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, part := range strings.Split(pattern, "/") {
+			if _, err := path.Match(part, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash separated name matches pattern.
+// ** matches any number of directories, patterns without / match the base name.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// selectFiles keeps the files of resp matching one of patterns
+// and returns the number of dropped files.
+func selectFiles(resp *pluginpb.CodeGeneratorResponse, patterns []string) int {
+	kept := resp.File[:0]
+	for _, f := range resp.File {
+		for _, pattern := range patterns {
+			if matchGlob(pattern, f.GetName()) {
+				kept = append(kept, f)
+				break
+			}
+		}
+	}
+	dropped := len(resp.File) - len(kept)
+	resp.File = kept
+	return dropped
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// schema is a JSON Schema as used by OpenAPI, describing protojson encoding.
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// wellKnownSchemas are the json mappings of well-known types.
+var wellKnownSchemas = map[string]*schema{
+	"google.protobuf.Timestamp":   {Type: "string", Format: "date-time"},
+	"google.protobuf.Duration":    {Type: "string"},
+	"google.protobuf.FieldMask":   {Type: "string"},
+	"google.protobuf.Any":         {Type: "object"},
+	"google.protobuf.Struct":      {Type: "object"},
+	"google.protobuf.Empty":       {Type: "object"},
+	"google.protobuf.Value":       {},
+	"google.protobuf.ListValue":   {Type: "array", Items: &schema{}},
+	"google.protobuf.BoolValue":   {Type: "boolean"},
+	"google.protobuf.StringValue": {Type: "string"},
+	"google.protobuf.BytesValue":  {Type: "string", Format: "byte"},
+	"google.protobuf.DoubleValue": {Type: "number", Format: "double"},
+	"google.protobuf.FloatValue":  {Type: "number", Format: "float"},
+	"google.protobuf.Int32Value":  {Type: "integer", Format: "int32"},
+	"google.protobuf.UInt32Value": {Type: "integer", Format: "uint32"},
+	"google.protobuf.Int64Value":  {Type: "string", Format: "int64"},
+	"google.protobuf.UInt64Value": {Type: "string", Format: "uint64"},
+}
+
+// schemaBuilder converts the messages of a request to schemas.
+type schemaBuilder struct {
+	refPrefix string
+	messages  map[string]*descriptorpb.DescriptorProto
+	enums     map[string]*descriptorpb.EnumDescriptorProto
+	comments  map[string]string
+	schemas   map[string]*schema
+}
+
+func newSchemaBuilder(req *pluginpb.CodeGeneratorRequest, refPrefix string) *schemaBuilder {
+	b := &schemaBuilder{
+		refPrefix: refPrefix,
+		messages:  map[string]*descriptorpb.DescriptorProto{},
+		enums:     map[string]*descriptorpb.EnumDescriptorProto{},
+		comments:  map[string]string{},
+		schemas:   map[string]*schema{},
+	}
+	for _, fd := range req.ProtoFile {
+		comments := map[string]string{}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			if c := strings.TrimSpace(loc.GetLeadingComments()); c != "" {
+				comments[fmt.Sprint(loc.Path)] = c
+			}
+		}
+		var walk func(prefix string, path []int32, mds []*descriptorpb.DescriptorProto)
+		walk = func(prefix string, path []int32, mds []*descriptorpb.DescriptorProto) {
+			for i, md := range mds {
+				name := prefix + md.GetName()
+				mpath := append(append([]int32{}, path...), int32(i))
+				b.messages[name] = md
+				b.comments[name] = comments[fmt.Sprint(mpath)]
+				for j, f := range md.Field {
+					b.comments[name+"."+f.GetName()] = comments[fmt.Sprint(append(append([]int32{}, mpath...), 2, int32(j)))]
+				}
+				for j, ed := range md.EnumType {
+					b.enums[name+"."+ed.GetName()] = ed
+					b.comments[name+"."+ed.GetName()] = comments[fmt.Sprint(append(append([]int32{}, mpath...), 4, int32(j)))]
+				}
+				walk(name+".", append(mpath, 3), md.NestedType)
+			}
+		}
+		walk(filePrefix(fd), []int32{4}, fd.MessageType)
+		for i, ed := range fd.EnumType {
+			name := filePrefix(fd) + ed.GetName()
+			b.enums[name] = ed
+			b.comments[name] = comments[fmt.Sprint([]int32{5, int32(i)})]
+		}
+	}
+	return b
+}
+
+// add adds the schema of the message or enum name and all types it references.
+func (b *schemaBuilder) add(name string) {
+	if _, ok := b.schemas[name]; ok || wellKnownSchemas[name] != nil {
+		return
+	}
+	if ed := b.enums[name]; ed != nil {
+		s := &schema{Type: "string", Description: b.comments[name]}
+		for _, v := range ed.Value {
+			s.Enum = append(s.Enum, v.GetName())
+		}
+		b.schemas[name] = s
+		return
+	}
+	md := b.messages[name]
+	if md == nil {
+		// not in the request, keep the reference resolvable
+		b.schemas[name] = &schema{Type: "object"}
+		return
+	}
+	s := &schema{Type: "object", Description: b.comments[name], Properties: map[string]*schema{}}
+	b.schemas[name] = s
+	for _, f := range md.Field {
+		fs := b.field(f)
+		fs.Description = b.comments[name+"."+f.GetName()]
+		s.Properties[f.GetJsonName()] = fs
+		if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED {
+			s.Required = append(s.Required, f.GetJsonName())
+		}
+	}
+}
+
+func (b *schemaBuilder) ref(typeName string) *schema {
+	name := strings.TrimPrefix(typeName, ".")
+	if wk := wellKnownSchemas[name]; wk != nil {
+		s := *wk
+		return &s
+	}
+	b.add(name)
+	return &schema{Ref: b.refPrefix + name}
+}
+
+func (b *schemaBuilder) field(f *descriptorpb.FieldDescriptorProto) *schema {
+	var s *schema
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		if md := b.messages[strings.TrimPrefix(f.GetTypeName(), ".")]; md.GetOptions().GetMapEntry() {
+			return &schema{Type: "object", AdditionalProperties: b.field(md.Field[1])}
+		}
+		s = b.ref(f.GetTypeName())
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		s = b.ref(f.GetTypeName())
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		s = &schema{Type: "boolean"}
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		s = &schema{Type: "string"}
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		s = &schema{Type: "string", Format: "byte"}
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		s = &schema{Type: "number", Format: "double"}
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		s = &schema{Type: "number", Format: "float"}
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		s = &schema{Type: "integer", Format: "int32"}
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		s = &schema{Type: "integer", Format: "uint32"}
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		// protojson encodes 64 bit integers as strings
+		s = &schema{Type: "string", Format: "int64"}
+	default:
+		s = &schema{Type: "string", Format: "uint64"}
+	}
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return &schema{Type: "array", Items: s}
+	}
+	return s
+}
+
+// requestSchemas converts all messages declared in the files to generate and the types they use.
+func requestSchemas(req *pluginpb.CodeGeneratorRequest, refPrefix string) map[string]*schema {
+	b := newSchemaBuilder(req, refPrefix)
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range req.ProtoFile {
+		files[fd.GetName()] = fd
+	}
+	for _, name := range req.FileToGenerate {
+		if fd := files[name]; fd != nil {
+			for _, m := range templateMessages(fd) {
+				b.add(m.FullName)
+			}
+			for _, e := range templateEnums(fd) {
+				b.add(e.FullName)
+			}
+		}
+	}
+	return b.schemas
+}
+
+// openAPIDocument is an OpenAPI document with only the components section filled.
+func openAPIDocument(req *pluginpb.CodeGeneratorRequest) ([]byte, error) {
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   strings.Join(req.FileToGenerate, ", "),
+			"version": "0.0.0",
+		},
+		"paths": map[string]interface{}{},
+		"components": map[string]interface{}{
+			"schemas": requestSchemas(req, "#/components/schemas/"),
+		},
+	}
+	return marshalSchema(doc)
+}
+
+// jsonSchemaDocument is a JSON Schema with the messages as definitions.
+func jsonSchemaDocument(req *pluginpb.CodeGeneratorRequest) ([]byte, error) {
+	doc := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$defs":   requestSchemas(req, "#/$defs/"),
+	}
+	return marshalSchema(doc)
+}
+
+func marshalSchema(doc interface{}) ([]byte, error) {
+	out, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("schema could not be encoded: %v", err)
+	}
+	return append(out, '\n'), nil
+}
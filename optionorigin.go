@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("options", "list the options set in a request and the file and extension declaring each", runOptions)
+}
+
+// optionOrigin is an option field set on an element and where the field is declared.
+type optionOrigin struct {
+	File    string `json:"file"`
+	Kind    string `json:"kind"`
+	Element string `json:"element"`
+	Options string `json:"options"`
+	Number  int32  `json:"number"`
+	// Field is the full name of the extension or the name of a field of Options,
+	// empty if the extension is unresolved
+	Field     string `json:"field,omitempty"`
+	Extension bool   `json:"extension"`
+	// Scope is the message an extension is declared in
+	Scope      string `json:"scope,omitempty"`
+	Type       string `json:"type,omitempty"`
+	DeclaredIn string `json:"declared_in,omitempty"`
+	// Source is request, extra-descriptors or builtin for options of descriptor.proto
+	Source string `json:"source,omitempty"`
+	// AlsoDeclaredIn are other files declaring the same number for Options,
+	// only one of them can be used
+	AlsoDeclaredIn []string `json:"also_declared_in,omitempty"`
+	// Candidates are files known to declare an unresolved extension
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+func runOptions(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		all     = false
+		builtin = false
+		extra   stringsFlag
+	)
+	fs := newFlagSet("options")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	fs.BoolVar(&builtin, "builtin", builtin, "also list the options declared in google/protobuf/descriptor.proto")
+	fs.Var(&extra, "extra-descriptors", "resolve extensions with this binary FileDescriptorSet, repeatable")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture options [ARGUMENTS] < REQUEST\n\n"+
+			"Each option set on a file, message, field, enum, enum value, service, method or\n"+
+			"oneof is listed with the extension it is resolved to, the file declaring it and\n"+
+			"other files declaring the same number. Unresolved extensions list the files\n"+
+			"known to declare them, see also the unresolved command.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if err := loadExtraDescriptors(extra); err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodePartialRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	origins := optionOrigins(req, all, builtin)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(origins)
+	}
+	return writeOptionsTable(os.Stdout, origins)
+}
+
+// optionOrigins lists the option fields set in the files to generate or all files of req.
+// Declarations are searched in the request and the -extra-descriptors.
+func optionOrigins(req *pluginpb.CodeGeneratorRequest, all, builtin bool) []optionOrigin {
+	type key struct {
+		options string
+		number  int32
+	}
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	inRequest := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		inRequest[fd.GetName()] = true
+	}
+	declared := map[key][]string{}
+	for _, fd := range withExtraFiles(req.ProtoFile) {
+		walkExtensions(fd, func(extendee string, number int32) {
+			k := key{strings.TrimPrefix(extendee, "."), number}
+			declared[k] = append(declared[k], fd.GetName())
+		})
+	}
+
+	origins := []optionOrigin{}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		walkFileOptions(fd, func(kind, name string, opts protoreflect.Message) {
+			base := optionOrigin{File: fd.GetName(), Kind: kind, Element: name, Options: string(opts.Descriptor().FullName())}
+			var found []optionOrigin
+			defer func() {
+				sort.SliceStable(found, func(i, j int) bool {
+					return found[i].Number < found[j].Number
+				})
+				origins = append(origins, found...)
+			}()
+			opts.Range(func(f protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+				o := base
+				o.Number = int32(f.Number())
+				o.Extension = f.IsExtension()
+				o.DeclaredIn = f.ParentFile().Path()
+				switch {
+				case !f.IsExtension():
+					if !builtin {
+						return true
+					}
+					o.Field = string(f.Name())
+					o.Source = "builtin"
+				case inRequest[o.DeclaredIn]:
+					o.Source = "request"
+				default:
+					o.Source = "extra-descriptors"
+				}
+				if f.IsExtension() {
+					o.Field = string(f.FullName())
+					if scope, ok := f.Parent().(protoreflect.MessageDescriptor); ok {
+						o.Scope = string(scope.FullName())
+					}
+				}
+				switch {
+				case f.Message() != nil:
+					o.Type = string(f.Message().FullName())
+				case f.Enum() != nil:
+					o.Type = string(f.Enum().FullName())
+				default:
+					o.Type = f.Kind().String()
+				}
+				for _, file := range declared[key{o.Options, o.Number}] {
+					if file != o.DeclaredIn {
+						o.AlsoDeclaredIn = append(o.AlsoDeclaredIn, file)
+					}
+				}
+				found = append(found, o)
+				return true
+			})
+			seen := map[int32]bool{}
+			for b := opts.GetUnknown(); len(b) > 0; {
+				num, typ, n := protowire.ConsumeTag(b)
+				if n < 0 {
+					return
+				}
+				m := protowire.ConsumeFieldValue(num, typ, b[n:])
+				if m < 0 {
+					return
+				}
+				b = b[n+m:]
+				if seen[int32(num)] {
+					// repeated and merged options are listed once
+					continue
+				}
+				seen[int32(num)] = true
+				o := base
+				o.Number = int32(num)
+				o.Extension = true
+				o.Candidates = declared[key{o.Options, o.Number}]
+				for _, ke := range knownExtensions {
+					if ke.extendee == o.Options && ke.lo <= o.Number && o.Number <= ke.hi {
+						o.Candidates = append(o.Candidates, ke.file)
+					}
+				}
+				found = append(found, o)
+			}
+		})
+	}
+	return origins
+}
+
+func writeOptionsTable(w io.Writer, origins []optionOrigin) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tELEMENT\tNUMBER\tOPTION\tTYPE\tDECLARED IN\tSOURCE\tALSO DECLARED IN\n")
+	for _, o := range origins {
+		field, declared := o.Field, o.DeclaredIn
+		if field == "" {
+			field = "? unresolved"
+			declared = strings.Join(o.Candidates, ",")
+			if declared == "" {
+				declared = "?"
+			}
+		} else if o.Extension {
+			field = "(" + field + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s %s\t%d\t%s\t%s\t%s\t%s\t%s\n", o.File, o.Kind, o.Element, o.Number,
+			field, o.Type, declared, o.Source, strings.Join(o.AlsoDeclaredIn, ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// optionsVisitor is called with the kind and full name of an element and its options.
+type optionsVisitor func(kind, name string, opts protoreflect.Message)
+
+// walkOptions calls fn for the options of all elements in all files of req.
+// Elements without options are skipped.
+func walkOptions(req *pluginpb.CodeGeneratorRequest, fn optionsVisitor) {
+	for _, fd := range req.ProtoFile {
+		walkFileOptions(fd, fn)
+	}
+}
+
+func walkFileOptions(fd *descriptorpb.FileDescriptorProto, fn optionsVisitor) {
+	visit := func(kind, name string, opts proto.Message) {
+		if m := opts.ProtoReflect(); m.IsValid() {
+			fn(kind, name, m)
+		}
+	}
+	prefix := fd.GetPackage()
+	if prefix != "" {
+		prefix += "."
+	}
+	visit("file", fd.GetName(), fd.Options)
+	for _, f := range fd.Extension {
+		visit("extension", prefix+f.GetName(), f.Options)
+	}
+	for _, ed := range fd.EnumType {
+		walkEnumOptions(ed, prefix, visit)
+	}
+	for _, sd := range fd.Service {
+		name := prefix + sd.GetName()
+		visit("service", name, sd.Options)
+		for _, m := range sd.Method {
+			visit("method", name+"."+m.GetName(), m.Options)
+		}
+	}
+	for _, md := range fd.MessageType {
+		walkMessageOptions(md, prefix, visit)
+	}
+}
+
+func walkEnumOptions(ed *descriptorpb.EnumDescriptorProto, prefix string, visit func(kind, name string, opts proto.Message)) {
+	name := prefix + ed.GetName()
+	visit("enum", name, ed.Options)
+	for _, v := range ed.Value {
+		// enum values are siblings of their enum
+		visit("enum value", prefix+v.GetName(), v.Options)
+	}
+}
+
+func walkMessageOptions(md *descriptorpb.DescriptorProto, prefix string, visit func(kind, name string, opts proto.Message)) {
+	name := prefix + md.GetName()
+	visit("message", name, md.Options)
+	for _, f := range md.Field {
+		visit("field", name+"."+f.GetName(), f.Options)
+	}
+	for _, o := range md.OneofDecl {
+		visit("oneof", name+"."+o.GetName(), o.Options)
+	}
+	for _, r := range md.ExtensionRange {
+		visit("extension range", name, r.Options)
+	}
+	for _, f := range md.Extension {
+		visit("extension", name+"."+f.GetName(), f.Options)
+	}
+	for _, ed := range md.EnumType {
+		walkEnumOptions(ed, name+".", visit)
+	}
+	for _, nested := range md.NestedType {
+		walkMessageOptions(nested, name+".", visit)
+	}
+}
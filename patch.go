@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// patchEdit is one edit of -patch, like a JSON Patch operation.
+// Path is a JSON Pointer like /proto_file/0/options/go_package or a field path
+// like proto_file[name="foo.proto"].options.go_package, [-] and /- append to lists.
+type patchEdit struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+
+	steps []patchStep
+}
+
+// patchStep is a field name, a list index or a list element selected by a field value.
+type patchStep struct {
+	name     string
+	index    int
+	key, val string
+}
+
+// appendIndex addresses the end of a list.
+const appendIndex = -1
+
+func (s patchStep) String() string {
+	switch {
+	case s.name != "":
+		return s.name
+	case s.key != "":
+		return fmt.Sprintf("[%s=%q]", s.key, s.val)
+	case s.index == appendIndex:
+		return "[-]"
+	}
+	return fmt.Sprintf("[%d]", s.index)
+}
+
+type patchEdits []patchEdit
+
+// loadPatch reads a json array of edits.
+func loadPatch(name string) (patchEdits, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var edits patchEdits
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&edits); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	for i := range edits {
+		e := &edits[i]
+		switch e.Op {
+		case "add", "replace", "test":
+			if e.Value == nil {
+				return nil, fmt.Errorf("%s: edit %d: %s needs a value", name, i+1, e.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%s: edit %d: unknown op %q, want add, replace, remove or test", name, i+1, e.Op)
+		}
+		if e.steps, err = parsePatchPath(e.Path); err != nil {
+			return nil, fmt.Errorf("%s: edit %d: %v", name, i+1, err)
+		}
+	}
+	return edits, nil
+}
+
+// parsePatchPath parses a JSON Pointer or a field path.
+func parsePatchPath(p string) ([]patchStep, error) {
+	var steps []patchStep
+	if strings.HasPrefix(p, "/") {
+		for _, token := range strings.Split(p[1:], "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			if n, err := strconv.Atoi(token); err == nil && n >= 0 {
+				steps = append(steps, patchStep{index: n})
+			} else if token == "-" {
+				steps = append(steps, patchStep{index: appendIndex})
+			} else {
+				steps = append(steps, patchStep{name: token})
+			}
+		}
+	} else {
+		for rest := p; rest != ""; {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q, want a field name at %q", p, rest)
+			}
+			steps = append(steps, patchStep{name: rest[:end]})
+			rest = rest[end:]
+			for strings.HasPrefix(rest, "[") {
+				inner, after, ok := cutSelector(rest[1:])
+				if !ok {
+					return nil, fmt.Errorf("invalid path %q, unterminated [ at %q", p, rest)
+				}
+				step, err := parseSelector(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: %v", p, err)
+				}
+				steps = append(steps, step)
+				rest = after
+			}
+			if strings.HasPrefix(rest, ".") {
+				if rest = rest[1:]; rest == "" {
+					return nil, fmt.Errorf("invalid path %q, it ends with .", p)
+				}
+			} else if rest != "" {
+				return nil, fmt.Errorf("invalid path %q, want . or [ at %q", p, rest)
+			}
+		}
+	}
+	if len(steps) == 0 || steps[0].name == "" {
+		return nil, fmt.Errorf("invalid path %q, want a field name first", p)
+	}
+	return steps, nil
+}
+
+// cutSelector splits s at the ] ending a selector, skipping quoted values.
+func cutSelector(s string) (inner, after string, ok bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ']':
+			return s[:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// parseSelector parses N, - or field=value with a quoted or plain value.
+func parseSelector(s string) (patchStep, error) {
+	if s == "-" {
+		return patchStep{index: appendIndex}, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return patchStep{index: n}, nil
+	}
+	key, val, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return patchStep{}, fmt.Errorf("want [N], [-] or [field=value], got [%s]", s)
+	}
+	if strings.HasPrefix(val, `"`) {
+		var err error
+		if val, err = strconv.Unquote(val); err != nil {
+			return patchStep{}, fmt.Errorf("invalid quoted value in [%s]", s)
+		}
+	}
+	return patchStep{key: key, val: val}, nil
+}
+
+// apply edits msg in place, unknown fields are kept.
+func (pe patchEdits) apply(msg proto.Message) error {
+	for i := range pe {
+		if err := pe[i].apply(msg.ProtoReflect()); err != nil {
+			return fmt.Errorf("patch edit %d %s %s: %v", i+1, pe[i].Op, pe[i].Path, err)
+		}
+	}
+	return nil
+}
+
+func (e *patchEdit) apply(m protoreflect.Message) error {
+	create := e.Op == "add"
+	steps := e.steps
+	for {
+		fd := patchField(m, steps[0].name)
+		if fd == nil {
+			return fmt.Errorf("%s has no field %s", m.Descriptor().FullName(), steps[0].name)
+		}
+		if fd.IsMap() {
+			return fmt.Errorf("map field %s is not supported", fd.Name())
+		}
+		steps = steps[1:]
+		if len(steps) == 0 {
+			return e.applyField(m, fd)
+		}
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			i, err := listIndex(fd, list, steps[0])
+			if err != nil {
+				return err
+			}
+			if steps = steps[1:]; len(steps) == 0 {
+				return e.applyElement(m, fd, i)
+			}
+			if fd.Message() == nil {
+				return fmt.Errorf("%s has no fields", fd.Name())
+			}
+			if i == list.Len() {
+				return fmt.Errorf("%s has no element %d", fd.Name(), i)
+			}
+			m = list.Get(i).Message()
+			continue
+		}
+		if fd.Message() == nil {
+			return fmt.Errorf("%s has no fields", fd.Name())
+		}
+		if !m.Has(fd) && !create {
+			return fmt.Errorf("%s is not set", fd.Name())
+		}
+		m = m.Mutable(fd).Message()
+	}
+}
+
+// patchField looks up a field by proto or json name.
+func patchField(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+	fields := m.Descriptor().Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// listIndex resolves an index or selector step in list.
+func listIndex(fd protoreflect.FieldDescriptor, list protoreflect.List, s patchStep) (int, error) {
+	switch {
+	case s.name != "":
+		return 0, fmt.Errorf("%s is a list, want an index or selector before %s", fd.Name(), s.name)
+	case s.key != "":
+		for i := 0; i < list.Len(); i++ {
+			m, ok := list.Get(i).Interface().(protoreflect.Message)
+			if !ok {
+				return 0, fmt.Errorf("%s has no fields to select %s", fd.Name(), s)
+			}
+			key := patchField(m, s.key)
+			if key == nil || key.IsList() || key.Message() != nil {
+				return 0, fmt.Errorf("%s elements have no scalar field %s", fd.Name(), s.key)
+			}
+			if patchText(m.Get(key), key) == s.val {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%s has no element %s", fd.Name(), s)
+	case s.index == appendIndex:
+		return list.Len(), nil
+	case s.index >= list.Len():
+		return 0, fmt.Errorf("%s has no element %s, it has %d", fd.Name(), s, list.Len())
+	}
+	return s.index, nil
+}
+
+// patchText formats a scalar for selectors, enums by name.
+func patchText(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+	if fd.Enum() != nil {
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprint(v.Enum())
+	}
+	if b, ok := v.Interface().([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// patchHolder returns a new message like m with field fd set from the json value.
+// With elem, value is a single element of the list fd.
+func (e *patchEdit) patchHolder(m protoreflect.Message, fd protoreflect.FieldDescriptor, elem bool) (protoreflect.Message, error) {
+	value := []byte(e.Value)
+	if elem {
+		value = append(append([]byte("["), value...), ']')
+	}
+	doc, err := json.Marshal(map[string]json.RawMessage{string(fd.Name()): value})
+	if err != nil {
+		return nil, err
+	}
+	holder := m.New()
+	if err := protojson.Unmarshal(doc, holder.Interface()); err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err)
+	}
+	return holder, nil
+}
+
+func (e *patchEdit) applyField(m protoreflect.Message, fd protoreflect.FieldDescriptor) error {
+	switch e.Op {
+	case "remove":
+		if !m.Has(fd) {
+			return fmt.Errorf("%s is not set", fd.Name())
+		}
+		m.Clear(fd)
+		return nil
+	case "replace":
+		if !m.Has(fd) {
+			return fmt.Errorf("%s is not set", fd.Name())
+		}
+	}
+	holder, err := e.patchHolder(m, fd, false)
+	if err != nil {
+		return err
+	}
+	if e.Op == "test" {
+		current := m.New()
+		if m.Has(fd) {
+			current.Set(fd, m.Get(fd))
+		}
+		if !proto.Equal(current.Interface(), holder.Interface()) {
+			return fmt.Errorf("test failed")
+		}
+		return nil
+	}
+	m.Set(fd, holder.Get(fd))
+	return nil
+}
+
+func (e *patchEdit) applyElement(m protoreflect.Message, fd protoreflect.FieldDescriptor, i int) error {
+	list := m.Mutable(fd).List()
+	if i == list.Len() && e.Op != "add" {
+		return fmt.Errorf("%s has no element %d", fd.Name(), i)
+	}
+	if e.Op == "remove" {
+		for j := i + 1; j < list.Len(); j++ {
+			list.Set(j-1, list.Get(j))
+		}
+		list.Truncate(list.Len() - 1)
+		return nil
+	}
+	holder, err := e.patchHolder(m, fd, true)
+	if err != nil {
+		return err
+	}
+	v := holder.Get(fd).List().Get(0)
+	switch e.Op {
+	case "test":
+		current := m.New()
+		current.Mutable(fd).List().Append(list.Get(i))
+		if !proto.Equal(current.Interface(), holder.Interface()) {
+			return fmt.Errorf("test failed")
+		}
+	case "replace":
+		list.Set(i, v)
+	default:
+		// add inserts before i
+		list.Append(v)
+		for j := list.Len() - 1; j > i; j-- {
+			list.Set(j, list.Get(j-1))
+		}
+		list.Set(i, v)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("presence", "report proto3 fields without presence treated as nullable and fields with default values", runPresence)
+}
+
+// nullableComment matches comments of fields consumers treat as nullable.
+const nullableComment = `(?i)\b(optional|nullable|null|unset|may be (empty|unset|omitted|missing)|if (set|present|unset|not set|missing))\b`
+
+// fieldBehaviorNumber is the number of the google.api.field_behavior option.
+const fieldBehaviorNumber = 1052
+
+// fieldBehaviorOptional is the OPTIONAL value of google.api.FieldBehavior.
+const fieldBehaviorOptional = 1
+
+// presenceFinding is a field whose presence or default value needs attention.
+type presenceFinding struct {
+	File  string `json:"file"`
+	Field string `json:"field"`
+	Type  string `json:"type"`
+	// Finding is implicit for proto3 fields without presence, default for fields with a default value
+	Finding string `json:"finding"`
+	// Reasons are the hints the field is treated as nullable
+	Reasons []string `json:"reasons,omitempty"`
+	Default string   `json:"default,omitempty"`
+}
+
+func runPresence(args []string) error {
+	var (
+		jsonIn   = false
+		jsonOut  = false
+		all      = false
+		implicit = false
+		comment  = nullableComment
+		options  stringsFlag
+	)
+	fs := newFlagSet("presence")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	fs.BoolVar(&implicit, "all-implicit", implicit, "list all proto3 fields without presence, not only the ones treated as nullable")
+	fs.StringVar(&comment, "comment-pattern", comment, "regular expression matching comments of fields treated as nullable, empty to ignore comments")
+	fs.Var(&options, "nullable-option", "treat fields as nullable if this option is set and not false or zero, matching its full name or declaring file pattern or field number, repeatable")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture presence [ARGUMENTS] < request\n\n"+
+			"Fields of proto3 files without presence, which are not optional, in a oneof, messages\n"+
+			"or repeated, can not tell an unset value from the zero value. They are reported if\n"+
+			"they are treated as nullable: their comment matches -comment-pattern, they have\n"+
+			"(google.api.field_behavior) = OPTIONAL or a -nullable-option, or a bool field has_NAME\n"+
+			"tracks their presence. Fields with default values are reported with the default.\n\n"+
+			"Arguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	var re *regexp.Regexp
+	if comment != "" {
+		var err error
+		if re, err = regexp.Compile(comment); err != nil {
+			return fmt.Errorf("-comment-pattern: %v", err)
+		}
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	findings := presenceFindings(req, all, implicit, re, optionMatcher(options))
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(findings)
+	}
+	return writePresenceTable(os.Stdout, findings)
+}
+
+func presenceFindings(req *pluginpb.CodeGeneratorRequest, all, implicit bool, comment *regexp.Regexp, om optionMatcher) []presenceFinding {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	findings := []presenceFinding{}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		comments := map[string]string{}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			comments[fmt.Sprint(loc.Path)] = loc.GetLeadingComments() + "\n" + loc.GetTrailingComments()
+		}
+		proto3 := fd.GetSyntax() == "proto3"
+		var md *descriptorpb.DescriptorProto
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			if m, ok := desc.(*descriptorpb.DescriptorProto); ok {
+				md = m
+				return
+			}
+			f, ok := desc.(*descriptorpb.FieldDescriptorProto)
+			if !ok || kind != "field" || md.GetOptions().GetMapEntry() {
+				return
+			}
+			finding := presenceFinding{File: fd.GetName(), Field: name, Type: fieldTypeName(f)}
+			if f.DefaultValue != nil {
+				finding.Finding = "default"
+				finding.Default = f.GetDefaultValue()
+				findings = append(findings, finding)
+				return
+			}
+			if !proto3 || !hasImplicitPresence(f) {
+				return
+			}
+			if comment != nil && comment.MatchString(comments[fmt.Sprint(path)]) {
+				finding.Reasons = append(finding.Reasons, "comment")
+			}
+			if opts := f.GetOptions(); opts != nil {
+				finding.Reasons = append(finding.Reasons, nullableOptions(opts.ProtoReflect(), om)...)
+			}
+			for _, sibling := range md.Field {
+				if sibling.GetName() == "has_"+f.GetName() && sibling.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BOOL {
+					finding.Reasons = append(finding.Reasons, "has_"+f.GetName()+" field")
+				}
+			}
+			if len(finding.Reasons) > 0 || implicit {
+				finding.Finding = "implicit"
+				findings = append(findings, finding)
+			}
+		})
+	}
+	return findings
+}
+
+// hasImplicitPresence reports whether a field of a proto3 file can not tell unset from zero.
+func hasImplicitPresence(f *descriptorpb.FieldDescriptorProto) bool {
+	switch {
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED,
+		f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP,
+		// proto3 optional fields are in a synthetic oneof
+		f.OneofIndex != nil:
+		return false
+	}
+	return true
+}
+
+// nullableOptions returns the options of a field marking it as nullable:
+// field_behavior OPTIONAL and options matching om with a value other than false or zero.
+func nullableOptions(opts protoreflect.Message, om optionMatcher) []string {
+	var reasons []string
+	opts.Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if !f.IsExtension() {
+			return true
+		}
+		if f.Number() == fieldBehaviorNumber && f.Kind() == protoreflect.EnumKind && f.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				if v.List().Get(i).Enum() == fieldBehaviorOptional {
+					reasons = append(reasons, "("+string(f.FullName())+") = OPTIONAL")
+				}
+			}
+			return true
+		}
+		if om.matches(f) && optionSet(f, v) {
+			reasons = append(reasons, "("+string(f.FullName())+")")
+		}
+		return true
+	})
+	// the declaration of field_behavior is often missing, its values are varints
+	for b := opts.GetUnknown(); len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			break
+		}
+		value := b[n : n+m]
+		b = b[n+m:]
+		if num == fieldBehaviorNumber && fieldBehaviorHas(typ, value, fieldBehaviorOptional) {
+			reasons = append(reasons, fmt.Sprintf("(%d) = OPTIONAL", num))
+		} else if om.matchesNumber(num) {
+			reasons = append(reasons, fmt.Sprintf("(%d)", num))
+		}
+	}
+	return reasons
+}
+
+// fieldBehaviorHas reports whether the unknown field_behavior value, packed or not, contains want.
+func fieldBehaviorHas(typ protowire.Type, value []byte, want uint64) bool {
+	if typ == protowire.VarintType {
+		v, _ := protowire.ConsumeVarint(value)
+		return v == want
+	}
+	if typ != protowire.BytesType {
+		return false
+	}
+	packed, _ := protowire.ConsumeBytes(value)
+	for len(packed) > 0 {
+		v, n := protowire.ConsumeVarint(packed)
+		if n < 0 {
+			return false
+		}
+		if v == want {
+			return true
+		}
+		packed = packed[n:]
+	}
+	return false
+}
+
+// optionSet reports whether an option value is not false or zero.
+func optionSet(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+	if f.IsList() {
+		return v.List().Len() > 0
+	}
+	switch f.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.MessageKind, protoreflect.GroupKind, protoreflect.StringKind, protoreflect.BytesKind:
+		return true
+	case protoreflect.EnumKind:
+		return v.Enum() != 0
+	}
+	return v.Interface() != f.Default().Interface()
+}
+
+// fieldTypeName is the type of a field as written in a proto file.
+func fieldTypeName(f *descriptorpb.FieldDescriptorProto) string {
+	if f.GetTypeName() != "" {
+		return strings.TrimPrefix(f.GetTypeName(), ".")
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+}
+
+func writePresenceTable(w io.Writer, findings []presenceFinding) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tFIELD\tTYPE\tFINDING\tDETAIL\n")
+	for _, f := range findings {
+		detail := strings.Join(f.Reasons, ", ")
+		if f.Finding == "default" {
+			detail = "default " + f.Default
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", f.File, f.Field, f.Type, f.Finding, detail)
+	}
+	return tw.Flush()
+}
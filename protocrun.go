@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	register("run", "run protoc and capture the request of each plugin it calls", runProtoc)
+}
+
+// builtinGenerators are the --NAME_out targets protoc handles without a plugin.
+var builtinGenerators = map[string]bool{
+	"cpp": true, "csharp": true, "java": true, "kotlin": true, "objc": true, "php": true,
+	"pyi": true, "python": true, "ruby": true, "rust": true, "upb": true, "upbdefs": true,
+	"descriptor_set": true, "dependency": true, "capture": true,
+}
+
+// protocPlugins lists the plugins of the --NAME_out arguments of protoc in order.
+func protocPlugins(args []string) []string {
+	var plugins []string
+	seen := map[string]bool{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name := arg[2:]
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if !strings.HasSuffix(name, "_out") {
+			continue
+		}
+		name = strings.TrimSuffix(name, "_out")
+		if name == "" || builtinGenerators[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		plugins = append(plugins, name)
+	}
+	return plugins
+}
+
+// runDirEnv is set by run for the capture plugins it adds to protoc. They store their
+// capture in DIR/PLUGIN themselves, protoc writes no output at all if a plugin fails.
+const runDirEnv = "PROTOC_GEN_CAPTURE_RUN_DIR"
+
+// maxProcessStderr limits the stderr of protoc recorded in captures, its end is kept.
+const maxProcessStderr = 64 << 10
+
+// processResult is the outcome of a process a capture was made for.
+type processResult struct {
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exit_code"`
+	Stderr   string   `json:"stderr,omitempty"`
+	// StderrTruncated is set if only the end of stderr is kept
+	StderrTruncated bool `json:"stderr_truncated,omitempty"`
+}
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (tw *tailWriter) Write(p []byte) (int, error) {
+	tw.buf = append(tw.buf, p...)
+	if over := len(tw.buf) - tw.max; over > 0 {
+		tw.buf = append(tw.buf[:0:0], tw.buf[over:]...)
+		tw.truncated = true
+	}
+	return len(p), nil
+}
+
+// storeRunCapture writes the capture out of a capture plugin added by run
+// to the file of the plugin in the directory of run, if it is one.
+func storeRunCapture(plugin, file string, out []byte) error {
+	dir := os.Getenv(runDirEnv)
+	if dir == "" {
+		return nil
+	}
+	name := filepath.Join(dir, plugin, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("capture directory could not be created: %v", err)
+	}
+	if err := os.WriteFile(name, out, 0o644); err != nil {
+		return fmt.Errorf("capture could not be written: %v", err)
+	}
+	return nil
+}
+
+// recordProcess adds the result of protoc to the metadata of the capture in name.
+// Plain captures without metadata are left alone.
+func recordProcess(name string, result *processResult) error {
+	bin, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	meta, payload, err := unwrapContainer(bin)
+	if err != nil || meta == nil {
+		return err
+	}
+	meta.Process = result
+	if bin, err = wrapContainer(meta, payload); err != nil {
+		return err
+	}
+	return os.WriteFile(name, bin, 0o644)
+}
+
+func runProtoc(args []string) error {
+	var (
+		dir = "captures"
+	)
+	fs := newFlagSet("run")
+	fs.StringVar(&dir, "dir", dir, "store the request of plugin NAME in DIR/NAME/out.proto.msg")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture run [ARGUMENTS] -- protoc PROTOC_ARGUMENTS...\n\n"+
+			"protoc is run with a capture plugin added for each --NAME_out argument of a plugin.\n"+
+			"The plugins still run, the exit code is the one of protoc. The captures are kept\n"+
+			"if protoc fails and record its exit code and stderr in their metadata.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("run needs the protoc command")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("own executable could not be found: %v", err)
+	}
+
+	command := fs.Args()
+	plugins := protocPlugins(command[1:])
+	if len(plugins) == 0 {
+		log.Printf("warning: no plugin outputs found, nothing is captured\n")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// the capture plugins come first, protoc stops at the first failing plugin
+	argv := []string{command[0]}
+	for _, name := range plugins {
+		out := filepath.Join(dir, name)
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return fmt.Errorf("capture directory could not be created: %v", err)
+		}
+		// leftovers of an earlier run must not get the result of this one
+		os.Remove(filepath.Join(out, "out.proto.msg"))
+		argv = append(argv,
+			"--plugin=protoc-gen-capture_"+name+"="+self,
+			"--capture_"+name+"_out="+out,
+			"--capture_"+name+"_opt=capture_plugin="+name,
+		)
+	}
+	argv = append(argv, command[1:]...)
+	stderr := &tailWriter{max: maxProcessStderr}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), runDirEnv+"="+abs)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	runErr := cmd.Run()
+	if runErr != nil {
+		if _, ok := runErr.(*exec.ExitError); !ok {
+			return fmt.Errorf("%s could not be run: %v", argv[0], runErr)
+		}
+	}
+	result := &processResult{
+		Command:         command,
+		ExitCode:        cmd.ProcessState.ExitCode(),
+		Stderr:          string(stderr.buf),
+		StderrTruncated: stderr.truncated,
+	}
+	for _, name := range plugins {
+		file := filepath.Join(dir, name, "out.proto.msg")
+		if err := recordProcess(file, result); err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("warning: result of %s could not be recorded in %s: %v\n", argv[0], file, err)
+			}
+			continue
+		}
+		logEvent(logInfo, "capture", "plugin", name, "file", file, "exit_code", result.ExitCode)
+	}
+	if runErr != nil {
+		return &exitError{code: result.ExitCode, err: fmt.Errorf("%s failed: %v", argv[0], runErr)}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokSymbol
+)
+
+// protoToken is a token of a .proto file with the comments before and after it.
+// Lines and columns are zero based like in source code info.
+type protoToken struct {
+	kind tokenKind
+	// text is the source of the token, the decoded value for strings
+	text              string
+	line, col         int
+	endLine, endCol   int
+	leading, trailing string
+	detached          []string
+}
+
+func (t protoToken) String() string {
+	if t.kind == tokEOF {
+		return "end of file"
+	}
+	return strconv.Quote(t.text)
+}
+
+// commentBlock are consecutive line comments or a block comment.
+type commentBlock struct {
+	text               string
+	startLine, endLine int
+	lineComment        bool
+}
+
+// lexProto splits src into tokens and attaches comments like protoc:
+// a comment on the line of a token is its trailing comment, the block
+// directly before a token is its leading comment and earlier ones are detached.
+func lexProto(name, src string) ([]protoToken, error) {
+	var toks []protoToken
+	var blocks []commentBlock
+	line, col := 0, 0
+	i := 0
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d:%d: %s", name, line+1, col+1, fmt.Sprintf(format, args...))
+	}
+	advance := func(n int) {
+		for _, r := range src[i : i+n] {
+			if r == '\n' {
+				line++
+				col = 0
+			} else {
+				col++
+			}
+		}
+		i += n
+	}
+	for {
+		// whitespace and comments
+		for i < len(src) {
+			c := src[i]
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v' {
+				advance(1)
+				continue
+			}
+			if strings.HasPrefix(src[i:], "//") {
+				end := strings.IndexByte(src[i:], '\n')
+				if end < 0 {
+					end = len(src) - i
+				}
+				text := src[i+2:i+end] + "\n"
+				startLine := line
+				if n := len(toks); n > 0 && toks[n-1].endLine == line && len(blocks) == 0 {
+					toks[n-1].trailing += text
+				} else if b := len(blocks); b > 0 && blocks[b-1].lineComment && blocks[b-1].endLine == line-1 {
+					blocks[b-1].text += text
+					blocks[b-1].endLine = line
+				} else {
+					blocks = append(blocks, commentBlock{text: text, startLine: startLine, endLine: line, lineComment: true})
+				}
+				advance(end)
+				continue
+			}
+			if strings.HasPrefix(src[i:], "/*") {
+				end := strings.Index(src[i+2:], "*/")
+				if end < 0 {
+					return nil, errorf("unterminated block comment")
+				}
+				text := blockCommentText(src[i+2 : i+2+end])
+				startLine := line
+				advance(end + 4)
+				if n := len(toks); n > 0 && toks[n-1].endLine == startLine && len(blocks) == 0 {
+					toks[n-1].trailing += text
+				} else {
+					blocks = append(blocks, commentBlock{text: text, startLine: startLine, endLine: line})
+				}
+				continue
+			}
+			break
+		}
+		tok := protoToken{line: line, col: col}
+		if n := len(blocks); n > 0 {
+			last := blocks[n-1]
+			if last.endLine >= line-1 {
+				tok.leading = last.text
+				blocks = blocks[:n-1]
+			}
+			for _, b := range blocks {
+				tok.detached = append(tok.detached, b.text)
+			}
+			blocks = nil
+		}
+		if i >= len(src) {
+			tok.kind = tokEOF
+			tok.endLine, tok.endCol = line, col
+			toks = append(toks, tok)
+			return toks, nil
+		}
+		c := src[i]
+		switch {
+		case isIdentStart(c):
+			n := 1
+			for i+n < len(src) && isIdentPart(src[i+n]) {
+				n++
+			}
+			tok.kind, tok.text = tokIdent, src[i:i+n]
+			advance(n)
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			n, float := scanNumber(src[i:])
+			tok.kind, tok.text = tokInt, src[i:i+n]
+			if float {
+				tok.kind = tokFloat
+			}
+			advance(n)
+		case c == '"' || c == '\'':
+			value, n, err := unquoteProto(src[i:])
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			tok.kind, tok.text = tokString, value
+			advance(n)
+		default:
+			_, n := utf8.DecodeRuneInString(src[i:])
+			tok.kind, tok.text = tokSymbol, src[i:i+n]
+			advance(n)
+		}
+		tok.endLine, tok.endCol = line, col
+		toks = append(toks, tok)
+	}
+}
+
+// blockCommentText strips the leading * of the lines of a block comment.
+func blockCommentText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if i == 0 {
+			continue
+		}
+		trimmed := strings.TrimLeft(l, " \t")
+		if strings.HasPrefix(trimmed, "*") {
+			lines[i] = trimmed[1:]
+		}
+	}
+	text := strings.Join(lines, "\n")
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scanNumber returns the length of the number at the start of s and whether it is a float.
+func scanNumber(s string) (int, bool) {
+	if len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		n := 2
+		for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+			n++
+		}
+		return n, false
+	}
+	n, float := 0, false
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	if n < len(s) && s[n] == '.' {
+		float = true
+		n++
+		for n < len(s) && isDigit(s[n]) {
+			n++
+		}
+	}
+	if n < len(s) && (s[n] == 'e' || s[n] == 'E') {
+		m := n + 1
+		if m < len(s) && (s[m] == '+' || s[m] == '-') {
+			m++
+		}
+		if m < len(s) && isDigit(s[m]) {
+			float = true
+			for n = m; n < len(s) && isDigit(s[n]); n++ {
+			}
+		}
+	}
+	return n, float
+}
+
+// parseProtoUint parses a decimal, hexadecimal or octal integer literal.
+func parseProtoUint(s string) (uint64, error) {
+	switch {
+	case len(s) > 2 && (s[1] == 'x' || s[1] == 'X'):
+		return strconv.ParseUint(s[2:], 16, 64)
+	case len(s) > 1 && s[0] == '0':
+		return strconv.ParseUint(s[1:], 8, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// unquoteProto decodes the string literal at the start of s with the escapes of protoc.
+// The value can hold arbitrary bytes for bytes fields.
+func unquoteProto(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); {
+		c := s[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("string literal contains a line break")
+		case c != '\\':
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		if i >= len(s) {
+			break
+		}
+		e := s[i]
+		i++
+		switch e {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(e)
+		case 'x', 'X':
+			n := 0
+			for n < 2 && i+n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[i+n]) >= 0 {
+				n++
+			}
+			if n == 0 {
+				return "", 0, fmt.Errorf("invalid hex escape in string literal")
+			}
+			v, _ := strconv.ParseUint(s[i:i+n], 16, 8)
+			sb.WriteByte(byte(v))
+			i += n
+		case 'u', 'U':
+			n := 4
+			if e == 'U' {
+				n = 8
+			}
+			if i+n > len(s) {
+				return "", 0, fmt.Errorf("invalid unicode escape in string literal")
+			}
+			v, err := strconv.ParseUint(s[i:i+n], 16, 32)
+			if err != nil || v > utf8.MaxRune {
+				return "", 0, fmt.Errorf("invalid unicode escape in string literal")
+			}
+			sb.WriteRune(rune(v))
+			i += n
+		default:
+			if e < '0' || e > '7' {
+				return "", 0, fmt.Errorf("invalid escape \\%c in string literal", e)
+			}
+			n := 1
+			for n < 3 && i-1+n < len(s) && s[i-1+n] >= '0' && s[i-1+n] <= '7' {
+				n++
+			}
+			v, _ := strconv.ParseUint(s[i-1:i-1+n], 8, 16)
+			if v > 255 {
+				return "", 0, fmt.Errorf("octal escape out of range in string literal")
+			}
+			sb.WriteByte(byte(v))
+			i += n - 1
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string literal")
+}
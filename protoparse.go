@@ -0,0 +1,952 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoOption is an option statement, interpreted after the types are resolved.
+type protoOption struct {
+	// options is the options message of the declaration
+	options proto.Message
+	name    []optionNamePart
+	value   protoConstant
+	// scope is the full name of the declaration the option is set in, for extension names
+	scope string
+	pos   protoToken
+}
+
+type optionNamePart struct {
+	name      string
+	extension bool
+}
+
+func (o *protoOption) String() string {
+	parts := make([]string, len(o.name))
+	for i, p := range o.name {
+		parts[i] = p.name
+		if p.extension {
+			parts[i] = "(" + p.name + ")"
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// protoConstant is the value of an option or a default.
+type protoConstant struct {
+	tok      protoToken
+	negative bool
+	// aggregate is the text format of a message value in braces
+	aggregate string
+}
+
+// protoDefault is the default value of a field, formatted once its type is resolved.
+type protoDefault struct {
+	field *descriptorpb.FieldDescriptorProto
+	value protoConstant
+}
+
+// protoParser builds the descriptor of a .proto file.
+type protoParser struct {
+	name     string
+	src      string
+	toks     []protoToken
+	pos      int
+	fd       *descriptorpb.FileDescriptorProto
+	options  []*protoOption
+	defaults []protoDefault
+	// scope is the relative full name of the enclosing message
+	scope []string
+	// maxRanges are the extension ranges up to max by their message,
+	// message sets allow larger numbers once their options are known
+	maxRanges map[*descriptorpb.DescriptorProto][]*descriptorpb.DescriptorProto_ExtensionRange
+}
+
+// protoSyntaxError aborts parsing, it is recovered by parseProto.
+type protoSyntaxError struct{ err error }
+
+// parseProto parses src into a file descriptor with uninterpreted options and unresolved type names.
+func parseProto(name, src string) (p *protoParser, err error) {
+	toks, err := lexProto(name, src)
+	if err != nil {
+		return nil, err
+	}
+	p = &protoParser{
+		name: name,
+		src:  src,
+		toks: toks,
+		fd: &descriptorpb.FileDescriptorProto{
+			Name:           proto.String(name),
+			SourceCodeInfo: &descriptorpb.SourceCodeInfo{},
+		},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(protoSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, se.err
+		}
+	}()
+	p.parseFile()
+	return p, nil
+}
+
+func (p *protoParser) failf(t protoToken, format string, args ...interface{}) {
+	panic(protoSyntaxError{fmt.Errorf("%s:%d:%d: %s", p.name, t.line+1, t.col+1, fmt.Sprintf(format, args...))})
+}
+
+func (p *protoParser) peek() protoToken {
+	return p.toks[p.pos]
+}
+
+func (p *protoParser) next() protoToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *protoParser) prev() protoToken {
+	return p.toks[p.pos-1]
+}
+
+func (p *protoParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokIdent || t.kind == tokSymbol) && t.text == text
+}
+
+func (p *protoParser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *protoParser) expect(text string) protoToken {
+	if !p.is(text) {
+		p.failf(p.peek(), "expected %q, found %s", text, p.peek())
+	}
+	return p.next()
+}
+
+func (p *protoParser) ident() string {
+	t := p.next()
+	if t.kind != tokIdent {
+		p.failf(t, "expected identifier, found %s", t)
+	}
+	return t.text
+}
+
+// fullIdent parses a dotted name, with a leading dot for type names if dot is set.
+func (p *protoParser) fullIdent(dot bool) string {
+	var sb strings.Builder
+	if dot && p.accept(".") {
+		sb.WriteByte('.')
+	}
+	sb.WriteString(p.ident())
+	for p.accept(".") {
+		sb.WriteByte('.')
+		sb.WriteString(p.ident())
+	}
+	return sb.String()
+}
+
+func (p *protoParser) stringLit() string {
+	t := p.next()
+	if t.kind != tokString {
+		p.failf(t, "expected string, found %s", t)
+	}
+	// adjacent strings are concatenated
+	s := t.text
+	for p.peek().kind == tokString {
+		s += p.next().text
+	}
+	return s
+}
+
+func (p *protoParser) intLit() (uint64, protoToken) {
+	t := p.next()
+	if t.kind != tokInt {
+		p.failf(t, "expected integer, found %s", t)
+	}
+	v, err := parseProtoUint(t.text)
+	if err != nil {
+		p.failf(t, "invalid integer %s", t.text)
+	}
+	return v, t
+}
+
+// int32Lit parses a positive int32 number, negative ones only if signed is set.
+func (p *protoParser) int32Lit(signed bool) int32 {
+	neg := signed && p.accept("-")
+	v, t := p.intLit()
+	switch {
+	case neg && v <= 1<<31:
+		return int32(-int64(v))
+	case !neg && v < 1<<31:
+		return int32(v)
+	}
+	p.failf(t, "integer %s out of range", t.text)
+	return 0
+}
+
+// location adds a source code location for path starting at tok, its span is set by end.
+func (p *protoParser) location(path []int32, tok protoToken) *descriptorpb.SourceCodeInfo_Location {
+	loc := &descriptorpb.SourceCodeInfo_Location{Path: append([]int32(nil), path...)}
+	if tok.leading != "" {
+		loc.LeadingComments = proto.String(tok.leading)
+	}
+	loc.LeadingDetachedComments = tok.detached
+	loc.Span = []int32{int32(tok.line), int32(tok.col)}
+	p.fd.SourceCodeInfo.Location = append(p.fd.SourceCodeInfo.Location, loc)
+	return loc
+}
+
+// end sets the span of loc to end at the last token, head is the token with the trailing comment.
+func (p *protoParser) end(loc *descriptorpb.SourceCodeInfo_Location, head protoToken) {
+	last := p.prev()
+	if head.trailing != "" {
+		loc.TrailingComments = proto.String(head.trailing)
+	}
+	if int32(last.endLine) != loc.Span[0] {
+		loc.Span = append(loc.Span, int32(last.endLine))
+	}
+	loc.Span = append(loc.Span, int32(last.endCol))
+}
+
+func appendPath(path []int32, elems ...int32) []int32 {
+	return append(append([]int32(nil), path...), elems...)
+}
+
+func (p *protoParser) parseFile() {
+	root := p.location(nil, protoToken{})
+	root.LeadingComments, root.LeadingDetachedComments = nil, nil
+	if p.is("syntax") || p.is("edition") {
+		start := p.peek()
+		if p.next().text == "edition" {
+			p.failf(start, "editions are not supported, only proto2 and proto3")
+		}
+		loc := p.location([]int32{12}, start)
+		p.expect("=")
+		t := p.peek()
+		syntax := p.stringLit()
+		if syntax != "proto2" && syntax != "proto3" {
+			p.failf(t, "unknown syntax %q", syntax)
+		}
+		if syntax == "proto3" {
+			// protoc leaves the syntax of proto2 files unset
+			p.fd.Syntax = proto.String(syntax)
+		}
+		head := p.expect(";")
+		p.end(loc, head)
+	}
+	for p.peek().kind != tokEOF {
+		start := p.peek()
+		switch {
+		case p.accept(";"):
+		case p.is("import"):
+			p.parseImport()
+		case p.is("package"):
+			if p.fd.Package != nil {
+				p.failf(start, "multiple package statements")
+			}
+			p.next()
+			loc := p.location([]int32{2}, start)
+			p.fd.Package = proto.String(p.fullIdent(false))
+			p.end(loc, p.expect(";"))
+		case p.is("option"):
+			if p.fd.Options == nil {
+				p.fd.Options = &descriptorpb.FileOptions{}
+			}
+			p.parseOptionStatement(p.fd.Options, []int32{8})
+		case p.is("message"):
+			p.fd.MessageType = append(p.fd.MessageType, nil)
+			i := len(p.fd.MessageType) - 1
+			p.fd.MessageType[i] = p.parseMessage([]int32{4, int32(i)})
+		case p.is("enum"):
+			p.fd.EnumType = append(p.fd.EnumType, nil)
+			i := len(p.fd.EnumType) - 1
+			p.fd.EnumType[i] = p.parseEnum([]int32{5, int32(i)})
+		case p.is("service"):
+			p.fd.Service = append(p.fd.Service, nil)
+			i := len(p.fd.Service) - 1
+			p.fd.Service[i] = p.parseService([]int32{6, int32(i)})
+		case p.is("extend"):
+			p.parseExtend(&p.fd.Extension, []int32{7}, &p.fd.MessageType, []int32{4})
+		default:
+			p.failf(start, "unexpected %s", start)
+		}
+	}
+	if p.pos == 0 {
+		root.Span = append(root.Span, 0)
+		return
+	}
+	p.end(root, protoToken{})
+}
+
+func (p *protoParser) proto3() bool {
+	return p.fd.GetSyntax() == "proto3"
+}
+
+func (p *protoParser) parseImport() {
+	start := p.expect("import")
+	i := int32(len(p.fd.Dependency))
+	loc := p.location([]int32{3, i}, start)
+	switch {
+	case p.accept("public"):
+		p.fd.PublicDependency = append(p.fd.PublicDependency, i)
+	case p.accept("weak"):
+		p.fd.WeakDependency = append(p.fd.WeakDependency, i)
+	}
+	p.fd.Dependency = append(p.fd.Dependency, p.stringLit())
+	p.end(loc, p.expect(";"))
+}
+
+// parseOptionStatement parses "option name = value;" for the options message opts.
+func (p *protoParser) parseOptionStatement(opts proto.Message, path []int32) {
+	start := p.expect("option")
+	loc := p.location(path, start)
+	p.parseOption(opts)
+	p.end(loc, p.expect(";"))
+}
+
+// parseOption parses "name = value" and records it for opts.
+func (p *protoParser) parseOption(opts proto.Message) *protoOption {
+	o := &protoOption{options: opts, pos: p.peek(), scope: strings.Join(p.scope, ".")}
+	for {
+		if p.accept("(") {
+			o.name = append(o.name, optionNamePart{name: p.fullIdent(true), extension: true})
+			p.expect(")")
+		} else {
+			o.name = append(o.name, optionNamePart{name: p.ident()})
+		}
+		if !p.accept(".") {
+			break
+		}
+	}
+	p.expect("=")
+	o.value = p.parseConstant()
+	p.options = append(p.options, o)
+	return o
+}
+
+func (p *protoParser) parseConstant() protoConstant {
+	var c protoConstant
+	if p.is("{") {
+		start := p.next()
+		depth := 1
+		for depth > 0 {
+			t := p.next()
+			switch {
+			case t.kind == tokEOF:
+				p.failf(start, "unterminated aggregate value")
+			case t.kind == tokSymbol && t.text == "{":
+				depth++
+			case t.kind == tokSymbol && t.text == "}":
+				depth--
+			}
+		}
+		c.tok = start
+		c.aggregate = p.sourceBetween(start, p.prev())
+		return c
+	}
+	if p.accept("-") {
+		c.negative = true
+	} else {
+		p.accept("+")
+	}
+	c.tok = p.next()
+	switch c.tok.kind {
+	case tokString:
+		for p.peek().kind == tokString {
+			c.tok.text += p.next().text
+		}
+		if c.negative {
+			p.failf(c.tok, "unexpected sign before string")
+		}
+	case tokIdent:
+		// enum values, booleans, inf and nan
+		for p.is(".") {
+			p.next()
+			c.tok.text += "." + p.ident()
+		}
+	case tokInt, tokFloat:
+	default:
+		p.failf(c.tok, "expected constant, found %s", c.tok)
+	}
+	return c
+}
+
+// sourceBetween returns the source inside the braces from open to close.
+func (p *protoParser) sourceBetween(open, close protoToken) string {
+	lines := strings.SplitAfter(p.src, "\n")
+	var sb strings.Builder
+	for l := open.line; l <= close.line; l++ {
+		line := lines[l]
+		from, to := 0, len(line)
+		if l == open.line {
+			from = byteOffset(line, open.endCol)
+		}
+		if l == close.line {
+			to = byteOffset(line, close.col)
+		}
+		sb.WriteString(line[from:to])
+	}
+	return sb.String()
+}
+
+// byteOffset converts a column counted in runes to a byte offset in line.
+func byteOffset(line string, col int) int {
+	n := 0
+	for i := range line {
+		if n == col {
+			return i
+		}
+		n++
+	}
+	return len(line)
+}
+
+// parseOptionList parses "[name = value, ...]" of fields and enum values.
+// default and json_name are no options, they are set on field.
+func (p *protoParser) parseOptionList(opts proto.Message, field *descriptorpb.FieldDescriptorProto) {
+	if !p.accept("[") {
+		return
+	}
+	for {
+		if field != nil && (p.is("default") || p.is("json_name")) {
+			t := p.next()
+			p.expect("=")
+			c := p.parseConstant()
+			if t.text == "json_name" {
+				if c.tok.kind != tokString {
+					p.failf(c.tok, "json_name must be a string")
+				}
+				field.JsonName = proto.String(c.tok.text)
+			} else {
+				if p.hasDefault(field) {
+					p.failf(t, "default is set twice")
+				}
+				p.defaults = append(p.defaults, protoDefault{field: field, value: c})
+			}
+		} else {
+			p.parseOption(opts)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect("]")
+}
+
+func (p *protoParser) hasDefault(field *descriptorpb.FieldDescriptorProto) bool {
+	for _, d := range p.defaults {
+		if d.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// protoScalarTypes maps the scalar type names to their field type.
+var protoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+}
+
+func (p *protoParser) parseMessage(path []int32) *descriptorpb.DescriptorProto {
+	start := p.expect("message")
+	loc := p.location(path, start)
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(p.ident())}
+	head := p.expect("{")
+	p.parseMessageBody(msg, path)
+	p.end(loc, head)
+	return msg
+}
+
+// parseMessageBody parses the declarations of a message or group up to the closing brace.
+func (p *protoParser) parseMessageBody(msg *descriptorpb.DescriptorProto, path []int32) {
+	p.scope = append(p.scope, msg.GetName())
+	defer func() { p.scope = p.scope[:len(p.scope)-1] }()
+	fields := fieldTarget{
+		fields: &msg.Field, fieldsPath: appendPath(path, 2),
+		nested: &msg.NestedType, nestedPath: appendPath(path, 3),
+	}
+	for !p.accept("}") {
+		start := p.peek()
+		switch {
+		case start.kind == tokEOF:
+			p.failf(start, "missing } of message %s", msg.GetName())
+		case p.accept(";"):
+		case p.is("message"):
+			msg.NestedType = append(msg.NestedType, nil)
+			i := len(msg.NestedType) - 1
+			msg.NestedType[i] = p.parseMessage(appendPath(path, 3, int32(i)))
+		case p.is("enum"):
+			msg.EnumType = append(msg.EnumType, nil)
+			i := len(msg.EnumType) - 1
+			msg.EnumType[i] = p.parseEnum(appendPath(path, 4, int32(i)))
+		case p.is("extend"):
+			p.parseExtend(&msg.Extension, appendPath(path, 6), &msg.NestedType, appendPath(path, 3))
+		case p.is("extensions"):
+			p.parseExtensions(msg, path)
+		case p.is("reserved"):
+			p.parseReserved(msg, path)
+		case p.is("option"):
+			if msg.Options == nil {
+				msg.Options = &descriptorpb.MessageOptions{}
+			}
+			p.parseOptionStatement(msg.Options, appendPath(path, 7))
+		case p.is("oneof"):
+			p.parseOneof(msg, path, fields)
+		default:
+			p.parseField(fields, false)
+		}
+	}
+	p.addSyntheticOneofs(msg)
+}
+
+// fieldTarget is where fields and the messages of their groups and maps are added.
+type fieldTarget struct {
+	fields     *[]*descriptorpb.FieldDescriptorProto
+	fieldsPath []int32
+	nested     *[]*descriptorpb.DescriptorProto
+	nestedPath []int32
+	oneof      *int32
+	extendee   string
+}
+
+func (p *protoParser) parseField(ft fieldTarget, extension bool) {
+	start := p.peek()
+	i := int32(len(*ft.fields))
+	loc := p.location(appendPath(ft.fieldsPath, i), start)
+	f := &descriptorpb.FieldDescriptorProto{OneofIndex: ft.oneof}
+	if extension {
+		f.Extendee = proto.String(ft.extendee)
+	}
+	*ft.fields = append(*ft.fields, f)
+
+	switch label := p.peek().text; {
+	case p.peek().kind != tokIdent:
+	case label == "optional" || label == "required" || label == "repeated":
+		if ft.oneof != nil {
+			p.failf(start, "fields in oneofs must not have labels")
+		}
+		p.next()
+		switch label {
+		case "optional":
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+			if p.proto3() {
+				f.Proto3Optional = proto.Bool(true)
+			}
+		case "required":
+			if p.proto3() {
+				p.failf(start, "required fields are not allowed in proto3")
+			}
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+		default:
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+	case p.is("map") && p.toks[p.pos+1].text == "<":
+	case ft.oneof != nil || p.proto3():
+	default:
+		p.failf(start, "expected label optional, required or repeated")
+	}
+	if f.Label == nil {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	}
+
+	var group *descriptorpb.DescriptorProto
+	switch {
+	case p.is("map") && p.toks[p.pos+1].text == "<":
+		if start.text != "map" {
+			p.failf(start, "map fields must not have labels")
+		}
+		if extension || ft.oneof != nil {
+			p.failf(start, "map fields are not allowed in extensions and oneofs")
+		}
+		p.next()
+		p.expect("<")
+		key := &descriptorpb.FieldDescriptorProto{
+			Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		t := p.next()
+		typ, ok := protoScalarTypes[t.text]
+		if !ok || typ == descriptorpb.FieldDescriptorProto_TYPE_DOUBLE || typ == descriptorpb.FieldDescriptorProto_TYPE_FLOAT || typ == descriptorpb.FieldDescriptorProto_TYPE_BYTES {
+			p.failf(t, "invalid map key type %s", t)
+		}
+		key.Type = typ.Enum()
+		p.expect(",")
+		value := &descriptorpb.FieldDescriptorProto{
+			Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		p.parseFieldType(value)
+		p.expect(">")
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		f.Name = proto.String(p.ident())
+		entry := &descriptorpb.DescriptorProto{
+			Name:    proto.String(mapEntryName(f.GetName())),
+			Field:   []*descriptorpb.FieldDescriptorProto{key, value},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		f.TypeName = entry.Name
+		*ft.nested = append(*ft.nested, entry)
+	case p.is("group"):
+		if p.proto3() {
+			p.failf(start, "groups are not allowed in proto3")
+		}
+		p.next()
+		name := p.ident()
+		if name[0] < 'A' || name[0] > 'Z' {
+			p.failf(p.prev(), "group names must start with a capital letter")
+		}
+		f.Name = proto.String(strings.ToLower(name))
+		f.Type = descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum()
+		f.TypeName = proto.String(name)
+		group = &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	default:
+		p.parseFieldType(f)
+		f.Name = proto.String(p.ident())
+	}
+	p.expect("=")
+	f.Number = proto.Int32(p.int32Lit(false))
+	f.Options = &descriptorpb.FieldOptions{}
+	n := len(p.options)
+	if p.parseOptionList(f.Options, f); n == len(p.options) {
+		f.Options = nil
+	}
+	head := p.peek()
+	if group != nil {
+		j := int32(len(*ft.nested))
+		*ft.nested = append(*ft.nested, group)
+		gloc := p.location(appendPath(ft.nestedPath, j), start)
+		p.expect("{")
+		p.parseMessageBody(group, appendPath(ft.nestedPath, j))
+		p.end(gloc, head)
+	} else {
+		p.expect(";")
+	}
+	p.end(loc, head)
+}
+
+// parseFieldType sets the scalar type or the type name to resolve of f.
+func (p *protoParser) parseFieldType(f *descriptorpb.FieldDescriptorProto) {
+	if typ, ok := protoScalarTypes[p.peek().text]; ok && p.peek().kind == tokIdent {
+		p.next()
+		f.Type = typ.Enum()
+		return
+	}
+	f.TypeName = proto.String(p.fullIdent(true))
+}
+
+// mapEntryName is the name protoc gives the entry message of a map field.
+func mapEntryName(field string) string {
+	var sb strings.Builder
+	upper := true
+	for _, c := range field {
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			sb.WriteRune(c - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(c)
+			upper = false
+		}
+	}
+	return sb.String() + "Entry"
+}
+
+func (p *protoParser) parseOneof(msg *descriptorpb.DescriptorProto, path []int32, ft fieldTarget) {
+	start := p.expect("oneof")
+	i := int32(len(msg.OneofDecl))
+	loc := p.location(appendPath(path, 8, i), start)
+	oneof := &descriptorpb.OneofDescriptorProto{Name: proto.String(p.ident())}
+	msg.OneofDecl = append(msg.OneofDecl, oneof)
+	head := p.expect("{")
+	ft.oneof = proto.Int32(i)
+	for !p.accept("}") {
+		switch {
+		case p.peek().kind == tokEOF:
+			p.failf(p.peek(), "missing } of oneof %s", oneof.GetName())
+		case p.accept(";"):
+		case p.is("option"):
+			if oneof.Options == nil {
+				oneof.Options = &descriptorpb.OneofOptions{}
+			}
+			p.parseOptionStatement(oneof.Options, appendPath(path, 8, i, 2))
+		default:
+			p.parseField(ft, false)
+		}
+	}
+	p.end(loc, head)
+}
+
+// addSyntheticOneofs adds the oneofs protoc declares for proto3 optional fields after the real ones.
+func (p *protoParser) addSyntheticOneofs(msg *descriptorpb.DescriptorProto) {
+	names := map[string]bool{}
+	for _, f := range msg.Field {
+		names[f.GetName()] = true
+	}
+	for _, o := range msg.OneofDecl {
+		names[o.GetName()] = true
+	}
+	for _, f := range msg.Field {
+		if !f.GetProto3Optional() {
+			continue
+		}
+		name := "_" + f.GetName()
+		for names[name] {
+			name = "X" + name
+		}
+		names[name] = true
+		f.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+		msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(name)})
+	}
+}
+
+// parseRanges parses "N [to N|max], ..." into inclusive ranges.
+func (p *protoParser) parseRanges(max int32, signed bool) [][2]int32 {
+	var ranges [][2]int32
+	for {
+		r := [2]int32{p.int32Lit(signed), 0}
+		r[1] = r[0]
+		if p.accept("to") {
+			if p.accept("max") {
+				r[1] = max
+			} else {
+				r[1] = p.int32Lit(signed)
+			}
+		}
+		if r[1] < r[0] {
+			p.failf(p.prev(), "range end %d is before its start %d", r[1], r[0])
+		}
+		ranges = append(ranges, r)
+		if !p.accept(",") {
+			return ranges
+		}
+	}
+}
+
+func (p *protoParser) parseExtensions(msg *descriptorpb.DescriptorProto, path []int32) {
+	start := p.expect("extensions")
+	first := len(msg.ExtensionRange)
+	loc := p.location(appendPath(path, 5), start)
+	for _, r := range p.parseRanges(maxFieldNumber, false) {
+		er := &descriptorpb.DescriptorProto_ExtensionRange{
+			Start: proto.Int32(r[0]),
+			End:   proto.Int32(r[1] + 1),
+		}
+		msg.ExtensionRange = append(msg.ExtensionRange, er)
+		if r[1] == maxFieldNumber {
+			if p.maxRanges == nil {
+				p.maxRanges = map[*descriptorpb.DescriptorProto][]*descriptorpb.DescriptorProto_ExtensionRange{}
+			}
+			p.maxRanges[msg] = append(p.maxRanges[msg], er)
+		}
+	}
+	if p.is("[") {
+		opts := &descriptorpb.ExtensionRangeOptions{}
+		p.parseOptionList(opts, nil)
+		for _, r := range msg.ExtensionRange[first:] {
+			r.Options = opts
+		}
+	}
+	p.end(loc, p.expect(";"))
+}
+
+func (p *protoParser) parseReserved(msg *descriptorpb.DescriptorProto, path []int32) {
+	start := p.expect("reserved")
+	if p.peek().kind == tokString {
+		loc := p.location(appendPath(path, 10), start)
+		for {
+			msg.ReservedName = append(msg.ReservedName, p.stringLit())
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.end(loc, p.expect(";"))
+		return
+	}
+	loc := p.location(appendPath(path, 9), start)
+	for _, r := range p.parseRanges(maxFieldNumber, false) {
+		msg.ReservedRange = append(msg.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+			Start: proto.Int32(r[0]),
+			End:   proto.Int32(r[1] + 1),
+		})
+	}
+	p.end(loc, p.expect(";"))
+}
+
+func (p *protoParser) parseEnum(path []int32) *descriptorpb.EnumDescriptorProto {
+	start := p.expect("enum")
+	loc := p.location(path, start)
+	enum := &descriptorpb.EnumDescriptorProto{Name: proto.String(p.ident())}
+	head := p.expect("{")
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokEOF:
+			p.failf(t, "missing } of enum %s", enum.GetName())
+		case p.accept(";"):
+		case p.is("option"):
+			if enum.Options == nil {
+				enum.Options = &descriptorpb.EnumOptions{}
+			}
+			p.parseOptionStatement(enum.Options, appendPath(path, 3))
+		case p.is("reserved"):
+			p.next()
+			if p.peek().kind == tokString {
+				rloc := p.location(appendPath(path, 5), t)
+				for {
+					enum.ReservedName = append(enum.ReservedName, p.stringLit())
+					if !p.accept(",") {
+						break
+					}
+				}
+				p.end(rloc, p.expect(";"))
+				continue
+			}
+			rloc := p.location(appendPath(path, 4), t)
+			for _, r := range p.parseRanges(1<<31-1, true) {
+				enum.ReservedRange = append(enum.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+					Start: proto.Int32(r[0]),
+					End:   proto.Int32(r[1]),
+				})
+			}
+			p.end(rloc, p.expect(";"))
+		default:
+			i := int32(len(enum.Value))
+			vloc := p.location(appendPath(path, 2, i), t)
+			v := &descriptorpb.EnumValueDescriptorProto{Name: proto.String(p.ident())}
+			enum.Value = append(enum.Value, v)
+			p.expect("=")
+			v.Number = proto.Int32(p.int32Lit(true))
+			if p.is("[") {
+				v.Options = &descriptorpb.EnumValueOptions{}
+				p.parseOptionList(v.Options, nil)
+			}
+			p.end(vloc, p.expect(";"))
+		}
+	}
+	p.end(loc, head)
+	return enum
+}
+
+func (p *protoParser) parseService(path []int32) *descriptorpb.ServiceDescriptorProto {
+	start := p.expect("service")
+	loc := p.location(path, start)
+	svc := &descriptorpb.ServiceDescriptorProto{Name: proto.String(p.ident())}
+	head := p.expect("{")
+	for !p.accept("}") {
+		t := p.peek()
+		switch {
+		case t.kind == tokEOF:
+			p.failf(t, "missing } of service %s", svc.GetName())
+		case p.accept(";"):
+		case p.is("option"):
+			if svc.Options == nil {
+				svc.Options = &descriptorpb.ServiceOptions{}
+			}
+			p.parseOptionStatement(svc.Options, appendPath(path, 3))
+		default:
+			p.expect("rpc")
+			i := int32(len(svc.Method))
+			mpath := appendPath(path, 2, i)
+			mloc := p.location(mpath, t)
+			m := &descriptorpb.MethodDescriptorProto{Name: proto.String(p.ident())}
+			svc.Method = append(svc.Method, m)
+			p.expect("(")
+			if p.streaming() {
+				m.ClientStreaming = proto.Bool(true)
+			}
+			m.InputType = proto.String(p.fullIdent(true))
+			p.expect(")")
+			p.expect("returns")
+			p.expect("(")
+			if p.streaming() {
+				m.ServerStreaming = proto.Bool(true)
+			}
+			m.OutputType = proto.String(p.fullIdent(true))
+			p.expect(")")
+			mhead := p.peek()
+			if p.accept("{") {
+				for !p.accept("}") {
+					switch {
+					case p.peek().kind == tokEOF:
+						p.failf(p.peek(), "missing } of method %s", m.GetName())
+					case p.accept(";"):
+					default:
+						if m.Options == nil {
+							m.Options = &descriptorpb.MethodOptions{}
+						}
+						p.parseOptionStatement(m.Options, appendPath(mpath, 4))
+					}
+				}
+			} else {
+				p.expect(";")
+			}
+			p.end(mloc, mhead)
+		}
+	}
+	p.end(loc, head)
+	return svc
+}
+
+// streaming accepts the stream keyword before a type name, stream can also be a type name.
+func (p *protoParser) streaming() bool {
+	if !p.is("stream") {
+		return false
+	}
+	t, next := p.peek(), p.toks[p.pos+1]
+	adjacent := next.line == t.endLine && next.col == t.endCol
+	if next.kind == tokIdent || (next.text == "." && !adjacent) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// parseExtend parses an extend block, its groups are added to nested.
+func (p *protoParser) parseExtend(fields *[]*descriptorpb.FieldDescriptorProto, fieldsPath []int32, nested *[]*descriptorpb.DescriptorProto, nestedPath []int32) {
+	start := p.expect("extend")
+	// protoc locates the block by the path of the extension list
+	loc := p.location(fieldsPath, start)
+	ft := fieldTarget{fields: fields, fieldsPath: fieldsPath, nested: nested, nestedPath: nestedPath}
+	ft.extendee = p.fullIdent(true)
+	head := p.expect("{")
+	for !p.accept("}") {
+		switch {
+		case p.peek().kind == tokEOF:
+			p.failf(p.peek(), "missing } of extend %s", ft.extendee)
+		case p.accept(";"):
+		default:
+			p.parseField(ft, true)
+		}
+	}
+	p.end(loc, head)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("provenance", "map lines of generated files to the messages, fields and other declarations they were generated for", runProvenance)
+}
+
+// provenanceSpan are lines of a generated file generated for a declaration.
+type provenanceSpan struct {
+	File      string `json:"file"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	// Source is the proto file declaring Element
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	// Element is the full name of the declaration
+	Element    string  `json:"element"`
+	Path       []int32 `json:"path,omitempty"`
+	SourceLine int     `json:"source_line,omitempty"`
+	// Via is annotation for generated code info of the plugin, marker for insertion points,
+	// comment for declarations quoted in comments and tag for go struct tags
+	Via string `json:"via"`
+}
+
+// provenanceDecl is a declaration of a request generated code can be traced to.
+type provenanceDecl struct {
+	file, kind, name string
+	path             []int32
+	number           int32
+}
+
+var (
+	// declarationComment matches declarations of fields and enum values quoted in comments
+	// like "// string name = 1;" or " * <code>repeated int32 ids = 2 [packed = true];</code>"
+	declarationComment = regexp.MustCompile(`(?:^|\s|>)(\w+)\s*=\s*(-?\d+)\s*(?:\[[^\]]*\])?\s*;`)
+	goStructTag        = regexp.MustCompile(`protobuf:"\w+,(\d+),(?:\w+,)*name=(\w+)`)
+	goOneofTag         = regexp.MustCompile(`protobuf_oneof:"(\w+)"`)
+	goStructType       = regexp.MustCompile(`^type (\w+) struct \{`)
+)
+
+func runProvenance(args []string) error {
+	var (
+		line   = ""
+		heurs  = true
+		source = false
+	)
+	fs := newFlagSet("provenance")
+	fs.StringVar(&line, "line", line, "only output the spans containing this GENERATED_FILE:LINE, innermost first")
+	fs.BoolVar(&heurs, "heuristics", heurs, "trace files without generated code info by insertion point markers, declarations in comments and go struct tags")
+	fs.BoolVar(&source, "source-lines", source, "add the line of each declaration in its proto file if the request has source code info")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture provenance [ARGUMENTS] REQUEST RESPONSE\n\n"+
+			"Writes json spans of generated lines with the declaration they were generated for.\n"+
+			"Plugins annotating their code fill the generated code info of the response files or\n"+
+			"write it to FILE.meta like protoc-gen-go with the parameter annotate_code. Go\n"+
+			"annotations of an identifier cover its whole declaration with doc comments. Other\n"+
+			"files are traced by heuristics, which only find single lines.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("provenance needs a request and a response")
+	}
+	wantFile, wantLine := "", 0
+	if line != "" {
+		i := strings.LastIndexByte(line, ':')
+		n, err := strconv.Atoi(line[i+1:])
+		if i <= 0 || err != nil || n <= 0 {
+			return fmt.Errorf("-line needs GENERATED_FILE:LINE, got %q", line)
+		}
+		wantFile, wantLine = line[:i], n
+	}
+	req, err := readRequestFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	resp, err := readResponseFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if resp.GetError() != "" {
+		return fmt.Errorf("%s is an error response: %s", fs.Arg(1), resp.GetError())
+	}
+	spans := provenanceSpans(req, resp, heurs)
+	if source {
+		regions := sourceRegions(req)
+		for i := range spans {
+			if r := regions[spans[i].Source][spans[i].Element]; r != nil {
+				spans[i].SourceLine = r.StartLine
+			}
+		}
+	}
+	if line != "" {
+		var found []provenanceSpan
+		for _, s := range spans {
+			if s.File == wantFile && s.StartLine <= wantLine && wantLine <= s.EndLine {
+				found = append(found, s)
+			}
+		}
+		sort.SliceStable(found, func(i, j int) bool {
+			return found[i].EndLine-found[i].StartLine < found[j].EndLine-found[j].StartLine
+		})
+		if len(found) == 0 {
+			log.Printf("warning: no declaration found for line %d of %s\n", wantLine, wantFile)
+		}
+		spans = append([]provenanceSpan{}, found...)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(spans)
+}
+
+// provenanceSpans traces the lines of the files of resp to the declarations of req, sorted
+// by file and line with enclosing spans first. Insertions into files of other plugins are skipped.
+func provenanceSpans(req *pluginpb.CodeGeneratorRequest, resp *pluginpb.CodeGeneratorResponse, heuristics bool) []provenanceSpan {
+	decls := map[string]map[string]provenanceDecl{}
+	var all []provenanceDecl
+	var names []string
+	for _, fd := range req.ProtoFile {
+		byPath := map[string]provenanceDecl{}
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			d := provenanceDecl{file: fd.GetName(), kind: kind, name: name, path: path}
+			switch desc := desc.(type) {
+			case *descriptorpb.FieldDescriptorProto:
+				d.number = desc.GetNumber()
+			case *descriptorpb.EnumValueDescriptorProto:
+				d.number = desc.GetNumber()
+			}
+			byPath[pathKey(path)] = d
+			all = append(all, d)
+		})
+		decls[fd.GetName()] = byPath
+		names = append(names, fd.GetName())
+	}
+
+	// meta holds the generated code info protoc-gen-go writes to FILE.meta by FILE
+	meta := map[string]*descriptorpb.GeneratedCodeInfo{}
+	isMeta := map[string]bool{}
+	for _, f := range resp.File {
+		if name := strings.TrimSuffix(f.GetName(), ".meta"); name != f.GetName() && f.GetInsertionPoint() == "" {
+			info := &descriptorpb.GeneratedCodeInfo{}
+			if err := prototext.Unmarshal([]byte(f.GetContent()), info); err != nil {
+				log.Printf("warning: %s is no generated code info: %v\n", f.GetName(), err)
+				continue
+			}
+			meta[name] = info
+			isMeta[f.GetName()] = true
+		}
+	}
+	spans := []provenanceSpan{}
+	for _, f := range resp.File {
+		if f.GetInsertionPoint() != "" || isMeta[f.GetName()] {
+			continue
+		}
+		info := f.GetGeneratedCodeInfo()
+		if len(info.GetAnnotation()) == 0 {
+			info = meta[f.GetName()]
+		}
+		var found []provenanceSpan
+		via := "annotations"
+		if len(info.GetAnnotation()) > 0 {
+			found = annotationSpans(f, info, decls)
+		} else if heuristics {
+			via = "heuristics"
+			found = heuristicSpans(f, all, sourcesByName(f.GetName(), names))
+		}
+		logEvent(logInfo, "provenance", "file", f.GetName(), "spans", len(found), "via", via)
+		spans = append(spans, found...)
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		a, b := spans[i], spans[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.EndLine > b.EndLine
+	})
+	return spans
+}
+
+// annotationSpans converts the annotations of a generated file to spans of the
+// innermost declaration containing their path.
+func annotationSpans(f *pluginpb.CodeGeneratorResponse_File, info *descriptorpb.GeneratedCodeInfo, decls map[string]map[string]provenanceDecl) []provenanceSpan {
+	content := f.GetContent()
+	lineOf := func(offset int) int {
+		if offset > len(content) {
+			offset = len(content)
+		}
+		return 1 + strings.Count(content[:offset], "\n")
+	}
+	var extents map[int][2]int
+	if strings.HasSuffix(f.GetName(), ".go") {
+		extents = goDeclExtents(f.GetName(), content)
+	}
+	var spans []provenanceSpan
+	for _, a := range info.GetAnnotation() {
+		byPath := decls[a.GetSourceFile()]
+		var d provenanceDecl
+		found := false
+		for p := a.Path; len(p) > 0 && !found; p = p[:len(p)-1] {
+			d, found = byPath[pathKey(p)]
+		}
+		if !found {
+			continue
+		}
+		begin, end := int(a.GetBegin()), int(a.GetEnd())
+		if e, ok := extents[begin]; ok {
+			begin, end = e[0], e[1]
+		}
+		if end > begin {
+			// end is exclusive
+			end--
+		}
+		spans = append(spans, provenanceSpan{
+			File:      f.GetName(),
+			StartLine: lineOf(begin),
+			EndLine:   lineOf(end),
+			Source:    d.file,
+			Kind:      d.kind,
+			Element:   d.name,
+			Path:      d.path,
+			Via:       "annotation",
+		})
+	}
+	return spans
+}
+
+// goDeclExtents maps the offsets of identifiers declared in go source to the extent
+// of their declaration with doc comments: functions, types, constants, variables and struct fields.
+func goDeclExtents(name, src string) map[int][2]int {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		logEvent(logDebug, "provenance", "file", name, "parse", err.Error())
+		return nil
+	}
+	extents := map[int][2]int{}
+	add := func(ident *ast.Ident, doc *ast.CommentGroup, node ast.Node) {
+		start := node.Pos()
+		if doc != nil {
+			start = doc.Pos()
+		}
+		extents[fset.Position(ident.Pos()).Offset] = [2]int{fset.Position(start).Offset, fset.Position(node.End()).Offset}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			add(n.Name, n.Doc, n)
+		case *ast.GenDecl:
+			for _, spec := range n.Specs {
+				var node ast.Node = spec
+				doc := n.Doc
+				if len(n.Specs) > 1 {
+					doc = nil
+				} else {
+					node = n
+				}
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Doc != nil {
+						doc = spec.Doc
+					}
+					add(spec.Name, doc, node)
+				case *ast.ValueSpec:
+					if spec.Doc != nil {
+						doc = spec.Doc
+					}
+					for _, ident := range spec.Names {
+						add(ident, doc, node)
+					}
+				}
+			}
+		case *ast.StructType:
+			for _, field := range n.Fields.List {
+				for _, ident := range field.Names {
+					add(ident, field.Doc, field)
+				}
+			}
+		}
+		return true
+	})
+	return extents
+}
+
+// heuristicSpans traces lines of a generated file without annotations: insertion point
+// markers naming a declaration, fields and enum values quoted in comments and go struct
+// tags. Ambiguous names are resolved by the next class scope marker or the enclosing go
+// struct, else by the files the generated file is named after.
+func heuristicSpans(f *pluginpb.CodeGeneratorResponse_File, all []provenanceDecl, sources []string) []provenanceSpan {
+	byName := map[string]provenanceDecl{}
+	for _, d := range all {
+		byName[d.name] = d
+	}
+	preferred := map[string]bool{}
+	for _, s := range sources {
+		preferred[s] = true
+	}
+	lines := strings.Split(f.GetContent(), "\n")
+	// scopes are the message of the next class scope marker or the enclosing go struct of each line
+	scopes := make([]string, len(lines))
+	next := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, m := range insertionMarker.FindAllStringSubmatch(lines[i], -1) {
+			if kind, name, ok := strings.Cut(m[1], ":"); ok && kind == "class_scope" {
+				next = name
+			}
+		}
+		scopes[i] = next
+	}
+	goStruct := ""
+	for i, line := range lines {
+		if m := goStructType.FindStringSubmatch(line); m != nil {
+			goStruct = m[1]
+		} else if strings.HasPrefix(line, "}") {
+			goStruct = ""
+		}
+		if goStruct != "" {
+			scopes[i] = goStruct
+		}
+	}
+
+	// pick returns the only candidate in scope, in a preferred file or at all
+	pick := func(cands []provenanceDecl, scope string) (provenanceDecl, bool) {
+		for _, filter := range []func(provenanceDecl) bool{
+			func(d provenanceDecl) bool { return scope != "" && parentMatches(d, scope) },
+			func(d provenanceDecl) bool { return preferred[d.file] },
+			func(provenanceDecl) bool { return true },
+		} {
+			var kept []provenanceDecl
+			for _, d := range cands {
+				if filter(d) {
+					kept = append(kept, d)
+				}
+			}
+			if len(kept) == 1 {
+				return kept[0], true
+			}
+			if len(kept) > 1 {
+				return provenanceDecl{}, false
+			}
+		}
+		return provenanceDecl{}, false
+	}
+	byNumber := func(name string, number int32, kinds ...string) []provenanceDecl {
+		var cands []provenanceDecl
+		for _, d := range all {
+			if d.number != number || d.name[strings.LastIndexByte(d.name, '.')+1:] != name {
+				continue
+			}
+			for _, k := range kinds {
+				if d.kind == k {
+					cands = append(cands, d)
+				}
+			}
+		}
+		return cands
+	}
+
+	var spans []provenanceSpan
+	add := func(i int, d provenanceDecl, via string) {
+		spans = append(spans, provenanceSpan{File: f.GetName(), StartLine: i + 1, EndLine: i + 1,
+			Source: d.file, Kind: d.kind, Element: d.name, Path: d.path, Via: via})
+	}
+	for i, line := range lines {
+		for _, m := range insertionMarker.FindAllStringSubmatch(line, -1) {
+			if _, name, ok := strings.Cut(m[1], ":"); ok {
+				if d, ok := byName[name]; ok {
+					add(i, d, "marker")
+				}
+			}
+		}
+		if m := goStructTag.FindStringSubmatch(line); m != nil {
+			number, _ := strconv.Atoi(m[1])
+			if d, ok := pick(byNumber(m[2], int32(number), "field", "extension"), scopes[i]); ok {
+				add(i, d, "tag")
+			}
+			continue
+		}
+		if m := goOneofTag.FindStringSubmatch(line); m != nil {
+			var cands []provenanceDecl
+			for _, d := range all {
+				if d.kind == "oneof" && strings.HasSuffix(d.name, "."+m[1]) {
+					cands = append(cands, d)
+				}
+			}
+			if d, ok := pick(cands, scopes[i]); ok {
+				add(i, d, "tag")
+			}
+			continue
+		}
+		comment := strings.Index(line, "//")
+		if t := strings.TrimSpace(line); comment < 0 && (strings.HasPrefix(t, "*") || strings.HasPrefix(t, "#")) {
+			comment = 0
+		}
+		if comment < 0 {
+			continue
+		}
+		for _, m := range declarationComment.FindAllStringSubmatch(line[comment:], -1) {
+			number, err := strconv.Atoi(m[2])
+			if err != nil {
+				continue
+			}
+			if d, ok := pick(byNumber(m[1], int32(number), "field", "extension", "enum value"), scopes[i]); ok {
+				add(i, d, "comment")
+			}
+		}
+	}
+	return spans
+}
+
+// parentMatches reports whether the declaration is in the message scope, a full
+// name or a go type name like Outer_Inner.
+func parentMatches(d provenanceDecl, scope string) bool {
+	i := strings.LastIndexByte(d.name, '.')
+	if i < 0 {
+		return false
+	}
+	parent := d.name[:i]
+	if parent == scope {
+		return true
+	}
+	// go type names drop the package and join nested names with _
+	for j := strings.IndexByte(parent, '.'); j >= 0; j = strings.IndexByte(parent, '.') {
+		if strings.ReplaceAll(parent, ".", "_") == scope {
+			return true
+		}
+		parent = parent[j+1:]
+	}
+	return parent == scope
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	register("prune", "remove old captures of a capture directory by count, age and size", runPrune)
+}
+
+// prunedCapture is a capture removed by prune and the policy removing it.
+type prunedCapture struct {
+	File   string    `json:"file"`
+	Time   time.Time `json:"time"`
+	Bytes  int64     `json:"bytes"`
+	Reason string    `json:"reason"`
+}
+
+// prunePolicy limits the captures of a directory, zero values do not limit it.
+type prunePolicy struct {
+	keepLast int
+	maxAge   time.Duration
+	maxSize  int64
+}
+
+func runPrune(args []string) error {
+	var (
+		jsonOut = false
+		dryRun  = false
+		maxAge  = ""
+		maxSize = ""
+		policy  prunePolicy
+	)
+	fs := newFlagSet("prune")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output the removed captures as json, else as table")
+	fs.BoolVar(&dryRun, "dry-run", dryRun, "only report the captures to remove")
+	fs.IntVar(&policy.keepLast, "keep-last", policy.keepLast, "keep only this many of the newest captures")
+	fs.StringVar(&maxAge, "max-age", maxAge, "remove captures older than this, like 36h, 30d or 2w")
+	fs.StringVar(&maxSize, "max-size", maxSize, "remove the oldest captures until the rest fits this size, like 500MB or 10GiB")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture prune [ARGUMENTS] CAPTURE-DIR\n\n"+
+			"Captures in the index of the directory are removed from disk and the index if they\n"+
+			"violate one of the policies, newer captures are kept first. Files not in the index\n"+
+			"are left alone.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("prune needs exactly one capture directory")
+	}
+	dir := fs.Arg(0)
+	if isRemote(dir) {
+		return fmt.Errorf("prune needs a local directory, not %s", dir)
+	}
+	var err error
+	if maxAge != "" {
+		if policy.maxAge, err = parseAge(maxAge); err != nil {
+			return fmt.Errorf("-max-age: %v", err)
+		}
+	}
+	if maxSize != "" {
+		if policy.maxSize, err = parseSize(maxSize); err != nil {
+			return fmt.Errorf("-max-size: %v", err)
+		}
+	}
+	if policy.keepLast < 0 {
+		return fmt.Errorf("-keep-last must not be negative")
+	}
+	if policy == (prunePolicy{}) {
+		return fmt.Errorf("prune needs -keep-last, -max-age or -max-size")
+	}
+
+	entries, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	pruned, kept := policy.apply(dir, entries, time.Now())
+	if !dryRun {
+		removed := map[string]bool{}
+		for _, p := range pruned {
+			name, err := splitPath(dir, p.File)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removed[p.File] = true
+		}
+		if len(removed) > 0 {
+			if err := dropFromIndex(dir, func(file string) bool { return removed[file] }); err != nil {
+				return err
+			}
+		}
+	}
+	var freed int64
+	for _, p := range pruned {
+		freed += p.Bytes
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	log.Printf("%s %d of %d captures, %d bytes, %d bytes are kept\n", verb, len(pruned), len(entries), freed, kept)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(pruned)
+	}
+	return writePruneTable(os.Stdout, pruned)
+}
+
+// apply returns the captures of entries violating the policy, oldest last,
+// and the size of the kept captures. Captures missing on disk are always removed,
+// index entries with file names outside of dir are skipped.
+func (pp prunePolicy) apply(dir string, entries []captureEntry, now time.Time) ([]prunedCapture, int64) {
+	sorted := append([]captureEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+	pruned := []prunedCapture{}
+	var size int64
+	kept := 0
+	full := false
+	for _, e := range sorted {
+		name, err := splitPath(dir, e.File)
+		if err != nil {
+			log.Printf("warning: skipping index entry: %v\n", err)
+			continue
+		}
+		p := prunedCapture{File: e.File, Time: e.Time}
+		info, err := os.Stat(name)
+		switch {
+		case err != nil:
+			p.Reason = "missing"
+		case pp.keepLast > 0 && kept >= pp.keepLast:
+			p.Reason = "keep-last"
+		case pp.maxAge > 0 && now.Sub(e.Time) > pp.maxAge:
+			p.Reason = "max-age"
+		case full || (pp.maxSize > 0 && size+info.Size() > pp.maxSize):
+			// older captures do not fill the gap left by a big one
+			full = true
+			p.Reason = "max-size"
+		default:
+			kept++
+			size += info.Size()
+			continue
+		}
+		if info != nil {
+			p.Bytes = info.Size()
+		}
+		pruned = append(pruned, p)
+	}
+	return pruned, size
+}
+
+// parseAge parses a duration like time.ParseDuration, also accepting days like 30d and weeks like 2w.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n := strings.TrimSuffix(s, suffix); n != s {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q, want a duration like 36h, 30d or 2w", s)
+	}
+	return d, nil
+}
+
+// sizeUnits are the suffixes of parseSize, longest first.
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes with an optional decimal or binary unit like 10GB or 512MiB.
+func parseSize(s string) (int64, error) {
+	n, factor := s, 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			n, factor = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size %q, want bytes like 500MB or 10GiB", s)
+	}
+	return int64(v * factor), nil
+}
+
+func writePruneTable(w io.Writer, pruned []prunedCapture) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "TIME\tFILE\tBYTES\tREASON\n")
+	for _, p := range pruned {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", p.Time.Format(time.RFC3339), p.File, p.Bytes, p.Reason)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("query", "find declarations matching expressions like type=message name~'Request$' has_option=deprecated", runQuery)
+}
+
+// queryKeys are the properties of declarations a query can test.
+var queryKeys = []struct{ key, description string }{
+	{"type", "message, field, oneof, extension, enum, enum_value, service or method"},
+	{"name", "full name without leading dot"},
+	{"file", "name of the declaring file"},
+	{"package", "package of the declaring file"},
+	{"has_option", "options set, by field name like deprecated, extension full name or number"},
+	{"label", "optional, required or repeated of fields and extensions"},
+	{"field_type", "type of fields and extensions like int32 or a full message name"},
+	{"number", "number of fields, extensions and enum values"},
+	{"comment", "leading and trailing comments"},
+}
+
+// queryTerm is a test of a query: key, an operator of =, !=, ~ or !~ and a value.
+type queryTerm struct {
+	key, op, value string
+	re             *regexp.Regexp
+}
+
+// queryMatch is a declaration matching all terms of a query.
+// Line and Column are 1 based and 0 if the file has no source code info.
+type queryMatch struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+}
+
+func runQuery(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		all     = false
+	)
+	fs := newFlagSet("query")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as FILE:LINE:COLUMN: TYPE NAME lines")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	fs.Usage = func() {
+		var keys strings.Builder
+		for _, k := range queryKeys {
+			fmt.Fprintf(&keys, "  %-12s%s\n", k.key, k.description)
+		}
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture query [ARGUMENTS] EXPRESSION... < REQUEST\n\n"+
+			"Lists the declarations matching all terms of the expressions. Terms are separated by\n"+
+			"spaces and compare a key with a value: KEY=VALUE and KEY!=VALUE test equality,\n"+
+			"KEY~REGEXP and KEY!~REGEXP test unanchored regular expressions. Values can be quoted\n"+
+			"with ' or \". Keys with several values like has_option match if one value does,\n"+
+			"keys without a value for a declaration only match != and !~.\n\nKeys:\n"+
+			keys.String()+"\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("query needs an expression")
+	}
+	terms, err := parseQuery(strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	matches := queryDeclarations(req, terms, all)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(matches)
+	}
+	return writeQueryMatches(os.Stdout, matches)
+}
+
+// parseQuery splits an expression into terms at spaces outside of quotes.
+func parseQuery(expr string) ([]queryTerm, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	for _, r := range expr {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			inWord = false
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("query has an unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	var terms []queryTerm
+	for _, w := range words {
+		i := strings.IndexAny(w, "!=~")
+		if i <= 0 {
+			return nil, fmt.Errorf("query term %q is not KEY=VALUE, KEY!=VALUE, KEY~REGEXP or KEY!~REGEXP", w)
+		}
+		t := queryTerm{key: w[:i]}
+		rest := w[i:]
+		for _, op := range []string{"!=", "!~", "=", "~"} {
+			if strings.HasPrefix(rest, op) {
+				t.op, t.value = op, rest[len(op):]
+				break
+			}
+		}
+		if t.op == "" {
+			return nil, fmt.Errorf("query term %q has no operator", w)
+		}
+		known := false
+		for _, k := range queryKeys {
+			known = known || k.key == t.key
+		}
+		if !known {
+			return nil, fmt.Errorf("query term %q has unknown key %s", w, t.key)
+		}
+		if strings.HasSuffix(t.op, "~") {
+			re, err := regexp.Compile(t.value)
+			if err != nil {
+				return nil, fmt.Errorf("query term %q: %v", w, err)
+			}
+			t.re = re
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// matches reports whether one of values passes a positive term or none passes a negated one.
+func (t queryTerm) matches(values []string) bool {
+	found := false
+	for _, v := range values {
+		if t.re != nil {
+			found = found || t.re.MatchString(v)
+		} else {
+			found = found || v == t.value
+		}
+	}
+	return found == !strings.HasPrefix(t.op, "!")
+}
+
+// queryDeclarations returns the declarations of the files to generate or all files matching terms.
+func queryDeclarations(req *pluginpb.CodeGeneratorRequest, terms []queryTerm, all bool) []queryMatch {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	regions := sourceRegions(req)
+	matches := []queryMatch{}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		comments := map[string]string{}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			comments[pathKey(loc.Path)] = loc.GetLeadingComments() + loc.GetTrailingComments()
+		}
+		// synthetic map entries and their key and value fields are not declared in the source
+		mapEntries := map[string]bool{}
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			if md, ok := desc.(*descriptorpb.DescriptorProto); ok && md.GetOptions().GetMapEntry() {
+				mapEntries[name] = true
+			}
+			if i := strings.LastIndexByte(name, '.'); mapEntries[name] || (i > 0 && mapEntries[name[:i]]) {
+				return
+			}
+			kind = strings.ReplaceAll(kind, " ", "_")
+			for _, t := range terms {
+				if !t.matches(queryValues(t.key, fd, kind, name, desc, comments[pathKey(path)])) {
+					return
+				}
+			}
+			m := queryMatch{File: fd.GetName(), Type: kind, Name: name}
+			if r := regions[fd.GetName()][name]; r != nil {
+				m.Line, m.Column = r.StartLine, r.StartColumn
+			}
+			matches = append(matches, m)
+		})
+	}
+	return matches
+}
+
+// queryValues returns the values of key for a declaration.
+func queryValues(key string, fd *descriptorpb.FileDescriptorProto, kind, name string, desc proto.Message, comment string) []string {
+	switch key {
+	case "type":
+		return []string{kind}
+	case "name":
+		return []string{name}
+	case "file":
+		return []string{fd.GetName()}
+	case "package":
+		return []string{fd.GetPackage()}
+	case "has_option":
+		return optionNames(desc)
+	case "comment":
+		if comment == "" {
+			return nil
+		}
+		return []string{comment}
+	}
+	switch d := desc.(type) {
+	case *descriptorpb.FieldDescriptorProto:
+		switch key {
+		case "label":
+			return []string{strings.ToLower(strings.TrimPrefix(d.GetLabel().String(), "LABEL_"))}
+		case "field_type":
+			return []string{fieldTypeName(d)}
+		case "number":
+			return []string{strconv.Itoa(int(d.GetNumber()))}
+		}
+	case *descriptorpb.EnumValueDescriptorProto:
+		if key == "number" {
+			return []string{strconv.Itoa(int(d.GetNumber()))}
+		}
+	}
+	return nil
+}
+
+// optionNames returns the names of the options set on a declaration: field names of
+// its options message, full names of extensions in and without parentheses and numbers.
+func optionNames(desc proto.Message) []string {
+	m := desc.ProtoReflect()
+	f := m.Descriptor().Fields().ByName("options")
+	if f == nil || !m.Has(f) {
+		return nil
+	}
+	opts := m.Get(f).Message()
+	var names []string
+	opts.Range(func(f protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if f.IsExtension() {
+			names = append(names, string(f.FullName()), "("+string(f.FullName())+")")
+		} else {
+			names = append(names, string(f.Name()))
+		}
+		names = append(names, strconv.Itoa(int(f.Number())))
+		return true
+	})
+	for b := opts.GetUnknown(); len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			break
+		}
+		b = b[n+m:]
+		names = append(names, strconv.Itoa(int(num)))
+	}
+	return names
+}
+
+func writeQueryMatches(w io.Writer, matches []queryMatch) error {
+	for _, m := range matches {
+		loc := m.File
+		if m.Line > 0 {
+			loc = fmt.Sprintf("%s:%d:%d", m.File, m.Line, m.Column)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s %s\n", loc, m.Type, m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// rawField is the json field -embed-raw adds with the original input.
+// It is not part of the messages and ignored on json input.
+const rawField = "raw_base64"
+
+// embedRaw adds the base64 encoded original input as first field of the json object out,
+// so consumers can fall back to the exact input if decoding lost data.
+func embedRaw(out, raw []byte) ([]byte, error) {
+	i := bytes.IndexByte(out, '{')
+	if i < 0 {
+		return nil, fmt.Errorf("json output is no object")
+	}
+	value, err := json.Marshal(base64.StdEncoding.EncodeToString(raw))
+	if err != nil {
+		return nil, err
+	}
+	rest := out[i+1:]
+	empty := bytes.HasPrefix(bytes.TrimSpace(rest), []byte("}"))
+	var b bytes.Buffer
+	b.Write(out[:i+1])
+	if jsonOptions.Multiline {
+		fmt.Fprintf(&b, "\n%s%q: ", jsonOptions.Indent, rawField)
+	} else {
+		fmt.Fprintf(&b, "%q:", rawField)
+	}
+	b.Write(value)
+	switch {
+	case !empty:
+		b.WriteByte(',')
+	case jsonOptions.Multiline:
+		b.WriteByte('\n')
+	}
+	b.Write(rest)
+	return b.Bytes(), nil
+}
+
+// stripRaw removes the field added by -embed-raw from json input.
+func stripRaw(js []byte) []byte {
+	if !bytes.Contains(js, []byte(`"`+rawField+`"`)) {
+		return js
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(js, &fields); err != nil {
+		// the decoder reports invalid json
+		return js
+	}
+	if _, ok := fields[rawField]; !ok {
+		return js
+	}
+	delete(fields, rawField)
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return js
+	}
+	return stripped
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("remap", func(ts *transformStep) error {
+		remaps, err := parseRemaps([]string{ts.From + "=" + ts.To})
+		if err != nil {
+			return fmt.Errorf("remap needs from and to: %v", err)
+		}
+		ts.remaps = remaps
+		return nil
+	}, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		return remapPackages(req, ts.remaps)
+	})
+}
+
+// packageRemap renames a proto package and its subpackages.
+type packageRemap struct {
+	from, to string
+}
+
+func parseRemaps(specs []string) ([]packageRemap, error) {
+	var remaps []packageRemap
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		from, to = strings.Trim(from, "."), strings.Trim(to, ".")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid remap %q, want old.pkg=new.pkg", spec)
+		}
+		remaps = append(remaps, packageRemap{from: from, to: to})
+	}
+	return remaps, nil
+}
+
+// name returns the remapped package or fully qualified name.
+func (pr packageRemap) name(name string) (string, bool) {
+	lead := ""
+	if strings.HasPrefix(name, ".") {
+		lead, name = ".", name[1:]
+	}
+	if name == pr.from {
+		return lead + pr.to, true
+	}
+	if strings.HasPrefix(name, pr.from+".") {
+		return lead + pr.to + name[len(pr.from):], true
+	}
+	return lead + name, false
+}
+
+// goPackageNames are the ways Go package names are derived from proto packages like
+// acme.foo.v1: the last two segments (foov1), all segments (acmefoov1), all joined by
+// underscores (acme_foo_v1) and the last segment (v1).
+var goPackageNames = []func(segments []string) string{
+	func(s []string) string {
+		if len(s) > 2 {
+			s = s[len(s)-2:]
+		}
+		return strings.Join(s, "")
+	},
+	func(s []string) string { return strings.Join(s, "") },
+	func(s []string) string { return strings.Join(s, "_") },
+	func(s []string) string { return s[len(s)-1] },
+}
+
+// goPackage replaces the package path of from with the one of to in the import path.
+// A package name derived from the proto package pkg is derived from the remapped one.
+func (pr packageRemap) goPackage(gp, pkg string) string {
+	ip, name, hasName := strings.Cut(gp, ";")
+	from := strings.ReplaceAll(pr.from, ".", "/")
+	to := strings.ReplaceAll(pr.to, ".", "/")
+	switch {
+	case ip == from:
+		ip = to
+	case strings.HasSuffix(ip, "/"+from):
+		ip = ip[:len(ip)-len(from)] + to
+	default:
+		ip = strings.Replace(ip, "/"+from+"/", "/"+to+"/", 1)
+	}
+	if !hasName {
+		return ip
+	}
+	if remapped, ok := pr.name(pkg); ok {
+		oldSegs, newSegs := strings.Split(pkg, "."), strings.Split(remapped, ".")
+		for _, derive := range goPackageNames {
+			if name == derive(oldSegs) {
+				name = derive(newSegs)
+				break
+			}
+		}
+	}
+	return ip + ";" + name
+}
+
+// remapPackages applies remaps to package names, type references and go_package of all files.
+// Options are resolved again to name their extensions after the remapped packages.
+func remapPackages(req *pluginpb.CodeGeneratorRequest, remaps []packageRemap) error {
+	ref := func(s *string) {
+		if s == nil {
+			return
+		}
+		for _, pr := range remaps {
+			if name, ok := pr.name(*s); ok {
+				*s = name
+				return
+			}
+		}
+	}
+	for _, fd := range req.ProtoFile {
+		for _, pr := range remaps {
+			if _, ok := pr.name(fd.GetPackage()); ok {
+				if gp := fd.GetOptions().GetGoPackage(); gp != "" {
+					fd.Options.GoPackage = proto.String(pr.goPackage(gp, fd.GetPackage()))
+				}
+				ref(fd.Package)
+				break
+			}
+		}
+		for _, f := range fd.Extension {
+			ref(f.TypeName)
+			ref(f.Extendee)
+		}
+		for _, sd := range fd.Service {
+			for _, m := range sd.Method {
+				ref(m.InputType)
+				ref(m.OutputType)
+			}
+		}
+		remapMessages(fd.MessageType, ref)
+	}
+	return resolveAgain(req)
+}
+
+func remapMessages(mds []*descriptorpb.DescriptorProto, ref func(*string)) {
+	for _, md := range mds {
+		for _, f := range md.Field {
+			ref(f.TypeName)
+			ref(f.Extendee)
+		}
+		for _, f := range md.Extension {
+			ref(f.TypeName)
+			ref(f.Extendee)
+		}
+		remapMessages(md.NestedType, ref)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Capture directories can be s3:// and gs:// urls. Captures are stored in a local
+// cache and copied with the aws and gcloud command line tools, which bring their
+// credentials and settings like AWS_ENDPOINT_URL. Object storage can not append,
+// so the index is rebuilt from the captures when a remote directory is read.
+
+func isRemote(dir string) bool {
+	return strings.HasPrefix(dir, "s3://") || strings.HasPrefix(dir, "gs://")
+}
+
+// remoteCacheDir returns the local cache of the remote directory url.
+func remoteCacheDir(url string) (string, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimRight(url, "/"), "://")
+	if rest == "" || strings.Contains("/"+rest+"/", "/../") {
+		return "", fmt.Errorf("remote capture directory %q needs a bucket", url)
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache directory for %s: %v", url, err)
+	}
+	dir := filepath.Join(base, "protoc-gen-capture", scheme, filepath.FromSlash(rest))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("cache directory for %s: %v", url, err)
+	}
+	return dir, nil
+}
+
+// runStorageTool runs the command line tool of an object storage,
+// its output is only shown if it fails.
+func runStorageTool(args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	// stdout carries the response in plugin mode
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", strings.Join(args[:3], " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// storeRemoteCapture stores the capture in the cache of url like storeCapture and uploads it.
+func storeRemoteCapture(url string, raw []byte, req *pluginpb.CodeGeneratorRequest, meta *captureMeta, module string) (*captureEntry, error) {
+	cache, err := remoteCacheDir(url)
+	if err != nil {
+		return nil, err
+	}
+	e, err := storeCapture(cache, raw, req, meta, module)
+	if err != nil {
+		return nil, err
+	}
+	local, dst := filepath.Join(cache, e.File), strings.TrimRight(url, "/")+"/"+e.File
+	if strings.HasPrefix(url, "s3://") {
+		err = runStorageTool("aws", "s3", "cp", "--only-show-errors", local, dst)
+	} else {
+		err = runStorageTool("gcloud", "storage", "cp", local, dst)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("capture could not be uploaded: %v", err)
+	}
+	return e, nil
+}
+
+// localCaptureDir returns dir or, for a remote url, its local cache after
+// downloading new captures and adding them to the index.
+func localCaptureDir(dir string) (string, error) {
+	if !isRemote(dir) {
+		return dir, nil
+	}
+	cache, err := remoteCacheDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(dir, "s3://") {
+		err = runStorageTool("aws", "s3", "sync", "--only-show-errors", dir, cache)
+	} else {
+		err = runStorageTool("gcloud", "storage", "rsync", "--recursive", dir, cache)
+	}
+	if err != nil {
+		return "", fmt.Errorf("captures could not be downloaded: %v", err)
+	}
+	n, err := indexCaptures(cache)
+	if err != nil {
+		return "", err
+	}
+	logEvent(logInfo, "sync", "url", dir, "dir", cache, "indexed", n)
+	return cache, nil
+}
+
+// indexCaptures adds the requests in dir missing in its index and returns their number.
+func indexCaptures(dir string) (int, error) {
+	indexed := map[string]bool{}
+	if _, err := os.Stat(filepath.Join(dir, captureIndex)); err == nil {
+		entries, err := readIndex(dir)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			indexed[e.File] = true
+		}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var added []*captureEntry
+	for _, f := range files {
+		if !f.Type().IsRegular() || f.Name() == captureIndex || indexed[f.Name()] || isChunk(dir, f.Name()) {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return 0, err
+		}
+		meta, payload, err := unwrapContainer(raw)
+		if err != nil {
+			continue
+		}
+		req, err := decodeRequest(payload, looksLikeJSON(payload))
+		if err != nil || len(req.ProtoFile) == 0 {
+			continue
+		}
+		var e *captureEntry
+		if meta != nil {
+			e = newCaptureEntry(meta.Time, payload, req, meta.Labels["buf_module"])
+		} else if info, err := f.Info(); err == nil {
+			e = newCaptureEntry(info.ModTime().UTC(), payload, req, "")
+		} else {
+			return 0, err
+		}
+		e.File = f.Name()
+		added = append(added, e)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	return len(added), appendToIndex(dir, added)
+}
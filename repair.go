@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("repair", nil, func(_ *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		changes := repairRequest(req)
+		for _, c := range changes {
+			log.Printf("repaired: %s\n", c)
+		}
+		logEvent(logInfo, "repair", "changes", len(changes))
+		return nil
+	})
+}
+
+// repairRequest fixes invariants of a request that hand edits tend to break
+// and returns a description of each change: imports and files to generate naming
+// a file by another path, packages missing from files referenced with one,
+// relative type names and dependency lists not matching the types and option
+// extensions a file uses.
+func repairRequest(req *pluginpb.CodeGeneratorRequest) []string {
+	var changes []string
+	changes = append(changes, repairFileNames(req)...)
+	changes = append(changes, repairPackages(req)...)
+	changes = append(changes, repairTypeNames(req)...)
+	changes = append(changes, repairDependencies(req)...)
+	if len(changes) > 0 {
+		req.ProtoFile = sortFiles(req.ProtoFile)
+	}
+	return changes
+}
+
+// repairFileNames points imports and files to generate naming a file missing in
+// the request to the only file whose path ends with the name or the other way around.
+func repairFileNames(req *pluginpb.CodeGeneratorRequest) []string {
+	present := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		present[fd.GetName()] = true
+	}
+	match := func(name string) string {
+		found := ""
+		for _, fd := range req.ProtoFile {
+			if strings.HasSuffix(fd.GetName(), "/"+name) || strings.HasSuffix(name, "/"+fd.GetName()) {
+				if found != "" {
+					return ""
+				}
+				found = fd.GetName()
+			}
+		}
+		return found
+	}
+	var changes []string
+	for i, name := range req.FileToGenerate {
+		if present[name] {
+			continue
+		}
+		if m := match(name); m != "" {
+			req.FileToGenerate[i] = m
+			changes = append(changes, fmt.Sprintf("file to generate %s is %s", name, m))
+		}
+	}
+	for _, fd := range req.ProtoFile {
+		for i, dep := range fd.Dependency {
+			if present[dep] {
+				continue
+			}
+			if m := match(dep); m != "" && m != fd.GetName() {
+				fd.Dependency[i] = m
+				changes = append(changes, fmt.Sprintf("%s imports %s instead of %s", fd.GetName(), m, dep))
+			}
+		}
+	}
+	return changes
+}
+
+// declaredTypes maps the fully qualified names of the messages and enums of files to their file.
+func declaredTypes(files []*descriptorpb.FileDescriptorProto) map[string]string {
+	types := map[string]string{}
+	var walk func(prefix string, mds []*descriptorpb.DescriptorProto, file string)
+	walk = func(prefix string, mds []*descriptorpb.DescriptorProto, file string) {
+		for _, md := range mds {
+			name := prefix + "." + md.GetName()
+			types[name] = file
+			for _, ed := range md.EnumType {
+				types[name+"."+ed.GetName()] = file
+			}
+			walk(name, md.NestedType, file)
+		}
+	}
+	for _, fd := range files {
+		prefix := ""
+		if fd.GetPackage() != "" {
+			prefix = "." + fd.GetPackage()
+		}
+		for _, ed := range fd.EnumType {
+			types[prefix+"."+ed.GetName()] = fd.GetName()
+		}
+		walk(prefix, fd.MessageType, fd.GetName())
+	}
+	return types
+}
+
+// walkTypeRefs calls fn with the scope and each type reference of fd:
+// field types, extendees and method input and output types.
+func walkTypeRefs(fd *descriptorpb.FileDescriptorProto, fn func(scope string, ref *string)) {
+	call := func(scope string, ref *string) {
+		if ref != nil {
+			fn(scope, ref)
+		}
+	}
+	pkg := fd.GetPackage()
+	for _, f := range fd.Extension {
+		call(pkg, f.TypeName)
+		call(pkg, f.Extendee)
+	}
+	for _, sd := range fd.Service {
+		for _, m := range sd.Method {
+			call(pkg, m.InputType)
+			call(pkg, m.OutputType)
+		}
+	}
+	var walk func(scope string, mds []*descriptorpb.DescriptorProto)
+	walk = func(scope string, mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			name := md.GetName()
+			if scope != "" {
+				name = scope + "." + name
+			}
+			for _, f := range md.Field {
+				call(name, f.TypeName)
+				call(name, f.Extendee)
+			}
+			for _, f := range md.Extension {
+				call(name, f.TypeName)
+				call(name, f.Extendee)
+			}
+			walk(name, md.NestedType)
+		}
+	}
+	walk(pkg, fd.MessageType)
+}
+
+// repairPackages sets the package of files without one if the other files refer
+// to their top level types with a single package.
+func repairPackages(req *pluginpb.CodeGeneratorRequest) []string {
+	types := declaredTypes(req.ProtoFile)
+	var unresolved []string
+	for _, fd := range req.ProtoFile {
+		walkTypeRefs(fd, func(_ string, ref *string) {
+			if strings.HasPrefix(*ref, ".") && types[*ref] == "" {
+				unresolved = append(unresolved, *ref)
+			}
+		})
+	}
+	var changes []string
+	for _, fd := range req.ProtoFile {
+		if fd.GetPackage() != "" {
+			continue
+		}
+		top := map[string]bool{}
+		for _, md := range fd.MessageType {
+			top[md.GetName()] = true
+		}
+		for _, ed := range fd.EnumType {
+			top[ed.GetName()] = true
+		}
+		pkgs := map[string]bool{}
+		for _, ref := range unresolved {
+			parts := strings.Split(ref[1:], ".")
+			for i := 1; i < len(parts); i++ {
+				if top[parts[i]] {
+					pkgs[strings.Join(parts[:i], ".")] = true
+					break
+				}
+			}
+		}
+		if len(pkgs) != 1 {
+			continue
+		}
+		for pkg := range pkgs {
+			fd.Package = proto.String(pkg)
+			changes = append(changes, fmt.Sprintf("%s has package %s", fd.GetName(), pkg))
+		}
+	}
+	return changes
+}
+
+// repairTypeNames qualifies relative type references like protoc resolves them,
+// from the innermost scope outwards.
+func repairTypeNames(req *pluginpb.CodeGeneratorRequest) []string {
+	types := declaredTypes(req.ProtoFile)
+	var changes []string
+	for _, fd := range req.ProtoFile {
+		walkTypeRefs(fd, func(scope string, ref *string) {
+			if *ref == "" || strings.HasPrefix(*ref, ".") {
+				return
+			}
+			for s := scope; ; {
+				full := "." + *ref
+				if s != "" {
+					full = "." + s + "." + *ref
+				}
+				if types[full] != "" {
+					changes = append(changes, fmt.Sprintf("%s refers to %s as %s", fd.GetName(), *ref, full))
+					*ref = full
+					return
+				}
+				if s == "" {
+					return
+				}
+				if i := strings.LastIndexByte(s, '.'); i >= 0 {
+					s = s[:i]
+				} else {
+					s = ""
+				}
+			}
+		})
+	}
+	return changes
+}
+
+// repairDependencies drops the imports of each file it uses no type or option
+// extension of and adds the imports of the ones it lacks. Public and weak imports
+// are kept, imports missing in the request too if the file uses types or options
+// the request does not declare.
+func repairDependencies(req *pluginpb.CodeGeneratorRequest) []string {
+	types := declaredTypes(req.ProtoFile)
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range req.ProtoFile {
+		byName[fd.GetName()] = fd
+	}
+	type extKey struct {
+		options string
+		number  int32
+	}
+	extFiles := map[extKey][]string{}
+	for _, fd := range req.ProtoFile {
+		walkExtensions(fd, func(extendee string, number int32) {
+			k := extKey{strings.TrimPrefix(extendee, "."), number}
+			extFiles[k] = append(extFiles[k], fd.GetName())
+		})
+	}
+	// exported are the files a file makes visible to its importers
+	exported := func(name string) map[string]bool {
+		files := map[string]bool{name: true}
+		queue := []string{name}
+		for len(queue) > 0 {
+			fd := byName[queue[0]]
+			queue = queue[1:]
+			for _, i := range fd.GetPublicDependency() {
+				if int(i) < len(fd.Dependency) && !files[fd.Dependency[i]] && byName[fd.Dependency[i]] != nil {
+					files[fd.Dependency[i]] = true
+					queue = append(queue, fd.Dependency[i])
+				}
+			}
+		}
+		return files
+	}
+
+	var changes []string
+	for _, fd := range req.ProtoFile {
+		name := fd.GetName()
+		used := map[string]bool{}
+		// unknown uses may come from imports missing in the request
+		unknown := false
+		walkTypeRefs(fd, func(_ string, ref *string) {
+			if file := types[*ref]; file != "" {
+				used[file] = true
+			} else if *ref != "" {
+				unknown = true
+			}
+		})
+		walkFileOptions(fd, func(_, _ string, opts protoreflect.Message) {
+			opts.Range(func(f protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+				if f.IsExtension() {
+					used[f.ParentFile().Path()] = true
+				}
+				return true
+			})
+			options := string(opts.Descriptor().FullName())
+			for b := opts.GetUnknown(); len(b) > 0; {
+				num, typ, n := protowire.ConsumeTag(b)
+				if n < 0 {
+					return
+				}
+				m := protowire.ConsumeFieldValue(num, typ, b[n:])
+				if m < 0 {
+					return
+				}
+				b = b[n+m:]
+				if files := extFiles[extKey{options, int32(num)}]; len(files) > 0 {
+					used[files[0]] = true
+				} else {
+					unknown = true
+				}
+			}
+		})
+		delete(used, name)
+
+		keep := map[int]bool{}
+		for _, i := range fd.PublicDependency {
+			keep[int(i)] = true
+		}
+		for _, i := range fd.WeakDependency {
+			keep[int(i)] = true
+		}
+		covered := map[string]bool{}
+		var deps []string
+		var public, weak []int32
+		for i, dep := range fd.Dependency {
+			visible := map[string]bool{dep: true}
+			if byName[dep] != nil {
+				visible = exported(dep)
+			}
+			needed := byName[dep] == nil && unknown
+			for file := range visible {
+				needed = needed || used[file]
+			}
+			if !needed && !keep[i] {
+				changes = append(changes, fmt.Sprintf("%s does not import unused %s", name, dep))
+				continue
+			}
+			for file := range visible {
+				covered[file] = true
+			}
+			for _, p := range fd.PublicDependency {
+				if int(p) == i {
+					public = append(public, int32(len(deps)))
+				}
+			}
+			for _, w := range fd.WeakDependency {
+				if int(w) == i {
+					weak = append(weak, int32(len(deps)))
+				}
+			}
+			deps = append(deps, dep)
+		}
+		var missing []string
+		for file := range used {
+			if !covered[file] {
+				missing = append(missing, file)
+			}
+		}
+		sort.Strings(missing)
+		for _, file := range missing {
+			deps = append(deps, file)
+			changes = append(changes, fmt.Sprintf("%s imports used %s", name, file))
+		}
+		fd.Dependency, fd.PublicDependency, fd.WeakDependency = deps, public, weak
+	}
+	return changes
+}
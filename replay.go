@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// pluginResult is the outcome of running a plugin on a request.
+type pluginResult struct {
+	stdout []byte
+	stderr []byte
+	// exitErr is set if the plugin did not exit successfully
+	exitErr error
+	// resp is nil if stdout is no valid CodeGeneratorResponse
+	resp *pluginpb.CodeGeneratorResponse
+	// resource usage of the plugin process
+	userTime, sysTime time.Duration
+	maxRSS            int64
+}
+
+// failed reports whether the plugin exited with an error or returned an error response.
+func (pr *pluginResult) failed() bool {
+	return pr.exitErr != nil || pr.resp == nil || pr.resp.GetError() != ""
+}
+
+// failure describes why the plugin failed, including stderr and the response error.
+func (pr *pluginResult) failure() string {
+	var sb bytes.Buffer
+	if pr.exitErr != nil {
+		fmt.Fprintf(&sb, "%v\n", pr.exitErr)
+	}
+	if pr.resp == nil {
+		sb.WriteString("plugin output is no CodeGeneratorResponse\n")
+	} else if msg := pr.resp.GetError(); msg != "" {
+		fmt.Fprintf(&sb, "%s\n", msg)
+	}
+	sb.Write(pr.stderr)
+	return sb.String()
+}
+
+// exitError maps the failure to an exit code,
+// exitPluginError for an error response and exitPluginFailed otherwise.
+func (pr *pluginResult) exitError() error {
+	if pr.exitErr == nil && pr.resp != nil && pr.resp.GetError() != "" {
+		return &exitError{code: exitPluginError, err: fmt.Errorf("plugin error: %s", pr.resp.GetError())}
+	}
+	return &exitError{code: exitPluginFailed, err: fmt.Errorf("plugin failed: %s", strings.TrimSpace(pr.failure()))}
+}
+
+// errorResponse is the response of the plugin if it reported an error,
+// else a response with the failure as error.
+func (pr *pluginResult) errorResponse() *pluginpb.CodeGeneratorResponse {
+	if pr.exitErr == nil && pr.resp != nil {
+		return pr.resp
+	}
+	return &pluginpb.CodeGeneratorResponse{Error: proto.String(strings.TrimSpace(pr.failure()))}
+}
+
+// runPlugin pipes the serialized request in into the plugin command argv.
+// An error is only returned if the plugin could not be run at all.
+func runPlugin(ctx context.Context, argv []string, in []byte) (*pluginResult, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no plugin given")
+	}
+	plugin := argv[0]
+	argv, err := wasmCommand(argv)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	pr := &pluginResult{
+		stdout: stdout.Bytes(),
+		stderr: stderr.Bytes(),
+	}
+	if ps := cmd.ProcessState; ps != nil {
+		pr.userTime = ps.UserTime()
+		pr.sysTime = ps.SystemTime()
+		pr.maxRSS = maxRSS(ps)
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok && ctx.Err() == nil {
+			return nil, fmt.Errorf("plugin %s could not be run: %v", plugin, err)
+		}
+		pr.exitErr = err
+	}
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if proto.Unmarshal(pr.stdout, resp) == nil {
+		pr.resp = resp
+	}
+	logEvent(logInfo, "plugin", "plugin", plugin, "bytes", len(pr.stdout), "failed", pr.failed(),
+		"user_ns", pr.userTime, "sys_ns", pr.sysTime)
+	return pr, nil
+}
+
+func init() {
+	register("replay", "run a plugin on a captured request and report the result", runReplay)
+}
+
+// runReport is the report of one replay run, e.g. in CI.
+type runReport struct {
+	Time    time.Time      `json:"time"`
+	Results []replayResult `json:"results"`
+}
+
+// replayResult describes a single plugin run.
+type replayResult struct {
+	Plugin   string        `json:"plugin"`
+	Capture  string        `json:"capture"`
+	Files    int           `json:"files"`
+	Bytes    int           `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	UserTime time.Duration `json:"user_ns"`
+	SysTime  time.Duration `json:"sys_ns"`
+	// MaxRSS is the peak resident set size of the plugin, 0 if unknown
+	MaxRSS int64  `json:"max_rss_bytes"`
+	Failed bool   `json:"failed"`
+	Error  string `json:"error,omitempty"`
+	// Changed lists the files differing from the golden response
+	Changed []string `json:"changed,omitempty"`
+
+	// want and got are the golden and the new response for -html-report
+	want, got *pluginpb.CodeGeneratorResponse
+}
+
+func runReplay(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		capture = "stdin"
+		golden  = ""
+		report  = ""
+		passErr = false
+		corpus  = ""
+		jobs    = runtime.NumCPU()
+		bench   = false
+		count   = 10
+		determ  = 0
+		cv      compilerVersionFlags
+		watch   = false
+		watchIn = ""
+		every   = 500 * time.Millisecond
+		lines   = 3
+		transf  = ""
+		htmlOut = ""
+	)
+	fs := newFlagSet("replay")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else deterministic binary proto")
+	fs.StringVar(&capture, "capture", capture, "name of the capture in the report")
+	fs.StringVar(&golden, "golden", golden, "response file to compare the plugin response with, with -corpus a directory of responses named like the captures")
+	fs.StringVar(&corpus, "corpus", corpus, "replay all captures in this directory instead of stdin and only write the report")
+	fs.IntVar(&jobs, "jobs", jobs, "only with -corpus: number of plugin runs in parallel")
+	fs.StringVar(&report, "report", report, "write a json report to this file")
+	fs.StringVar(&htmlOut, "html-report", htmlOut, "with -golden: write a standalone html page with a summary and side-by-side diffs of the changed files to this file")
+	fs.BoolVar(&bench, "bench", bench, "run the plugin -count times and print latency and memory statistics instead of the response, as json with -json-out")
+	fs.IntVar(&count, "count", count, "only with -bench: number of plugin runs")
+	fs.IntVar(&determ, "determinism-check", determ, "run the plugin this many times and report the generated files differing between runs instead of writing the response")
+	fs.StringVar(&cv.set, "set-compiler-version", cv.set, "replace the compiler version of the request with MAJOR.MINOR.PATCH[-SUFFIX]")
+	fs.BoolVar(&cv.clear, "clear-compiler-version", cv.clear, "remove the compiler version from the request")
+	fs.StringVar(&wasmRuntime, "wasm-runtime", wasmRuntime, "WASI runtime for plugins ending in .wasm, else the first of wasmtime, wazero, wasmer and wasmedge in PATH")
+	fs.StringVar(&transf, "transform", transf, "apply the operations of this yaml or json pipeline file to the request on stdin")
+	fs.BoolVar(&watch, "watch", watch, "replay again whenever the plugin binary changes and print the diff against the previous response until interrupted")
+	fs.StringVar(&watchIn, "watch-capture", watchIn, "for -watch: read the request from this file instead of stdin and also replay when it changes")
+	fs.DurationVar(&every, "watch-interval", every, "for -watch: how often to check for changes")
+	fs.IntVar(&lines, "context", lines, "for -watch and -html-report: number of context lines in the diff")
+	fs.BoolVar(&passErr, "pass-error", passErr, "write a failing plugin's error as response and exit successfully, like protoc expects it from a plugin")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture replay [ARGUMENTS] PLUGIN [PLUGIN-ARGS...] < request\n"+
+			"   or: protoc-gen-capture replay -corpus DIR [ARGUMENTS] PLUGIN [PLUGIN-ARGS...]\n"+
+			"   or: protoc-gen-capture replay -watch [-watch-capture FILE] [ARGUMENTS] PLUGIN [PLUGIN-ARGS...]\n\n"+
+			"Plugins ending in .wasm are WASI modules and run with a WASI runtime.\n"+
+			"Exits with 2 if the plugin returned an error response and 3 if it failed otherwise.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if htmlOut != "" && golden == "" {
+		return fmt.Errorf("-html-report needs -golden")
+	}
+	var pipeline transformPipeline
+	if transf != "" {
+		if corpus != "" || watchIn != "" {
+			return fmt.Errorf("-transform can not be combined with -corpus or -watch-capture")
+		}
+		var err error
+		if pipeline, err = loadTransform(transf); err != nil {
+			return err
+		}
+	}
+	if corpus != "" {
+		if bench || determ > 0 || watch {
+			return fmt.Errorf("-bench, -determinism-check and -watch can not be combined with -corpus")
+		}
+		var err error
+		if corpus, err = localCaptureDir(corpus); err != nil {
+			return err
+		}
+		if golden != "" {
+			if golden, err = localCaptureDir(golden); err != nil {
+				return err
+			}
+		}
+		return replayCorpus(fs.Args(), corpus, golden, report, htmlOut, lines, jobs, &cv)
+	}
+	if watch && watchIn != "" {
+		return watchReplay(os.Stdout, fs.Args(), nil, watchIn, every, lines, &cv)
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	if err := pipeline.apply(req); err != nil {
+		return err
+	}
+	if err := cv.apply(req); err != nil {
+		return err
+	}
+	var want *pluginpb.CodeGeneratorResponse
+	if golden != "" {
+		want, err = readResponseFile(golden)
+		if err != nil {
+			return err
+		}
+	}
+
+	if watch {
+		return watchReplay(os.Stdout, fs.Args(), req, "", every, lines, &cv)
+	}
+	if determ > 0 {
+		return checkDeterminism(os.Stdout, fs.Args(), req, determ)
+	}
+	if bench {
+		return benchReplay(fs.Args(), req, want, capture, report, count, jsonOut)
+	}
+
+	res, pr, err := replay(context.Background(), fs.Args(), req, want)
+	if err != nil {
+		return err
+	}
+	res.Capture = capture
+	if report != "" {
+		if err := writeJSONFile(report, &runReport{Time: time.Now().UTC(), Results: []replayResult{*res}}); err != nil {
+			return err
+		}
+	}
+	if htmlOut != "" {
+		if err := writeHTMLReport(htmlOut, []replayResult{*res}, lines); err != nil {
+			return err
+		}
+	}
+	resp := pr.resp
+	if res.Failed {
+		if !passErr {
+			return pr.exitError()
+		}
+		resp = pr.errorResponse()
+	}
+	out, err := encode(resp, jsonOut)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// replay runs the plugin on req and compares the response with want if it is not nil.
+func replay(ctx context.Context, argv []string, req *pluginpb.CodeGeneratorRequest, want *pluginpb.CodeGeneratorResponse) (*replayResult, *pluginResult, error) {
+	in, err := encode(req, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	pr, err := runPlugin(ctx, argv, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := &replayResult{
+		Plugin:   strings.Join(argv, " "),
+		Duration: time.Since(start),
+		UserTime: pr.userTime,
+		SysTime:  pr.sysTime,
+		MaxRSS:   pr.maxRSS,
+		Failed:   pr.failed(),
+	}
+	if res.Failed {
+		res.Error = strings.TrimSpace(pr.failure())
+	}
+	if pr.resp != nil {
+		res.Files = len(pr.resp.File)
+		for _, f := range pr.resp.File {
+			res.Bytes += len(f.GetContent())
+		}
+		if want != nil {
+			got := pr.resp
+			if isSigned(want) {
+				// compare signatures for goldens written with -content-hash
+				got = signResponse(got)
+			}
+			res.Changed = changedFiles(want, got)
+			res.want, res.got = want, got
+		}
+	}
+	return res, pr, nil
+}
+
+// replayCorpus replays all captures in dir with up to jobs plugin runs in parallel.
+// context is the number of context lines in the diffs of htmlReport.
+func replayCorpus(argv []string, dir, goldenDir, report, htmlReport string, context, jobs int, cv *compilerVersionFlags) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("corpus could not be read: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && e.Name() != captureIndex && !isChunk(dir, e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]replayResult, len(names))
+	// broken marks captures which could not be replayed, they are failed results in the report
+	broken := make([]bool, len(names))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				res, err := replayFile(argv, filepath.Join(dir, names[i]), goldenDir, cv)
+				if err != nil {
+					res = &replayResult{
+						Plugin: strings.Join(argv, " "),
+						Failed: true,
+						Error:  fmt.Sprintf("capture could not be replayed: %v", err),
+					}
+					broken[i] = true
+				}
+				res.Capture = names[i]
+				results[i] = *res
+			}
+		}()
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	failed, changed, unreplayed := 0, 0, 0
+	for i, res := range results {
+		if broken[i] {
+			unreplayed++
+			log.Printf("%s: %s\n", res.Capture, res.Error)
+		} else if res.Failed {
+			failed++
+			log.Printf("%s: %s\n", res.Capture, res.Error)
+		}
+		if len(res.Changed) > 0 {
+			changed++
+		}
+	}
+	if report != "" {
+		if err := writeJSONFile(report, &runReport{Time: time.Now().UTC(), Results: results}); err != nil {
+			return err
+		}
+	}
+	if htmlReport != "" {
+		if err := writeHTMLReport(htmlReport, results, context); err != nil {
+			return err
+		}
+	}
+	log.Printf("replayed %d captures, %d failed, %d changed, %d could not be replayed\n", len(results)-unreplayed, failed, changed, unreplayed)
+	if unreplayed > 0 {
+		return fmt.Errorf("%d of %d captures could not be replayed", unreplayed, len(results))
+	}
+	if failed > 0 {
+		return &exitError{code: exitPluginFailed, err: fmt.Errorf("plugin failed on %d of %d captures", failed, len(results))}
+	}
+	return nil
+}
+
+// replayFile replays the capture in file with the compiler version set by cv and compares it with the response
+// of the same name in goldenDir if it is set.
+func replayFile(argv []string, file, goldenDir string, cv *compilerVersionFlags) (*replayResult, error) {
+	req, err := readRequestFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := cv.apply(req); err != nil {
+		return nil, err
+	}
+	var want *pluginpb.CodeGeneratorResponse
+	if goldenDir != "" {
+		want, err = readResponseFile(filepath.Join(goldenDir, filepath.Base(file)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, _, err := replay(context.Background(), argv, req, want)
+	return res, err
+}
+
+// fileKey identifies generated content by file name and insertion point.
+type fileKey struct {
+	name, insertionPoint string
+}
+
+// responseContents maps the files of resp to their content.
+// Content for the same name and insertion point is concatenated.
+func responseContents(resp *pluginpb.CodeGeneratorResponse) map[fileKey]string {
+	m := map[fileKey]string{}
+	for _, f := range resp.File {
+		m[fileKey{f.GetName(), f.GetInsertionPoint()}] += f.GetContent()
+	}
+	return m
+}
+
+// changedKeys lists the keys added, removed or modified from a to b, sorted.
+func changedKeys(a, b map[fileKey]string) []fileKey {
+	var keys []fileKey
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].insertionPoint < keys[j].insertionPoint
+	})
+	return keys
+}
+
+// changedFiles lists the names of files added, removed or modified from a to b.
+func changedFiles(a, b *pluginpb.CodeGeneratorResponse) []string {
+	var changed []string
+	for _, k := range changedKeys(responseContents(a), responseContents(b)) {
+		if len(changed) == 0 || changed[len(changed)-1] != k.name {
+			changed = append(changed, k.name)
+		}
+	}
+	return changed
+}
+
+// readRequestFile reads a binary or json CodeGeneratorRequest, reassembling chunks for chunk manifests.
+func readRequestFile(name string) (*pluginpb.CodeGeneratorRequest, error) {
+	var raw []byte
+	var err error
+	if isChunkManifest(name) {
+		raw, err = readChunks(name)
+	} else if raw, err = os.ReadFile(name); err != nil {
+		err = fmt.Errorf("request could not be read: %v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req, err := decodeRequest(raw, looksLikeJSON(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return req, nil
+}
+
+// readResponseFile reads a binary or json CodeGeneratorResponse.
+func readResponseFile(name string) (*pluginpb.CodeGeneratorResponse, error) {
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("response could not be read: %v", err)
+	}
+	msg, err := decode(raw, false, looksLikeJSON(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return msg.(*pluginpb.CodeGeneratorResponse), nil
+}
+
+func writeJSONFile(name string, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(out, '\n'), 0o644)
+}
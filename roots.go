@@ -0,0 +1,34 @@
+package main
+
+import (
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// rootsOnly removes all files but the files to generate from req
+// and, if imports is set, the files they import directly.
+// It returns the number of removed files.
+func rootsOnly(req *pluginpb.CodeGeneratorRequest, imports bool) int {
+	roots := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		roots[name] = true
+	}
+	keep := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		if roots[fd.GetName()] {
+			keep[fd.GetName()] = true
+			for _, dep := range fd.Dependency {
+				keep[dep] = keep[dep] || imports
+			}
+		}
+	}
+	var files []*descriptorpb.FileDescriptorProto
+	for _, fd := range req.ProtoFile {
+		if keep[fd.GetName()] {
+			files = append(files, fd)
+		}
+	}
+	dropped := len(req.ProtoFile) - len(files)
+	req.ProtoFile = files
+	return dropped
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// sarifFinding is a lint issue or breaking change located by file and full name of the element.
+type sarifFinding struct {
+	File    string
+	Element string
+	Rule    string
+	Message string
+}
+
+// sarifRule is a rule id with its description.
+type sarifRule struct {
+	id, description string
+}
+
+// rulesUsage formats rules for the usage text of a command.
+func rulesUsage(rules []sarifRule, width int) string {
+	var sb strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&sb, "  %-*s%s\n", width, r.id, r.description)
+	}
+	return sb.String()
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name           string               `json:"name"`
+		InformationURI string               `json:"informationUri"`
+		Rules          []sarifReportingRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifReportingRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// sourceRegions maps the full names of the declarations of each file to their source region.
+func sourceRegions(req *pluginpb.CodeGeneratorRequest) map[string]map[string]*sarifRegion {
+	regions := map[string]map[string]*sarifRegion{}
+	for _, fd := range req.ProtoFile {
+		spans := map[string][]int32{}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			spans[pathKey(loc.Path)] = loc.Span
+		}
+		byName := map[string]*sarifRegion{}
+		walkDeclarations(fd, func(kind, name string, p []int32, desc proto.Message) {
+			span := spans[pathKey(p)]
+			if len(span) != 3 && len(span) != 4 {
+				return
+			}
+			// spans are zero based with the end column excluded, the end line is omitted if it is the start line
+			r := &sarifRegion{StartLine: int(span[0]) + 1, StartColumn: int(span[1]) + 1, EndLine: int(span[0]) + 1}
+			r.EndColumn = int(span[len(span)-1]) + 1
+			if len(span) == 4 {
+				r.EndLine = int(span[2]) + 1
+			}
+			byName[name] = r
+		})
+		regions[fd.GetName()] = byName
+	}
+	return regions
+}
+
+func pathKey(p []int32) string {
+	return fmt.Sprint(p)
+}
+
+// writeSARIF writes findings as SARIF 2.1.0 log. Elements are located in the source of req,
+// else their closest enclosing declaration or the file. prefix is prepended to the file names.
+func writeSARIF(w io.Writer, tool string, rules []sarifRule, findings []sarifFinding, req *pluginpb.CodeGeneratorRequest, prefix string) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = tool
+	run.Tool.Driver.InformationURI = "https://github.com/arnehormann/protoc-gen-capture"
+	for _, r := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifReportingRule{ID: r.id, ShortDescription: sarifMessage{r.description}})
+	}
+	regions := sourceRegions(req)
+	for _, f := range findings {
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = path.Join(prefix, f.File)
+		byName := regions[f.File]
+		for name := f.Element; name != "" && byName != nil; {
+			if r, ok := byName[name]; ok {
+				loc.PhysicalLocation.Region = r
+				break
+			}
+			i := strings.LastIndexByte(name, '.')
+			if i < 0 {
+				break
+			}
+			name = name[:i]
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.Rule,
+			Level:     "error",
+			Message:   sarifMessage{f.Element + ": " + f.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(&sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
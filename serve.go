@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func init() {
+	register("serve", "serve conversion and replay over http", runServe)
+}
+
+const serveUsage = `usage: protoc-gen-capture serve [ARGUMENTS]
+
+Endpoints:
+  POST /convert?type=request|response
+      convert the body, a request unless type=response
+  POST /replay?plugin=NAME
+      run the plugin registered with -plugin NAME=COMMAND on the request in the body
+  GET /captures
+      list the captures in -captures
+  GET /captures/NAME
+      get a capture
+  GET /metrics
+      metrics of the endpoints, captures and plugin runs in the Prometheus text format
+  POST /protoc_gen_capture.CapturePlugin/Generate
+      gRPC method CapturePlugin.Generate, needs -tls-cert and -tls-key as the
+      go standard library only speaks HTTP/2 over TLS. It stores the request
+      in -captures if set and runs the plugin named in the capture-plugin
+      metadata or returns the request wrapped like in plugin mode.
+      See the remote command for a client.
+
+Input is json for Content-Type application/json, else binary proto.
+Output is binary proto if Accept names application/x-protobuf or
+application/octet-stream, else json.
+
+Arguments:
+`
+
+type server struct {
+	captures string
+	plugins  map[string][]string
+	maxBody  int64
+	metrics  *serverMetrics
+}
+
+func runServe(args []string) error {
+	var (
+		addr     = "localhost:8080"
+		captures = ""
+		plugins  stringsFlag
+		maxBody  = int64(256 << 20)
+		certFile = ""
+		keyFile  = ""
+	)
+	fs := newFlagSet("serve")
+	fs.StringVar(&addr, "addr", addr, "address to listen on")
+	fs.StringVar(&captures, "captures", captures, "directory of captures served in /captures")
+	fs.Var(&plugins, "plugin", "register plugin NAME=COMMAND for /replay, repeatable")
+	fs.Int64Var(&maxBody, "max-body", maxBody, "maximum size of a request body in bytes")
+	fs.StringVar(&certFile, "tls-cert", certFile, "serve https and gRPC with this PEM certificate, needs -tls-key")
+	fs.StringVar(&keyFile, "tls-key", keyFile, "PEM key for -tls-cert")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, serveUsage)
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	s := &server{
+		captures: captures,
+		plugins:  map[string][]string{},
+		maxBody:  maxBody,
+		metrics:  newServerMetrics(),
+	}
+	for _, p := range plugins {
+		name, command, ok := strings.Cut(p, "=")
+		if !ok || name == "" || command == "" {
+			return fmt.Errorf("invalid -plugin %q, want NAME=COMMAND", p)
+		}
+		s.plugins[name] = strings.Fields(command)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	log.Printf("listening on %s\n", addr)
+	if certFile != "" {
+		return http.ListenAndServeTLS(addr, certFile, keyFile, s.handler())
+	}
+	return http.ListenAndServe(addr, s.handler())
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.metrics.instrument("/convert", s.convert))
+	mux.HandleFunc("/replay", s.metrics.instrument("/replay", s.replay))
+	mux.HandleFunc("/captures", s.metrics.instrument("/captures", s.listCaptures))
+	mux.HandleFunc("/captures/", s.metrics.instrument("/captures/", s.getCapture))
+	mux.HandleFunc(grpcGenerate, s.metrics.instrument(grpcGenerate, s.generate))
+	mux.HandleFunc("/metrics", s.metrics.serve)
+	return mux
+}
+
+// jsonRequested reports whether the response should be json, which is the default.
+func jsonRequested(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return !strings.Contains(accept, "application/x-protobuf") &&
+		!strings.Contains(accept, "application/protobuf") &&
+		!strings.Contains(accept, "application/octet-stream")
+}
+
+func jsonBody(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "application/json"
+}
+
+func (s *server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	bin, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return bin, true
+}
+
+func writeMessage(w http.ResponseWriter, r *http.Request, status int, msg proto.Message) {
+	asJSON := jsonRequested(r)
+	out, err := encode(msg, asJSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+func (s *server) convert(w http.ResponseWriter, r *http.Request) {
+	bin, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	reqIn := r.URL.Query().Get("type") != "response"
+	msg, err := decode(bin, reqIn, jsonBody(r))
+	if err != nil {
+		s.metrics.decodeFailed("/convert")
+		http.Error(w, fmt.Sprintf("%v\n%s", err, explainDecode(bin, reqIn, jsonBody(r))), http.StatusBadRequest)
+		return
+	}
+	writeMessage(w, r, http.StatusOK, msg)
+}
+
+func (s *server) replay(w http.ResponseWriter, r *http.Request) {
+	bin, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	plugin := r.URL.Query().Get("plugin")
+	argv, ok := s.plugins[plugin]
+	if !ok {
+		http.Error(w, "unknown plugin, register it with -plugin", http.StatusNotFound)
+		return
+	}
+	req, err := decodeRequest(bin, jsonBody(r))
+	if err != nil {
+		s.metrics.decodeFailed("/replay")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, pr, err := replay(r.Context(), argv, req, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.metrics.replayed(plugin, res.Duration, res.Failed || pr.resp == nil)
+	w.Header().Set("X-Replay-Duration", res.Duration.String())
+	if pr.resp == nil {
+		http.Error(w, res.Error, http.StatusBadGateway)
+		return
+	}
+	status := http.StatusOK
+	if res.Failed {
+		status = http.StatusUnprocessableEntity
+	}
+	writeMessage(w, r, status, pr.resp)
+}
+
+type captureInfo struct {
+	Name    string    `json:"name"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (s *server) listCaptures(w http.ResponseWriter, r *http.Request) {
+	if s.captures == "" {
+		http.Error(w, "no capture directory, set it with -captures", http.StatusNotFound)
+		return
+	}
+	entries, err := os.ReadDir(s.captures)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infos := []captureInfo{}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		infos = append(infos, captureInfo{Name: e.Name(), Bytes: fi.Size(), ModTime: fi.ModTime().UTC()})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(infos)
+}
+
+func (s *server) getCapture(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/captures/")
+	if s.captures == "" || name == "" || strings.ContainsAny(name, `/\`) || name == ".." {
+		http.NotFound(w, r)
+		return
+	}
+	bin, err := os.ReadFile(filepath.Join(s.captures, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	req, err := decodeRequest(bin, looksLikeJSON(bin))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeMessage(w, r, http.StatusOK, req)
+}
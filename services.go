@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("services", "list the services and methods with their types, streaming and options", runServices)
+}
+
+type serviceSurface struct {
+	Name    string          `json:"name"`
+	File    string          `json:"file"`
+	Options json.RawMessage `json:"options,omitempty"`
+	Methods []methodSurface `json:"methods"`
+}
+
+type methodSurface struct {
+	Name            string          `json:"name"`
+	Input           string          `json:"input"`
+	Output          string          `json:"output"`
+	ClientStreaming bool            `json:"client_streaming"`
+	ServerStreaming bool            `json:"server_streaming"`
+	Options         json.RawMessage `json:"options,omitempty"`
+
+	options string
+}
+
+// streaming describes the streaming of a method like grpc does.
+func (m *methodSurface) streaming() string {
+	switch {
+	case m.ClientStreaming && m.ServerStreaming:
+		return "bidi"
+	case m.ClientStreaming:
+		return "client"
+	case m.ServerStreaming:
+		return "server"
+	}
+	return "unary"
+}
+
+func runServices(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		all     = false
+	)
+	fs := newFlagSet("services")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	services, err := serviceSurfaces(req, all)
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(services)
+	}
+	return writeServicesTable(os.Stdout, services)
+}
+
+func serviceSurfaces(req *pluginpb.CodeGeneratorRequest, all bool) ([]serviceSurface, error) {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	services := []serviceSurface{}
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		for _, sd := range fd.Service {
+			s := serviceSurface{Name: filePrefix(fd) + sd.GetName(), File: fd.GetName(), Methods: []methodSurface{}}
+			var err error
+			if sd.Options != nil {
+				if s.Options, err = protojson.Marshal(sd.Options); err != nil {
+					return nil, fmt.Errorf("options of %s could not be encoded: %v", s.Name, err)
+				}
+			}
+			for _, md := range sd.Method {
+				m := methodSurface{
+					Name:            md.GetName(),
+					Input:           strings.TrimPrefix(md.GetInputType(), "."),
+					Output:          strings.TrimPrefix(md.GetOutputType(), "."),
+					ClientStreaming: md.GetClientStreaming(),
+					ServerStreaming: md.GetServerStreaming(),
+					options:         optionsText(md.Options, 80),
+				}
+				if md.Options != nil {
+					if m.Options, err = protojson.Marshal(md.Options); err != nil {
+						return nil, fmt.Errorf("options of %s.%s could not be encoded: %v", s.Name, m.Name, err)
+					}
+				}
+				s.Methods = append(s.Methods, m)
+			}
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
+
+func writeServicesTable(w io.Writer, services []serviceSurface) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "METHOD\tINPUT\tOUTPUT\tSTREAMING\tOPTIONS\n")
+	for _, s := range services {
+		for _, m := range s.Methods {
+			fmt.Fprintf(tw, "%s.%s\t%s\t%s\t%s\t%s\n", s.Name, m.Name, m.Input, m.Output, m.streaming(), m.options)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixScheme prefixes the path of a unix domain socket in -in and -out.
+const unixScheme = "unix://"
+
+// openStreams opens in for reading and out for writing, they are nil for stdin and stdout.
+// Files and named pipes are opened, unix://PATH connects to a unix domain socket.
+// The same socket in both is used as a single connection, like a build daemon
+// answering a stream of -batch messages.
+func openStreams(in, out string) (io.ReadCloser, io.WriteCloser, error) {
+	if in != "" && in == out && strings.HasPrefix(in, unixScheme) {
+		conn, err := dialUnix(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the connection is closed once, with the output
+		return io.NopCloser(conn), conn, nil
+	}
+	var r io.ReadCloser
+	if in != "" {
+		var err error
+		if r, err = openInput(in); err != nil {
+			return nil, nil, err
+		}
+	}
+	var w io.WriteCloser
+	if out != "" {
+		var err error
+		if w, err = openOutput(out); err != nil {
+			if r != nil {
+				r.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return r, w, nil
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if strings.HasPrefix(name, unixScheme) {
+		return dialUnix(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("input could not be opened: %v", err)
+	}
+	return f, nil
+}
+
+func openOutput(name string) (io.WriteCloser, error) {
+	if strings.HasPrefix(name, unixScheme) {
+		return dialUnix(name)
+	}
+	// named pipes are opened, not replaced
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("output could not be opened: %v", err)
+	}
+	return f, nil
+}
+
+// unixConn closes the writing side first so the peer reads to the end of the output.
+type unixConn struct {
+	*net.UnixConn
+}
+
+func (c unixConn) Close() error {
+	werr := c.CloseWrite()
+	if err := c.UnixConn.Close(); err != nil {
+		return err
+	}
+	return werr
+}
+
+func dialUnix(name string) (unixConn, error) {
+	addr := &net.UnixAddr{Name: strings.TrimPrefix(name, unixScheme), Net: "unix"}
+	conn, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		return unixConn{}, fmt.Errorf("unix socket could not be connected: %v", err)
+	}
+	return unixConn{conn}, nil
+}
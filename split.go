@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// splitManifest is the name of the request file in a split directory.
+// It is a json request where proto_file only contains the file names.
+const splitManifest = "manifest.json"
+
+// splitPath checks the file name from a request can be used inside a directory.
+func splitPath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("file name %q can not be used as path", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// splitRequest writes each file of req to dir as NAME.json or NAME.txtpb
+// and the rest of the request to the manifest.
+func splitRequest(req *pluginpb.CodeGeneratorRequest, dir, format string) error {
+	var marshal func(proto.Message) ([]byte, error)
+	switch format {
+	case "json":
+		marshal = func(m proto.Message) ([]byte, error) {
+			return encode(m, true)
+		}
+	case "txtpb":
+		marshal = prototext.MarshalOptions{Multiline: true, Indent: "\t"}.Marshal
+	default:
+		return fmt.Errorf("unknown split format %q, use json or txtpb", format)
+	}
+
+	manifest := proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+	manifest.ProtoFile = nil
+	for _, fd := range req.ProtoFile {
+		dst, err := splitPath(dir, fd.GetName())
+		if err != nil {
+			return err
+		}
+		out, err := marshal(fd)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fd.GetName(), err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst+"."+format, append(out, '\n'), 0o644); err != nil {
+			return err
+		}
+		manifest.ProtoFile = append(manifest.ProtoFile, &descriptorpb.FileDescriptorProto{Name: fd.Name})
+	}
+	out, err := encode(manifest, true)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, splitManifest), append(out, '\n'), 0o644)
+}
+
+// joinRequest reads a request written by splitRequest from dir.
+// It fails if files depend on files missing in the request.
+func joinRequest(dir string) (*pluginpb.CodeGeneratorRequest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, splitManifest))
+	if err != nil {
+		return nil, fmt.Errorf("manifest could not be read: %v", err)
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := protojson.Unmarshal(raw, req); err != nil {
+		return nil, fmt.Errorf("%s: %v", splitManifest, err)
+	}
+
+	type split struct {
+		name   string
+		raw    []byte
+		asText bool
+	}
+	files := make([]split, 0, len(req.ProtoFile))
+	for _, stub := range req.ProtoFile {
+		src, err := splitPath(dir, stub.GetName())
+		if err != nil {
+			return nil, err
+		}
+		f := split{name: stub.GetName()}
+		f.raw, err = os.ReadFile(src + ".json")
+		if os.IsNotExist(err) {
+			f.asText = true
+			f.raw, err = os.ReadFile(src + ".txtpb")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s could not be read: %v", stub.GetName(), err)
+		}
+		files = append(files, f)
+	}
+
+	// extension names can only be resolved when the descriptors are known,
+	// so the files are read a second time with a resolver
+	var resolver interface {
+		protoregistry.ExtensionTypeResolver
+		protoregistry.MessageTypeResolver
+	}
+	for pass := 0; pass < 2; pass++ {
+		req.ProtoFile = req.ProtoFile[:0]
+		for _, f := range files {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if f.asText {
+				err = prototext.UnmarshalOptions{DiscardUnknown: pass == 0, Resolver: resolver}.Unmarshal(f.raw, fd)
+			} else {
+				err = protojson.UnmarshalOptions{DiscardUnknown: pass == 0, Resolver: resolver}.Unmarshal(f.raw, fd)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.name, err)
+			}
+			if fd.GetName() != f.name {
+				return nil, fmt.Errorf("%s contains file %q", f.name, fd.GetName())
+			}
+			req.ProtoFile = append(req.ProtoFile, fd)
+		}
+		if pass == 0 {
+			if missing := missingDependencies(req); len(missing) > 0 {
+				return nil, fmt.Errorf("missing dependencies: %s", strings.Join(missing, ", "))
+			}
+			types, err := protoTypes(req.ProtoFile)
+			if err != nil {
+				return nil, fmt.Errorf("types could not be loaded: %v", err)
+			}
+			resolver = types
+		}
+	}
+	return req, nil
+}
+
+// missingDependencies lists the dependencies not contained in the request
+// as "FILE imports DEPENDENCY".
+func missingDependencies(req *pluginpb.CodeGeneratorRequest) []string {
+	present := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		present[fd.GetName()] = true
+	}
+	var missing []string
+	for _, fd := range req.ProtoFile {
+		for _, dep := range fd.Dependency {
+			if !present[dep] {
+				missing = append(missing, fd.GetName()+" imports "+dep)
+			}
+		}
+	}
+	for _, name := range req.FileToGenerate {
+		if !present[name] {
+			missing = append(missing, "file to generate "+name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("stats", "print descriptor statistics of a request as table or json", runStats)
+}
+
+type counts struct {
+	Messages   int `json:"messages"`
+	Fields     int `json:"fields"`
+	Enums      int `json:"enums"`
+	EnumValues int `json:"enum_values"`
+	Services   int `json:"services"`
+	Methods    int `json:"methods"`
+	Extensions int `json:"extensions"`
+}
+
+func (c *counts) add(o counts) {
+	c.Messages += o.Messages
+	c.Fields += o.Fields
+	c.Enums += o.Enums
+	c.EnumValues += o.EnumValues
+	c.Services += o.Services
+	c.Methods += o.Methods
+	c.Extensions += o.Extensions
+}
+
+type fileStats struct {
+	Name     string  `json:"name"`
+	Package  string  `json:"package"`
+	Generate bool    `json:"generate"`
+	Bytes    int     `json:"bytes"`
+	Share    float64 `json:"share"`
+	counts
+}
+
+type packageStats struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int    `json:"bytes"`
+	counts
+}
+
+type fieldNumber struct {
+	Field  string `json:"field"`
+	Number int32  `json:"number"`
+}
+
+type requestStats struct {
+	Files          int            `json:"files"`
+	FilesGenerated int            `json:"files_to_generate"`
+	Bytes          int            `json:"bytes"`
+	MaxDepth       int            `json:"max_nesting_depth"`
+	DeepestMessage string         `json:"deepest_message,omitempty"`
+	Total          counts         `json:"total"`
+	Packages       []packageStats `json:"packages"`
+	LargestFiles   []fileStats    `json:"largest_files"`
+	LargestNumbers []fieldNumber  `json:"largest_field_numbers"`
+}
+
+// generatedFile describes a file or insertion of a response.
+type generatedFile struct {
+	Name           string  `json:"name"`
+	InsertionPoint string  `json:"insertion_point,omitempty"`
+	Bytes          int     `json:"bytes"`
+	Lines          int     `json:"lines"`
+	Share          float64 `json:"share"`
+}
+
+type extensionStats struct {
+	Extension string  `json:"extension"`
+	Files     int     `json:"files"`
+	Bytes     int     `json:"bytes"`
+	Share     float64 `json:"share"`
+}
+
+type responseStats struct {
+	Files           int              `json:"files"`
+	InsertionPoints int              `json:"insertion_points"`
+	Bytes           int              `json:"bytes"`
+	Lines           int              `json:"lines"`
+	Error           string           `json:"error,omitempty"`
+	Extensions      []extensionStats `json:"extensions"`
+	LargestFiles    []generatedFile  `json:"largest_files"`
+	// AllFiles is sorted by name
+	AllFiles []generatedFile `json:"all_files"`
+}
+
+func runStats(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		reqIn   = true
+		top     = 10
+	)
+	fs := newFlagSet("stats")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&reqIn, "req-in", reqIn, "input is request, not response")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.IntVar(&top, "top", top, "number of entries in largest files and field numbers, 0 for all")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	if !reqIn {
+		msg, err := decode(bin, false, jsonIn)
+		if err != nil {
+			return err
+		}
+		st := newResponseStats(msg.(*pluginpb.CodeGeneratorResponse), top)
+		if jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(st)
+		}
+		return st.writeTable(os.Stdout)
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	st := newRequestStats(req, top)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(st)
+	}
+	return st.writeTable(os.Stdout)
+}
+
+func newRequestStats(req *pluginpb.CodeGeneratorRequest, top int) *requestStats {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	st := &requestStats{
+		Files:          len(req.ProtoFile),
+		FilesGenerated: len(req.FileToGenerate),
+		Bytes:          proto.Size(req),
+	}
+	var numbers []fieldNumber
+	pkgs := map[string]*packageStats{}
+	files := make([]fileStats, 0, len(req.ProtoFile))
+	for _, fd := range req.ProtoFile {
+		f := fileStats{
+			Name:     fd.GetName(),
+			Package:  fd.GetPackage(),
+			Generate: generate[fd.GetName()],
+			Bytes:    proto.Size(fd),
+		}
+		if st.Bytes > 0 {
+			f.Share = float64(f.Bytes) / float64(st.Bytes)
+		}
+		prefix := fd.GetPackage()
+		if prefix != "" {
+			prefix += "."
+		}
+		f.Enums, f.EnumValues = countEnums(fd.EnumType)
+		f.Extensions = len(fd.Extension)
+		f.Services = len(fd.Service)
+		for _, sd := range fd.Service {
+			f.Methods += len(sd.Method)
+		}
+		for _, md := range fd.MessageType {
+			st.countMessage(&f.counts, &numbers, prefix, md, 1)
+		}
+		files = append(files, f)
+
+		p := pkgs[f.Package]
+		if p == nil {
+			p = &packageStats{Name: f.Package}
+			pkgs[f.Package] = p
+		}
+		p.Files++
+		p.Bytes += f.Bytes
+		p.add(f.counts)
+		st.Total.add(f.counts)
+	}
+
+	for _, p := range pkgs {
+		st.Packages = append(st.Packages, *p)
+	}
+	sort.Slice(st.Packages, func(i, j int) bool {
+		return st.Packages[i].Name < st.Packages[j].Name
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Bytes > files[j].Bytes
+	})
+	sort.SliceStable(numbers, func(i, j int) bool {
+		return numbers[i].Number > numbers[j].Number
+	})
+	if top > 0 && len(files) > top {
+		files = files[:top]
+	}
+	if top > 0 && len(numbers) > top {
+		numbers = numbers[:top]
+	}
+	st.LargestFiles = files
+	st.LargestNumbers = numbers
+	return st
+}
+
+func countEnums(eds []*descriptorpb.EnumDescriptorProto) (enums, values int) {
+	for _, ed := range eds {
+		values += len(ed.Value)
+	}
+	return len(eds), values
+}
+
+// countMessage adds md and its nested declarations to c.
+// Synthetic map entry messages are not counted, their fields are.
+func (st *requestStats) countMessage(c *counts, numbers *[]fieldNumber, prefix string, md *descriptorpb.DescriptorProto, depth int) {
+	name := prefix + md.GetName()
+	if !md.GetOptions().GetMapEntry() {
+		c.Messages++
+		if depth > st.MaxDepth {
+			st.MaxDepth = depth
+			st.DeepestMessage = name
+		}
+	}
+	c.Fields += len(md.Field)
+	c.Extensions += len(md.Extension)
+	for _, fd := range md.Field {
+		*numbers = append(*numbers, fieldNumber{Field: name + "." + fd.GetName(), Number: fd.GetNumber()})
+	}
+	enums, values := countEnums(md.EnumType)
+	c.Enums += enums
+	c.EnumValues += values
+	for _, nested := range md.NestedType {
+		st.countMessage(c, numbers, name+".", nested, depth+1)
+	}
+}
+
+func (st *requestStats) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "files\t%d\n", st.Files)
+	fmt.Fprintf(tw, "files to generate\t%d\n", st.FilesGenerated)
+	fmt.Fprintf(tw, "request bytes\t%d\n", st.Bytes)
+	fmt.Fprintf(tw, "messages\t%d\n", st.Total.Messages)
+	fmt.Fprintf(tw, "fields\t%d\n", st.Total.Fields)
+	fmt.Fprintf(tw, "enums\t%d\n", st.Total.Enums)
+	fmt.Fprintf(tw, "enum values\t%d\n", st.Total.EnumValues)
+	fmt.Fprintf(tw, "services\t%d\n", st.Total.Services)
+	fmt.Fprintf(tw, "methods\t%d\n", st.Total.Methods)
+	fmt.Fprintf(tw, "extensions\t%d\n", st.Total.Extensions)
+	fmt.Fprintf(tw, "max nesting depth\t%d\t%s\n", st.MaxDepth, st.DeepestMessage)
+
+	fmt.Fprint(tw, "\nPACKAGE\tFILES\tBYTES\tMESSAGES\tFIELDS\tENUMS\tSERVICES\tMETHODS\tEXTENSIONS\n")
+	for _, p := range st.Packages {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			p.Name, p.Files, p.Bytes, p.Messages, p.Fields, p.Enums, p.Services, p.Methods, p.Extensions)
+	}
+
+	fmt.Fprint(tw, "\nFILE\tBYTES\tSHARE\tMESSAGES\tFIELDS\tGENERATE\n")
+	for _, f := range st.LargestFiles {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%d\t%d\t%t\n",
+			f.Name, f.Bytes, 100*f.Share, f.Messages, f.Fields, f.Generate)
+	}
+
+	fmt.Fprint(tw, "\nFIELD\tNUMBER\n")
+	for _, n := range st.LargestNumbers {
+		fmt.Fprintf(tw, "%s\t%d\n", n.Field, n.Number)
+	}
+	return tw.Flush()
+}
+
+func newResponseStats(resp *pluginpb.CodeGeneratorResponse, top int) *responseStats {
+	st := &responseStats{
+		Error:        resp.GetError(),
+		Extensions:   []extensionStats{},
+		LargestFiles: []generatedFile{},
+		AllFiles:     []generatedFile{},
+	}
+	exts := map[string]*extensionStats{}
+	for _, f := range resp.File {
+		g := generatedFile{
+			Name:           f.GetName(),
+			InsertionPoint: f.GetInsertionPoint(),
+			Bytes:          len(f.GetContent()),
+			Lines:          strings.Count(f.GetContent(), "\n"),
+		}
+		if g.InsertionPoint != "" {
+			st.InsertionPoints++
+		} else {
+			st.Files++
+		}
+		st.Bytes += g.Bytes
+		st.Lines += g.Lines
+		st.AllFiles = append(st.AllFiles, g)
+
+		ext := path.Ext(g.Name)
+		e := exts[ext]
+		if e == nil {
+			e = &extensionStats{Extension: ext}
+			exts[ext] = e
+		}
+		// insertions add to the bytes of a file, not to the files
+		if g.InsertionPoint == "" {
+			e.Files++
+		}
+		e.Bytes += g.Bytes
+	}
+	for i := range st.AllFiles {
+		if st.Bytes > 0 {
+			st.AllFiles[i].Share = float64(st.AllFiles[i].Bytes) / float64(st.Bytes)
+		}
+	}
+	for _, e := range exts {
+		if st.Bytes > 0 {
+			e.Share = float64(e.Bytes) / float64(st.Bytes)
+		}
+		st.Extensions = append(st.Extensions, *e)
+	}
+	sort.Slice(st.Extensions, func(i, j int) bool {
+		if st.Extensions[i].Bytes != st.Extensions[j].Bytes {
+			return st.Extensions[i].Bytes > st.Extensions[j].Bytes
+		}
+		return st.Extensions[i].Extension < st.Extensions[j].Extension
+	})
+	sort.SliceStable(st.AllFiles, func(i, j int) bool {
+		return st.AllFiles[i].Name < st.AllFiles[j].Name
+	})
+	st.LargestFiles = append(st.LargestFiles, st.AllFiles...)
+	sort.SliceStable(st.LargestFiles, func(i, j int) bool {
+		return st.LargestFiles[i].Bytes > st.LargestFiles[j].Bytes
+	})
+	if top > 0 && len(st.LargestFiles) > top {
+		st.LargestFiles = st.LargestFiles[:top]
+	}
+	return st
+}
+
+func (st *responseStats) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "files\t%d\n", st.Files)
+	fmt.Fprintf(tw, "insertion points\t%d\n", st.InsertionPoints)
+	fmt.Fprintf(tw, "bytes\t%d\n", st.Bytes)
+	fmt.Fprintf(tw, "lines\t%d\n", st.Lines)
+	if st.Error != "" {
+		fmt.Fprintf(tw, "error\t%s\n", st.Error)
+	}
+
+	fmt.Fprint(tw, "\nEXTENSION\tFILES\tBYTES\tSHARE\n")
+	for _, e := range st.Extensions {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\n", e.Extension, e.Files, e.Bytes, 100*e.Share)
+	}
+
+	fmt.Fprint(tw, "\nFILE\tINSERTION POINT\tBYTES\tLINES\tSHARE\n")
+	for _, f := range st.LargestFiles {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\n", f.Name, f.InsertionPoint, f.Bytes, f.Lines, 100*f.Share)
+	}
+	return tw.Flush()
+}
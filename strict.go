@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// strictDecoding makes decode fail on unknown fields, set by -strict.
+var strictDecoding = false
+
+// maxStrictReports limits the unknown fields logged by checkUnknownFields.
+const maxStrictReports = 20
+
+// checkUnknownFields logs the unknown fields left in msg decoded from the binary proto bin
+// with their byte offsets in bin and fails if there are any. Fields of extensions are
+// located inside the extension, their offsets are unknown.
+func checkUnknownFields(msg proto.Message, bin []byte) error {
+	ww := &wireWalker{}
+	ww.walk(bin, msg.ProtoReflect().Descriptor(), 0, "")
+	// resolved extensions are unknown to the walker, offsets are taken in order per path
+	offsets := map[string][]int{}
+	for _, u := range ww.unknown {
+		offsets[u.path] = append(offsets[u.path], u.offset)
+	}
+	unknown := 0
+	unknownFields(msg.ProtoReflect(), "", func(path string, num protowire.Number) {
+		unknown++
+		if unknown > maxStrictReports {
+			return
+		}
+		field := fmt.Sprintf("field %d", num)
+		where := "top level"
+		if path != "" {
+			where = path
+			field = path + "." + field
+		}
+		if o := offsets[field]; len(o) > 0 {
+			log.Printf("unknown field %d in %s at byte offset %d\n", num, where, o[0])
+			offsets[field] = o[1:]
+		} else {
+			log.Printf("unknown field %d in %s\n", num, where)
+		}
+	})
+	if unknown > maxStrictReports {
+		log.Printf("%d more unknown fields\n", unknown-maxStrictReports)
+	}
+	if unknown > 0 {
+		return fmt.Errorf("-strict: %d unknown fields in %s would be dropped in json output", unknown, msg.ProtoReflect().Descriptor().Name())
+	}
+	return nil
+}
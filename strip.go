@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	registerTransform("strip-option", func(ts *transformStep) error {
+		if len(ts.Options) == 0 {
+			return fmt.Errorf("strip-option needs options")
+		}
+		return nil
+	}, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		stripOptions(req, optionMatcher(ts.Options))
+		return nil
+	})
+}
+
+// optionMatcher matches extensions by full name or by the file declaring them
+// with path.Match patterns, or by field number.
+type optionMatcher []string
+
+func (om optionMatcher) matches(fd protoreflect.FieldDescriptor) bool {
+	for _, pattern := range om {
+		if ok, _ := path.Match(pattern, string(fd.FullName())); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, fd.ParentFile().Path()); ok {
+			return true
+		}
+		if pattern == strconv.Itoa(int(fd.Number())) {
+			return true
+		}
+	}
+	return false
+}
+
+func (om optionMatcher) matchesNumber(num protowire.Number) bool {
+	for _, pattern := range om {
+		if pattern == strconv.Itoa(int(num)) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripOptions removes matching extensions from all options in req.
+// Unresolved extensions can only be matched by number.
+func stripOptions(req *pluginpb.CodeGeneratorRequest, om optionMatcher) {
+	walkOptions(req, func(kind, name string, opts protoreflect.Message) {
+		opts.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			if fd.IsExtension() && om.matches(fd) {
+				opts.Clear(fd)
+			}
+			return true
+		})
+		var kept []byte
+		for b := opts.GetUnknown(); len(b) > 0; {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				return
+			}
+			m := protowire.ConsumeFieldValue(num, typ, b[n:])
+			if m < 0 {
+				return
+			}
+			if !om.matchesNumber(num) {
+				kept = append(kept, b[:n+m]...)
+			}
+			b = b[n+m:]
+		}
+		opts.SetUnknown(kept)
+	})
+}
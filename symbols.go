@@ -0,0 +1,63 @@
+package main
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// declarationFunc is called for each declaration of a file with its kind, full name,
+// SourceCodeInfo path and descriptor.
+type declarationFunc func(kind, name string, path []int32, desc proto.Message)
+
+// walkDeclarations calls fn for the messages including map entries, fields, extensions,
+// oneofs, enums, enum values, services and methods of fd.
+func walkDeclarations(fd *descriptorpb.FileDescriptorProto, fn declarationFunc) {
+	// child returns path extended by a field number and an index without aliasing path
+	child := func(path []int32, field, i int) []int32 {
+		return append(append(make([]int32, 0, len(path)+2), path...), int32(field), int32(i))
+	}
+	addEnums := func(prefix string, path []int32, field int, eds []*descriptorpb.EnumDescriptorProto) {
+		for i, ed := range eds {
+			epath := child(path, field, i)
+			fn("enum", prefix+ed.GetName(), epath, ed)
+			for j, v := range ed.Value {
+				// enum values are scoped like their enum
+				fn("enum value", prefix+v.GetName(), child(epath, 2, j), v)
+			}
+		}
+	}
+	addExtensions := func(prefix string, path []int32, field int, exts []*descriptorpb.FieldDescriptorProto) {
+		for i, f := range exts {
+			fn("extension", prefix+f.GetName(), child(path, field, i), f)
+		}
+	}
+	var walk func(prefix string, path []int32, field int, mds []*descriptorpb.DescriptorProto)
+	walk = func(prefix string, path []int32, field int, mds []*descriptorpb.DescriptorProto) {
+		for i, md := range mds {
+			mpath := child(path, field, i)
+			name := prefix + md.GetName()
+			fn("message", name, mpath, md)
+			for j, f := range md.Field {
+				fn("field", name+"."+f.GetName(), child(mpath, 2, j), f)
+			}
+			for j, o := range md.OneofDecl {
+				fn("oneof", name+"."+o.GetName(), child(mpath, 8, j), o)
+			}
+			addExtensions(name+".", mpath, 6, md.Extension)
+			walk(name+".", mpath, 3, md.NestedType)
+			addEnums(name+".", mpath, 4, md.EnumType)
+		}
+	}
+	prefix := filePrefix(fd)
+	walk(prefix, nil, 4, fd.MessageType)
+	addEnums(prefix, nil, 5, fd.EnumType)
+	for i, sd := range fd.Service {
+		spath := child(nil, 6, i)
+		name := prefix + sd.GetName()
+		fn("service", name, spath, sd)
+		for j, m := range sd.Method {
+			fn("method", name+"."+m.GetName(), child(spath, 2, j), m)
+		}
+	}
+	addExtensions(prefix, nil, 7, fd.Extension)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("syntax", "report the files per syntax and edition and the features they use", runSyntax)
+}
+
+// syntaxUsage counts the files of a syntax or edition and the features they use.
+type syntaxUsage struct {
+	Syntax          string `json:"syntax"`
+	Files           int    `json:"files"`
+	Generate        int    `json:"files_to_generate"`
+	Groups          int    `json:"groups"`
+	Required        int    `json:"required_fields"`
+	Extensions      int    `json:"extensions"`
+	ExtensionRanges int    `json:"extension_ranges"`
+	Proto3Optional  int    `json:"proto3_optional_fields"`
+}
+
+type syntaxReport struct {
+	Syntaxes []*syntaxUsage `json:"syntaxes"`
+	// Unexpected are files with a syntax none of the files to generate uses.
+	Unexpected []unexpectedSyntax `json:"unexpected"`
+}
+
+type unexpectedSyntax struct {
+	File   string `json:"file"`
+	Syntax string `json:"syntax"`
+}
+
+func runSyntax(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+	)
+	fs := newFlagSet("syntax")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	r := newSyntaxReport(req)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(r)
+	}
+	return r.writeTable(os.Stdout)
+}
+
+// fileSyntax returns the syntax of fd or the edition for files using editions.
+func fileSyntax(fd *descriptorpb.FileDescriptorProto) string {
+	if fd.GetSyntax() == "" {
+		return "proto2"
+	}
+	if fd.GetSyntax() != editionsSyntax {
+		return fd.GetSyntax()
+	}
+	edition, _ := wireVarint(fd.ProtoReflect().GetUnknown(), fileEditionField)
+	switch edition {
+	case edition2023:
+		return "edition 2023"
+	case edition2024:
+		return "edition 2024"
+	}
+	return fmt.Sprintf("edition %d", edition)
+}
+
+func newSyntaxReport(req *pluginpb.CodeGeneratorRequest) *syntaxReport {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	r := &syntaxReport{Syntaxes: []*syntaxUsage{}, Unexpected: []unexpectedSyntax{}}
+	bySyntax := map[string]*syntaxUsage{}
+	for _, fd := range req.ProtoFile {
+		syntax := fileSyntax(fd)
+		u := bySyntax[syntax]
+		if u == nil {
+			u = &syntaxUsage{Syntax: syntax}
+			bySyntax[syntax] = u
+			r.Syntaxes = append(r.Syntaxes, u)
+		}
+		u.Files++
+		if generate[fd.GetName()] {
+			u.Generate++
+		}
+		countSyntaxFeatures(u, fd)
+	}
+	for _, fd := range req.ProtoFile {
+		if syntax := fileSyntax(fd); bySyntax[syntax].Generate == 0 && len(req.FileToGenerate) > 0 {
+			r.Unexpected = append(r.Unexpected, unexpectedSyntax{File: fd.GetName(), Syntax: syntax})
+		}
+	}
+	return r
+}
+
+// countSyntaxFeatures adds the groups, required fields and extensions of fd to u.
+// For editions, groups are delimited message fields and required fields have legacy required presence.
+func countSyntaxFeatures(u *syntaxUsage, fd *descriptorpb.FileDescriptorProto) {
+	editions := fd.GetSyntax() == editionsSyntax
+	fields := func(parent featureSet, fds []*descriptorpb.FieldDescriptorProto) {
+		for _, f := range fds {
+			fs := parent.with(f.Options, fieldFeatures)
+			if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP ||
+				(editions && fs.encoding == encodingDelimited && f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE) {
+				u.Groups++
+			}
+			if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED ||
+				(editions && fs.presence == presenceLegacyRequired) {
+				u.Required++
+			}
+			if f.GetProto3Optional() {
+				u.Proto3Optional++
+			}
+		}
+	}
+	var walk func(parent featureSet, mds []*descriptorpb.DescriptorProto)
+	walk = func(parent featureSet, mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			ms := parent.with(md.Options, messageFeatures)
+			u.Extensions += len(md.Extension)
+			u.ExtensionRanges += len(md.ExtensionRange)
+			fields(ms, md.Field)
+			fields(ms, md.Extension)
+			walk(ms, md.NestedType)
+		}
+	}
+	file := edition2023Features.with(fd.Options, fileFeatures)
+	u.Extensions += len(fd.Extension)
+	fields(file, fd.Extension)
+	walk(file, fd.MessageType)
+}
+
+func (r *syntaxReport) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "SYNTAX\tFILES\tGENERATE\tGROUPS\tREQUIRED\tEXTENSIONS\tEXTENSION RANGES\tPROTO3 OPTIONAL\n")
+	for _, u := range r.Syntaxes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			u.Syntax, u.Files, u.Generate, u.Groups, u.Required, u.Extensions, u.ExtensionRanges, u.Proto3Optional)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Unexpected) == 0 {
+		return nil
+	}
+	fmt.Fprint(w, "\nsyntax not used by the files to generate:\n")
+	for _, u := range r.Unexpected {
+		if _, err := fmt.Fprintf(w, "  %s (%s)\n", u.File, u.Syntax); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// teeInput writes the input as received to each destination, a directory if it
+// ends with a slash or exists, else a file. Failures are only logged, so
+// the output for protoc does not change.
+func teeInput(dests []string, raw []byte) {
+	for _, dest := range dests {
+		name := dest
+		if fi, err := os.Stat(dest); strings.HasSuffix(dest, "/") || (err == nil && fi.IsDir()) {
+			sum := sha256.Sum256(raw)
+			name = filepath.Join(dest, time.Now().UTC().Format("20060102T150405.000000000Z")+"-"+hex.EncodeToString(sum[:])[:12]+".proto.msg")
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			log.Printf("warning: tee to %s failed: %v\n", dest, err)
+			continue
+		}
+		if err := os.WriteFile(name, raw, 0o644); err != nil {
+			log.Printf("warning: tee to %s failed: %v\n", dest, err)
+			continue
+		}
+		logEvent(logInfo, "tee", "file", name, "bytes", len(raw))
+	}
+}
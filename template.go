@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// namedMessage is a message with its full name, for templates.
+type namedMessage struct {
+	FullName string
+	*descriptorpb.DescriptorProto
+}
+
+// namedEnum is an enum with its full name, for templates.
+type namedEnum struct {
+	FullName string
+	*descriptorpb.EnumDescriptorProto
+}
+
+// namedService is a service with its full name, for templates.
+type namedService struct {
+	FullName string
+	*descriptorpb.ServiceDescriptorProto
+}
+
+// templateFuncs are the helpers available in -template.
+func templateFuncs(req *pluginpb.CodeGeneratorRequest) template.FuncMap {
+	return template.FuncMap{
+		// generated lists the files to generate
+		"generated": func() []*descriptorpb.FileDescriptorProto {
+			byName := map[string]*descriptorpb.FileDescriptorProto{}
+			for _, fd := range req.GetProtoFile() {
+				byName[fd.GetName()] = fd
+			}
+			var files []*descriptorpb.FileDescriptorProto
+			for _, name := range req.GetFileToGenerate() {
+				if fd := byName[name]; fd != nil {
+					files = append(files, fd)
+				}
+			}
+			return files
+		},
+		"messages": templateMessages,
+		"fields": func(m interface{}) ([]*descriptorpb.FieldDescriptorProto, error) {
+			switch m := m.(type) {
+			case namedMessage:
+				return m.Field, nil
+			case *descriptorpb.DescriptorProto:
+				return m.Field, nil
+			}
+			return nil, fmt.Errorf("fields: %T is no message", m)
+		},
+		"enums":    templateEnums,
+		"services": templateServices,
+		"methods": func(s interface{}) ([]*descriptorpb.MethodDescriptorProto, error) {
+			switch s := s.(type) {
+			case namedService:
+				return s.Method, nil
+			case *descriptorpb.ServiceDescriptorProto:
+				return s.Method, nil
+			}
+			return nil, fmt.Errorf("methods: %T is no service", s)
+		},
+		"typeName": templateTypeName,
+		"json": func(m proto.Message) (string, error) {
+			out, err := protojson.Marshal(m)
+			return string(out), err
+		},
+		"join":       strings.Join,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trimPrefix": strings.TrimPrefix,
+		"replace":    strings.ReplaceAll,
+	}
+}
+
+func filePrefix(fd *descriptorpb.FileDescriptorProto) string {
+	if fd.GetPackage() == "" {
+		return ""
+	}
+	return fd.GetPackage() + "."
+}
+
+// templateMessages lists all messages of fd including nested ones, without map entries.
+func templateMessages(fd *descriptorpb.FileDescriptorProto) []namedMessage {
+	var msgs []namedMessage
+	var walk func(prefix string, mds []*descriptorpb.DescriptorProto)
+	walk = func(prefix string, mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			if md.GetOptions().GetMapEntry() {
+				continue
+			}
+			name := prefix + md.GetName()
+			msgs = append(msgs, namedMessage{FullName: name, DescriptorProto: md})
+			walk(name+".", md.NestedType)
+		}
+	}
+	walk(filePrefix(fd), fd.MessageType)
+	return msgs
+}
+
+// templateEnums lists all enums of fd including nested ones.
+func templateEnums(fd *descriptorpb.FileDescriptorProto) []namedEnum {
+	var enums []namedEnum
+	for _, ed := range fd.EnumType {
+		enums = append(enums, namedEnum{FullName: filePrefix(fd) + ed.GetName(), EnumDescriptorProto: ed})
+	}
+	for _, m := range templateMessages(fd) {
+		for _, ed := range m.EnumType {
+			enums = append(enums, namedEnum{FullName: m.FullName + "." + ed.GetName(), EnumDescriptorProto: ed})
+		}
+	}
+	return enums
+}
+
+func templateServices(fd *descriptorpb.FileDescriptorProto) []namedService {
+	var svcs []namedService
+	for _, sd := range fd.Service {
+		svcs = append(svcs, namedService{FullName: filePrefix(fd) + sd.GetName(), ServiceDescriptorProto: sd})
+	}
+	return svcs
+}
+
+// templateTypeName describes the type of a field like in a proto file.
+func templateTypeName(f *descriptorpb.FieldDescriptorProto) string {
+	name := strings.TrimPrefix(f.GetTypeName(), ".")
+	if name == "" {
+		name = strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+	}
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		name = "repeated " + name
+	}
+	return name
+}
+
+// loadTemplate parses the template file name for -template.
+// The helpers are bound to the request when it is rendered.
+func loadTemplate(name string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(name)).Funcs(templateFuncs(nil)).ParseFiles(name)
+	if err != nil {
+		return nil, fmt.Errorf("template could not be loaded: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes tmpl with msg as data.
+func renderTemplate(tmpl *template.Template, msg proto.Message) ([]byte, error) {
+	req, _ := msg.(*pluginpb.CodeGeneratorRequest)
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("template error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Funcs(templateFuncs(req)).Execute(&buf, msg); err != nil {
+		return nil, fmt.Errorf("template error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,6 @@
+
+5cmd/protoc-gen-go/testdata/extensions/base/base.protogoproto.protoc.extension.base"3
+BaseMessage
+field (	Rfield*
+*"+
+MessageSetWireFormatMessage*d:BGZEgoogle.golang.org/protobuf/cmd/protoc-gen-go/testdata/extensions/base
\ No newline at end of file
@@ -0,0 +1,4 @@
+
+7cmd/protoc-gen-go/testdata/extensions/extra/extra.protogoproto.protoc.extension.extra""
+ExtraMessage
+data (RdataBHZFgoogle.golang.org/protobuf/cmd/protoc-gen-go/testdata/extensions/extra
\ No newline at end of file
@@ -0,0 +1,3 @@
+
+/internal/testprotos/annotation/annotation.protogo_annotation google/protobuf/descriptor.proto:J
+track_field_use.google.protobuf.MessageOptions (RtrackFieldUseB;Z9google.golang.org/protobuf/internal/testprotos/annotation
\ No newline at end of file
@@ -0,0 +1,108 @@
+
+/internal/testprotos/fieldtrack/fieldtrack.protogoproto.proto.test/internal/testprotos/annotation/annotation.proto#internal/testprotos/test/test.proto.internal/testprotos/test/weak1/test_weak.proto.internal/testprotos/test/weak2/test_weak.proto"$
+TestFieldTrack%
+optional_int32 (RoptionalInt32%
+optional_int64 (RoptionalInt64'
+optional_uint32 (RoptionalUint32'
+optional_uint64 (RoptionalUint64'
+optional_sint32 (RoptionalSint32'
+optional_sint64 (RoptionalSint64)
+optional_fixed32 (RoptionalFixed32)
+optional_fixed64 (RoptionalFixed64+
+optional_sfixed32	 (RoptionalSfixed32+
+optional_sfixed64
+ (RoptionalSfixed64%
+optional_float (RoptionalFloat'
+optional_double (RoptionalDouble#
+optional_bool (RoptionalBool'
+optional_string (	RoptionalString%
+optional_bytes (RoptionalBytesP
+optional_enum (2+.goproto.proto.test.TestAllTypes.NestedEnumRoptionalEnumY
+optional_message (2..goproto.proto.test.TestAllTypes.NestedMessageRoptionalMessage%
+repeated_int32 (RrepeatedInt32%
+repeated_int64 (RrepeatedInt64'
+repeated_uint32 (RrepeatedUint32'
+repeated_uint64 (RrepeatedUint64'
+repeated_sint32 (RrepeatedSint32'
+repeated_sint64 (RrepeatedSint64)
+repeated_fixed32 (RrepeatedFixed32)
+repeated_fixed64 (RrepeatedFixed64+
+repeated_sfixed32 (RrepeatedSfixed32+
+repeated_sfixed64 (RrepeatedSfixed64%
+repeated_float (RrepeatedFloat'
+repeated_double  (RrepeatedDouble#
+repeated_bool! (RrepeatedBool'
+repeated_string" (	RrepeatedString%
+repeated_bytes# (RrepeatedBytesP
+repeated_enum$ (2+.goproto.proto.test.TestAllTypes.NestedEnumRrepeatedEnumY
+repeated_message% (2..goproto.proto.test.TestAllTypes.NestedMessageRrepeatedMessage`
+map_string_int32) (26.goproto.proto.test.TestFieldTrack.MapStringInt32EntryRmapStringInt32`
+map_string_int64* (26.goproto.proto.test.TestFieldTrack.MapStringInt64EntryRmapStringInt64c
+map_string_uint32+ (27.goproto.proto.test.TestFieldTrack.MapStringUint32EntryRmapStringUint32c
+map_string_uint64, (27.goproto.proto.test.TestFieldTrack.MapStringUint64EntryRmapStringUint64c
+map_string_sint32- (27.goproto.proto.test.TestFieldTrack.MapStringSint32EntryRmapStringSint32c
+map_string_sint64. (27.goproto.proto.test.TestFieldTrack.MapStringSint64EntryRmapStringSint64f
+map_string_fixed32/ (28.goproto.proto.test.TestFieldTrack.MapStringFixed32EntryRmapStringFixed32f
+map_string_fixed640 (28.goproto.proto.test.TestFieldTrack.MapStringFixed64EntryRmapStringFixed64i
+map_string_sfixed321 (29.goproto.proto.test.TestFieldTrack.MapStringSfixed32EntryRmapStringSfixed32i
+map_string_sfixed642 (29.goproto.proto.test.TestFieldTrack.MapStringSfixed64EntryRmapStringSfixed64`
+map_string_float3 (26.goproto.proto.test.TestFieldTrack.MapStringFloatEntryRmapStringFloatc
+map_string_double4 (27.goproto.proto.test.TestFieldTrack.MapStringDoubleEntryRmapStringDouble]
+map_string_bool5 (25.goproto.proto.test.TestFieldTrack.MapStringBoolEntryRmapStringBoolc
+map_string_string6 (27.goproto.proto.test.TestFieldTrack.MapStringStringEntryRmapStringString`
+map_string_bytes7 (26.goproto.proto.test.TestFieldTrack.MapStringBytesEntryRmapStringBytes]
+map_string_enum8 (25.goproto.proto.test.TestFieldTrack.MapStringEnumEntryRmapStringEnumf
+map_string_message9 (28.goproto.proto.test.TestFieldTrack.MapStringMessageEntryRmapStringMessageT
+weak_message1d (2+.goproto.proto.test.weak.WeakImportMessage1BPRweakMessage1T
+weak_message2e (2+.goproto.proto.test.weak.WeakImportMessage2BPRweakMessage2A
+MapStringInt32Entry
+key (	Rkey
+value (Rvalue:8A
+MapStringInt64Entry
+key (	Rkey
+value (Rvalue:8B
+MapStringUint32Entry
+key (	Rkey
+value (Rvalue:8B
+MapStringUint64Entry
+key (	Rkey
+value (Rvalue:8B
+MapStringSint32Entry
+key (	Rkey
+value (Rvalue:8B
+MapStringSint64Entry
+key (	Rkey
+value (Rvalue:8C
+MapStringFixed32Entry
+key (	Rkey
+value (Rvalue:8C
+MapStringFixed64Entry
+key (	Rkey
+value (Rvalue:8D
+MapStringSfixed32Entry
+key (	Rkey
+value (Rvalue:8D
+MapStringSfixed64Entry
+key (	Rkey
+value (Rvalue:8A
+MapStringFloatEntry
+key (	Rkey
+value (Rvalue:8B
+MapStringDoubleEntry
+key (	Rkey
+value (Rvalue:8@
+MapStringBoolEntry
+key (	Rkey
+value (Rvalue:8B
+MapStringStringEntry
+key (	Rkey
+value (	Rvalue:8A
+MapStringBytesEntry
+key (	Rkey
+value (Rvalue:8m
+MapStringEnumEntry
+key (	RkeyA
+value (2+.goproto.proto.test.TestAllTypes.NestedEnumRvalue:8s
+MapStringMessageEntry
+key (	RkeyD
+value (2..goproto.proto.test.TestAllTypes.NestedMessageRvalue:8:͎B;Z9google.golang.org/protobuf/internal/testprotos/fieldtrackXX
\ No newline at end of file
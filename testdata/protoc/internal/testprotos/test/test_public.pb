@@ -0,0 +1,3 @@
+
+*internal/testprotos/test/test_public.protogoproto.proto.test"
+PublicImportMessageB5Z3google.golang.org/protobuf/internal/testprotos/test
\ No newline at end of file
@@ -0,0 +1,4 @@
+
+.internal/testprotos/test/weak1/test_weak.protogoproto.proto.test.weak""
+WeakImportMessage1
+a (RaB;Z9google.golang.org/protobuf/internal/testprotos/test/weak1
\ No newline at end of file
@@ -0,0 +1,4 @@
+
+.internal/testprotos/test/weak2/test_weak.protogoproto.proto.test.weak""
+WeakImportMessage2
+a (RaB;Z9google.golang.org/protobuf/internal/testprotos/test/weak2
\ No newline at end of file
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// transformStep is one operation of a transformation pipeline.
+type transformStep struct {
+	Op string `json:"op"`
+	// Files limits strip-source-info, redact and obfuscate to matching files, for filter
+	// it keeps the matching files to generate.
+	Files []string `json:"files"`
+	// Exclude drops matching files to generate in filter.
+	Exclude []string `json:"exclude"`
+	// From and To rename a package in remap.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Value replaces the parameter, Append adds a comma separated option in set-parameter.
+	// Value is the version in compiler-version, without it the version is removed.
+	Value  *string `json:"value"`
+	Append string  `json:"append"`
+	// Patterns are replaced by Replacement in comments and the parameter in redact,
+	// without Patterns all comments are removed.
+	Patterns    []string `json:"patterns"`
+	Replacement string   `json:"replacement"`
+	// Options are removed by strip-option, like -strip-option.
+	Options []string `json:"options"`
+	// Mapping receives the pseudonyms of obfuscate, encrypted with the key in
+	// the environment variable KeyEnv if it is set.
+	Mapping string `json:"mapping"`
+	KeyEnv  string `json:"key_env"`
+	// Expr is the CEL expression of filter-expr and map-expr.
+	Expr string `json:"expr"`
+	// Paths are the directories fix-deps reads files written by -split from.
+	Paths []string `json:"paths"`
+
+	// origin names the step in errors, the flag or the step of the -transform file
+	origin   string
+	patterns []*regexp.Regexp
+	remaps   []packageRemap
+	expr     celExpr
+	version  *pluginpb.Version
+}
+
+// transformPipeline is an ordered list of operations applied to a request.
+type transformPipeline []transformStep
+
+// transformOp is an operation steps can use. check validates the arguments of a step
+// and prepares its unexported fields, it is not called for steps created by flags
+// with prepared fields.
+type transformOp struct {
+	check func(ts *transformStep) error
+	apply func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error
+}
+
+var transformOps = map[string]transformOp{}
+
+// registerTransform adds an operation for pipelines. Transformations are added as
+// operations instead of flags of the plugin mode, flags only add steps.
+func registerTransform(name string, check func(ts *transformStep) error, apply func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error) {
+	if check == nil {
+		check = func(*transformStep) error { return nil }
+	}
+	transformOps[name] = transformOp{check: check, apply: apply}
+}
+
+func init() {
+	registerTransform("strip-source-info", nil, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		for _, fd := range req.ProtoFile {
+			if ts.inScope(fd) {
+				fd.SourceCodeInfo = nil
+			}
+		}
+		return nil
+	})
+	registerTransform("filter", func(ts *transformStep) error {
+		if len(ts.Files) == 0 && len(ts.Exclude) == 0 {
+			return fmt.Errorf("filter needs files or exclude")
+		}
+		return nil
+	}, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		var kept []string
+		for _, name := range req.FileToGenerate {
+			if (len(ts.Files) == 0 || matchFile(ts.Files, name)) && !matchFile(ts.Exclude, name) {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("filter left no files to generate")
+		}
+		req.FileToGenerate = kept
+		return nil
+	})
+	registerTransform("set-parameter", func(ts *transformStep) error {
+		if ts.Value == nil && ts.Append == "" {
+			return fmt.Errorf("set-parameter needs value or append")
+		}
+		return nil
+	}, func(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+		if ts.Value != nil {
+			req.Parameter = proto.String(*ts.Value)
+		}
+		if ts.Append != "" {
+			param := ts.Append
+			if req.GetParameter() != "" {
+				param = req.GetParameter() + "," + param
+			}
+			req.Parameter = &param
+		}
+		return nil
+	})
+	registerTransform("redact", func(ts *transformStep) error {
+		if ts.Replacement == "" {
+			ts.Replacement = "REDACTED"
+		}
+		for _, p := range ts.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", p, err)
+			}
+			ts.patterns = append(ts.patterns, re)
+		}
+		return nil
+	}, applyRedact)
+}
+
+// transformOpNames returns the sorted names of the operations.
+func transformOpNames() []string {
+	names := make([]string, 0, len(transformOps))
+	for name := range transformOps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadTransform reads a pipeline from a json file or a yaml file using block
+// sequences of mappings with scalar and list values.
+func loadTransform(name string) (transformPipeline, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var raw []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &raw)
+	} else {
+		raw, err = parseYAMLSteps(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	pipeline := make(transformPipeline, len(raw))
+	for i, r := range raw {
+		dec := json.NewDecoder(bytes.NewReader(r))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&pipeline[i]); err != nil {
+			return nil, fmt.Errorf("%s: step %d: %s", name, i+1, strings.TrimPrefix(err.Error(), "json: "))
+		}
+		if err := pipeline[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: step %d: %v", name, i+1, err)
+		}
+		pipeline[i].origin = fmt.Sprintf("transform step %d", i+1)
+	}
+	return pipeline, nil
+}
+
+// parseYAMLSteps converts the supported yaml subset to json objects.
+func parseYAMLSteps(data string) ([]json.RawMessage, error) {
+	var steps []map[string]interface{}
+	stepIndent := -1
+	listKey := ""
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can not be used for indentation", n+1)
+		}
+		indent := len(line) - len(text)
+		item := text == "-" || strings.HasPrefix(text, "- ")
+		switch {
+		case item && (stepIndent < 0 || indent == stepIndent):
+			stepIndent = indent
+			steps = append(steps, map[string]interface{}{})
+			listKey = ""
+			text = strings.TrimSpace(strings.TrimPrefix(text, "-"))
+			if text == "" {
+				continue
+			}
+		case len(steps) == 0 || indent <= stepIndent:
+			return nil, fmt.Errorf("line %d: want a list of steps starting with -", n+1)
+		case item && listKey != "":
+			list := steps[len(steps)-1][listKey].([]string)
+			steps[len(steps)-1][listKey] = append(list, yamlScalar(strings.TrimSpace(text[1:])))
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \"'") {
+			return nil, fmt.Errorf("line %d: want KEY: VALUE", n+1)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			steps[len(steps)-1][key] = []string{}
+			listKey = key
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("line %d: lists must end on the same line", n+1)
+			}
+			list := []string{}
+			if inner := strings.TrimSpace(value[1 : len(value)-1]); inner != "" {
+				for _, v := range strings.Split(inner, ",") {
+					list = append(list, yamlScalar(strings.TrimSpace(v)))
+				}
+			}
+			steps[len(steps)-1][key] = list
+			listKey = ""
+		default:
+			steps[len(steps)-1][key] = yamlScalar(value)
+			listKey = ""
+		}
+	}
+	raw := make([]json.RawMessage, len(steps))
+	for i, step := range steps {
+		var err error
+		if raw[i], err = json.Marshal(step); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// stripYAMLComment removes a comment outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar unquotes a scalar.
+func yamlScalar(s string) string {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// check validates the arguments of a step.
+func (ts *transformStep) check() error {
+	for _, pattern := range append(append([]string{}, ts.Files...), ts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q", pattern)
+		}
+	}
+	op, ok := transformOps[ts.Op]
+	if !ok {
+		return fmt.Errorf("unknown op %q, want one of %s", ts.Op, strings.Join(transformOpNames(), ", "))
+	}
+	return op.check(ts)
+}
+
+// inScope reports whether the step applies to fd, all files without Files.
+func (ts *transformStep) inScope(fd *descriptorpb.FileDescriptorProto) bool {
+	return len(ts.Files) == 0 || matchFile(ts.Files, fd.GetName())
+}
+
+// matchFile reports whether name matches one of patterns.
+func matchFile(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs the steps in order.
+func (tp transformPipeline) apply(req *pluginpb.CodeGeneratorRequest) error {
+	for i := range tp {
+		ts := &tp[i]
+		if err := transformOps[ts.Op].apply(ts, req); err != nil {
+			return fmt.Errorf("%s: %v", ts.origin, err)
+		}
+	}
+	return nil
+}
+
+// applyRedact replaces the patterns of a redact step in comments and the parameter.
+func applyRedact(ts *transformStep, req *pluginpb.CodeGeneratorRequest) error {
+	redact := func(s string) string {
+		for _, re := range ts.patterns {
+			s = re.ReplaceAllLiteralString(s, ts.Replacement)
+		}
+		return s
+	}
+	for _, fd := range req.ProtoFile {
+		if !ts.inScope(fd) {
+			continue
+		}
+		for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+			if len(ts.patterns) == 0 {
+				loc.LeadingComments, loc.TrailingComments, loc.LeadingDetachedComments = nil, nil, nil
+				continue
+			}
+			if loc.LeadingComments != nil {
+				*loc.LeadingComments = redact(*loc.LeadingComments)
+			}
+			if loc.TrailingComments != nil {
+				*loc.TrailingComments = redact(*loc.TrailingComments)
+			}
+			for j, c := range loc.LeadingDetachedComments {
+				loc.LeadingDetachedComments[j] = redact(c)
+			}
+		}
+	}
+	if req.Parameter != nil && len(ts.patterns) > 0 {
+		*req.Parameter = redact(*req.Parameter)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	register("trend", "summarize replay reports of successive runs as time series", runTrend)
+}
+
+// trendPoint aggregates the results of one plugin in one run.
+type trendPoint struct {
+	Time     time.Time     `json:"time"`
+	Captures int           `json:"captures"`
+	Failed   int           `json:"failed"`
+	Changed  int           `json:"changed"`
+	Files    int           `json:"files"`
+	Bytes    int           `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	CPU      time.Duration `json:"cpu_ns"`
+	MaxRSS   int64         `json:"max_rss_bytes"`
+}
+
+// pluginTrend is the time series of a plugin over all runs.
+type pluginTrend struct {
+	Plugin string  `json:"plugin"`
+	Runs   int     `json:"runs"`
+	Growth float64 `json:"bytes_growth"`
+	// DiffFrequency is the share of runs with changed output
+	DiffFrequency float64       `json:"diff_frequency"`
+	MeanDuration  time.Duration `json:"mean_duration_ns"`
+	Series        []trendPoint  `json:"series"`
+}
+
+func runTrend(args []string) error {
+	var (
+		jsonOut = false
+		series  = false
+	)
+	fs := newFlagSet("trend")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&series, "series", series, "print every run in table output")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture trend [ARGUMENTS] REPORT.json...\n\n")
+		fmt.Fprint(os.Stdout, "Reports are written by replay -report.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+
+	var reports []runReport
+	for _, name := range fs.Args() {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var r runReport
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		reports = append(reports, r)
+	}
+	trends := newTrends(reports)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(trends)
+	}
+	return writeTrendTable(os.Stdout, trends, series)
+}
+
+func newTrends(reports []runReport) []pluginTrend {
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Time.Before(reports[j].Time)
+	})
+	byPlugin := map[string]*pluginTrend{}
+	var plugins []string
+	for _, r := range reports {
+		points := map[string]*trendPoint{}
+		for _, res := range r.Results {
+			p := points[res.Plugin]
+			if p == nil {
+				p = &trendPoint{Time: r.Time}
+				points[res.Plugin] = p
+			}
+			p.Captures++
+			if res.Failed {
+				p.Failed++
+			}
+			if len(res.Changed) > 0 {
+				p.Changed++
+			}
+			p.Files += res.Files
+			p.Bytes += res.Bytes
+			p.Duration += res.Duration
+			p.CPU += res.UserTime + res.SysTime
+			if res.MaxRSS > p.MaxRSS {
+				p.MaxRSS = res.MaxRSS
+			}
+		}
+		for plugin, p := range points {
+			t := byPlugin[plugin]
+			if t == nil {
+				t = &pluginTrend{Plugin: plugin}
+				byPlugin[plugin] = t
+				plugins = append(plugins, plugin)
+			}
+			t.Series = append(t.Series, *p)
+		}
+	}
+	sort.Strings(plugins)
+
+	trends := make([]pluginTrend, 0, len(plugins))
+	for _, plugin := range plugins {
+		t := byPlugin[plugin]
+		t.Runs = len(t.Series)
+		var total time.Duration
+		diffs := 0
+		for _, p := range t.Series {
+			total += p.Duration
+			if p.Changed > 0 {
+				diffs++
+			}
+		}
+		t.MeanDuration = total / time.Duration(t.Runs)
+		t.DiffFrequency = float64(diffs) / float64(t.Runs)
+		if first := t.Series[0].Bytes; first > 0 {
+			t.Growth = float64(t.Series[t.Runs-1].Bytes-first) / float64(first)
+		}
+		trends = append(trends, *t)
+	}
+	return trends
+}
+
+func writeTrendTable(w io.Writer, trends []pluginTrend, series bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "PLUGIN\tRUNS\tFIRST BYTES\tLAST BYTES\tGROWTH\tMEAN DURATION\tLAST DURATION\tDIFF FREQUENCY\n")
+	for _, t := range trends {
+		first, last := t.Series[0], t.Series[t.Runs-1]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%+.1f%%\t%v\t%v\t%.0f%%\n",
+			t.Plugin, t.Runs, first.Bytes, last.Bytes, 100*t.Growth,
+			t.MeanDuration.Round(time.Millisecond), last.Duration.Round(time.Millisecond), 100*t.DiffFrequency)
+	}
+	if series {
+		for _, t := range trends {
+			fmt.Fprintf(tw, "\n%s\nTIME\tCAPTURES\tFAILED\tCHANGED\tFILES\tBYTES\tDURATION\tCPU\tMAX RSS\n", t.Plugin)
+			for _, p := range t.Series {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%d\n",
+					p.Time.Format(time.RFC3339), p.Captures, p.Failed, p.Changed, p.Files, p.Bytes,
+					p.Duration.Round(time.Millisecond), p.CPU.Round(time.Millisecond), p.MaxRSS)
+			}
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("unresolved", "list option extensions that could not be resolved and where they are declared", runUnresolved)
+}
+
+// knownExtension is a range of extension numbers declared by a commonly imported file.
+type knownExtension struct {
+	extendee string
+	lo, hi   int32
+	file     string
+}
+
+// knownExtensions lists where popular option extensions are declared.
+var knownExtensions = []knownExtension{
+	{"google.protobuf.FieldOptions", 1071, 1071, "validate/validate.proto"},
+	{"google.protobuf.MessageOptions", 1071, 1072, "validate/validate.proto"},
+	{"google.protobuf.OneofOptions", 1071, 1071, "validate/validate.proto"},
+	{"google.protobuf.FieldOptions", 1159, 1159, "buf/validate/validate.proto"},
+	{"google.protobuf.MessageOptions", 1159, 1159, "buf/validate/validate.proto"},
+	{"google.protobuf.OneofOptions", 1159, 1159, "buf/validate/validate.proto"},
+	{"google.protobuf.MethodOptions", 72295728, 72295728, "google/api/annotations.proto"},
+	{"google.protobuf.MethodOptions", 1051, 1051, "google/api/client.proto"},
+	{"google.protobuf.ServiceOptions", 1049, 1050, "google/api/client.proto"},
+	{"google.protobuf.FieldOptions", 1052, 1052, "google/api/field_behavior.proto"},
+	{"google.protobuf.FieldOptions", 1055, 1055, "google/api/resource.proto"},
+	{"google.protobuf.MessageOptions", 1053, 1053, "google/api/resource.proto"},
+	{"google.protobuf.FileOptions", 1053, 1053, "google/api/resource.proto"},
+	{"google.protobuf.MethodOptions", 1049, 1049, "google/longrunning/operations.proto"},
+	{"google.protobuf.FileOptions", 1042, 1042, "protoc-gen-openapiv2/options/annotations.proto"},
+	{"google.protobuf.MethodOptions", 1042, 1042, "protoc-gen-openapiv2/options/annotations.proto"},
+	{"google.protobuf.MessageOptions", 1042, 1042, "protoc-gen-openapiv2/options/annotations.proto"},
+	{"google.protobuf.ServiceOptions", 1042, 1042, "protoc-gen-openapiv2/options/annotations.proto"},
+	{"google.protobuf.FieldOptions", 1042, 1042, "protoc-gen-openapiv2/options/annotations.proto"},
+	{"google.protobuf.EnumOptions", 62001, 62999, "gogoproto/gogo.proto"},
+	{"google.protobuf.EnumValueOptions", 66001, 66999, "gogoproto/gogo.proto"},
+	{"google.protobuf.FileOptions", 63001, 63999, "gogoproto/gogo.proto"},
+	{"google.protobuf.MessageOptions", 64001, 64999, "gogoproto/gogo.proto"},
+	{"google.protobuf.FieldOptions", 65001, 65999, "gogoproto/gogo.proto"},
+}
+
+type unresolvedExtension struct {
+	Options    string   `json:"options"`
+	Number     int32    `json:"number"`
+	Uses       int      `json:"uses"`
+	DeclaredIn []string `json:"declared_in,omitempty"`
+	Missing    []string `json:"missing_imports,omitempty"`
+	UsedIn     []string `json:"used_in"`
+}
+
+func runUnresolved(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		extra   stringsFlag
+	)
+	fs := newFlagSet("unresolved")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.Var(&extra, "extra-descriptors", "resolve extensions with this binary FileDescriptorSet, repeatable")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if err := loadExtraDescriptors(extra); err != nil {
+		return err
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodePartialRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	exts := unresolvedExtensions(req)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(exts)
+	}
+	return writeUnresolvedTable(os.Stdout, exts)
+}
+
+// decodePartialRequest is decodeRequest for binary requests with missing imports.
+func decodePartialRequest(bin []byte, jsonIn bool) (*pluginpb.CodeGeneratorRequest, error) {
+	if jsonIn {
+		return decodeRequest(bin, jsonIn)
+	}
+	_, bin, err := unwrapContainer(bin)
+	if err != nil {
+		return nil, err
+	}
+	req, err := unmarshalRequestTypes(bin, partialProtoTypes)
+	if err != nil {
+		return nil, fmt.Errorf("proto unmarshal error: %v", err)
+	}
+	return req, nil
+}
+
+// unresolvedExtensions lists the unknown fields set directly in options messages,
+// which are extensions whose declaring file is missing from the request.
+func unresolvedExtensions(req *pluginpb.CodeGeneratorRequest) []unresolvedExtension {
+	type key struct {
+		options string
+		number  int32
+	}
+	found := map[key]*unresolvedExtension{}
+	for _, fd := range req.ProtoFile {
+		file := fd.GetName()
+		walkFileOptions(fd, func(kind, name string, opts protoreflect.Message) {
+			options := string(opts.Descriptor().FullName())
+			for b := opts.GetUnknown(); len(b) > 0; {
+				num, typ, n := protowire.ConsumeTag(b)
+				if n < 0 {
+					return
+				}
+				m := protowire.ConsumeFieldValue(num, typ, b[n:])
+				if m < 0 {
+					return
+				}
+				b = b[n+m:]
+				k := key{options, int32(num)}
+				u := found[k]
+				if u == nil {
+					u = &unresolvedExtension{Options: options, Number: int32(num)}
+					found[k] = u
+				}
+				u.Uses++
+				if n := len(u.UsedIn); n == 0 || u.UsedIn[n-1] != file {
+					u.UsedIn = append(u.UsedIn, file)
+				}
+			}
+		})
+	}
+
+	// extensions declared in the request may still be unresolved if building the registry failed
+	declared := map[key][]string{}
+	for _, fd := range req.ProtoFile {
+		walkExtensions(fd, func(extendee string, number int32) {
+			k := key{strings.TrimPrefix(extendee, "."), number}
+			declared[k] = append(declared[k], fd.GetName())
+		})
+	}
+
+	// imports missing from the request are the likely declarations of the rest
+	present := map[string]bool{}
+	for _, fd := range req.ProtoFile {
+		present[fd.GetName()] = true
+	}
+	missing := map[string][]string{}
+	for _, fd := range req.ProtoFile {
+		for _, dep := range fd.Dependency {
+			if !present[dep] {
+				missing[fd.GetName()] = append(missing[fd.GetName()], dep)
+			}
+		}
+	}
+
+	exts := make([]unresolvedExtension, 0, len(found))
+	for k, u := range found {
+		u.DeclaredIn = declared[k]
+		for _, file := range u.UsedIn {
+			u.Missing = append(u.Missing, missing[file]...)
+		}
+		u.Missing = dedupeSorted(u.Missing)
+		for _, ke := range knownExtensions {
+			if ke.extendee == k.options && ke.lo <= k.number && k.number <= ke.hi {
+				u.DeclaredIn = append(u.DeclaredIn, ke.file)
+			}
+		}
+		exts = append(exts, *u)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if exts[i].Options != exts[j].Options {
+			return exts[i].Options < exts[j].Options
+		}
+		return exts[i].Number < exts[j].Number
+	})
+	return exts
+}
+
+func writeUnresolvedTable(w io.Writer, exts []unresolvedExtension) error {
+	if len(exts) == 0 {
+		_, err := fmt.Fprintln(w, "all option extensions are resolved")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "OPTIONS\tNUMBER\tUSES\tDECLARED IN\tUSED IN\tMISSING IMPORTS\n")
+	for _, u := range exts {
+		declared := strings.Join(u.DeclaredIn, ",")
+		if declared == "" {
+			declared = "?"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n",
+			u.Options, u.Number, u.Uses, declared, strings.Join(u.UsedIn, ","), strings.Join(u.Missing, ","))
+	}
+	return tw.Flush()
+}
+
+func dedupeSorted(s []string) []string {
+	sort.Strings(s)
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// walkExtensions calls fn with the extendee and number of all extensions declared in fd.
+func walkExtensions(fd *descriptorpb.FileDescriptorProto, fn func(extendee string, number int32)) {
+	for _, f := range fd.Extension {
+		fn(f.GetExtendee(), f.GetNumber())
+	}
+	var walk func(mds []*descriptorpb.DescriptorProto)
+	walk = func(mds []*descriptorpb.DescriptorProto) {
+		for _, md := range mds {
+			for _, f := range md.Extension {
+				fn(f.GetExtendee(), f.GetNumber())
+			}
+			walk(md.NestedType)
+		}
+	}
+	walk(fd.MessageType)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("uses", "list the fields, extensions, methods and map values referencing a message or enum", runUses)
+}
+
+// typeUse is a declaration referencing a type.
+type typeUse struct {
+	File    string `json:"file"`
+	Kind    string `json:"kind"`
+	Element string `json:"element"`
+}
+
+func runUses(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+	)
+	fs := newFlagSet("uses")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture uses [ARGUMENTS] FULL.TYPE.NAME < request\n\n"+
+			"Kinds:\n"+
+			"  field      a field of the type, singular or repeated\n"+
+			"  map value  a map field with values of the type\n"+
+			"  extension  an extension of the type\n"+
+			"  extends    an extension of the message\n"+
+			"  input      a method taking the message\n"+
+			"  output     a method returning the message\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("uses needs a message or enum name")
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(fs.Arg(0), ".")
+	if s := findSymbol(req, name); s == nil || (s.Kind != "message" && s.Kind != "enum") {
+		return fmt.Errorf("%s is no message or enum declared in the request", fs.Arg(0))
+	}
+	uses := typeUses(req, name)
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(uses)
+	}
+	return writeUsesTable(os.Stdout, uses)
+}
+
+// typeUses lists the references to the message or enum with the full name.
+func typeUses(req *pluginpb.CodeGeneratorRequest, name string) []typeUse {
+	typeName := "." + name
+	// map entries are reported as the map field using them
+	mapFields := map[string]string{}
+	for _, fd := range req.ProtoFile {
+		walkDeclarations(fd, func(kind, full string, path []int32, desc proto.Message) {
+			if md, ok := desc.(*descriptorpb.DescriptorProto); ok && md.GetOptions().GetMapEntry() {
+				mapFields["."+full] = ""
+			}
+		})
+	}
+	for _, fd := range req.ProtoFile {
+		walkDeclarations(fd, func(kind, full string, path []int32, desc proto.Message) {
+			if f, ok := desc.(*descriptorpb.FieldDescriptorProto); ok && kind == "field" {
+				if _, isMap := mapFields[f.GetTypeName()]; isMap && f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+					mapFields[f.GetTypeName()] = full
+				}
+			}
+		})
+	}
+
+	uses := []typeUse{}
+	for _, fd := range req.ProtoFile {
+		add := func(kind, element string) {
+			uses = append(uses, typeUse{File: fd.GetName(), Kind: kind, Element: element})
+		}
+		walkDeclarations(fd, func(kind, full string, path []int32, desc proto.Message) {
+			switch d := desc.(type) {
+			case *descriptorpb.FieldDescriptorProto:
+				if kind == "extension" && d.GetExtendee() == typeName {
+					add("extends", full)
+				}
+				if d.GetTypeName() != typeName {
+					return
+				}
+				if entry := full[:strings.LastIndex(full, ".")]; kind == "field" && strings.HasSuffix(full, ".value") {
+					if field, ok := mapFields["."+entry]; ok {
+						add("map value", field)
+						return
+					}
+				}
+				if _, isMap := mapFields[d.GetTypeName()]; !isMap {
+					add(kind, full)
+				}
+			case *descriptorpb.MethodDescriptorProto:
+				if d.GetInputType() == typeName {
+					add("input", full)
+				}
+				if d.GetOutputType() == typeName {
+					add("output", full)
+				}
+			}
+		})
+	}
+	return uses
+}
+
+func writeUsesTable(w io.Writer, uses []typeUse) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "FILE\tKIND\tELEMENT\n")
+	for _, u := range uses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", u.File, u.Kind, u.Element)
+	}
+	return tw.Flush()
+}
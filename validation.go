@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func init() {
+	register("validation", "report the validation rules of protovalidate and protoc-gen-validate options per field", runValidation)
+}
+
+// validationOptions match the options of protovalidate and protoc-gen-validate,
+// by number if their declarations are missing.
+var validationOptions = []string{"buf.validate.*", "validate.*", "1159", "1071", "1072"}
+
+// validationRule is an option with validation rules of a declaration.
+type validationRule struct {
+	File    string          `json:"file"`
+	Element string          `json:"element"`
+	Kind    string          `json:"kind"`
+	Option  string          `json:"option"`
+	Rules   json.RawMessage `json:"rules"`
+
+	text string
+}
+
+type validationReport struct {
+	Fields    int              `json:"fields"`
+	Validated int              `json:"validated_fields"`
+	Rules     []validationRule `json:"rules"`
+}
+
+func runValidation(args []string) error {
+	var (
+		jsonIn  = false
+		jsonOut = false
+		all     = false
+		options stringsFlag
+	)
+	fs := newFlagSet("validation")
+	fs.BoolVar(&jsonIn, "json-in", jsonIn, "input is json, else binary proto")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	fs.BoolVar(&all, "all", all, "include all files, not only the files to generate")
+	fs.Var(&options, "option", "report options matching this full name or declaring file pattern or field number instead of "+strings.Join(validationOptions, " and ")+", repeatable")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stdout, "usage: protoc-gen-capture validation [ARGUMENTS] < request\n\n"+
+			"Rules are only resolved if the files declaring the options are in the request.\n"+
+			"Unresolved options are reported by field number.\n\nArguments:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		options = validationOptions
+	}
+
+	bin, err := readStdin()
+	if err != nil {
+		return err
+	}
+	req, err := decodeRequest(bin, jsonIn)
+	if err != nil {
+		return err
+	}
+	r, err := newValidationReport(req, optionMatcher(options), all)
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(r)
+	}
+	return r.writeTable(os.Stdout)
+}
+
+// descriptorOptions returns the options of a descriptor proto or nil.
+func descriptorOptions(desc proto.Message) protoreflect.Message {
+	m := desc.ProtoReflect()
+	f := m.Descriptor().Fields().ByName("options")
+	if f == nil || !m.Has(f) {
+		return nil
+	}
+	return m.Get(f).Message()
+}
+
+func newValidationReport(req *pluginpb.CodeGeneratorRequest, om optionMatcher, all bool) (*validationReport, error) {
+	generate := map[string]bool{}
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	r := &validationReport{Rules: []validationRule{}}
+	var err error
+	for _, fd := range req.ProtoFile {
+		if !all && !generate[fd.GetName()] {
+			continue
+		}
+		mapEntries := ""
+		walkDeclarations(fd, func(kind, name string, path []int32, desc proto.Message) {
+			if md, ok := desc.(*descriptorpb.DescriptorProto); ok && md.GetOptions().GetMapEntry() {
+				mapEntries = name + "."
+			}
+			if mapEntries != "" && strings.HasPrefix(name, mapEntries) {
+				return
+			}
+			if kind == "field" {
+				r.Fields++
+			}
+			opts := descriptorOptions(desc)
+			if opts == nil || err != nil {
+				return
+			}
+			validated := false
+			add := func(option string, rules proto.Message, value interface{}) {
+				rule := validationRule{File: fd.GetName(), Element: name, Kind: kind, Option: option}
+				var text []byte
+				switch {
+				case rules != nil:
+					if rule.Rules, err = protojson.Marshal(rules); err == nil {
+						text, err = prototext.Marshal(rules)
+					}
+				case value != nil:
+					rule.Rules, err = json.Marshal(value)
+					text = rule.Rules
+				default:
+					rule.Rules, text = json.RawMessage("null"), []byte("unresolved")
+				}
+				rule.text = strings.Join(strings.Fields(string(text)), " ")
+				r.Rules = append(r.Rules, rule)
+				validated = true
+			}
+			opts.Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+				if !f.IsExtension() || !om.matches(f) {
+					return true
+				}
+				if f.Kind() == protoreflect.MessageKind || f.Kind() == protoreflect.GroupKind {
+					add(string(f.FullName()), v.Message().Interface(), nil)
+				} else {
+					add(string(f.FullName()), nil, v.Interface())
+				}
+				return err == nil
+			})
+			unknownFields(opts, "", func(path string, num protowire.Number) {
+				if path == "" && om.matchesNumber(num) {
+					add(fmt.Sprint(num), nil, nil)
+				}
+			})
+			if validated && kind == "field" {
+				r.Validated++
+			}
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rules could not be encoded: %v", err)
+	}
+	return r, nil
+}
+
+func (r *validationReport) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "ELEMENT\tKIND\tOPTION\tRULES\n")
+	for _, rule := range r.Rules {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", rule.Element, rule.Kind, rule.Option, rule.text)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	percent := 100.0
+	if r.Fields > 0 {
+		percent = float64(r.Validated) * 100 / float64(r.Fields)
+	}
+	_, err := fmt.Fprintf(w, "\n%d of %d fields validated (%.1f%%)\n", r.Validated, r.Fields, percent)
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"text/tabwriter"
+)
+
+func init() {
+	register("version", "print the module version, vcs revision and protobuf runtime version of this build", runVersion)
+}
+
+// buildInfo identifies the build of this program, it is stored in the metadata of captures.
+type buildInfo struct {
+	Module       string `json:"module"`
+	Version      string `json:"version"`
+	GoVersion    string `json:"go_version"`
+	Revision     string `json:"vcs_revision,omitempty"`
+	RevisionTime string `json:"vcs_time,omitempty"`
+	Modified     bool   `json:"vcs_modified,omitempty"`
+	Protobuf     string `json:"protobuf_version,omitempty"`
+}
+
+func newBuildInfo() *buildInfo {
+	info := &buildInfo{Version: "unknown", GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module, info.Version = bi.Main.Path, bi.Main.Version
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.RevisionTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == "google.golang.org/protobuf" {
+			info.Protobuf = dep.Version
+			if dep.Replace != nil {
+				info.Protobuf = dep.Replace.Path + "@" + dep.Replace.Version
+			}
+		}
+	}
+	return info
+}
+
+func runVersion(args []string) error {
+	jsonOut := false
+	fs := newFlagSet("version")
+	fs.BoolVar(&jsonOut, "json-out", jsonOut, "output as json, else as table")
+	if done, err := parseFlags(fs, args); done || err != nil {
+		return err
+	}
+	info := newBuildInfo()
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(info)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "module\t%s\n", info.Module)
+	fmt.Fprintf(tw, "version\t%s\n", info.Version)
+	if info.Revision != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Fprintf(tw, "revision\t%s %s%s\n", info.Revision, info.RevisionTime, modified)
+	}
+	fmt.Fprintf(tw, "go\t%s\n", info.GoVersion)
+	fmt.Fprintf(tw, "protobuf\t%s\n", info.Protobuf)
+	return tw.Flush()
+}
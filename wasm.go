@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// wasmRuntime runs plugins compiled to WASI modules, the first known runtime in PATH if empty.
+var wasmRuntime = ""
+
+// wasmRuntimes are the known WASI runtimes with the arguments to run a module.
+// They pass stdin and stdout to the module by default.
+var wasmRuntimes = []struct {
+	name string
+	args func(module string, args []string) []string
+}{
+	{"wasmtime", func(module string, args []string) []string {
+		return append([]string{"run", module}, args...)
+	}},
+	{"wazero", func(module string, args []string) []string {
+		return append([]string{"run", module}, args...)
+	}},
+	{"wasmer", func(module string, args []string) []string {
+		return append([]string{"run", module, "--"}, args...)
+	}},
+	{"wasmedge", func(module string, args []string) []string {
+		return append([]string{module}, args...)
+	}},
+}
+
+// isWasm reports whether the plugin is a WASI module.
+func isWasm(plugin string) bool {
+	return strings.HasSuffix(plugin, ".wasm")
+}
+
+// wasmCommand returns argv unchanged for native plugins and the command
+// running the module with a WASI runtime for WASI modules.
+func wasmCommand(argv []string) ([]string, error) {
+	if len(argv) == 0 || !isWasm(argv[0]) {
+		return argv, nil
+	}
+	module, args := argv[0], argv[1:]
+	if wasmRuntime != "" {
+		runtime, err := exec.LookPath(wasmRuntime)
+		if err != nil {
+			return nil, fmt.Errorf("WASI runtime %s not found: %v", wasmRuntime, err)
+		}
+		for _, r := range wasmRuntimes {
+			if strings.TrimSuffix(filepath.Base(runtime), ".exe") == r.name {
+				return append([]string{runtime}, r.args(module, args)...), nil
+			}
+		}
+		// unknown runtimes get the module and its arguments
+		return append([]string{runtime, module}, args...), nil
+	}
+	for _, r := range wasmRuntimes {
+		if runtime, err := exec.LookPath(r.name); err == nil {
+			return append([]string{runtime}, r.args(module, args)...), nil
+		}
+	}
+	return nil, fmt.Errorf("no WASI runtime found to run %s, install wasmtime, wazero, wasmer or wasmedge or use -wasm-runtime", module)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// fileStamp changes when a file is rewritten.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statStamp(name string) fileStamp {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}
+}
+
+// watchReplay replays req whenever the plugin binary or the capture file changes
+// and prints the diff of the generated files against the previous response until interrupted.
+// If capture is not empty, req is read from it.
+func watchReplay(w io.Writer, argv []string, req *pluginpb.CodeGeneratorRequest, capture string, interval time.Duration, lines int, cv *compilerVersionFlags) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("no plugin to watch")
+	}
+	binary := argv[0]
+	var err error
+	if !isWasm(binary) {
+		if binary, err = exec.LookPath(binary); err != nil {
+			return err
+		}
+	}
+	watched := []string{binary}
+	if capture != "" {
+		watched = append(watched, capture)
+	}
+	stamps := func() []fileStamp {
+		s := make([]fileStamp, len(watched))
+		for i, name := range watched {
+			s[i] = statStamp(name)
+		}
+		return s
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(w, "watching %s, interrupt to stop\n", strings.Join(watched, " and "))
+	var prev *pluginpb.CodeGeneratorResponse
+	run := 0
+	last := stamps()
+	for {
+		run++
+		err = nil
+		if capture != "" {
+			if req, err = readRequestFile(capture); err == nil {
+				err = cv.apply(req)
+			}
+		}
+		var res *replayResult
+		var pr *pluginResult
+		if err == nil {
+			res, pr, err = replay(ctx, argv, req, nil)
+		}
+		now := time.Now().Format("15:04:05")
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			fmt.Fprintf(w, "== %s run %d: %v\n", now, run, err)
+		case res.Failed:
+			fmt.Fprintf(w, "== %s run %d: failed in %v: %s\n", now, run, res.Duration.Round(time.Millisecond), res.Error)
+		case prev == nil:
+			fmt.Fprintf(w, "== %s run %d: %d files, %d bytes in %v\n", now, run, res.Files, res.Bytes, res.Duration.Round(time.Millisecond))
+			prev = pr.resp
+		default:
+			diff, changed := diffResponses(prev, pr.resp, "previous/", "current/", lines)
+			fmt.Fprintf(w, "== %s run %d: %d files, %d bytes in %v, %d changed\n%s", now, run, res.Files, res.Bytes, res.Duration.Round(time.Millisecond), len(changed), diff)
+			prev = pr.resp
+		}
+
+		// wait for a change and until writing it is done
+		for changed := false; ; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			cur := stamps()
+			if !equalStamps(cur, last) {
+				changed, last = true, cur
+				continue
+			}
+			if changed {
+				break
+			}
+		}
+	}
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}